@@ -0,0 +1,19 @@
+package config
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "q3 server config tools",
+	}
+	cmd.AddCommand(
+		newImportCommand(),
+		newRenderCommand(),
+		newSchemaCommand(),
+		newValidateCommand(),
+	)
+	return cmd
+}
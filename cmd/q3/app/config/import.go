@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	quakeserver "github.com/ChrisRx/quake-kube/internal/quake/server"
+)
+
+func newImportCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "import <server.cfg>",
+		Short:        "convert an existing server.cfg into a server configuration file",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			cfg, err := quakeserver.ImportConfig(data)
+			if err != nil {
+				return fmt.Errorf("%s: %w", args[0], err)
+			}
+			if len(cfg.Maps) == 0 {
+				fmt.Fprintf(os.Stderr, "warning: no map rotation found in %s\n", args[0])
+			}
+			data, err = yaml.Marshal(cfg)
+			if err != nil {
+				return err
+			}
+			_, err = os.Stdout.Write(data)
+			return err
+		},
+	}
+	return cmd
+}
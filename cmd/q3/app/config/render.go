@@ -0,0 +1,36 @@
+package config
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	quakeserver "github.com/ChrisRx/quake-kube/internal/quake/server"
+)
+
+func newRenderCommand() *cobra.Command {
+	var opts struct {
+		ConfigFile string
+	}
+	cmd := &cobra.Command{
+		Use:          "render",
+		Short:        "print the server.cfg generated from a server configuration file",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := quakeserver.ReadConfigFromFile(opts.ConfigFile)
+			if err != nil {
+				return err
+			}
+			data, err := cfg.Marshal()
+			if err != nil {
+				return err
+			}
+			_, err = os.Stdout.Write(data)
+			return err
+		},
+	}
+	cmd.Flags().StringVarP(&opts.ConfigFile, "config", "c", "", "server configuration file")
+	_ = cmd.MarkFlagRequired("config")
+	return cmd
+}
@@ -0,0 +1,27 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	quakeserver "github.com/ChrisRx/quake-kube/internal/quake/server"
+)
+
+func newSchemaCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "schema",
+		Short:        "print the JSON Schema for server configuration files",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := quakeserver.Schema()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s\n", data)
+			return nil
+		},
+	}
+	return cmd
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	quakeserver "github.com/ChrisRx/quake-kube/internal/quake/server"
+)
+
+func newValidateCommand() *cobra.Command {
+	var opts struct {
+		ConfigFile string
+		AssetsDir  string
+	}
+	cmd := &cobra.Command{
+		Use:           "validate",
+		Short:         "validate a server configuration file",
+		Args:          cobra.NoArgs,
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if opts.AssetsDir != "" && !filepath.IsAbs(opts.AssetsDir) {
+				opts.AssetsDir, err = filepath.Abs(opts.AssetsDir)
+				if err != nil {
+					return err
+				}
+			}
+			if _, err := quakeserver.ValidateConfigFile(opts.ConfigFile, opts.AssetsDir); err != nil {
+				return err
+			}
+			fmt.Printf("%s is valid\n", opts.ConfigFile)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&opts.ConfigFile, "config", "c", "", "server configuration file")
+	cmd.Flags().StringVar(&opts.AssetsDir, "assets-dir", "", "(optional) check that maps exist in the assets directory")
+	_ = cmd.MarkFlagRequired("config")
+	return cmd
+}
@@ -0,0 +1,53 @@
+package content
+
+import (
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	contentapiv3 "github.com/ChrisRx/quake-kube/internal/quake/content/api/v3"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/auth"
+)
+
+// clientOptions are the options of the commands that use the content server.
+type clientOptions struct {
+	Addr     string
+	Insecure bool
+	Token    string
+
+	// gRPC TLS client auth
+	KeyFile    string
+	CertFile   string
+	CACertFile string
+}
+
+func (o *clientOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&o.Addr, "addr", ":9090", "Address for content server")
+	cmd.Flags().BoolVar(&o.Insecure, "insecure", false, "Allow insecure gRPC client connection")
+	cmd.Flags().StringVar(&o.Token, "token", "", "API token of the content server")
+	cmd.Flags().StringVar(&o.CertFile, "cert", "", "Client certificate file")
+	cmd.Flags().StringVar(&o.KeyFile, "key", "", "Client certificate key file")
+	cmd.Flags().StringVar(&o.CACertFile, "ca-cert", "", "CA certificate file the content server is verified with")
+}
+
+func (o *clientOptions) dial() (contentapiv3.AssetsClient, error) {
+	var copts []grpc.DialOption
+	if o.Insecure {
+		copts = append(copts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	} else {
+		tlsConfig, err := auth.ClientTLSConfig(o.CertFile, o.KeyFile, o.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		copts = append(copts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	}
+	if o.Token != "" {
+		copts = append(copts, grpc.WithPerRPCCredentials(auth.TokenCredentials(o.Token, o.Insecure)))
+	}
+	conn, err := grpc.Dial(o.Addr, copts...)
+	if err != nil {
+		return nil, err
+	}
+	return contentapiv3.NewAssetsClient(conn), nil
+}
@@ -2,17 +2,26 @@ package content
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"log"
 	"net"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/soheilhy/cmux"
 	"github.com/spf13/cobra"
 
 	quakecontent "github.com/ChrisRx/quake-kube/internal/quake/content"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/auth"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/precompress"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/replica"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/storage"
 	quakecontentutil "github.com/ChrisRx/quake-kube/internal/quake/content/util"
+	quakeserver "github.com/ChrisRx/quake-kube/internal/quake/server"
 	"github.com/ChrisRx/quake-kube/pkg/must"
 	"github.com/ChrisRx/quake-kube/pkg/mux"
 )
@@ -21,7 +30,20 @@ var opts struct {
 	Addr           string
 	ServerAddr     string
 	AssetsDir      string
+	Storage        string
 	SeedContentURL string
+	ConfigFiles    []string
+	Precompress    []string
+	Follow         []string
+	FollowInterval time.Duration
+	FollowToken    string
+	FollowCert     string
+	FollowKey      string
+	FollowCACert   string
+	AuthConfig     string
+	TLSCertFile    string
+	TLSKeyFile     string
+	TLSClientCA    string
 }
 
 func NewCommand() *cobra.Command {
@@ -42,6 +64,23 @@ func NewCommand() *cobra.Command {
 				return err
 			}
 
+			var s storage.Storage = storage.NewLocal(opts.AssetsDir)
+			if opts.Storage != "" {
+				if !strings.HasPrefix(opts.Storage, "s3://") {
+					return fmt.Errorf("invalid storage %q, must be an s3:// URL, local files are stored in --assets-dir", opts.Storage)
+				}
+				// The files are mirrored, seeded and precompressed in the assets
+				// directory itself.
+				if opts.SeedContentURL != "" || len(opts.Follow) > 0 || len(opts.Precompress) > 0 {
+					return fmt.Errorf("--seed-content-url, --follow and --precompress can't be used with --storage")
+				}
+				s, err = storage.New(opts.Storage)
+				if err != nil {
+					return err
+				}
+				log.Printf("content: upload sessions and demos are kept in %s, so replicas need sticky routing for resumable uploads\n", opts.AssetsDir)
+			}
+
 			if opts.SeedContentURL != "" {
 				u, err := url.Parse(opts.SeedContentURL)
 				if err != nil {
@@ -52,14 +91,75 @@ func NewCommand() *cobra.Command {
 				}
 			}
 
+			var a *auth.Authenticator
+			if opts.AuthConfig != "" {
+				cfg, err := auth.ReadConfigFromFile(opts.AuthConfig)
+				if err != nil {
+					return err
+				}
+				a, err = auth.New(cfg)
+				if err != nil {
+					return err
+				}
+			}
+
+			l := must.Must(net.Listen("tcp", opts.Addr))
+			if opts.TLSCertFile != "" || opts.TLSKeyFile != "" {
+				cfg, err := auth.ServerTLSConfig(opts.TLSCertFile, opts.TLSKeyFile, opts.TLSClientCA)
+				if err != nil {
+					return err
+				}
+				l = tls.NewListener(l, cfg)
+			} else if opts.TLSClientCA != "" {
+				return fmt.Errorf("--tls-client-ca requires --tls-cert and --tls-key")
+			}
+
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
 
-			m := mux.New(must.Must(net.Listen("tcp", opts.Addr)))
-			m.Register(quakecontent.NewRPCServer(ctx, opts.AssetsDir, opts.ServerAddr)).
+			m := mux.New(l)
+			usedMaps := func() ([]quakecontentutil.UsedMap, error) {
+				return quakeserver.ReadUsedMaps(opts.ConfigFiles...)
+			}
+			rpc := quakecontent.NewRPCServer(ctx, opts.AssetsDir, s, opts.ServerAddr)
+			rpc.UsedMaps = usedMaps
+			rpc.Auth = a
+			m.Register(rpc).
 				Match(cmux.HTTP2MatchHeaderFieldSendSettings("content-type", "application/grpc"))
-			m.Register(quakecontent.NewHTTPContentServer(ctx, opts.AssetsDir)).
+			hs := quakecontent.NewHTTPContentServer(ctx, opts.AssetsDir, s)
+			hs.UsedMaps = usedMaps
+			hs.Index = rpc.Index
+			hs.Auth = a
+			if len(opts.Precompress) > 0 {
+				hs.Precompressed, err = precompress.NewStore(opts.AssetsDir, opts.Precompress...)
+				if err != nil {
+					return err
+				}
+			}
+			m.Register(hs).
 				Any()
+			if len(opts.Follow) > 0 {
+				f := &replica.Follower{
+					Dir:       opts.AssetsDir,
+					Index:     rpc.Index,
+					Upstreams: opts.Follow,
+					Interval:  opts.FollowInterval,
+				}
+				if opts.FollowToken != "" {
+					data, err := os.ReadFile(opts.FollowToken)
+					if err != nil {
+						return err
+					}
+					f.Token = strings.TrimSpace(string(data))
+				}
+				if opts.FollowCert != "" || opts.FollowKey != "" || opts.FollowCACert != "" {
+					f.TLSConfig, err = auth.ClientTLSConfig(opts.FollowCert, opts.FollowKey, opts.FollowCACert)
+					if err != nil {
+						return err
+					}
+				}
+				go f.Run(ctx)
+			}
 			fmt.Printf("Starting server %s\n", opts.Addr)
 			return m.Serve()
 		},
@@ -68,6 +168,24 @@ func NewCommand() *cobra.Command {
 	cmd.Flags().
 		StringVar(&opts.ServerAddr, "server-addr", "", "(optional) dedicated server <host>:<port>")
 	cmd.Flags().StringVarP(&opts.AssetsDir, "assets-dir", "d", "assets", "assets directory")
+	cmd.Flags().StringVar(&opts.Storage, "storage", "", "(optional) store the assets in an S3-compatible object store, e.g. s3://bucket/prefix?endpoint=http://minio:9000, instead of the assets directory")
 	cmd.Flags().StringVar(&opts.SeedContentURL, "seed-content-url", "", "seed content from another content server")
+	cmd.Flags().StringArrayVar(&opts.Follow, "follow", nil, "(optional) mirror the files of another content server, can be repeated")
+	cmd.Flags().DurationVar(&opts.FollowInterval, "follow-interval", 30*time.Second, "how often the followed content servers are checked for changes")
+	cmd.Flags().StringVar(&opts.FollowToken, "follow-token-file", "", "(optional) file with the API token sent to the followed content servers")
+	cmd.Flags().StringVar(&opts.FollowCert, "follow-cert", "", "(optional) client certificate file for the followed content servers")
+	cmd.Flags().StringVar(&opts.FollowKey, "follow-key", "", "(optional) client certificate key file for the followed content servers")
+	cmd.Flags().StringVar(&opts.FollowCACert, "follow-ca-cert", "", "(optional) CA certificate file the followed content servers are verified with")
+	cmd.Flags().StringSliceVar(&opts.Precompress, "precompress", nil, "(optional) precompress assets with these encodings (br, gzip)")
+	cmd.Flags().StringVar(&opts.AuthConfig, "auth-config", "", "(optional) file with the API tokens and client certificates allowed to read and change files, without it files can only be read over HTTP and uploaded over gRPC")
+	cmd.Flags().StringVar(&opts.TLSCertFile, "tls-cert", "", "(optional) serve with TLS using this certificate")
+	cmd.Flags().StringVar(&opts.TLSKeyFile, "tls-key", "", "(optional) key of the TLS certificate")
+	cmd.Flags().StringVar(&opts.TLSClientCA, "tls-client-ca", "", "(optional) CA that client certificates are verified with")
+	cmd.Flags().StringArrayVarP(&opts.ConfigFiles, "config", "c", nil, "(optional) server configuration file, whose maps can't be removed unless forced")
+	cmd.AddCommand(
+		newListCommand(),
+		newMoveCommand(),
+		newRemoveCommand(),
+	)
 	return cmd
 }
@@ -0,0 +1,84 @@
+package content
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	contentapiv3 "github.com/ChrisRx/quake-kube/internal/quake/content/api/v3"
+	contentutil "github.com/ChrisRx/quake-kube/internal/quake/content/util"
+)
+
+func newListCommand() *cobra.Command {
+	var opts struct {
+		clientOptions
+		Maps     bool
+		GameName string
+	}
+	cmd := &cobra.Command{
+		Use:          "ls [file]",
+		Short:        "list the files, or maps, on the content server",
+		Example:      "  q3 content ls --addr content:9090 --insecure --maps --game missionpack",
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := opts.dial()
+			if err != nil {
+				return err
+			}
+			ctx := context.Background()
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			defer w.Flush()
+
+			switch {
+			case len(args) == 1:
+				resp, err := client.StatFile(ctx, &contentapiv3.StatFileRequest{Name: args[0]})
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(w, "Name:\t%s\n", resp.File.Name)
+				fmt.Fprintf(w, "Size:\t%d\n", resp.File.Compressed)
+				fmt.Fprintf(w, "Checksum:\t%d\n", resp.File.Checksum)
+				fmt.Fprintf(w, "Modified:\t%s\n", resp.ModTime.AsTime().Local())
+				if len(resp.Maps) > 0 {
+					fmt.Fprintf(w, "Maps:\t%s\n", strings.Join(resp.Maps, ", "))
+				}
+			case opts.Maps:
+				resp, err := client.ListMaps(ctx, &contentapiv3.ListMapsRequest{GameName: opts.GameName})
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(w, "NAME\tFILE\tENTITIES")
+				for _, m := range resp.Maps {
+					fmt.Fprintf(w, "%s\t%s\t%s\n", m.Name, m.File, strings.Join(m.Entities, ","))
+				}
+			default:
+				resp, err := client.GetManifest(ctx, &emptypb.Empty{})
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(w, "NAME\tSIZE\tCHECKSUM")
+				for _, f := range resp.Files {
+					if opts.GameName != "" {
+						switch contentutil.GameDir(f.Name) {
+						case "", "baseq3", opts.GameName:
+						default:
+							continue
+						}
+					}
+					fmt.Fprintf(w, "%s\t%d\t%d\n", f.Name, f.Compressed, f.Checksum)
+				}
+			}
+			return nil
+		},
+	}
+	opts.addFlags(cmd)
+	cmd.Flags().BoolVar(&opts.Maps, "maps", false, "list the maps instead of the files")
+	cmd.Flags().StringVar(&opts.GameName, "game", "", "only list what is used when running the game directory, e.g. missionpack")
+	return cmd
+}
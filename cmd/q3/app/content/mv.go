@@ -0,0 +1,43 @@
+package content
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	contentapiv3 "github.com/ChrisRx/quake-kube/internal/quake/content/api/v3"
+)
+
+func newMoveCommand() *cobra.Command {
+	var opts struct {
+		clientOptions
+		Force bool
+	}
+	cmd := &cobra.Command{
+		Use:          "mv <file> <new file>",
+		Short:        "rename a file on the content server",
+		Example:      "  q3 content mv --addr content:9090 --insecure baseq3/ctf.pk3 missionpack/ctf.pk3",
+		Args:         cobra.ExactArgs(2),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := opts.dial()
+			if err != nil {
+				return err
+			}
+			f, err := client.MoveFile(context.Background(), &contentapiv3.MoveFileRequest{
+				Name:    args[0],
+				NewName: args[1],
+				Force:   opts.Force,
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Moved %s to %s\n", args[0], f.Name)
+			return nil
+		},
+	}
+	opts.addFlags(cmd)
+	cmd.Flags().BoolVarP(&opts.Force, "force", "f", false, "move map packs even if the server uses their maps")
+	return cmd
+}
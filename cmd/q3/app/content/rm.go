@@ -0,0 +1,44 @@
+package content
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	contentapiv3 "github.com/ChrisRx/quake-kube/internal/quake/content/api/v3"
+)
+
+func newRemoveCommand() *cobra.Command {
+	var opts struct {
+		clientOptions
+		Force bool
+	}
+	cmd := &cobra.Command{
+		Use:          "rm <file>...",
+		Short:        "remove files from the content server",
+		Example:      "  q3 content rm --addr content:9090 --insecure baseq3/broken.pk3",
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := opts.dial()
+			if err != nil {
+				return err
+			}
+			for _, name := range args {
+				_, err := client.DeleteFile(context.Background(), &contentapiv3.DeleteFileRequest{
+					Name:  name,
+					Force: opts.Force,
+				})
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Removed %s\n", name)
+			}
+			return nil
+		},
+	}
+	opts.addFlags(cmd)
+	cmd.Flags().BoolVarP(&opts.Force, "force", "f", false, "remove map packs even if the server uses their maps")
+	return cmd
+}
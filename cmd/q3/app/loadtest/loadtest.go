@@ -0,0 +1,89 @@
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	quakeloadtest "github.com/ChrisRx/quake-kube/internal/quake/loadtest"
+	"github.com/ChrisRx/quake-kube/internal/util/fs"
+	quakenet "github.com/ChrisRx/quake-kube/pkg/quake/net"
+)
+
+var opts struct {
+	URL            string
+	Clients        int
+	Duration       time.Duration
+	Ramp           time.Duration
+	ConnectTimeout time.Duration
+	PacketRate     int
+	Password       string
+	AssetsDir      string
+	ReplayFile     string
+}
+
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "loadtest",
+		Short:        "simulate many clients connecting through the websocket proxy",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.URL == "" {
+				return errors.New("--url is required")
+			}
+			cfg := &quakeloadtest.Config{
+				URL:            opts.URL,
+				Clients:        opts.Clients,
+				Duration:       opts.Duration,
+				Ramp:           opts.Ramp,
+				ConnectTimeout: opts.ConnectTimeout,
+				PacketRate:     opts.PacketRate,
+				Password:       opts.Password,
+			}
+			if opts.AssetsDir != "" {
+				err := fs.WalkFiles(opts.AssetsDir, func(path string, info os.FileInfo, err error) error {
+					p, err := quakenet.ReadPak(path)
+					if err != nil {
+						return err
+					}
+					cfg.Paks = append(cfg.Paks, p)
+					return nil
+				}, ".pk3")
+				if err != nil {
+					return err
+				}
+			}
+			if opts.ReplayFile != "" {
+				frames, err := quakeloadtest.ReadReplayFile(opts.ReplayFile)
+				if err != nil {
+					return err
+				}
+				cfg.Replay = frames
+			}
+
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer cancel()
+
+			report, err := quakeloadtest.Run(ctx, cfg)
+			if err != nil {
+				return err
+			}
+			report.Print(os.Stdout)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&opts.URL, "url", "", "websocket url of the proxy, e.g. ws://localhost:8080")
+	cmd.Flags().IntVar(&opts.Clients, "clients", 16, "number of clients")
+	cmd.Flags().DurationVar(&opts.Duration, "duration", time.Minute, "how long clients stay connected")
+	cmd.Flags().DurationVar(&opts.Ramp, "ramp", 10*time.Second, "spread the connections over this long")
+	cmd.Flags().DurationVar(&opts.ConnectTimeout, "connect-timeout", 30*time.Second, "timeout for each client to connect")
+	cmd.Flags().IntVar(&opts.PacketRate, "packet-rate", 30, "packets sent per second by each client")
+	cmd.Flags().StringVar(&opts.Password, "password", "", "server password")
+	cmd.Flags().StringVar(&opts.AssetsDir, "assets-dir", "", "directory with the server's pk3 files, required for pure servers")
+	cmd.Flags().StringVar(&opts.ReplayFile, "replay", "", "file with recorded player input, instead of synthetic input")
+	return cmd
+}
@@ -18,8 +18,12 @@ import (
 
 	quakeclient "github.com/ChrisRx/quake-kube/internal/quake/client"
 	"github.com/ChrisRx/quake-kube/internal/quake/content"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/auth"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/precompress"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/storage"
 	quakecontentutil "github.com/ChrisRx/quake-kube/internal/quake/content/util"
 	quakeserver "github.com/ChrisRx/quake-kube/internal/quake/server"
+	"github.com/ChrisRx/quake-kube/internal/util/exec"
 	. "github.com/ChrisRx/quake-kube/pkg/must"
 	"github.com/ChrisRx/quake-kube/pkg/mux"
 )
@@ -34,6 +38,18 @@ var opts struct {
 	WatchInterval  time.Duration
 	ShutdownDelay  time.Duration
 	SeedContentURL string
+	RandomRcon     bool
+	ServersFile    string
+	IdleTimeout    time.Duration
+	IdleAction     string
+	Engine         string
+	ServerBinary   string
+	ServerArgs     string
+	ServerEnv      []string
+	ServerWorkDir  string
+	ServerBasePath string
+	Precompress    []string
+	AuthConfig     string
 }
 
 func NewCommand() *cobra.Command {
@@ -68,17 +84,49 @@ func NewCommand() *cobra.Command {
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
 
-			qs := quakeserver.Server{
-				Addr:          opts.ServerAddr,
-				ConfigFile:    opts.ConfigFile,
-				Dir:           opts.AssetsDir,
-				WatchInterval: opts.WatchInterval,
-				ShutdownDelay: opts.ShutdownDelay,
+			switch quakeserver.IdleAction(opts.IdleAction) {
+			case quakeserver.IdleStop, quakeserver.IdleExit:
+			default:
+				return fmt.Errorf("invalid --idle-action %q, must be stop or exit", opts.IdleAction)
+			}
+			serverArgs, err := exec.SplitArgs(opts.ServerArgs)
+			if err != nil {
+				return fmt.Errorf("invalid --server-args: %w", err)
+			}
+
+			inst := &quakeserver.Instances{}
+			if opts.ServersFile != "" {
+				if cmd.Flags().Changed("config") || cmd.Flags().Changed("server-addr") {
+					return errors.New("--servers cannot be used with --config or --server-addr")
+				}
+				inst, err = quakeserver.ReadInstancesFromFile(opts.ServersFile)
+				if err != nil {
+					return err
+				}
+			}
+			// The auth config is read before the servers are started, so that an
+			// invalid one is reported rather than leaving them running.
+			var a *auth.Authenticator
+			if opts.AuthConfig != "" {
+				cfg, err := auth.ReadConfigFromFile(opts.AuthConfig)
+				if err != nil {
+					return err
+				}
+				a, err = auth.New(cfg)
+				if err != nil {
+					return err
+				}
+			}
+			var instances []quakeclient.ServerInstance
+			for _, s := range inst.Servers {
+				instances = append(instances, quakeclient.ServerInstance{Name: s.Name, Addr: s.Addr})
 			}
+			qs := newSupervisor(inst, serverArgs)
+
 			go func() {
-				// The main context should only cancel after the quake server is
-				// finished. This allows for graceful termination and the child process
-				// to be safely killed before exiting.
+				// The main context should only cancel after the quake servers are
+				// finished. This allows for graceful termination and the child
+				// processes to be safely killed before exiting.
 				defer cancel()
 
 				ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
@@ -88,7 +136,7 @@ func NewCommand() *cobra.Command {
 					fmt.Println("\rCTRL+C pressed again, shutting down ...")
 
 					// We still need to call HardStop to ensure that the underlying child
-					// process is killed before exiting.
+					// processes are killed before exiting.
 					qs.HardStop()
 					os.Exit(1)
 				})
@@ -98,16 +146,48 @@ func NewCommand() *cobra.Command {
 				}
 			}()
 
+			serverAddr := opts.ServerAddr
+			if len(instances) > 0 {
+				serverAddr = instances[0].Addr
+			}
+			usedMaps := usedMapsFunc(inst)
 			m := mux.New(Must(net.Listen("tcp", opts.ClientAddr)))
-			m.Register(content.NewRPCServer(ctx, opts.AssetsDir, opts.ServerAddr)).
+			// The dedicated server reads the assets directory, so it is always the
+			// storage.
+			assets := storage.NewLocal(opts.AssetsDir)
+			rpc := content.NewRPCServer(ctx, opts.AssetsDir, assets, serverAddr)
+			rpc.UsedMaps = usedMaps
+			rpc.Auth = a
+			m.Register(rpc).
 				Match(cmux.HTTP2MatchHeaderFieldSendSettings("content-type", "application/grpc"))
-			m.Register(content.NewHTTPContentServer(ctx, opts.AssetsDir)).
-				Match(cmux.PrefixMatcher("GET /assets"))
-			m.Register(Must(quakeclient.NewProxy(ctx, opts.ServerAddr))).
-				Match(cmux.HTTP1HeaderField("Upgrade", "websocket"))
+			hs := content.NewHTTPContentServer(ctx, opts.AssetsDir, assets)
+			hs.UsedMaps = usedMaps
+			hs.Index = rpc.Index
+			hs.Auth = a
+			if len(opts.Precompress) > 0 {
+				hs.Precompressed = Must(precompress.NewStore(opts.AssetsDir, opts.Precompress...))
+			}
+			// Connections are matched by their first request, so the client
+			// server also proxies these requests to the content server, when
+			// they are sent on a connection that was matched to it.
+			m.Register(hs).
+				Match(cmux.PrefixMatcher("GET /assets", "GET /demos", "POST /uploads", "HEAD /uploads", "GET /uploads", "PATCH /uploads", "DELETE /uploads", "OPTIONS /uploads", "GET /files", "DELETE /files", "POST /files", "OPTIONS /files"))
+			if len(instances) > 0 {
+				router := Must(quakeclient.NewProxyRouter(ctx, instances))
+				router.Waker = qs
+				m.Register(router).
+					Match(cmux.HTTP1HeaderField("Upgrade", "websocket"))
+			} else {
+				proxy := Must(quakeclient.NewProxy(ctx, opts.ServerAddr))
+				proxy.Waker = qs
+				m.Register(proxy).
+					Match(cmux.HTTP1HeaderField("Upgrade", "websocket"))
+			}
 			m.Register(Must(quakeclient.NewHTTPClientServer(ctx, &quakeclient.Config{
 				ContentServerURL: opts.ContentServer,
-				ServerAddr:       opts.ServerAddr,
+				ServerAddr:       serverAddr,
+				Servers:          instances,
+				Waker:            qs,
 			}))).
 				Any()
 			fmt.Printf("Starting server %s\n", opts.ClientAddr)
@@ -118,14 +198,119 @@ func NewCommand() *cobra.Command {
 	cmd.Flags().StringVar(&opts.ContentServer, "content-server", "http://127.0.0.1:8080", "content server url")
 	cmd.Flags().BoolVar(&opts.AcceptEula, "agree-eula", false, "agree to the Quake 3 demo EULA")
 	cmd.Flags().StringVar(&opts.AssetsDir, "assets-dir", "assets", "location for game files")
+	cmd.Flags().StringSliceVar(&opts.Precompress, "precompress", nil, "(optional) precompress assets with these encodings (br, gzip)")
+	cmd.Flags().StringVar(&opts.AuthConfig, "auth-config", "", "(optional) file with the API tokens allowed to read and upload files, anyone can upload without it")
 	cmd.Flags().StringVar(&opts.ClientAddr, "client-addr", "0.0.0.0:8080", "client address <host>:<port>")
 	cmd.Flags().StringVar(&opts.ServerAddr, "server-addr", "0.0.0.0:27960", "dedicated server <host>:<port>")
 	cmd.Flags().DurationVar(&opts.WatchInterval, "watch-interval", 15*time.Second, "watch interval for config file")
 	cmd.Flags().DurationVar(&opts.ShutdownDelay, "shutdown-delay", 1*time.Minute, "delay for graceful shutdown")
 	cmd.Flags().StringVar(&opts.SeedContentURL, "seed-content-url", "", "seed content from another content server")
+	cmd.Flags().StringVar(&opts.ServersFile, "servers", "", "file describing several dedicated servers to run, instead of --config and --server-addr")
+	cmd.Flags().DurationVar(&opts.IdleTimeout, "idle-timeout", 0, "stop the dedicated server after this long without players, 0 disables it")
+	cmd.Flags().StringVar(&opts.IdleAction, "idle-action", "stop", "action taken when idle, either stop (until a client connects) or exit")
+	cmd.Flags().StringVar(&opts.Engine, "engine", "ioq3", fmt.Sprintf("dedicated server engine, one of %v", quakeserver.Engines()))
+	cmd.Flags().StringVar(&opts.ServerBinary, "server-binary", "", "dedicated server binary, the binary of the engine by default")
+	cmd.Flags().StringVar(&opts.ServerArgs, "server-args", "", "extra arguments for the dedicated server, split like a shell does, e.g. \"+set sv_hostname 'Quake Kube'\"")
+	cmd.Flags().StringArrayVar(&opts.ServerEnv, "server-env", nil, "extra environment variable for the dedicated server, KEY=value")
+	cmd.Flags().StringVar(&opts.ServerWorkDir, "server-workdir", "", "working directory of the dedicated server, --assets-dir by default")
+	cmd.Flags().StringVar(&opts.ServerBasePath, "server-basepath", "", "additional directory the dedicated server looks for game files in")
+	cmd.Flags().BoolVar(&opts.RandomRcon, "random-rcon-password", false, "generate a random rcon password when the config doesn't set one")
 	return cmd
 }
 
+// newSupervisor returns a supervisor for each of the server instances, or for
+// the single server set by --config and --server-addr. The args are the split
+// --server-args.
+func newSupervisor(inst *quakeserver.Instances, args []string) *quakeserver.Supervisor {
+	if len(inst.Servers) == 0 {
+		return &quakeserver.Supervisor{
+			Servers: []*quakeserver.Server{{
+				Addr:          opts.ServerAddr,
+				ConfigFile:    opts.ConfigFile,
+				Dir:           opts.AssetsDir,
+				WatchInterval: opts.WatchInterval,
+				ShutdownDelay: opts.ShutdownDelay,
+				Engine:        quakeserver.Engine(opts.Engine),
+				Binary:        opts.ServerBinary,
+				Args:          args,
+				Env:           opts.ServerEnv,
+				WorkDir:       opts.ServerWorkDir,
+				BasePath:      opts.ServerBasePath,
+
+				GenerateRconPassword: opts.RandomRcon,
+				IdleTimeout:          opts.IdleTimeout,
+				IdleAction:           quakeserver.IdleAction(opts.IdleAction),
+			}},
+		}
+	}
+	sv := &quakeserver.Supervisor{}
+	for _, s := range inst.Servers {
+		server := &quakeserver.Server{
+			Name:          s.Name,
+			Addr:          s.Addr,
+			ConfigFile:    s.ConfigFile,
+			Dir:           opts.AssetsDir,
+			WatchInterval: opts.WatchInterval,
+			ShutdownDelay: opts.ShutdownDelay,
+			Engine:        quakeserver.Engine(opts.Engine),
+			Binary:        opts.ServerBinary,
+			Args:          args,
+			Env:           opts.ServerEnv,
+			WorkDir:       opts.ServerWorkDir,
+			BasePath:      opts.ServerBasePath,
+
+			GenerateRconPassword: opts.RandomRcon,
+			IdleTimeout:          opts.IdleTimeout,
+			IdleAction:           quakeserver.IdleAction(opts.IdleAction),
+		}
+		if s.Engine != "" {
+			// The binary of another engine is unlikely to be the one set for
+			// all servers.
+			server.Engine = s.Engine
+			server.Binary = ""
+		}
+		if s.Binary != "" {
+			server.Binary = s.Binary
+		}
+		if s.Args != nil {
+			server.Args = s.Args
+		}
+		if s.Env != nil {
+			server.Env = s.Env
+		}
+		sv.Servers = append(sv.Servers, server)
+	}
+	return sv
+}
+
+// usedMapsFunc returns a function that reads the maps used by the servers from
+// their config files, so that config changes are picked up.
+func usedMapsFunc(inst *quakeserver.Instances) func() ([]quakecontentutil.UsedMap, error) {
+	var configFiles []string
+	if len(inst.Servers) == 0 && opts.ConfigFile != "" {
+		configFiles = append(configFiles, opts.ConfigFile)
+	}
+	defaults := len(inst.Servers) == 0 && opts.ConfigFile == ""
+	for _, s := range inst.Servers {
+		if s.ConfigFile == "" {
+			defaults = true
+			continue
+		}
+		configFiles = append(configFiles, s.ConfigFile)
+	}
+	return func() ([]quakecontentutil.UsedMap, error) {
+		used, err := quakeserver.ReadUsedMaps(configFiles...)
+		if err != nil {
+			return nil, err
+		}
+		// Servers without a config file use the default config.
+		if defaults {
+			used = append(used, quakeserver.Default().UsedMaps()...)
+		}
+		return used, nil
+	}
+}
+
 func registerSecondInterrupt(ready <-chan struct{}, fn func()) {
 	go func() {
 		<-ready
@@ -4,9 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"net"
 	"net/url"
+	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
 
 	"github.com/soheilhy/cmux"
@@ -15,19 +19,27 @@ import (
 	quakeclient "github.com/ChrisRx/quake-kube/internal/quake/client"
 	quakecontentutil "github.com/ChrisRx/quake-kube/internal/quake/content/util"
 	quakeserver "github.com/ChrisRx/quake-kube/internal/quake/server"
+	"github.com/ChrisRx/quake-kube/internal/util/exec"
 	httputil "github.com/ChrisRx/quake-kube/internal/util/net/http"
 	"github.com/ChrisRx/quake-kube/pkg/must"
 	"github.com/ChrisRx/quake-kube/pkg/mux"
 )
 
 var opts struct {
-	ClientAddr    string
-	ServerAddr    string
-	ContentServer string
-	AcceptEula    bool
-	AssetsDir     string
-	ConfigFile    string
-	WatchInterval time.Duration
+	ClientAddr     string
+	ServerAddr     string
+	ContentServer  string
+	AcceptEula     bool
+	AssetsDir      string
+	ConfigFile     string
+	WatchInterval  time.Duration
+	RandomRcon     bool
+	Engine         string
+	ServerBinary   string
+	ServerArgs     string
+	ServerEnv      []string
+	ServerWorkDir  string
+	ServerBasePath string
 }
 
 func NewCommand() *cobra.Command {
@@ -48,28 +60,66 @@ func NewCommand() *cobra.Command {
 				return errors.New("You must agree to the EULA to continue")
 			}
 
+			serverArgs, err := exec.SplitArgs(opts.ServerArgs)
+			if err != nil {
+				return fmt.Errorf("invalid --server-args: %w", err)
+			}
+
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
 
-			// Sync with content server and start ioq3ded server process.
+			ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			// Sync with content server and start ioq3ded server process. The
+			// lifecycle tracks where this is at, so that clients aren't served
+			// the game before the server is ready.
+			lc := &quakeserver.Lifecycle{}
+			errch := make(chan error, 1)
 			go func() {
-				if err := httputil.GetUntil(opts.ContentServer+"/assets/manifest.json", ctx.Done()); err != nil {
-					panic(err)
-				}
-				// TODO(ChrisRx): only download what is in map config
-				if err := quakecontentutil.DownloadAssets(must.Must(url.Parse(opts.ContentServer)), opts.AssetsDir); err != nil {
-					panic(err)
-				}
+				errch <- func() error {
+					if err := httputil.GetUntil(opts.ContentServer+"/assets/manifest.json", ctx.Done()); err != nil {
+						return err
+					}
+					// Only the files needed for the maps in the rotation are
+					// downloaded, and maps added to it later are downloaded
+					// before the config is reloaded.
+					contentURL := must.Must(url.Parse(opts.ContentServer))
+					syncAssets := func(ctx context.Context, cfg *quakeserver.Config) error {
+						return quakecontentutil.SyncAssets(ctx, contentURL, opts.AssetsDir, cfg.UsedMaps())
+					}
+					cfg := quakeserver.Default()
+					if opts.ConfigFile != "" {
+						c, err := quakeserver.ReadConfigFromFile(opts.ConfigFile)
+						if err != nil {
+							return err
+						}
+						cfg = c
+					}
+					if err := syncAssets(ctx, cfg); err != nil {
+						return fmt.Errorf("cannot download assets: %w", err)
+					}
 
-				s := quakeserver.Server{
-					Dir:           opts.AssetsDir,
-					WatchInterval: opts.WatchInterval,
-					ConfigFile:    opts.ConfigFile,
-					Addr:          opts.ServerAddr,
-				}
-				if err := s.Start(ctx); err != nil {
-					panic(err)
-				}
+					lc.Set(quakeserver.StateStarting)
+					go lc.Watch(ctx, opts.ServerAddr, 1*time.Second)
+
+					s := quakeserver.Server{
+						Dir:           opts.AssetsDir,
+						WatchInterval: opts.WatchInterval,
+						ConfigFile:    opts.ConfigFile,
+						Addr:          opts.ServerAddr,
+						Engine:        quakeserver.Engine(opts.Engine),
+						Binary:        opts.ServerBinary,
+						Args:          serverArgs,
+						Env:           opts.ServerEnv,
+						WorkDir:       opts.ServerWorkDir,
+						BasePath:      opts.ServerBasePath,
+
+						GenerateRconPassword: opts.RandomRcon,
+						Sync:                 syncAssets,
+					}
+					return s.Start(ctx)
+				}()
 			}()
 
 			m := mux.New(must.Must(net.Listen("tcp", opts.ClientAddr)))
@@ -78,10 +128,33 @@ func NewCommand() *cobra.Command {
 			m.Register(must.Must(quakeclient.NewHTTPClientServer(ctx, &quakeclient.Config{
 				ContentServerURL: opts.ContentServer,
 				ServerAddr:       opts.ServerAddr,
+				Lifecycle:        lc,
+				DemosDir:         opts.AssetsDir,
 			}))).
 				Any()
 			fmt.Printf("Starting server %s\n", opts.ClientAddr)
-			return m.Serve()
+			serveErr := make(chan error, 1)
+			go func() {
+				serveErr <- m.Serve()
+			}()
+			select {
+			case err := <-serveErr:
+				return err
+			case err := <-errch:
+				if err == nil || ctx.Err() != nil {
+					return nil
+				}
+				// The failure is reported by the health check, so the client
+				// server keeps serving it until the command is stopped.
+				lc.Fail(err)
+				log.Printf("quakeserver: %v\n", err)
+				select {
+				case <-ctx.Done():
+					return err
+				case err := <-serveErr:
+					return err
+				}
+			}
 		},
 	}
 	cmd.Flags().StringVarP(&opts.ConfigFile, "config", "c", "", "server configuration file")
@@ -95,5 +168,12 @@ func NewCommand() *cobra.Command {
 		StringVar(&opts.ServerAddr, "server-addr", "0.0.0.0:27960", "dedicated server <host>:<port>")
 	cmd.Flags().
 		DurationVar(&opts.WatchInterval, "watch-interval", 15*time.Second, "dedicated server <host>:<port>")
+	cmd.Flags().StringVar(&opts.Engine, "engine", "ioq3", fmt.Sprintf("dedicated server engine, one of %v", quakeserver.Engines()))
+	cmd.Flags().StringVar(&opts.ServerBinary, "server-binary", "", "dedicated server binary, the binary of the engine by default")
+	cmd.Flags().StringVar(&opts.ServerArgs, "server-args", "", "extra arguments for the dedicated server, split like a shell does, e.g. \"+set sv_hostname 'Quake Kube'\"")
+	cmd.Flags().StringArrayVar(&opts.ServerEnv, "server-env", nil, "extra environment variable for the dedicated server, KEY=value")
+	cmd.Flags().StringVar(&opts.ServerWorkDir, "server-workdir", "", "working directory of the dedicated server, --assets-dir by default")
+	cmd.Flags().StringVar(&opts.ServerBasePath, "server-basepath", "", "additional directory the dedicated server looks for game files in")
+	cmd.Flags().BoolVar(&opts.RandomRcon, "random-rcon-password", false, "generate a random rcon password when the config doesn't set one")
 	return cmd
 }
@@ -0,0 +1,61 @@
+package upload
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const progressWidth = 30
+
+// progressBar prints the progress of an upload on a single line, e.g.
+//
+//	maps.pk3 [=============>                ]  45% 22.5/50.0 MB
+type progressBar struct {
+	w     io.Writer
+	name  string
+	total int64
+	n     int64
+	last  int
+}
+
+func newProgressBar(w io.Writer, name string, total int64) *progressBar {
+	p := &progressBar{w: w, name: name, total: total, last: -1}
+	p.print()
+	return p
+}
+
+func (p *progressBar) Add(n int) {
+	p.n += int64(n)
+	p.print()
+}
+
+// Set sets the progress, e.g. when an upload is resumed.
+func (p *progressBar) Set(n int64) {
+	p.n = n
+	p.print()
+}
+
+// Done ends the line of the progress bar.
+func (p *progressBar) Done() {
+	fmt.Fprintln(p.w)
+}
+
+func (p *progressBar) print() {
+	percent := 100
+	if p.total > 0 {
+		percent = int(p.n * 100 / p.total)
+	}
+	// Only print when the percentage changes, so that small chunks don't
+	// flood the output.
+	if percent == p.last {
+		return
+	}
+	p.last = percent
+	filled := percent * progressWidth / 100
+	bar := strings.Repeat("=", filled)
+	if filled < progressWidth {
+		bar += ">" + strings.Repeat(" ", progressWidth-filled-1)
+	}
+	fmt.Fprintf(p.w, "\r%s [%s] %3d%% %.1f/%.1f MB", p.name, bar, percent, float64(p.n)/(1<<20), float64(p.total)/(1<<20))
+}
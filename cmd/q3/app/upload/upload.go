@@ -2,24 +2,29 @@ package upload
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
-	"google.golang.org/protobuf/types/known/emptypb"
 
-	contentapiv1 "github.com/ChrisRx/quake-kube/internal/quake/content/api/v1"
-	contentapiv2 "github.com/ChrisRx/quake-kube/internal/quake/content/api/v2"
+	contentapiv3 "github.com/ChrisRx/quake-kube/internal/quake/content/api/v3"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/auth"
 )
 
 var opts struct {
-	Addr     string
-	Insecure bool
+	Addr      string
+	Insecure  bool
+	GameName  string
+	Resumable bool
+	Retries   int
+	Token     string
 
 	// gRPC TLS client auth
 	KeyFile    string
@@ -36,60 +41,203 @@ func NewCommand() *cobra.Command {
 			var copts []grpc.DialOption
 			if opts.Insecure {
 				copts = append(copts, grpc.WithTransportCredentials(insecure.NewCredentials()))
-			}
-			if opts.KeyFile != "" && opts.CertFile != "" {
-				tlsConfig := &tls.Config{
-					ClientCAs: x509.NewCertPool(),
-				}
-				if opts.CACertFile != "" {
-					caCert, err := os.ReadFile(opts.CACertFile)
-					if err != nil {
-						return err
-					}
-					if !tlsConfig.ClientCAs.AppendCertsFromPEM(caCert) {
-						return fmt.Errorf("failed to add ca cert file: %s", opts.CACertFile)
-					}
-				}
-				cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+			} else {
+				tlsConfig, err := auth.ClientTLSConfig(opts.CertFile, opts.KeyFile, opts.CACertFile)
 				if err != nil {
 					return err
 				}
-				tlsConfig.Certificates = []tls.Certificate{cert}
 				copts = append(copts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
 			}
+			if opts.Token != "" {
+				copts = append(copts, grpc.WithPerRPCCredentials(auth.TokenCredentials(opts.Token, opts.Insecure)))
+			}
 			conn, err := grpc.Dial(opts.Addr, copts...)
 			if err != nil {
 				return err
 			}
-			client := contentapiv1.NewAssetsClient(conn)
-
-			data, err := os.ReadFile(args[0])
-			if err != nil {
-				return err
+			client := contentapiv3.NewAssetsClient(conn)
+			if opts.Resumable {
+				return uploadFileResumable(context.Background(), client, args[0])
 			}
+			return uploadFile(context.Background(), client, args[0])
+		},
+	}
+	cmd.Flags().StringVar(&opts.Addr, "addr", ":9090", "Address for content server")
+	cmd.Flags().BoolVar(&opts.Insecure, "insecure", false, "Allow insecure gRPC client connection")
+	cmd.Flags().StringVar(&opts.Token, "token", "", "API token of the content server")
+	cmd.Flags().StringVar(&opts.CertFile, "cert", "", "Client certificate file")
+	cmd.Flags().StringVar(&opts.KeyFile, "key", "", "Client certificate key file")
+	cmd.Flags().StringVar(&opts.CACertFile, "ca-cert", "", "CA certificate file the content server is verified with")
+	cmd.Flags().StringVar(&opts.GameName, "game", "baseq3", "Game directory the file is uploaded to")
+	cmd.Flags().BoolVar(&opts.Resumable, "resumable", false, "Upload in a session that is resumed when the connection fails")
+	cmd.Flags().IntVar(&opts.Retries, "retries", 5, "Number of times a resumable upload is resumed")
+	return cmd
+}
 
-			resp, err := client.FileUpload(context.Background(), &contentapiv1.FileUploadRequest{
-				Name: args[0],
-				File: data,
+// chunkSize is the size of the chunks a file is uploaded in, which must be
+// well below the maximum gRPC message size of 4MB.
+const chunkSize = 1 << 20
+
+// readHeader returns the header of the file to upload, and seeks back to the
+// start of the file.
+func readHeader(f *os.File) (*contentapiv3.UploadFileHeader, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return &contentapiv3.UploadFileHeader{
+		Name:     filepath.Base(f.Name()),
+		GameName: opts.GameName,
+		Size:     fi.Size(),
+		Checksum: h.Sum32(),
+	}, nil
+}
+
+func uploadFile(ctx context.Context, client contentapiv3.AssetsClient, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hdr, err := readHeader(f)
+	if err != nil {
+		return err
+	}
+	stream, err := client.UploadFile(ctx)
+	if err != nil {
+		return err
+	}
+	err = stream.Send(&contentapiv3.UploadFileRequest{
+		Request: &contentapiv3.UploadFileRequest_Header{Header: hdr},
+	})
+	if err != nil && err != io.EOF {
+		return err
+	}
+	p := newProgressBar(os.Stderr, hdr.Name, hdr.Size)
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			// Send returns io.EOF when the server has ended the upload, and the
+			// reason is returned by CloseAndRecv.
+			err := stream.Send(&contentapiv3.UploadFileRequest{
+				Request: &contentapiv3.UploadFileRequest_Chunk{Chunk: buf[:n]},
 			})
+			if err == io.EOF {
+				break
+			}
 			if err != nil {
 				return err
 			}
-			fmt.Printf("resp: %+v\n", resp)
+			p.Add(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	p.Done()
+	if err != nil {
+		return err
+	}
+	fmt.Println(resp.Message)
+	return nil
+}
 
-			{
-				client := contentapiv2.NewAssetsClient(conn)
-				resp, err := client.GetManifest(context.Background(), &emptypb.Empty{})
-				if err != nil {
-					return err
-				}
-				fmt.Printf("resp: %+v\n", resp)
-			}
+// uploadFileResumable uploads a file in an upload session, and resumes from
+// the offset the server has received when a write fails.
+func uploadFileResumable(ctx context.Context, client contentapiv3.AssetsClient, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
-			return nil
-		},
+	hdr, err := readHeader(f)
+	if err != nil {
+		return err
 	}
-	cmd.Flags().StringVar(&opts.Addr, "addr", ":9090", "Address for content server")
-	cmd.Flags().BoolVar(&opts.Insecure, "insecure", false, "Allow insecure gRPC client connection")
-	return cmd
+	sess, err := client.CreateUploadSession(ctx, &contentapiv3.CreateUploadSessionRequest{Header: hdr})
+	if err != nil {
+		return err
+	}
+	p := newProgressBar(os.Stderr, hdr.Name, hdr.Size)
+	for attempt := 0; sess.Offset < hdr.Size; attempt++ {
+		err := writeSession(ctx, client, f, sess, p)
+		if err == nil {
+			break
+		}
+		if attempt >= opts.Retries {
+			p.Done()
+			return err
+		}
+		time.Sleep(time.Duration(attempt+1) * time.Second)
+		s, err := client.GetUploadSession(ctx, &contentapiv3.GetUploadSessionRequest{Id: sess.Id})
+		if err != nil {
+			// The server is likely still unreachable, and the offset is
+			// asked for again after the next attempt.
+			continue
+		}
+		sess = s
+	}
+	resp, err := client.FinalizeUploadSession(ctx, &contentapiv3.FinalizeUploadSessionRequest{Id: sess.Id})
+	p.Done()
+	if err != nil {
+		return err
+	}
+	fmt.Println(resp.Message)
+	return nil
+}
+
+// writeSession writes the rest of the file to the session, starting at its
+// offset.
+func writeSession(ctx context.Context, client contentapiv3.AssetsClient, f *os.File, sess *contentapiv3.UploadSession, p *progressBar) error {
+	if _, err := f.Seek(sess.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	p.Set(sess.Offset)
+	stream, err := client.WriteUploadSession(ctx)
+	if err != nil {
+		return err
+	}
+	req := &contentapiv3.WriteUploadSessionRequest{Id: sess.Id, Offset: sess.Offset}
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			req.Chunk = buf[:n]
+			err := stream.Send(req)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			req = &contentapiv3.WriteUploadSessionRequest{}
+			p.Add(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	s, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	sess.Offset = s.Offset
+	return nil
 }
@@ -6,7 +6,9 @@ import (
 	"github.com/spf13/cobra"
 
 	q3cmd "github.com/ChrisRx/quake-kube/cmd/q3/app/cmd"
+	q3config "github.com/ChrisRx/quake-kube/cmd/q3/app/config"
 	q3content "github.com/ChrisRx/quake-kube/cmd/q3/app/content"
+	q3loadtest "github.com/ChrisRx/quake-kube/cmd/q3/app/loadtest"
 	q3proxy "github.com/ChrisRx/quake-kube/cmd/q3/app/proxy"
 	q3run "github.com/ChrisRx/quake-kube/cmd/q3/app/run"
 	q3server "github.com/ChrisRx/quake-kube/cmd/q3/app/server"
@@ -24,7 +26,9 @@ func main() {
 	}
 	cmd.AddCommand(
 		q3cmd.NewCommand(),
+		q3config.NewCommand(),
 		q3content.NewCommand(),
+		q3loadtest.NewCommand(),
 		q3proxy.NewCommand(),
 		q3run.NewCommand(),
 		q3server.NewCommand(),
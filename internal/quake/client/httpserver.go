@@ -2,23 +2,59 @@ package client
 
 import (
 	"context"
+	"errors"
 	"html/template"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	contentutil "github.com/ChrisRx/quake-kube/internal/quake/content/util"
+	quakeserver "github.com/ChrisRx/quake-kube/internal/quake/server"
 	quakenet "github.com/ChrisRx/quake-kube/pkg/quake/net"
 )
 
 type Config struct {
 	ContentServerURL string
 	ServerAddr       string
+
+	// Servers are the dedicated servers to choose from on the landing page.
+	// When empty, only the server at ServerAddr is served.
+	Servers []ServerInstance
+
+	// Waker is used to start servers that were stopped while idle. It is
+	// optional.
+	Waker Waker
+
+	// Lifecycle is the state of the server at ServerAddr, when it is managed
+	// by the same process. Until it is ready, a waiting page is served instead
+	// of the game client.
+	Lifecycle *quakeserver.Lifecycle
+
+	// DemosDir is the assets directory the demos of the server are saved in,
+	// when it is managed by the same process as a separate content server.
+	// The demos are then served from it, rather than proxied to the content
+	// server, which doesn't have them.
+	DemosDir string
+}
+
+// Waker starts dedicated servers that were stopped while idle. Servers are
+// identified by name, which is empty when there is only a single server.
+type Waker interface {
+	// Wake starts the server if it was stopped, and reports whether it is
+	// still starting.
+	Wake(name string) bool
+
+	// Stopped reports whether the server was stopped while idle.
+	Stopped(name string) bool
 }
 
 type HTTPClientServer struct {
@@ -49,37 +85,108 @@ func NewHTTPClientServer(ctx context.Context, cfg *Config) (*HTTPClientServer, e
 	if err != nil {
 		return nil, err
 	}
+	data, err = static.ReadFile("servers.html")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := templates.New("servers").Parse(string(data)); err != nil {
+		return nil, err
+	}
+	data, err = static.ReadFile("starting.html")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := templates.New("starting").Parse(string(data)); err != nil {
+		return nil, err
+	}
 	e.Renderer = &TemplateRenderer{templates}
 
+	servers := make(map[string]ServerInstance)
+	for _, s := range cfg.Servers {
+		servers[s.Name] = s
+	}
+	if cfg.ServerAddr == "" && len(cfg.Servers) > 0 {
+		cfg.ServerAddr = cfg.Servers[0].Addr
+	}
+
 	e.GET("/", func(c echo.Context) error {
-		m, err := quakenet.GetInfo(cfg.ServerAddr)
-		if err != nil {
-			return err
-		}
-		needsPass := false
-		if v, ok := m["g_needpass"]; ok {
-			if v == "1" {
-				needsPass = true
+		if len(cfg.Servers) == 0 {
+			if cfg.Lifecycle != nil && !cfg.Lifecycle.Ready() {
+				state, _ := cfg.Lifecycle.State()
+				return c.Render(http.StatusServiceUnavailable, "starting", map[string]interface{}{
+					"State": state,
+				})
 			}
+			return renderIndex(c, cfg, "", cfg.ServerAddr, "")
 		}
-		return c.Render(http.StatusOK, "index", map[string]interface{}{
-			"ServerAddr": cfg.ServerAddr,
-			"NeedsPass":  needsPass,
+		return c.Render(http.StatusOK, "servers", map[string]interface{}{
+			"Servers": listServers(cfg),
 		})
 	})
 
+	// The health check only fails for a degraded server, whereas the
+	// readiness check fails until the server can accept clients.
 	e.GET("/health", func(c echo.Context) error {
-		if _, err := quakenet.SendCommandWithTimeout(
-			cfg.ServerAddr,
-			quakenet.GetStatusCommand,
-			1*time.Second,
-		); err != nil {
-			return c.JSON(http.StatusServiceUnavailable, err.Error())
+		if cfg.Lifecycle != nil {
+			switch state, err := cfg.Lifecycle.State(); state {
+			case quakeserver.StateReady:
+				return c.JSON(http.StatusOK, "OK")
+			case quakeserver.StateDegraded:
+				return c.JSON(http.StatusServiceUnavailable, lifecycleStatus(state, err))
+			default:
+				return c.JSON(http.StatusOK, lifecycleStatus(state, err))
+			}
+		}
+		if len(cfg.Servers) == 0 {
+			if err := checkHealth(cfg, "", cfg.ServerAddr); err != nil {
+				return c.JSON(http.StatusServiceUnavailable, err.Error())
+			}
+			return c.JSON(http.StatusOK, "OK")
+		}
+
+		// Every server must be healthy, and the status of each is returned so
+		// that it is obvious which one isn't.
+		code := http.StatusOK
+		status := make(map[string]string)
+		for _, s := range cfg.Servers {
+			status[s.Name] = "OK"
+			if err := checkHealth(cfg, s.Name, s.Addr); err != nil {
+				status[s.Name] = err.Error()
+				code = http.StatusServiceUnavailable
+			}
+		}
+		return c.JSON(code, status)
+	})
+	e.GET("/ready", func(c echo.Context) error {
+		if cfg.Lifecycle != nil {
+			if state, err := cfg.Lifecycle.State(); state != quakeserver.StateReady {
+				return c.JSON(http.StatusServiceUnavailable, lifecycleStatus(state, err))
+			}
+			return c.JSON(http.StatusOK, "OK")
+		}
+		instances := cfg.Servers
+		if len(instances) == 0 {
+			instances = []ServerInstance{{Addr: cfg.ServerAddr}}
+		}
+		// Servers that were stopped while idle must stay ready, otherwise the
+		// requests that wake them up wouldn't be routed here.
+		for _, s := range instances {
+			if err := checkHealth(cfg, s.Name, s.Addr); err != nil {
+				return c.JSON(http.StatusServiceUnavailable, err.Error())
+			}
 		}
 		return c.JSON(http.StatusOK, "OK")
 	})
 	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
 
+	e.GET("/api/config/schema", func(c echo.Context) error {
+		data, err := quakeserver.Schema()
+		if err != nil {
+			return err
+		}
+		return c.JSONBlob(http.StatusOK, data)
+	})
+
 	e.GET("/info", func(c echo.Context) error {
 		m, err := quakenet.GetInfo(cfg.ServerAddr)
 		if err != nil {
@@ -96,22 +203,77 @@ func NewHTTPClientServer(ctx context.Context, cfg *Config) (*HTTPClientServer, e
 		return c.JSON(http.StatusOK, m)
 	})
 
+	g := e.Group("/servers/:name")
+	g.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if _, ok := servers[c.Param("name")]; !ok {
+				return echo.ErrNotFound
+			}
+			return next(c)
+		}
+	})
+	g.GET("", func(c echo.Context) error {
+		s := servers[c.Param("name")]
+		return renderIndex(c, cfg, s.Name, s.Addr, "/servers/"+s.Name)
+	})
+	g.GET("/info", func(c echo.Context) error {
+		m, err := quakenet.GetInfo(servers[c.Param("name")].Addr)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, m)
+	})
+	g.GET("/status", func(c echo.Context) error {
+		m, err := quakenet.GetStatus(servers[c.Param("name")].Addr)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, m)
+	})
+
+	if cfg.DemosDir != "" {
+		e.GET("/demos", func(c echo.Context) error {
+			demos, err := contentutil.ReadDemos(cfg.DemosDir)
+			if err != nil {
+				return err
+			}
+			return c.JSONPretty(http.StatusOK, demos, "    ")
+		})
+		e.GET("/demos/:id", func(c echo.Context) error {
+			d, path, err := contentutil.ReadDemo(cfg.DemosDir, c.Param("id"))
+			if errors.Is(err, os.ErrNotExist) {
+				return c.String(http.StatusNotFound, "demo not found")
+			}
+			if err != nil {
+				return err
+			}
+			return c.Attachment(path, d.File)
+		})
+	}
+
 	e.GET("/*", echo.WrapHandler(http.FileServer(static)))
 
-	// Quake3 assets requests must be proxied to the content server. The host
+	// Quake3 assets and demos requests must be proxied to the content server,
+	// along with the uploads and file management, since a browser can send
+	// them on a connection that was first used for the game client. The host
 	// header is manipulated to ensure that services like CloudFlare will not
 	// reject requests based upon incorrect host header.
 	csurl, err := url.Parse(cfg.ContentServerURL)
 	if err != nil {
 		return nil, err
 	}
-	g := e.Group("/assets")
-	g.Use(middleware.ProxyWithConfig(middleware.ProxyConfig{
+	proxy := middleware.ProxyWithConfig(middleware.ProxyConfig{
 		Balancer: middleware.NewRoundRobinBalancer([]*middleware.ProxyTarget{
 			{URL: csurl},
 		}),
 		Transport: &HostHeaderTransport{RoundTripper: http.DefaultTransport, Host: csurl.Host},
-	}))
+	})
+	e.Group("/assets").Use(proxy)
+	if cfg.DemosDir == "" {
+		e.Group("/demos").Use(proxy)
+	}
+	e.Group("/uploads").Use(proxy)
+	e.Group("/files").Use(proxy)
 	return &HTTPClientServer{
 		Echo: e,
 		ctx:  ctx,
@@ -119,6 +281,96 @@ func NewHTTPClientServer(ctx context.Context, cfg *Config) (*HTTPClientServer, e
 	}, nil
 }
 
+// renderIndex renders the game client page for the server at addr. The path
+// is the websocket path for the server, and is empty for a single server. A
+// server that was stopped while idle is started, and a page that waits for it
+// is rendered instead.
+func renderIndex(c echo.Context, cfg *Config, name, addr, path string) error {
+	if cfg.Waker != nil && cfg.Waker.Wake(name) {
+		return c.Render(http.StatusServiceUnavailable, "starting", map[string]interface{}{
+			"Name":  name,
+			"State": quakeserver.StateStarting,
+		})
+	}
+	m, err := quakenet.GetInfo(addr)
+	if err != nil {
+		return err
+	}
+	needsPass := false
+	if v, ok := m["g_needpass"]; ok {
+		if v == "1" {
+			needsPass = true
+		}
+	}
+	return c.Render(http.StatusOK, "index", map[string]interface{}{
+		"ServerAddr": addr,
+		"NeedsPass":  needsPass,
+		"Game":       m["game"],
+		"Path":       path,
+	})
+}
+
+type serverListing struct {
+	Name       string
+	Online     bool
+	Stopped    bool
+	Map        string
+	GameType   string
+	Players    string
+	MaxPlayers string
+}
+
+// listServers gets the info of every server for the landing page. The
+// servers are queried concurrently with a short timeout, so that a server
+// that is down doesn't hold up the page.
+func listServers(cfg *Config) []serverListing {
+	result := make([]serverListing, len(cfg.Servers))
+	var wg sync.WaitGroup
+	for i, s := range cfg.Servers {
+		wg.Add(1)
+		go func(i int, s ServerInstance) {
+			defer wg.Done()
+
+			result[i].Name = s.Name
+			if cfg.Waker != nil && cfg.Waker.Stopped(s.Name) {
+				result[i].Stopped = true
+				return
+			}
+			m, err := quakenet.GetInfoWithTimeout(s.Addr, 1*time.Second)
+			if err != nil {
+				return
+			}
+			result[i].Online = true
+			result[i].Map = m["mapname"]
+			result[i].Players = m["clients"]
+			result[i].MaxPlayers = m["sv_maxclients"]
+			if n, err := strconv.Atoi(m["gametype"]); err == nil {
+				result[i].GameType = quakeserver.GameType(n).String()
+			}
+		}(i, s)
+	}
+	wg.Wait()
+	return result
+}
+
+func lifecycleStatus(state quakeserver.State, err error) map[string]string {
+	m := map[string]string{"state": string(state)}
+	if err != nil {
+		m["error"] = err.Error()
+	}
+	return m
+}
+
+// checkHealth checks that the server responds to getstatus. Servers that
+// were stopped while idle are healthy.
+func checkHealth(cfg *Config, name, addr string) error {
+	if cfg.Waker != nil && cfg.Waker.Stopped(name) {
+		return nil
+	}
+	_, err := quakenet.SendCommandWithTimeout(addr, quakenet.GetStatusCommand, 1*time.Second)
+	return err
+}
+
 func (h *HTTPClientServer) Serve(l net.Listener) error {
 	s := &http.Server{
 		Handler:        h,
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	contentutil "github.com/ChrisRx/quake-kube/internal/quake/content/util"
+)
+
+// TestContentServerProxy checks that the requests for the content server are
+// proxied to it, whatever their method.
+func TestContentServerProxy(t *testing.T) {
+	var received []string
+	cs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer cs.Close()
+
+	h, err := NewHTTPClientServer(context.Background(), &Config{ContentServerURL: cs.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := []string{
+		"GET /assets/manifest.json",
+		"POST /uploads",
+		"HEAD /uploads/0123",
+		"PATCH /uploads/0123",
+		"POST /uploads/0123/finalize",
+		"DELETE /uploads/0123",
+		"GET /files/baseq3/maps.pk3",
+		"POST /files/move",
+		"DELETE /files/baseq3/maps.pk3",
+	}
+	for _, req := range requests {
+		method, path, _ := strings.Cut(req, " ")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		if rec.Code != http.StatusNoContent {
+			t.Errorf("%s: expected status %d, received %d", req, http.StatusNoContent, rec.Code)
+		}
+	}
+	if strings.Join(received, "\n") != strings.Join(requests, "\n") {
+		t.Errorf("expected requests:\n%s\nreceived:\n%s", strings.Join(requests, "\n"), strings.Join(received, "\n"))
+	}
+}
+
+// TestServeDemos checks that the demos are served from DemosDir, rather than
+// proxied to the content server, when it is set.
+func TestServeDemos(t *testing.T) {
+	cs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request to the content server: %s %s", r.Method, r.URL.Path)
+	}))
+	defer cs.Close()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "match.dm_68")
+	if err := os.WriteFile(src, []byte("demo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := contentutil.SaveDemo(dir, &contentutil.Demo{ID: "match", Map: "q3dm17"}, src); err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewHTTPClientServer(context.Background(), &Config{ContentServerURL: cs.URL, DemosDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/demos", nil))
+	var demos []*contentutil.Demo
+	if err := json.Unmarshal(rec.Body.Bytes(), &demos); err != nil {
+		t.Fatal(err)
+	}
+	if len(demos) != 1 || demos[0].ID != "match" {
+		t.Errorf("expected demo match, received %s", rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/demos/match", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "demo" {
+		t.Errorf("expected demo file, received %d %q", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/demos/missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, received %d", http.StatusNotFound, rec.Code)
+	}
+}
@@ -3,10 +3,12 @@ package client
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -24,6 +26,10 @@ var DefaultUpgrader = &websocket.Upgrader{
 type WebsocketUDPProxy struct {
 	Upgrader *websocket.Upgrader
 
+	// Waker, when set, starts the server if it was stopped while idle. The
+	// client retries connecting while the server is starting.
+	Waker Waker
+
 	ctx  context.Context
 	addr net.Addr
 }
@@ -46,8 +52,12 @@ func NewProxy(ctx context.Context, addr string) (*WebsocketUDPProxy, error) {
 }
 
 func (w *WebsocketUDPProxy) Serve(l net.Listener) error {
+	return serveWebsocket(w.ctx, l, w)
+}
+
+func serveWebsocket(ctx context.Context, l net.Listener, h http.Handler) error {
 	s := &http.Server{
-		Handler: w,
+		Handler: h,
 	}
 
 	errch := make(chan error, 1)
@@ -62,12 +72,79 @@ func (w *WebsocketUDPProxy) Serve(l net.Listener) error {
 	select {
 	case err := <-errch:
 		return err
-	case <-w.ctx.Done():
+	case <-ctx.Done():
 		return s.Close()
 	}
 }
 
+// ServerInstance is one of several dedicated servers that are served by the
+// same client.
+type ServerInstance struct {
+	Name string
+	Addr string
+}
+
+// WebsocketUDPRouter proxies websocket connections to one of several
+// dedicated servers, selected by the URL path /servers/<name>. Connections to
+// any other path go to the first server.
+type WebsocketUDPRouter struct {
+	// Waker, when set, starts the server if it was stopped while idle.
+	Waker Waker
+
+	ctx     context.Context
+	proxies map[string]*WebsocketUDPProxy
+	def     string
+}
+
+func NewProxyRouter(ctx context.Context, servers []ServerInstance) (*WebsocketUDPRouter, error) {
+	if len(servers) == 0 {
+		return nil, errors.New("wsproxy: must have at least one server")
+	}
+	r := &WebsocketUDPRouter{
+		ctx:     ctx,
+		proxies: make(map[string]*WebsocketUDPProxy),
+	}
+	for _, s := range servers {
+		p, err := NewProxy(ctx, s.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("wsproxy: %s: %w", s.Name, err)
+		}
+		r.proxies[s.Name] = p
+		if r.def == "" {
+			r.def = s.Name
+		}
+	}
+	return r, nil
+}
+
+func (r *WebsocketUDPRouter) Serve(l net.Listener) error {
+	return serveWebsocket(r.ctx, l, r)
+}
+
+func (r *WebsocketUDPRouter) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	name := r.def
+	if path, ok := strings.CutPrefix(req.URL.Path, "/servers/"); ok {
+		name = strings.TrimSuffix(path, "/")
+	}
+	p, ok := r.proxies[name]
+	if !ok {
+		http.NotFound(rw, req)
+		return
+	}
+	if r.Waker != nil {
+		r.Waker.Wake(name)
+	}
+	p.serve(rw, req)
+}
+
 func (w *WebsocketUDPProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if w.Waker != nil {
+		w.Waker.Wake("")
+	}
+	w.serve(rw, req)
+}
+
+func (w *WebsocketUDPProxy) serve(rw http.ResponseWriter, req *http.Request) {
 	ctx, cancel := context.WithCancel(req.Context())
 	defer cancel()
 
@@ -6,29 +6,33 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
 	"google.golang.org/protobuf/types/known/emptypb"
 
-	quakecontentutil "github.com/ChrisRx/quake-kube/internal/quake/content/util"
+	"github.com/ChrisRx/quake-kube/internal/log"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/auth"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/manifest"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/storage"
 	fsutil "github.com/ChrisRx/quake-kube/internal/util/fs"
 )
 
 type AssetsService struct {
 	UnimplementedAssetsServer
 
-	dir string
+	storage storage.Storage
+	index   *manifest.Index
 }
 
-func NewAssetsService(assetsDir string) *AssetsService {
-	return &AssetsService{dir: assetsDir}
+func NewAssetsService(s storage.Storage, index *manifest.Index) *AssetsService {
+	return &AssetsService{storage: s, index: index}
 }
 
 func (s *AssetsService) GetManifest(ctx context.Context, req *emptypb.Empty) (*Manifest, error) {
 	m := &Manifest{}
-	files, err := quakecontentutil.ReadManifest(s.dir)
+	files, _, err := s.index.Files()
 	if err != nil {
 		return nil, err
 	}
@@ -42,7 +46,26 @@ func (s *AssetsService) GetManifest(ctx context.Context, req *emptypb.Empty) (*M
 	return m, nil
 }
 
+// FileUpload writes the uploaded file, when it is within the quota of the
+// caller.
 func (s *AssetsService) FileUpload(ctx context.Context, req *FileUploadRequest) (*FileUploadResponse, error) {
+	id := auth.FromContext(ctx)
+	release, err := id.ReserveUpload(int64(len(req.File)))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.fileUpload(ctx, req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	log.Info("installed upload", "name", req.Name, "size", len(req.File), "identity", id.String())
+	return resp, nil
+}
+
+func (s *AssetsService) fileUpload(ctx context.Context, req *FileUploadRequest) (*FileUploadResponse, error) {
+	defer s.index.Invalidate()
+
 	if req.GameName == "" {
 		req.GameName = "baseq3"
 	}
@@ -62,13 +85,7 @@ func (s *AssetsService) FileUpload(ctx context.Context, req *FileUploadRequest)
 			}
 			defer pak.Close()
 
-			dst, err := os.Create(filepath.Join(s.dir, req.GameName, filepath.Base(f.Name)))
-			if err != nil {
-				return nil, err
-			}
-			defer dst.Close()
-
-			if _, err = io.Copy(dst, pak); err != nil {
+			if err := s.writeFile(ctx, path.Join(req.GameName, path.Base(f.Name)), pak); err != nil {
 				return nil, err
 			}
 			files = append(files, filepath.Base(f.Name))
@@ -82,13 +99,7 @@ func (s *AssetsService) FileUpload(ctx context.Context, req *FileUploadRequest)
 			Message: fmt.Sprintf("Loaded the following map packs from file %s:\n%s", req.Name, strings.Join(files, "\n")),
 		}, nil
 	}
-	dst, err := os.Create(filepath.Join(s.dir, req.GameName, req.Name))
-	if err != nil {
-		return nil, err
-	}
-	defer dst.Close()
-
-	if _, err = io.Copy(dst, bytes.NewReader(req.File)); err != nil {
+	if err := s.writeFile(ctx, path.Join(req.GameName, req.Name), bytes.NewReader(req.File)); err != nil {
 		return nil, err
 	}
 	return &FileUploadResponse{
@@ -97,3 +108,16 @@ func (s *AssetsService) FileUpload(ctx context.Context, req *FileUploadRequest)
 		Message: fmt.Sprintf("File %s uploaded successfully.", filepath.Join(req.GameName, req.Name)),
 	}, nil
 }
+
+func (s *AssetsService) writeFile(ctx context.Context, name string, r io.Reader) error {
+	w, err := s.storage.Create(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	return w.Commit()
+}
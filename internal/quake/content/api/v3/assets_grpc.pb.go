@@ -0,0 +1,549 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.24.4
+// source: content/v3/assets.proto
+
+package v3
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Assets_GetManifest_FullMethodName           = "/content.service.v3.Assets/GetManifest"
+	Assets_UploadFile_FullMethodName            = "/content.service.v3.Assets/UploadFile"
+	Assets_CreateUploadSession_FullMethodName   = "/content.service.v3.Assets/CreateUploadSession"
+	Assets_GetUploadSession_FullMethodName      = "/content.service.v3.Assets/GetUploadSession"
+	Assets_WriteUploadSession_FullMethodName    = "/content.service.v3.Assets/WriteUploadSession"
+	Assets_FinalizeUploadSession_FullMethodName = "/content.service.v3.Assets/FinalizeUploadSession"
+	Assets_DeleteUploadSession_FullMethodName   = "/content.service.v3.Assets/DeleteUploadSession"
+	Assets_DeleteFile_FullMethodName            = "/content.service.v3.Assets/DeleteFile"
+	Assets_MoveFile_FullMethodName              = "/content.service.v3.Assets/MoveFile"
+	Assets_StatFile_FullMethodName              = "/content.service.v3.Assets/StatFile"
+	Assets_ListMaps_FullMethodName              = "/content.service.v3.Assets/ListMaps"
+)
+
+// AssetsClient is the client API for Assets service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AssetsClient interface {
+	GetManifest(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*Manifest, error)
+	UploadFile(ctx context.Context, opts ...grpc.CallOption) (Assets_UploadFileClient, error)
+	CreateUploadSession(ctx context.Context, in *CreateUploadSessionRequest, opts ...grpc.CallOption) (*UploadSession, error)
+	GetUploadSession(ctx context.Context, in *GetUploadSessionRequest, opts ...grpc.CallOption) (*UploadSession, error)
+	WriteUploadSession(ctx context.Context, opts ...grpc.CallOption) (Assets_WriteUploadSessionClient, error)
+	FinalizeUploadSession(ctx context.Context, in *FinalizeUploadSessionRequest, opts ...grpc.CallOption) (*UploadFileResponse, error)
+	DeleteUploadSession(ctx context.Context, in *DeleteUploadSessionRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	DeleteFile(ctx context.Context, in *DeleteFileRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	MoveFile(ctx context.Context, in *MoveFileRequest, opts ...grpc.CallOption) (*File, error)
+	StatFile(ctx context.Context, in *StatFileRequest, opts ...grpc.CallOption) (*StatFileResponse, error)
+	ListMaps(ctx context.Context, in *ListMapsRequest, opts ...grpc.CallOption) (*ListMapsResponse, error)
+}
+
+type assetsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAssetsClient(cc grpc.ClientConnInterface) AssetsClient {
+	return &assetsClient{cc}
+}
+
+func (c *assetsClient) GetManifest(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*Manifest, error) {
+	out := new(Manifest)
+	err := c.cc.Invoke(ctx, Assets_GetManifest_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *assetsClient) UploadFile(ctx context.Context, opts ...grpc.CallOption) (Assets_UploadFileClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Assets_ServiceDesc.Streams[0], Assets_UploadFile_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &assetsUploadFileClient{stream}
+	return x, nil
+}
+
+type Assets_UploadFileClient interface {
+	Send(*UploadFileRequest) error
+	CloseAndRecv() (*UploadFileResponse, error)
+	grpc.ClientStream
+}
+
+type assetsUploadFileClient struct {
+	grpc.ClientStream
+}
+
+func (x *assetsUploadFileClient) Send(m *UploadFileRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *assetsUploadFileClient) CloseAndRecv() (*UploadFileResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(UploadFileResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *assetsClient) CreateUploadSession(ctx context.Context, in *CreateUploadSessionRequest, opts ...grpc.CallOption) (*UploadSession, error) {
+	out := new(UploadSession)
+	err := c.cc.Invoke(ctx, Assets_CreateUploadSession_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *assetsClient) GetUploadSession(ctx context.Context, in *GetUploadSessionRequest, opts ...grpc.CallOption) (*UploadSession, error) {
+	out := new(UploadSession)
+	err := c.cc.Invoke(ctx, Assets_GetUploadSession_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *assetsClient) WriteUploadSession(ctx context.Context, opts ...grpc.CallOption) (Assets_WriteUploadSessionClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Assets_ServiceDesc.Streams[1], Assets_WriteUploadSession_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &assetsWriteUploadSessionClient{stream}
+	return x, nil
+}
+
+type Assets_WriteUploadSessionClient interface {
+	Send(*WriteUploadSessionRequest) error
+	CloseAndRecv() (*UploadSession, error)
+	grpc.ClientStream
+}
+
+type assetsWriteUploadSessionClient struct {
+	grpc.ClientStream
+}
+
+func (x *assetsWriteUploadSessionClient) Send(m *WriteUploadSessionRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *assetsWriteUploadSessionClient) CloseAndRecv() (*UploadSession, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(UploadSession)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *assetsClient) FinalizeUploadSession(ctx context.Context, in *FinalizeUploadSessionRequest, opts ...grpc.CallOption) (*UploadFileResponse, error) {
+	out := new(UploadFileResponse)
+	err := c.cc.Invoke(ctx, Assets_FinalizeUploadSession_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *assetsClient) DeleteUploadSession(ctx context.Context, in *DeleteUploadSessionRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Assets_DeleteUploadSession_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *assetsClient) DeleteFile(ctx context.Context, in *DeleteFileRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Assets_DeleteFile_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *assetsClient) MoveFile(ctx context.Context, in *MoveFileRequest, opts ...grpc.CallOption) (*File, error) {
+	out := new(File)
+	err := c.cc.Invoke(ctx, Assets_MoveFile_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *assetsClient) StatFile(ctx context.Context, in *StatFileRequest, opts ...grpc.CallOption) (*StatFileResponse, error) {
+	out := new(StatFileResponse)
+	err := c.cc.Invoke(ctx, Assets_StatFile_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *assetsClient) ListMaps(ctx context.Context, in *ListMapsRequest, opts ...grpc.CallOption) (*ListMapsResponse, error) {
+	out := new(ListMapsResponse)
+	err := c.cc.Invoke(ctx, Assets_ListMaps_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AssetsServer is the server API for Assets service.
+// All implementations must embed UnimplementedAssetsServer
+// for forward compatibility
+type AssetsServer interface {
+	GetManifest(context.Context, *emptypb.Empty) (*Manifest, error)
+	UploadFile(Assets_UploadFileServer) error
+	CreateUploadSession(context.Context, *CreateUploadSessionRequest) (*UploadSession, error)
+	GetUploadSession(context.Context, *GetUploadSessionRequest) (*UploadSession, error)
+	WriteUploadSession(Assets_WriteUploadSessionServer) error
+	FinalizeUploadSession(context.Context, *FinalizeUploadSessionRequest) (*UploadFileResponse, error)
+	DeleteUploadSession(context.Context, *DeleteUploadSessionRequest) (*emptypb.Empty, error)
+	DeleteFile(context.Context, *DeleteFileRequest) (*emptypb.Empty, error)
+	MoveFile(context.Context, *MoveFileRequest) (*File, error)
+	StatFile(context.Context, *StatFileRequest) (*StatFileResponse, error)
+	ListMaps(context.Context, *ListMapsRequest) (*ListMapsResponse, error)
+	mustEmbedUnimplementedAssetsServer()
+}
+
+// UnimplementedAssetsServer must be embedded to have forward compatible implementations.
+type UnimplementedAssetsServer struct {
+}
+
+func (UnimplementedAssetsServer) GetManifest(context.Context, *emptypb.Empty) (*Manifest, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetManifest not implemented")
+}
+func (UnimplementedAssetsServer) UploadFile(Assets_UploadFileServer) error {
+	return status.Errorf(codes.Unimplemented, "method UploadFile not implemented")
+}
+func (UnimplementedAssetsServer) CreateUploadSession(context.Context, *CreateUploadSessionRequest) (*UploadSession, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateUploadSession not implemented")
+}
+func (UnimplementedAssetsServer) GetUploadSession(context.Context, *GetUploadSessionRequest) (*UploadSession, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUploadSession not implemented")
+}
+func (UnimplementedAssetsServer) WriteUploadSession(Assets_WriteUploadSessionServer) error {
+	return status.Errorf(codes.Unimplemented, "method WriteUploadSession not implemented")
+}
+func (UnimplementedAssetsServer) FinalizeUploadSession(context.Context, *FinalizeUploadSessionRequest) (*UploadFileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FinalizeUploadSession not implemented")
+}
+func (UnimplementedAssetsServer) DeleteUploadSession(context.Context, *DeleteUploadSessionRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteUploadSession not implemented")
+}
+func (UnimplementedAssetsServer) DeleteFile(context.Context, *DeleteFileRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteFile not implemented")
+}
+func (UnimplementedAssetsServer) MoveFile(context.Context, *MoveFileRequest) (*File, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MoveFile not implemented")
+}
+func (UnimplementedAssetsServer) StatFile(context.Context, *StatFileRequest) (*StatFileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StatFile not implemented")
+}
+func (UnimplementedAssetsServer) ListMaps(context.Context, *ListMapsRequest) (*ListMapsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMaps not implemented")
+}
+func (UnimplementedAssetsServer) mustEmbedUnimplementedAssetsServer() {}
+
+// UnsafeAssetsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AssetsServer will
+// result in compilation errors.
+type UnsafeAssetsServer interface {
+	mustEmbedUnimplementedAssetsServer()
+}
+
+func RegisterAssetsServer(s grpc.ServiceRegistrar, srv AssetsServer) {
+	s.RegisterService(&Assets_ServiceDesc, srv)
+}
+
+func _Assets_GetManifest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AssetsServer).GetManifest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Assets_GetManifest_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AssetsServer).GetManifest(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Assets_UploadFile_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AssetsServer).UploadFile(&assetsUploadFileServer{stream})
+}
+
+type Assets_UploadFileServer interface {
+	SendAndClose(*UploadFileResponse) error
+	Recv() (*UploadFileRequest, error)
+	grpc.ServerStream
+}
+
+type assetsUploadFileServer struct {
+	grpc.ServerStream
+}
+
+func (x *assetsUploadFileServer) SendAndClose(m *UploadFileResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *assetsUploadFileServer) Recv() (*UploadFileRequest, error) {
+	m := new(UploadFileRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Assets_CreateUploadSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateUploadSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AssetsServer).CreateUploadSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Assets_CreateUploadSession_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AssetsServer).CreateUploadSession(ctx, req.(*CreateUploadSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Assets_GetUploadSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUploadSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AssetsServer).GetUploadSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Assets_GetUploadSession_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AssetsServer).GetUploadSession(ctx, req.(*GetUploadSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Assets_WriteUploadSession_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AssetsServer).WriteUploadSession(&assetsWriteUploadSessionServer{stream})
+}
+
+type Assets_WriteUploadSessionServer interface {
+	SendAndClose(*UploadSession) error
+	Recv() (*WriteUploadSessionRequest, error)
+	grpc.ServerStream
+}
+
+type assetsWriteUploadSessionServer struct {
+	grpc.ServerStream
+}
+
+func (x *assetsWriteUploadSessionServer) SendAndClose(m *UploadSession) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *assetsWriteUploadSessionServer) Recv() (*WriteUploadSessionRequest, error) {
+	m := new(WriteUploadSessionRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Assets_FinalizeUploadSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FinalizeUploadSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AssetsServer).FinalizeUploadSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Assets_FinalizeUploadSession_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AssetsServer).FinalizeUploadSession(ctx, req.(*FinalizeUploadSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Assets_DeleteUploadSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteUploadSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AssetsServer).DeleteUploadSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Assets_DeleteUploadSession_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AssetsServer).DeleteUploadSession(ctx, req.(*DeleteUploadSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Assets_DeleteFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AssetsServer).DeleteFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Assets_DeleteFile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AssetsServer).DeleteFile(ctx, req.(*DeleteFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Assets_MoveFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MoveFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AssetsServer).MoveFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Assets_MoveFile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AssetsServer).MoveFile(ctx, req.(*MoveFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Assets_StatFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AssetsServer).StatFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Assets_StatFile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AssetsServer).StatFile(ctx, req.(*StatFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Assets_ListMaps_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMapsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AssetsServer).ListMaps(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Assets_ListMaps_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AssetsServer).ListMaps(ctx, req.(*ListMapsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Assets_ServiceDesc is the grpc.ServiceDesc for Assets service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Assets_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "content.service.v3.Assets",
+	HandlerType: (*AssetsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetManifest",
+			Handler:    _Assets_GetManifest_Handler,
+		},
+		{
+			MethodName: "CreateUploadSession",
+			Handler:    _Assets_CreateUploadSession_Handler,
+		},
+		{
+			MethodName: "GetUploadSession",
+			Handler:    _Assets_GetUploadSession_Handler,
+		},
+		{
+			MethodName: "FinalizeUploadSession",
+			Handler:    _Assets_FinalizeUploadSession_Handler,
+		},
+		{
+			MethodName: "DeleteUploadSession",
+			Handler:    _Assets_DeleteUploadSession_Handler,
+		},
+		{
+			MethodName: "DeleteFile",
+			Handler:    _Assets_DeleteFile_Handler,
+		},
+		{
+			MethodName: "MoveFile",
+			Handler:    _Assets_MoveFile_Handler,
+		},
+		{
+			MethodName: "StatFile",
+			Handler:    _Assets_StatFile_Handler,
+		},
+		{
+			MethodName: "ListMaps",
+			Handler:    _Assets_ListMaps_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "UploadFile",
+			Handler:       _Assets_UploadFile_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "WriteUploadSession",
+			Handler:       _Assets_WriteUploadSession_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "content/v3/assets.proto",
+}
@@ -0,0 +1,285 @@
+package v3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ChrisRx/quake-kube/internal/log"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/auth"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/manifest"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/storage"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/upload"
+	quakecontentutil "github.com/ChrisRx/quake-kube/internal/quake/content/util"
+)
+
+type AssetsService struct {
+	UnimplementedAssetsServer
+
+	storage storage.Storage
+	uploads *upload.Store
+	index   *manifest.Index
+
+	// UsedMaps returns the maps used by the server, whose map packs can't be
+	// deleted unless forced.
+	UsedMaps func() ([]quakecontentutil.UsedMap, error)
+}
+
+func NewAssetsService(s storage.Storage, uploads *upload.Store, index *manifest.Index) *AssetsService {
+	return &AssetsService{storage: s, uploads: uploads, index: index}
+}
+
+func (s *AssetsService) GetManifest(ctx context.Context, req *emptypb.Empty) (*Manifest, error) {
+	m := &Manifest{}
+	files, _, err := s.index.Files()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		m.Files = append(m.Files, &File{
+			Name:       f.Name,
+			Checksum:   f.Checksum,
+			Compressed: f.Compressed,
+		})
+	}
+	return m, nil
+}
+
+// UploadFile receives a file as a header followed by chunks. The file is
+// written to a temporary file in the staging directory, and only installed
+// once the size and checksum match the header, so a failed upload never
+// replaces an existing file. The map packs in zip files are extracted.
+func (s *AssetsService) UploadFile(stream Assets_UploadFileServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if req.GetHeader() == nil {
+		return status.Error(codes.InvalidArgument, "the first message must be the header")
+	}
+	hdr := toHeader(req.GetHeader())
+	if err := hdr.Validate(); err != nil {
+		return toStatus(err)
+	}
+	w, err := s.uploads.NewWriter(stream.Context(), hdr)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(req.GetChunk()); err != nil {
+			return toStatus(err)
+		}
+	}
+	result, err := w.Commit()
+	if err != nil {
+		return toStatus(err)
+	}
+	s.index.Invalidate()
+	return stream.SendAndClose(&UploadFileResponse{
+		Name:    hdr.Name,
+		Size:    hdr.Size,
+		Message: result.Message,
+		Files:   result.Files,
+	})
+}
+
+func (s *AssetsService) CreateUploadSession(ctx context.Context, req *CreateUploadSessionRequest) (*UploadSession, error) {
+	if req.Header == nil {
+		return nil, status.Error(codes.InvalidArgument, "missing header")
+	}
+	sess, err := s.uploads.Create(ctx, *toHeader(req.Header))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toUploadSession(sess), nil
+}
+
+func (s *AssetsService) GetUploadSession(ctx context.Context, req *GetUploadSessionRequest) (*UploadSession, error) {
+	sess, err := s.uploads.Get(req.Id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toUploadSession(sess), nil
+}
+
+// WriteUploadSession appends the chunks to a session. The chunks received
+// before the stream breaks are kept, and the upload is resumed from the
+// offset returned by GetUploadSession.
+func (s *AssetsService) WriteUploadSession(stream Assets_WriteUploadSessionServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	sess, err := s.uploads.Write(stream.Context(), req.Id, req.Offset, &chunkReader{stream: stream, buf: req.Chunk})
+	if err != nil {
+		return toStatus(err)
+	}
+	return stream.SendAndClose(toUploadSession(sess))
+}
+
+func (s *AssetsService) FinalizeUploadSession(ctx context.Context, req *FinalizeUploadSessionRequest) (*UploadFileResponse, error) {
+	sess, err := s.uploads.Get(req.Id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	result, err := s.uploads.Finalize(ctx, req.Id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	s.index.Invalidate()
+	return &UploadFileResponse{
+		Name:    sess.Name,
+		Size:    sess.Size,
+		Message: result.Message,
+		Files:   result.Files,
+	}, nil
+}
+
+func (s *AssetsService) DeleteUploadSession(ctx context.Context, req *DeleteUploadSessionRequest) (*emptypb.Empty, error) {
+	if err := s.uploads.Delete(ctx, req.Id); err != nil {
+		return nil, toStatus(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *AssetsService) DeleteFile(ctx context.Context, req *DeleteFileRequest) (*emptypb.Empty, error) {
+	used, err := s.usedMaps(req.Force)
+	if err != nil {
+		return nil, err
+	}
+	if err := quakecontentutil.DeleteFile(ctx, s.storage, req.Name, used); err != nil {
+		return nil, toStatus(err)
+	}
+	log.Info("deleted file", "name", req.Name, "identity", auth.FromContext(ctx).String())
+	s.index.Invalidate()
+	return &emptypb.Empty{}, nil
+}
+
+func (s *AssetsService) MoveFile(ctx context.Context, req *MoveFileRequest) (*File, error) {
+	used, err := s.usedMaps(req.Force)
+	if err != nil {
+		return nil, err
+	}
+	f, err := s.index.MoveFile(ctx, req.Name, req.NewName, used)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	log.Info("moved file", "name", req.Name, "newName", req.NewName, "identity", auth.FromContext(ctx).String())
+	return &File{Name: f.Name, Checksum: f.Checksum, Compressed: f.Compressed}, nil
+}
+
+// usedMaps returns the maps used by the server, or none when force is set.
+func (s *AssetsService) usedMaps(force bool) ([]quakecontentutil.UsedMap, error) {
+	if force || s.UsedMaps == nil {
+		return nil, nil
+	}
+	return s.UsedMaps()
+}
+
+func (s *AssetsService) StatFile(ctx context.Context, req *StatFileRequest) (*StatFileResponse, error) {
+	info, err := s.index.Stat(ctx, req.Name)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &StatFileResponse{
+		File:    &File{Name: info.Name, Checksum: info.Checksum, Compressed: info.Compressed},
+		ModTime: timestamppb.New(info.ModTime),
+		Maps:    info.Maps,
+	}, nil
+}
+
+func (s *AssetsService) ListMaps(ctx context.Context, req *ListMapsRequest) (*ListMapsResponse, error) {
+	maps, err := s.index.Maps()
+	if err != nil {
+		return nil, err
+	}
+	if req.GameName != "" {
+		maps = quakecontentutil.FilterGameMaps(maps, req.GameName)
+	}
+	resp := &ListMapsResponse{}
+	for _, m := range maps {
+		resp.Maps = append(resp.Maps, &Map{File: m.File, Name: m.Name, Entities: m.Entities})
+	}
+	return resp, nil
+}
+
+// chunkReader reads the chunks of a WriteUploadSession stream.
+type chunkReader struct {
+	stream Assets_WriteUploadSessionServer
+	buf    []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		req, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		r.buf = req.Chunk
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func toHeader(h *UploadFileHeader) *upload.Header {
+	return &upload.Header{
+		Name:     h.Name,
+		GameName: h.GameName,
+		Size:     h.Size,
+		Checksum: h.Checksum,
+	}
+}
+
+func toUploadSession(sess *upload.Session) *UploadSession {
+	return &UploadSession{
+		Id: sess.ID,
+		Header: &UploadFileHeader{
+			Name:     sess.Name,
+			GameName: sess.GameName,
+			Size:     sess.Size,
+			Checksum: sess.Checksum,
+		},
+		Offset:  sess.Offset,
+		Expires: timestamppb.New(sess.Expires),
+	}
+}
+
+// toStatus returns the gRPC status for the errors of the upload package, and
+// of the file operations.
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, upload.ErrInvalid), errors.Is(err, upload.ErrTooLarge), errors.Is(err, upload.ErrIncomplete), errors.Is(err, quakecontentutil.ErrInvalidName):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, upload.ErrChecksumMismatch):
+		return status.Error(codes.DataLoss, err.Error())
+	case errors.Is(err, upload.ErrNotFound), errors.Is(err, os.ErrNotExist):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, upload.ErrOffsetMismatch):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, upload.ErrBusy):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, os.ErrExist):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, quakecontentutil.ErrFileInUse):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, auth.ErrQuotaExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return err
+}
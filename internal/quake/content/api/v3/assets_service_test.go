@@ -0,0 +1,376 @@
+package v3
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/ChrisRx/quake-kube/internal/quake/content/manifest"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/storage"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/upload"
+	quakecontentutil "github.com/ChrisRx/quake-kube/internal/quake/content/util"
+)
+
+func newTestClient(t *testing.T, dir string) AssetsClient {
+	t.Helper()
+
+	s := storage.NewLocal(dir)
+	return serveTestClient(t, NewAssetsService(s, upload.NewStore(dir, s), manifest.NewIndex(s)))
+}
+
+func serveTestClient(t *testing.T, svc *AssetsService) AssetsClient {
+	t.Helper()
+
+	l := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	RegisterAssetsServer(s, svc)
+	go s.Serve(l)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return l.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewAssetsClient(conn)
+}
+
+func uploadFile(client AssetsClient, hdr *UploadFileHeader, data []byte, chunkSize int) (*UploadFileResponse, error) {
+	stream, err := client.UploadFile(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.Send(&UploadFileRequest{Request: &UploadFileRequest_Header{Header: hdr}}); err != nil {
+		return nil, err
+	}
+	for len(data) > 0 {
+		n := min(chunkSize, len(data))
+		if err := stream.Send(&UploadFileRequest{Request: &UploadFileRequest_Chunk{Chunk: data[:n]}}); err != nil {
+			break
+		}
+		data = data[n:]
+	}
+	return stream.CloseAndRecv()
+}
+
+func TestUploadFile(t *testing.T) {
+	dir := t.TempDir()
+	client := newTestClient(t, dir)
+
+	// Larger than the default maximum message size of 4MB.
+	data := bytes.Repeat([]byte("pak0"), 5<<20/4)
+	resp, err := uploadFile(client, &UploadFileHeader{
+		Name:     "maps.pk3",
+		Size:     int64(len(data)),
+		Checksum: crc32.ChecksumIEEE(data),
+	}, data, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"baseq3/maps.pk3"}, resp.Files); diff != "" {
+		t.Errorf("v3: after UploadFile differs: (-want +got)\n%s", diff)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "baseq3", "maps.pk3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("v3: uploaded file differs")
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"mappack/ztn.pk3", "mappack/readme.txt"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(name))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = uploadFile(client, &UploadFileHeader{
+		Name:     "mappack.zip",
+		GameName: "osp",
+		Size:     int64(buf.Len()),
+		Checksum: crc32.ChecksumIEEE(buf.Bytes()),
+	}, buf.Bytes(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"osp/ztn.pk3"}, resp.Files); diff != "" {
+		t.Errorf("v3: after UploadFile of zip differs: (-want +got)\n%s", diff)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "osp", "mappack.zip")); !os.IsNotExist(err) {
+		t.Errorf("expected zip file to not be kept, received %v", err)
+	}
+}
+
+func TestUploadFileErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		hdr  *UploadFileHeader
+		data []byte
+		code codes.Code
+	}{
+		{
+			name: "checksum mismatch",
+			hdr:  &UploadFileHeader{Name: "maps.pk3", Size: 4, Checksum: 1},
+			data: []byte("pak0"),
+			code: codes.DataLoss,
+		},
+		{
+			name: "incomplete",
+			hdr:  &UploadFileHeader{Name: "maps.pk3", Size: 8, Checksum: crc32.ChecksumIEEE([]byte("pak0"))},
+			data: []byte("pak0"),
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "too large",
+			hdr:  &UploadFileHeader{Name: "maps.pk3", Size: 2},
+			data: []byte("pak0"),
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "path in name",
+			hdr:  &UploadFileHeader{Name: "../maps.pk3", Size: 4, Checksum: crc32.ChecksumIEEE([]byte("pak0"))},
+			data: []byte("pak0"),
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "path in game name",
+			hdr:  &UploadFileHeader{Name: "maps.pk3", GameName: "..", Size: 4, Checksum: crc32.ChecksumIEEE([]byte("pak0"))},
+			data: []byte("pak0"),
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "not a map pack",
+			hdr:  &UploadFileHeader{Name: "server.cfg", Size: 4, Checksum: crc32.ChecksumIEEE([]byte("pak0"))},
+			data: []byte("pak0"),
+			code: codes.InvalidArgument,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			client := newTestClient(t, dir)
+			_, err := uploadFile(client, c.hdr, c.data, 1)
+			if code := status.Code(err); code != c.code {
+				t.Fatalf("expected code %v, received %v", c.code, err)
+			}
+			// Nothing is left behind by a failed upload.
+			files, err := filepath.Glob(filepath.Join(dir, "*", "*"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 0 {
+				t.Fatalf("expected no files, received %v", files)
+			}
+		})
+	}
+}
+
+func TestUploadSession(t *testing.T) {
+	dir := t.TempDir()
+	client := newTestClient(t, dir)
+	ctx := context.Background()
+
+	data := bytes.Repeat([]byte("pak0"), 1024)
+	sess, err := client.CreateUploadSession(ctx, &CreateUploadSessionRequest{
+		Header: &UploadFileHeader{Name: "maps.pk3", Size: int64(len(data)), Checksum: crc32.ChecksumIEEE(data)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first write is cancelled part way through, like a dropped
+	// connection, and the data received until then is kept.
+	wctx, cancel := context.WithCancel(ctx)
+	stream, err := client.WriteUploadSession(wctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&WriteUploadSessionRequest{Id: sess.Id, Chunk: data[:1000]}); err != nil {
+		t.Fatal(err)
+	}
+	var offset int64
+	for i := 0; i < 100 && offset == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		s, err := client.GetUploadSession(ctx, &GetUploadSessionRequest{Id: sess.Id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset = s.Offset
+	}
+	cancel()
+	if offset != 1000 {
+		t.Fatalf("expected offset 1000, received %d", offset)
+	}
+
+	if _, err := client.FinalizeUploadSession(ctx, &FinalizeUploadSessionRequest{Id: sess.Id}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected incomplete session to not be finalized, received %v", err)
+	}
+
+	// Writes must continue at the offset. The cancelled write can still be
+	// ending, in which case the session is busy.
+	_, err = writeSession(client, sess.Id, 0, data)
+	for i := 0; i < 100 && status.Code(err) == codes.Aborted; i++ {
+		time.Sleep(10 * time.Millisecond)
+		_, err = writeSession(client, sess.Id, 0, data)
+	}
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected offset mismatch, received %v", err)
+	}
+	s, err := writeSession(client, sess.Id, offset, data[offset:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Offset != int64(len(data)) {
+		t.Fatalf("expected offset %d, received %d", len(data), s.Offset)
+	}
+
+	resp, err := client.FinalizeUploadSession(ctx, &FinalizeUploadSessionRequest{Id: sess.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"baseq3/maps.pk3"}, resp.Files); diff != "" {
+		t.Errorf("v3: after FinalizeUploadSession differs: (-want +got)\n%s", diff)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "baseq3", "maps.pk3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("v3: uploaded file differs")
+	}
+	if _, err := client.GetUploadSession(ctx, &GetUploadSessionRequest{Id: sess.Id}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected session to be removed, received %v", err)
+	}
+}
+
+func writeSession(client AssetsClient, id string, offset int64, data []byte) (*UploadSession, error) {
+	stream, err := client.WriteUploadSession(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.Send(&WriteUploadSessionRequest{Id: id, Offset: offset}); err != nil {
+		return nil, err
+	}
+	for len(data) > 0 {
+		n := min(1000, len(data))
+		if err := stream.Send(&WriteUploadSessionRequest{Chunk: data[:n]}); err != nil {
+			break
+		}
+		data = data[n:]
+	}
+	return stream.CloseAndRecv()
+}
+
+// newTestMapPack returns a pk3 file with empty maps.
+func newTestMapPack(t *testing.T, maps ...string) []byte {
+	t.Helper()
+
+	// The header of a BSP file, with the entities lump directly after it.
+	const lumps = 17
+	var bsp bytes.Buffer
+	bsp.WriteString("IBSP")
+	header := make([]int32, 1+lumps*2)
+	header[0] = 46
+	header[1] = 8 + lumps*8
+	binary.Write(&bsp, binary.LittleEndian, header)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range maps {
+		w, err := zw.Create("maps/" + name + ".bsp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(bsp.Bytes())
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	s := storage.NewLocal(dir)
+	svc := NewAssetsService(s, upload.NewStore(dir, s), manifest.NewIndex(s))
+	svc.UsedMaps = func() ([]quakecontentutil.UsedMap, error) {
+		return []quakecontentutil.UsedMap{{Name: "q3dm17", Game: "baseq3"}}, nil
+	}
+	client := serveTestClient(t, svc)
+	ctx := context.Background()
+
+	if err := os.MkdirAll(filepath.Join(dir, "baseq3"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "baseq3", "maps.pk3"), newTestMapPack(t, "q3dm17", "ztn"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	maps, err := client.ListMaps(ctx, &ListMapsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, m := range maps.Maps {
+		names = append(names, m.File+":"+m.Name)
+	}
+	if diff := cmp.Diff([]string{"baseq3/maps.pk3:q3dm17", "baseq3/maps.pk3:ztn"}, names); diff != "" {
+		t.Errorf("v3: after ListMaps differs: (-want +got)\n%s", diff)
+	}
+
+	if _, err := client.MoveFile(ctx, &MoveFileRequest{Name: "baseq3/maps.pk3", NewName: "baseq3/dm.pk3"}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected used map pack to not be moved, received %v", err)
+	}
+	if _, err := client.MoveFile(ctx, &MoveFileRequest{Name: "baseq3/maps.pk3", NewName: "baseq3/dm.cfg", Force: true}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected invalid name, received %v", err)
+	}
+	f, err := client.MoveFile(ctx, &MoveFileRequest{Name: "baseq3/maps.pk3", NewName: "baseq3/dm.pk3", Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name != "baseq3/dm.pk3" {
+		t.Fatalf("expected baseq3/dm.pk3, received %q", f.Name)
+	}
+	if _, err := client.MoveFile(ctx, &MoveFileRequest{Name: "baseq3/maps.pk3", NewName: "baseq3/dm.pk3", Force: true}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected moved file to not be found, received %v", err)
+	}
+	if _, err := client.StatFile(ctx, &StatFileRequest{Name: "../dm.pk3"}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected invalid name, received %v", err)
+	}
+
+	if _, err := client.DeleteFile(ctx, &DeleteFileRequest{Name: "baseq3/dm.pk3"}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected used map pack to not be deleted, received %v", err)
+	}
+	if _, err := client.DeleteFile(ctx, &DeleteFileRequest{Name: "baseq3/dm.pk3", Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.StatFile(ctx, &StatFileRequest{Name: "baseq3/dm.pk3"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected deleted file to not be found, received %v", err)
+	}
+}
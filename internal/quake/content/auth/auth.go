@@ -0,0 +1,339 @@
+// Package auth authenticates the callers of the content server, by API token
+// or client certificate, and authorizes them to read or write its files.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	ErrUnauthenticated  = errors.New("unauthenticated")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrQuotaExceeded    = errors.New("quota exceeded")
+)
+
+// Permission is what a caller is allowed to do. Reading includes downloading
+// the files and listing them, and writing includes uploading, moving and
+// deleting them.
+type Permission string
+
+const (
+	Read  Permission = "read"
+	Write Permission = "write"
+)
+
+// Config describes the identities of the callers of the content server.
+type Config struct {
+	// Anonymous are the permissions of callers without credentials. They
+	// default to read, so that game clients can download the files.
+	Anonymous []Permission `json:"anonymous"`
+
+	Identities []IdentityConfig `json:"identities"`
+}
+
+// IdentityConfig is a caller that is authenticated by any of its API tokens,
+// or client certificate common names.
+type IdentityConfig struct {
+	Name        string       `json:"name"`
+	Tokens      []string     `json:"tokens"`
+	CommonNames []string     `json:"commonNames"`
+	Permissions []Permission `json:"permissions"`
+	Quota       Quota        `json:"quota"`
+}
+
+// Quota limits the uploads of an identity. Limits that aren't set are
+// unlimited. The uploads are counted in memory, per process, so each replica
+// of the content server has its own daily quota, which restarts from zero.
+type Quota struct {
+	// MaxFileSize is the size of the largest file that can be uploaded, e.g.
+	// 500Mi.
+	MaxFileSize *resource.Quantity `json:"maxFileSize,omitempty"`
+
+	// MaxBytesPerDay is the total size of the files that can be uploaded in
+	// the last 24 hours, e.g. 5Gi.
+	MaxBytesPerDay *resource.Quantity `json:"maxBytesPerDay,omitempty"`
+}
+
+// ReadConfigFromFile reads and validates an auth config file.
+func ReadConfigFromFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// Validate checks that the identities have unique names and credentials, and
+// valid permissions.
+func (cfg *Config) Validate() error {
+	if err := validatePermissions(cfg.Anonymous); err != nil {
+		return fmt.Errorf("anonymous: %w", err)
+	}
+	names := make(map[string]bool)
+	tokens := make(map[string]bool)
+	commonNames := make(map[string]bool)
+	for i, id := range cfg.Identities {
+		if id.Name == "" {
+			return fmt.Errorf("identities[%d].name: must not be empty", i)
+		}
+		if names[id.Name] {
+			return fmt.Errorf("identities[%d].name: duplicate name %q", i, id.Name)
+		}
+		names[id.Name] = true
+		if len(id.Tokens) == 0 && len(id.CommonNames) == 0 {
+			return fmt.Errorf("identities[%d]: must have tokens or commonNames", i)
+		}
+		for j, token := range id.Tokens {
+			if token == "" {
+				return fmt.Errorf("identities[%d].tokens[%d]: must not be empty", i, j)
+			}
+			if tokens[token] {
+				return fmt.Errorf("identities[%d].tokens[%d]: token is already used", i, j)
+			}
+			tokens[token] = true
+		}
+		for j, cn := range id.CommonNames {
+			if cn == "" {
+				return fmt.Errorf("identities[%d].commonNames[%d]: must not be empty", i, j)
+			}
+			if commonNames[cn] {
+				return fmt.Errorf("identities[%d].commonNames[%d]: duplicate common name %q", i, j, cn)
+			}
+			commonNames[cn] = true
+		}
+		if err := validatePermissions(id.Permissions); err != nil {
+			return fmt.Errorf("identities[%d].permissions: %w", i, err)
+		}
+		if q := id.Quota.MaxFileSize; q != nil && q.Sign() < 0 {
+			return fmt.Errorf("identities[%d].quota.maxFileSize: must not be negative", i)
+		}
+		if q := id.Quota.MaxBytesPerDay; q != nil && q.Sign() < 0 {
+			return fmt.Errorf("identities[%d].quota.maxBytesPerDay: must not be negative", i)
+		}
+	}
+	return nil
+}
+
+func validatePermissions(perms []Permission) error {
+	for _, p := range perms {
+		switch p {
+		case Read, Write:
+		default:
+			return fmt.Errorf("invalid permission %q, must be one of: %s, %s", p, Read, Write)
+		}
+	}
+	return nil
+}
+
+// Identity is an authenticated caller. A nil identity, which callers have
+// when authentication is disabled, is allowed everything.
+type Identity struct {
+	Name        string
+	Permissions []Permission
+	Quota       Quota
+
+	mu      sync.Mutex
+	uploads []*upload
+}
+
+type upload struct {
+	time time.Time
+	size int64
+}
+
+func (id *Identity) String() string {
+	if id == nil {
+		return "anonymous"
+	}
+	return id.Name
+}
+
+// Allowed reports whether the identity has the permission.
+func (id *Identity) Allowed(p Permission) bool {
+	if id == nil {
+		return true
+	}
+	for _, perm := range id.Permissions {
+		if perm == p {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckUpload returns ErrQuotaExceeded when an upload of the size is over the
+// quota of the identity. It doesn't count the upload, which is done with
+// ReserveUpload.
+func (id *Identity) CheckUpload(size int64) error {
+	if id == nil {
+		return nil
+	}
+	id.mu.Lock()
+	defer id.mu.Unlock()
+
+	return id.checkUpload(size)
+}
+
+// ReserveUpload counts an upload of the size towards the quota of the
+// identity, or returns ErrQuotaExceeded when it is over it. The check and the
+// reservation are atomic, so that concurrent uploads can't all fit in the
+// same remaining quota. The release function must be called if the upload
+// fails, and gives the size back.
+func (id *Identity) ReserveUpload(size int64) (release func(), err error) {
+	if id == nil {
+		return func() {}, nil
+	}
+	id.mu.Lock()
+	defer id.mu.Unlock()
+
+	if err := id.checkUpload(size); err != nil {
+		return nil, err
+	}
+	u := &upload{time: time.Now(), size: size}
+	id.uploads = append(id.uploads, u)
+	return func() {
+		id.mu.Lock()
+		defer id.mu.Unlock()
+
+		id.uploads = slices.DeleteFunc(id.uploads, func(v *upload) bool { return v == u })
+	}, nil
+}
+
+func (id *Identity) checkUpload(size int64) error {
+	if max := id.Quota.MaxFileSize; max != nil && size > max.Value() {
+		return fmt.Errorf("%w: %s can upload files of up to %s", ErrQuotaExceeded, id.Name, max)
+	}
+	if max := id.Quota.MaxBytesPerDay; max != nil && id.uploaded()+size > max.Value() {
+		return fmt.Errorf("%w: %s can upload %s per day", ErrQuotaExceeded, id.Name, max)
+	}
+	return nil
+}
+
+// uploaded returns the size of the uploads in the last 24 hours, and forgets
+// the older ones. The lock must be held.
+func (id *Identity) uploaded() int64 {
+	since := time.Now().Add(-24 * time.Hour)
+	for len(id.uploads) > 0 && id.uploads[0].time.Before(since) {
+		id.uploads = id.uploads[1:]
+	}
+	var n int64
+	for _, u := range id.uploads {
+		n += u.size
+	}
+	return n
+}
+
+// Authenticator finds the identity of callers.
+type Authenticator struct {
+	anonymous   *Identity
+	tokens      map[[sha256.Size]byte]*Identity
+	commonNames map[string]*Identity
+}
+
+func New(cfg *Config) (*Authenticator, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	a := &Authenticator{
+		anonymous:   &Identity{Name: "anonymous", Permissions: cfg.Anonymous},
+		tokens:      make(map[[sha256.Size]byte]*Identity),
+		commonNames: make(map[string]*Identity),
+	}
+	if cfg.Anonymous == nil {
+		a.anonymous.Permissions = []Permission{Read}
+	}
+	for _, c := range cfg.Identities {
+		id := &Identity{Name: c.Name, Permissions: c.Permissions, Quota: c.Quota}
+		// Tokens are looked up by their hash, so that the lookup doesn't take
+		// longer the more of a token is right.
+		for _, token := range c.Tokens {
+			a.tokens[sha256.Sum256([]byte(token))] = id
+		}
+		for _, cn := range c.CommonNames {
+			a.commonNames[cn] = id
+		}
+	}
+	return a, nil
+}
+
+// Authenticate returns the identity of a caller with an API token, or a
+// verified client certificate, or the anonymous identity for callers without
+// either. An unknown token is an error, while the certificates of unknown
+// common names are treated as anonymous, since any certificate signed by the
+// client CA is accepted when connecting.
+func (a *Authenticator) Authenticate(token string, state *tls.ConnectionState) (*Identity, error) {
+	if token != "" {
+		id, ok := a.tokens[sha256.Sum256([]byte(token))]
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid token", ErrUnauthenticated)
+		}
+		return id, nil
+	}
+	if state != nil && len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0 {
+		if id, ok := a.commonNames[state.VerifiedChains[0][0].Subject.CommonName]; ok {
+			return id, nil
+		}
+	}
+	return a.anonymous, nil
+}
+
+// Authorize returns the identity of a caller that has the permission.
+func (a *Authenticator) Authorize(token string, state *tls.ConnectionState, p Permission) (*Identity, error) {
+	id, err := a.Authenticate(token, state)
+	if err != nil {
+		return nil, err
+	}
+	if !id.Allowed(p) {
+		if id == a.anonymous {
+			return nil, fmt.Errorf("%w: %s requires credentials", ErrUnauthenticated, p)
+		}
+		return nil, fmt.Errorf("%w: %s can't %s", ErrPermissionDenied, id.Name, p)
+	}
+	return id, nil
+}
+
+// Token returns the API token of an Authorization header, which must use the
+// Bearer scheme, or an empty token when there is no header.
+func Token(header string) (string, error) {
+	if header == "" {
+		return "", nil
+	}
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", fmt.Errorf("%w: authorization must be a bearer token", ErrUnauthenticated)
+	}
+	return strings.TrimSpace(token), nil
+}
+
+type identityKey struct{}
+
+// NewContext returns a context with the identity of the caller.
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the identity of the caller, which is nil when
+// authentication is disabled.
+func FromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
@@ -0,0 +1,339 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/soheilhy/cmux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const testConfig = `
+anonymous: [read]
+identities:
+- name: ci
+  tokens: [ci-token]
+  permissions: [read, write]
+  quota:
+    maxFileSize: 10
+    maxBytesPerDay: 25
+- name: mapper
+  commonNames: [mapper.example.com]
+  permissions: [read, write]
+- name: viewer
+  tokens: [viewer-token]
+  permissions: [read]
+`
+
+func newTestAuthenticator(t *testing.T) *Authenticator {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "auth.yaml")
+	if err := os.WriteFile(path, []byte(testConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := ReadConfigFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestConfigValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{"no credentials", "identities: [{name: ci}]", "identities[0]: must have tokens or commonNames"},
+		{"duplicate name", "identities: [{name: ci, tokens: [a]}, {name: ci, tokens: [b]}]", `identities[1].name: duplicate name "ci"`},
+		{"duplicate token", "identities: [{name: ci, tokens: [a]}, {name: cd, tokens: [a]}]", "identities[1].tokens[0]: token is already used"},
+		{"invalid permission", "identities: [{name: ci, tokens: [a], permissions: [admin]}]", `identities[0].permissions: invalid permission "admin"`},
+		{"invalid anonymous", "anonymous: [delete]", `anonymous: invalid permission "delete"`},
+		{"negative quota", "identities: [{name: ci, tokens: [a], quota: {maxFileSize: -1}}]", "identities[0].quota.maxFileSize: must not be negative"},
+		{"unknown field", "identities: [{name: ci, token: a}]", `unknown field "token"`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "auth.yaml")
+			if err := os.WriteFile(path, []byte(tc.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ReadConfigFromFile(path); err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("auth: after ReadConfigFromFile expected %q, received %v", tc.err, err)
+			}
+		})
+	}
+}
+
+// verifiedState returns the connection state of a client certificate that
+// was verified.
+func verifiedState(cn string) *tls.ConnectionState {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+	return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+}
+
+func TestAuthorize(t *testing.T) {
+	a := newTestAuthenticator(t)
+
+	cases := []struct {
+		name  string
+		token string
+		state *tls.ConnectionState
+		perm  Permission
+		id    string
+		err   error
+	}{
+		{"anonymous read", "", nil, Read, "anonymous", nil},
+		{"anonymous write", "", nil, Write, "", ErrUnauthenticated},
+		{"token write", "ci-token", nil, Write, "ci", nil},
+		{"read-only token write", "viewer-token", nil, Write, "", ErrPermissionDenied},
+		{"invalid token", "wrong", nil, Read, "", ErrUnauthenticated},
+		{"certificate write", "", verifiedState("mapper.example.com"), Write, "mapper", nil},
+		{"unknown certificate write", "", verifiedState("other.example.com"), Write, "", ErrUnauthenticated},
+		{"unverified certificate write", "", &tls.ConnectionState{PeerCertificates: verifiedState("mapper.example.com").VerifiedChains[0]}, Write, "", ErrUnauthenticated},
+		{"token before certificate", "viewer-token", verifiedState("mapper.example.com"), Write, "", ErrPermissionDenied},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			id, err := a.Authorize(tc.token, tc.state, tc.perm)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("auth: after Authorize expected %v, received %v", tc.err, err)
+			}
+			if err == nil && id.Name != tc.id {
+				t.Errorf("auth: after Authorize expected %q, received %q", tc.id, id.Name)
+			}
+		})
+	}
+}
+
+func TestQuota(t *testing.T) {
+	a := newTestAuthenticator(t)
+	id, err := a.Authenticate("ci-token", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := id.CheckUpload(11); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("auth: after CheckUpload of a large file expected ErrQuotaExceeded, received %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := id.ReserveUpload(10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := id.CheckUpload(6); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("auth: after CheckUpload over the daily quota expected ErrQuotaExceeded, received %v", err)
+	}
+	if err := id.CheckUpload(5); err != nil {
+		t.Errorf("auth: after CheckUpload within the daily quota received %v", err)
+	}
+
+	// A failed upload gives its reservation back, and concurrent uploads
+	// can't both have the remaining quota.
+	release, err := id.ReserveUpload(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := id.ReserveUpload(5); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("auth: after ReserveUpload of the reserved quota expected ErrQuotaExceeded, received %v", err)
+	}
+	release()
+	if err := id.CheckUpload(5); err != nil {
+		t.Errorf("auth: after releasing an upload received %v", err)
+	}
+
+	// Uploads older than a day no longer count.
+	id.uploads[0].time = time.Now().Add(-25 * time.Hour)
+	if err := id.CheckUpload(10); err != nil {
+		t.Errorf("auth: after CheckUpload of a day later received %v", err)
+	}
+
+	// Without authentication, uploads are unlimited.
+	if err := FromContext(context.Background()).CheckUpload(1 << 40); err != nil {
+		t.Errorf("auth: after CheckUpload without an identity received %v", err)
+	}
+}
+
+func TestToken(t *testing.T) {
+	for header, expected := range map[string]string{"": "", "Bearer abc": "abc", "bearer  abc ": "abc"} {
+		if token, err := Token(header); err != nil || token != expected {
+			t.Errorf("auth: after Token of %q expected %q, received %q (%v)", header, expected, token, err)
+		}
+	}
+	if _, err := Token("Basic abc"); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("auth: after Token of basic auth expected ErrUnauthenticated, received %v", err)
+	}
+}
+
+// newTestCA returns a CA, and a certificate signed by it for the common name.
+func newTestCA(t *testing.T, cn string) (*x509.CertPool, tls.Certificate) {
+	t.Helper()
+
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	caKey := newKey()
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err = x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := newKey()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return pool, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// TestServerCredentials checks that gRPC calls are authorized by the client
+// certificate of a TLS connection that is multiplexed with cmux, the way the
+// content server serves them.
+func TestServerCredentials(t *testing.T) {
+	a := newTestAuthenticator(t)
+	pool, cert := newTestCA(t, "mapper.example.com")
+
+	l := bufconn.Listen(1 << 20)
+	m := cmux.New(tls.NewListener(l, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}))
+	grpcl := m.MatchWithWriters(cmux.HTTP2MatchHeaderFieldSendSettings("content-type", "application/grpc"))
+
+	// The health checks need to be allowed to write, so that they are only
+	// allowed with the client certificate.
+	perm := func(string) Permission { return Write }
+	s := grpc.NewServer(
+		grpc.Creds(ServerCredentials()),
+		grpc.UnaryInterceptor(UnaryServerInterceptor(a, perm)),
+		grpc.StreamInterceptor(StreamServerInterceptor(a, perm)),
+	)
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	go s.Serve(grpcl)
+	go m.Serve()
+	t.Cleanup(func() {
+		s.Stop()
+		m.Close()
+	})
+
+	check := func(cfg *tls.Config, opts ...grpc.DialOption) error {
+		t.Helper()
+
+		conn, err := grpc.Dial("mapper.example.com", append(opts,
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return l.DialContext(ctx)
+			}),
+			grpc.WithTransportCredentials(credentials.NewTLS(cfg)),
+		)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		_, err = healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+		return err
+	}
+	if err := check(&tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}}); err != nil {
+		t.Errorf("auth: after Check with a client certificate received %v", err)
+	}
+	if err := check(&tls.Config{RootCAs: pool}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("auth: after Check without credentials expected Unauthenticated, received %v", err)
+	}
+	err := check(&tls.Config{RootCAs: pool}, grpc.WithPerRPCCredentials(TokenCredentials("viewer-token", false)))
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("auth: after Check with a read-only token expected PermissionDenied, received %v", err)
+	}
+	if err := check(&tls.Config{RootCAs: pool}, grpc.WithPerRPCCredentials(TokenCredentials("ci-token", false))); err != nil {
+		t.Errorf("auth: after Check with a token received %v", err)
+	}
+}
+
+// TestInsecureServerCredentials checks that tokens are also accepted on
+// connections without TLS, such as behind a TLS terminating proxy.
+func TestInsecureServerCredentials(t *testing.T) {
+	a := newTestAuthenticator(t)
+
+	l := bufconn.Listen(1 << 20)
+	perm := func(string) Permission { return Write }
+	s := grpc.NewServer(
+		grpc.Creds(ServerCredentials()),
+		grpc.UnaryInterceptor(UnaryServerInterceptor(a, perm)),
+	)
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	go s.Serve(l)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return l.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithPerRPCCredentials(TokenCredentials("ci-token", true)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(healthpb.HealthCheckResponse_SERVING, resp.Status); diff != "" {
+		t.Errorf("auth: after Check differs: (-want +got)\n%s", diff)
+	}
+}
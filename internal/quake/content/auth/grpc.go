@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor authorizes the callers of unary methods, with the
+// permission that perm returns for the full method name, and adds their
+// identity to the context.
+func UnaryServerInterceptor(a *Authenticator, perm func(method string) Permission) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := a.authorizeContext(ctx, perm(info.FullMethod))
+		if err != nil {
+			return nil, err
+		}
+		resp, err := handler(ctx, req)
+		return resp, toStatus(err)
+	}
+}
+
+// StreamServerInterceptor authorizes the callers of streaming methods, like
+// UnaryServerInterceptor.
+func StreamServerInterceptor(a *Authenticator, perm func(method string) Permission) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authorizeContext(ss.Context(), perm(info.FullMethod))
+		if err != nil {
+			return err
+		}
+		return toStatus(handler(srv, &serverStream{ServerStream: ss, ctx: ctx}))
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// authorizeContext authorizes the caller of a call with the token in its
+// metadata, or its client certificate.
+func (a *Authenticator) authorizeContext(ctx context.Context, p Permission) (context.Context, error) {
+	var header string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			header = values[0]
+		}
+	}
+	token, err := Token(header)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	var state *tls.ConnectionState
+	if pr, ok := peer.FromContext(ctx); ok {
+		if info, ok := pr.AuthInfo.(credentials.TLSInfo); ok {
+			state = &info.State
+		}
+	}
+	id, err := a.Authorize(token, state, p)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return NewContext(ctx, id), nil
+}
+
+// toStatus returns the gRPC status of the auth errors.
+func toStatus(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch {
+	case errors.Is(err, ErrUnauthenticated):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, ErrPermissionDenied):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, ErrQuotaExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return err
+}
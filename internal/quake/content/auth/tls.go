@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/soheilhy/cmux"
+	"google.golang.org/grpc/credentials"
+)
+
+// ServerTLSConfig returns the TLS config of the content server. Client
+// certificates are requested, and verified with the client CA when it is
+// given, but connections without them are still accepted, since game clients
+// download the files anonymously.
+//
+// No application protocols are negotiated, so that browsers keep using
+// HTTP/1.1, which is what the HTTP server is matched on.
+func ServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		cfg.ClientCAs, err = readCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
+
+// ClientTLSConfig returns the TLS config of a content server client, with an
+// optional client certificate, and CA to verify the server with instead of
+// the system roots.
+func ClientTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cfg := &tls.Config{}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pool, err := readCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+func readCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("failed to add ca cert file: %s", path)
+	}
+	return pool, nil
+}
+
+// tlsConn returns the TLS connection of a connection accepted from a TLS
+// listener, which the content server multiplexes with cmux, or nil for
+// connections without TLS.
+func tlsConn(c net.Conn) *tls.Conn {
+	for {
+		switch conn := c.(type) {
+		case *tls.Conn:
+			return conn
+		case *cmux.MuxConn:
+			c = conn.Conn
+		default:
+			return nil
+		}
+	}
+}
+
+type connKey struct{}
+
+// ConnContext is used as the ConnContext of the HTTP server, so that the TLS
+// connection state is known even though the connections are wrapped by cmux.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if conn := tlsConn(c); conn != nil {
+		return context.WithValue(ctx, connKey{}, conn)
+	}
+	return ctx
+}
+
+// RequestConnectionState returns the TLS connection state of an HTTP request,
+// or nil when it wasn't made with TLS.
+func RequestConnectionState(r *http.Request) *tls.ConnectionState {
+	if r.TLS != nil {
+		return r.TLS
+	}
+	if conn, ok := r.Context().Value(connKey{}).(*tls.Conn); ok {
+		state := conn.ConnectionState()
+		return &state
+	}
+	return nil
+}
+
+// serverCredentials are the transport credentials of the gRPC server. The TLS
+// handshake has already been done by the listener, so they only make the
+// connection state available to the interceptors.
+type serverCredentials struct{}
+
+// ServerCredentials returns the transport credentials of a gRPC server that
+// serves connections accepted from a TLS listener, or without TLS.
+func ServerCredentials() credentials.TransportCredentials {
+	return serverCredentials{}
+}
+
+type plainInfo struct {
+	credentials.CommonAuthInfo
+}
+
+func (plainInfo) AuthType() string {
+	return "insecure"
+}
+
+func (serverCredentials) ServerHandshake(c net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn := tlsConn(c)
+	if conn == nil {
+		return c, plainInfo{credentials.CommonAuthInfo{SecurityLevel: credentials.NoSecurity}}, nil
+	}
+	return c, credentials.TLSInfo{
+		State:          conn.ConnectionState(),
+		CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+	}, nil
+}
+
+func (serverCredentials) ClientHandshake(ctx context.Context, addr string, c net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, fmt.Errorf("auth: server credentials can't be used by clients")
+}
+
+func (serverCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "tls"}
+}
+
+func (c serverCredentials) Clone() credentials.TransportCredentials {
+	return c
+}
+
+func (serverCredentials) OverrideServerName(string) error {
+	return nil
+}
+
+// tokenCredentials send an API token with every call.
+type tokenCredentials struct {
+	token      string
+	requireTLS bool
+}
+
+// TokenCredentials returns the per-call credentials of a client with an API
+// token. Unless the connection is insecure, the token is only sent over TLS.
+func TokenCredentials(token string, insecure bool) credentials.PerRPCCredentials {
+	return tokenCredentials{token: token, requireTLS: !insecure}
+}
+
+func (c tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + c.token}, nil
+}
+
+func (c tokenCredentials) RequireTransportSecurity() bool {
+	return c.requireTLS
+}
+
+// tokenTransport sends an API token with every HTTP request.
+type tokenTransport struct {
+	token string
+	next  http.RoundTripper
+}
+
+// TokenTransport returns an HTTP transport that sends an API token with
+// every request made with next.
+func TokenTransport(token string, next http.RoundTripper) http.RoundTripper {
+	return &tokenTransport{token: token, next: next}
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request.
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,65 @@
+package content
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ChrisRx/quake-kube/internal/quake/content/auth"
+)
+
+// requestPermission returns the permission needed for a request. Requests
+// that don't change anything need to be allowed to read, except for the
+// uploads, whose sessions are only for the callers allowed to write.
+func requestPermission(r *http.Request) auth.Permission {
+	if r.URL.Path == "/uploads" || strings.HasPrefix(r.URL.Path, "/uploads/") {
+		return auth.Write
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return auth.Read
+	}
+	return auth.Write
+}
+
+// errAuthRequired is returned for the requests that change files when
+// authentication is disabled.
+var errAuthRequired = errors.New("changing files over HTTP requires --auth-config")
+
+// authenticate authorizes the callers, when authentication is enabled, by the
+// bearer token in the Authorization header, or their client certificate.
+// Without authentication, only reading is allowed, since the content server
+// can be reached by anyone who can load the game, e.g. on the client port of
+// q3 run.
+func (h *HTTPServer) authenticate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		r := c.Request()
+		if h.Auth == nil {
+			if requestPermission(r) == auth.Write {
+				return c.String(http.StatusForbidden, errAuthRequired.Error())
+			}
+			return next(c)
+		}
+		token, err := auth.Token(r.Header.Get(echo.HeaderAuthorization))
+		if err != nil {
+			return authError(c, err)
+		}
+		id, err := h.Auth.Authorize(token, auth.RequestConnectionState(r), requestPermission(r))
+		if err != nil {
+			return authError(c, err)
+		}
+		c.SetRequest(r.WithContext(auth.NewContext(r.Context(), id)))
+		return next(c)
+	}
+}
+
+// authError responds with the HTTP status for the errors of authorization.
+func authError(c echo.Context, err error) error {
+	if errors.Is(err, auth.ErrPermissionDenied) {
+		return c.String(http.StatusForbidden, err.Error())
+	}
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+	return c.String(http.StatusUnauthorized, err.Error())
+}
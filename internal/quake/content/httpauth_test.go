@@ -0,0 +1,118 @@
+package content
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/ChrisRx/quake-kube/internal/quake/content/auth"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/storage"
+)
+
+func TestHTTPAuth(t *testing.T) {
+	maxFileSize := resource.MustParse("1Ki")
+	a, err := auth.New(&auth.Config{
+		Identities: []auth.IdentityConfig{
+			{Name: "ci", Tokens: []string{"ci-token"}, Permissions: []auth.Permission{auth.Read, auth.Write}, Quota: auth.Quota{MaxFileSize: &maxFileSize}},
+			{Name: "viewer", Tokens: []string{"viewer-token"}, Permissions: []auth.Permission{auth.Read}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	h := NewHTTPContentServer(context.Background(), dir, storage.NewLocal(dir))
+	h.Auth = a
+
+	do := func(method, path, token string, size int) *httptest.ResponseRecorder {
+		t.Helper()
+
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if size > 0 {
+			req.Header.Set(headerUploadLength, fmt.Sprint(size))
+			req.Header.Set(headerUploadMetadata, "filename "+base64.StdEncoding.EncodeToString([]byte("maps.pk3")))
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		size   int
+		code   int
+	}{
+		{"anonymous read", http.MethodGet, "/maps", "", 0, http.StatusOK},
+		{"anonymous upload", http.MethodPost, "/uploads", "", 4, http.StatusUnauthorized},
+		{"anonymous upload session", http.MethodHead, "/uploads/0123456789abcdef0123456789abcdef", "", 0, http.StatusUnauthorized},
+		{"invalid token", http.MethodGet, "/maps", "wrong", 0, http.StatusUnauthorized},
+		{"read-only upload", http.MethodPost, "/uploads", "viewer-token", 4, http.StatusForbidden},
+		{"read-only delete", http.MethodDelete, "/files/baseq3/maps.pk3", "viewer-token", 0, http.StatusForbidden},
+		{"upload", http.MethodPost, "/uploads", "ci-token", 4, http.StatusCreated},
+		{"upload over quota", http.MethodPost, "/uploads", "ci-token", 2048, http.StatusRequestEntityTooLarge},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := do(tc.method, tc.path, tc.token, tc.size)
+			if rec.Code != tc.code {
+				t.Fatalf("expected status %d, received %d: %s", tc.code, rec.Code, rec.Body)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("expected WWW-Authenticate %q, received %q", "Bearer", rec.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+// testToken is the token of the identity allowed to write by newTestAuth.
+const testToken = "test-token"
+
+// newTestAuth returns an authenticator that allows anyone to read, and the
+// callers with testToken to write.
+func newTestAuth(t *testing.T) *auth.Authenticator {
+	t.Helper()
+
+	a, err := auth.New(&auth.Config{
+		Identities: []auth.IdentityConfig{
+			{Name: "test", Tokens: []string{testToken}, Permissions: []auth.Permission{auth.Read, auth.Write}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestHTTPWithoutAuth(t *testing.T) {
+	dir := t.TempDir()
+	h := NewHTTPContentServer(context.Background(), dir, storage.NewLocal(dir))
+	for _, tc := range []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{http.MethodGet, "/maps", http.StatusOK},
+		{http.MethodPost, "/uploads", http.StatusForbidden},
+		{http.MethodPatch, "/uploads/0123456789abcdef0123456789abcdef", http.StatusForbidden},
+		{http.MethodDelete, "/uploads/0123456789abcdef0123456789abcdef", http.StatusForbidden},
+		{http.MethodDelete, "/files/baseq3/maps.pk3", http.StatusForbidden},
+		{http.MethodPost, "/files/move", http.StatusForbidden},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+		if rec.Code != tc.code {
+			t.Errorf("%s %s: expected status %d, received %d: %s", tc.method, tc.path, tc.code, rec.Code, rec.Body)
+		}
+	}
+}
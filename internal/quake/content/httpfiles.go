@@ -0,0 +1,94 @@
+package content
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ChrisRx/quake-kube/internal/log"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/auth"
+	contentutil "github.com/ChrisRx/quake-kube/internal/quake/content/util"
+)
+
+type moveFileRequest struct {
+	Name    string `json:"name"`
+	NewName string `json:"newName"`
+	Force   bool   `json:"force"`
+}
+
+// registerFileRoutes adds the routes that manage the files in the storage,
+// which are named by their path in it, e.g. /files/baseq3/maps.pk3:
+//
+//   - GET /files/*name returns information about a file.
+//   - DELETE /files/*name removes a file. Map packs with maps used by the
+//     server are only removed with ?force=true.
+//   - POST /files/move renames a file, from name to newName in the JSON body.
+//     Map packs with maps used by the server are only moved with force.
+func (h *HTTPServer) registerFileRoutes() {
+	h.GET("/files/*", func(c echo.Context) error {
+		info, err := h.Index.Stat(c.Request().Context(), c.Param("*"))
+		if err != nil {
+			return fileError(c, err)
+		}
+		return c.JSONPretty(http.StatusOK, info, "    ")
+	})
+	h.DELETE("/files/*", func(c echo.Context) error {
+		force, _ := strconv.ParseBool(c.QueryParam("force"))
+		used, err := h.usedMaps(force)
+		if err != nil {
+			return err
+		}
+		ctx := c.Request().Context()
+		if err := contentutil.DeleteFile(ctx, h.storage, c.Param("*"), used); err != nil {
+			return fileError(c, err)
+		}
+		log.Info("deleted file", "name", c.Param("*"), "identity", auth.FromContext(ctx).String())
+		h.Index.Invalidate()
+		return c.NoContent(http.StatusNoContent)
+	})
+	h.POST("/files/move", func(c echo.Context) error {
+		var req moveFileRequest
+		if err := c.Bind(&req); err != nil {
+			return err
+		}
+		used, err := h.usedMaps(req.Force)
+		if err != nil {
+			return err
+		}
+		ctx := c.Request().Context()
+		f, err := h.Index.MoveFile(ctx, req.Name, req.NewName, used)
+		if err != nil {
+			return fileError(c, err)
+		}
+		log.Info("moved file", "name", req.Name, "newName", req.NewName, "identity", auth.FromContext(ctx).String())
+		return c.JSONPretty(http.StatusOK, f, "    ")
+	})
+}
+
+// usedMaps returns the maps used by the server, or none when force is set.
+func (h *HTTPServer) usedMaps(force bool) ([]contentutil.UsedMap, error) {
+	if force || h.UsedMaps == nil {
+		return nil, nil
+	}
+	return h.UsedMaps()
+}
+
+// fileError responds with the HTTP status for the errors of the file
+// operations.
+func fileError(c echo.Context, err error) error {
+	var code int
+	switch {
+	case errors.Is(err, contentutil.ErrInvalidName):
+		code = http.StatusBadRequest
+	case errors.Is(err, os.ErrNotExist):
+		code = http.StatusNotFound
+	case errors.Is(err, os.ErrExist), errors.Is(err, contentutil.ErrFileInUse):
+		code = http.StatusConflict
+	default:
+		return err
+	}
+	return c.String(code, err.Error())
+}
@@ -2,16 +2,28 @@ package content
 
 import (
 	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
 	"net"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"github.com/ChrisRx/quake-kube/internal/quake/content/auth"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/manifest"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/precompress"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/storage"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/upload"
 	contentutil "github.com/ChrisRx/quake-kube/internal/quake/content/util"
 )
 
@@ -20,51 +32,188 @@ type HTTPServer struct {
 
 	ctx       context.Context
 	assetsDir string
+	storage   storage.Storage
+	uploads   *upload.Store
+
+	// UsedMaps returns the maps used by the server, whose map packs can't be
+	// deleted unless forced.
+	UsedMaps func() ([]contentutil.UsedMap, error)
+
+	// Index is the checksum index of the storage, which can be shared with the
+	// gRPC server.
+	Index *manifest.Index
+
+	// Precompressed has the precompressed variants of the assets, which are
+	// served to clients that accept their encoding. It only works with a
+	// local storage.
+	Precompressed *precompress.Store
+
+	// Auth authorizes the callers, when it is set. Callers need to be allowed
+	// to read to download and list the files, and to write to upload, move
+	// and delete them.
+	Auth *auth.Authenticator
 }
 
-func NewHTTPContentServer(ctx context.Context, assetsDir string) *HTTPServer {
+// NewHTTPContentServer returns an HTTP content server for the files in the
+// storage. Uploads are staged, and demos are read, in the assets directory,
+// which is local to each content server even when the storage is shared.
+func NewHTTPContentServer(ctx context.Context, assetsDir string, s storage.Storage) *HTTPServer {
 	e := echo.New()
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 	e.Use(middleware.BodyLimit("1000M"))
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{"*"},
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept},
+		AllowOrigins:  []string{"*"},
+		AllowHeaders:  []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, "Range", headerTusResumable, headerUploadOffset, headerUploadLength, headerUploadMetadata},
+		ExposeHeaders: []string{echo.HeaderLocation, "ETag", "Content-Range", headerTusResumable, headerUploadOffset, headerUploadLength},
 	}))
+	h := &HTTPServer{
+		Echo:      e,
+		ctx:       ctx,
+		assetsDir: assetsDir,
+		storage:   s,
+		uploads:   upload.NewStore(assetsDir, s),
+		Index:     manifest.NewIndex(s),
+	}
+	e.Use(h.authenticate)
+	// Both the manifest and maps can be limited to the files used by a single
+	// mod with the game query parameter (e.g. ?game=missionpack).
 	e.GET("/assets/manifest.json", func(c echo.Context) error {
-		files, err := contentutil.ReadManifest(assetsDir)
+		files, etag, err := h.Index.Files()
 		if err != nil {
 			return err
 		}
-		return c.JSONPretty(http.StatusOK, files, "   ")
-	})
-	e.GET("/assets/*", func(c echo.Context) error {
-		path := filepath.Join(assetsDir, trimAssetName(c.Param("*")))
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			return c.String(http.StatusNotFound, "file not found")
+		if game := c.QueryParam("game"); game != "" {
+			files = contentutil.FilterGame(files, game)
+			etag = manifest.ETag(files)
 		}
-		return c.File(path)
+		c.Response().Header().Set(echo.HeaderCacheControl, "no-cache")
+		c.Response().Header().Set("ETag", etag)
+		if etagMatch(c.Request().Header.Get("If-None-Match"), etag) {
+			return c.NoContent(http.StatusNotModified)
+		}
+		return c.JSONPretty(http.StatusOK, files, "   ")
 	})
+	e.GET("/assets/*", h.serveAsset)
+	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
 	e.GET("/maps", func(c echo.Context) error {
-		maps, err := contentutil.ReadMaps(assetsDir)
+		maps, err := h.Index.Maps()
 		if err != nil {
 			return err
 		}
+		if game := c.QueryParam("game"); game != "" {
+			maps = contentutil.FilterGameMaps(maps, game)
+		}
 		return c.JSONPretty(http.StatusOK, maps, "    ")
 	})
-	return &HTTPServer{
-		Echo:      e,
-		ctx:       ctx,
-		assetsDir: assetsDir,
+	e.GET("/demos", func(c echo.Context) error {
+		demos, err := contentutil.ReadDemos(assetsDir)
+		if err != nil {
+			return err
+		}
+		return c.JSONPretty(http.StatusOK, demos, "    ")
+	})
+	e.GET("/demos/:id", func(c echo.Context) error {
+		d, path, err := contentutil.ReadDemo(assetsDir, c.Param("id"))
+		if errors.Is(err, os.ErrNotExist) {
+			return c.String(http.StatusNotFound, "demo not found")
+		}
+		if err != nil {
+			return err
+		}
+		return c.Attachment(path, d.File)
+	})
+	h.registerUploadRoutes()
+	h.registerFileRoutes()
+	return h
+}
+
+// serveAsset serves a file from the storage. Files in the manifest are
+// requested by their checksum and name (e.g. baseq3/2483777038-pak0.pk3), so a
+// URL always has the same contents and is cached indefinitely. The checksum is
+// also the entity tag, which is used to validate conditional and range
+// requests.
+func (h *HTTPServer) serveAsset(c echo.Context) error {
+	name, checksum, ok := splitAssetName(c.Param("*"))
+	name = filepath.ToSlash(name)
+	// Hidden files, such as the upload sessions, are never served.
+	if isHidden(name) {
+		return c.String(http.StatusNotFound, "file not found")
+	}
+	ctx := c.Request().Context()
+	fi, err := h.storage.Stat(ctx, name)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrInvalid) {
+		return c.String(http.StatusNotFound, "file not found")
 	}
+	if err != nil {
+		return err
+	}
+	f, err := h.Index.File(name)
+	if err != nil {
+		return err
+	}
+
+	header := c.Response().Header()
+	var content io.ReadSeekCloser
+	modTime := fi.ModTime
+	if f != nil {
+		if ok && checksum == f.Checksum {
+			header.Set(echo.HeaderCacheControl, "public, max-age=31536000, immutable")
+		} else {
+			header.Set(echo.HeaderCacheControl, "no-cache")
+		}
+		etag := strconv.FormatUint(uint64(f.Checksum), 10)
+		if h.Precompressed != nil {
+			header.Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+			if p, enc := h.Precompressed.Negotiate(c.Request().Header.Get(echo.HeaderAcceptEncoding), f); p != "" {
+				file, err := os.Open(p)
+				if err != nil {
+					return err
+				}
+				pfi, err := file.Stat()
+				if err != nil {
+					file.Close()
+					return err
+				}
+				content, modTime = file, pfi.ModTime()
+				etag += "-" + enc
+				header.Set(echo.HeaderContentEncoding, enc)
+			}
+		}
+		header.Set("ETag", `"`+etag+`"`)
+	}
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = echo.MIMEOctetStream
+	}
+	header.Set(echo.HeaderContentType, contentType)
+
+	if content == nil {
+		content, err = h.storage.Open(ctx, name)
+		if err != nil {
+			return err
+		}
+	}
+	defer content.Close()
+
+	http.ServeContent(c.Response(), c.Request(), name, modTime, content)
+	return nil
 }
 
 func (h *HTTPServer) Serve(l net.Listener) error {
+	go h.uploads.RunCleanup(h.ctx, 1*time.Hour)
+	if h.Precompressed != nil {
+		h.Index.Notify(h.Precompressed.Notify)
+		go h.Precompressed.Run(h.ctx, h.Index.Files)
+	}
+	go watchIndex(h.ctx, h.Index)
+
 	s := &http.Server{
 		Handler:        h,
 		ReadTimeout:    5 * time.Minute,
 		WriteTimeout:   5 * time.Minute,
 		MaxHeaderBytes: 1 << 20,
+		ConnContext:    auth.ConnContext,
 	}
 
 	errch := make(chan error, 1)
@@ -84,10 +233,46 @@ func (h *HTTPServer) Serve(l net.Listener) error {
 	}
 }
 
+// etagMatch reports whether the If-None-Match header matches the entity tag.
+func etagMatch(header, etag string) bool {
+	for _, s := range strings.Split(header, ",") {
+		s = strings.TrimSpace(s)
+		if s == "*" || strings.TrimPrefix(s, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// isHidden reports whether any element of the path is a hidden file or
+// directory.
+func isHidden(path string) bool {
+	for _, elem := range strings.Split(filepath.ToSlash(path), "/") {
+		if strings.HasPrefix(elem, ".") && elem != "." {
+			return true
+		}
+	}
+	return false
+}
+
 // trimAssetName returns a path string that has been prefixed with a crc32
 // checksum.
 func trimAssetName(s string) string {
+	name, _, _ := splitAssetName(s)
+	return name
+}
+
+// splitAssetName splits the crc32 checksum prefix from a path string. Paths
+// without the prefix are returned as is.
+func splitAssetName(s string) (string, uint32, bool) {
 	d, f := filepath.Split(s)
-	f = f[strings.Index(f, "-")+1:]
-	return filepath.Join(d, f)
+	prefix, name, ok := strings.Cut(f, "-")
+	if !ok {
+		return filepath.Join(d, f), 0, false
+	}
+	checksum, err := strconv.ParseUint(prefix, 10, 32)
+	if err != nil {
+		return filepath.Join(d, f), 0, false
+	}
+	return filepath.Join(d, name), uint32(checksum), true
 }
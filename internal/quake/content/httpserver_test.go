@@ -1,9 +1,20 @@
 package content
 
 import (
+	"bytes"
+	"context"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+
+	"github.com/ChrisRx/quake-kube/internal/quake/content/precompress"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/storage"
 )
 
 func TestTrimAssetName(t *testing.T) {
@@ -33,3 +44,107 @@ func TestTrimAssetName(t *testing.T) {
 		})
 	}
 }
+
+func TestManifestETag(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "baseq3"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "baseq3", "pak0.pk3"), []byte("pak0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHTTPContentServer(context.Background(), dir, storage.NewLocal(dir))
+	h.Auth = newTestAuth(t)
+	get := func(etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/assets/manifest.json", nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected status %d with an etag, received %d %q", http.StatusOK, rec.Code, etag)
+	}
+	if rec := get(etag); rec.Code != http.StatusNotModified {
+		t.Fatalf("expected status %d, received %d", http.StatusNotModified, rec.Code)
+	}
+
+	// Removing a file through the content server changes the manifest.
+	req := httptest.NewRequest(http.MethodDelete, "/files/baseq3/pak0.pk3", nil)
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if rec := get(etag); rec.Code != http.StatusOK || rec.Body.String() != "[]\n" {
+		t.Fatalf("expected empty manifest, received %d %q", rec.Code, rec.Body)
+	}
+}
+
+func TestServeAsset(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("pak0"), 1024)
+	if err := os.MkdirAll(filepath.Join(dir, "baseq3"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "baseq3", "pak0.pk3"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHTTPContentServer(context.Background(), dir, storage.NewLocal(dir))
+	checksum := strconv.FormatUint(uint64(crc32.ChecksumIEEE(data)), 10)
+	get := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/assets/baseq3/"+checksum+"-pak0.pk3", nil)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), data) {
+		t.Fatalf("expected status %d with the file, received %d", http.StatusOK, rec.Code)
+	}
+	if diff := cmp.Diff(`"`+checksum+`"`, rec.Header().Get("ETag")); diff != "" {
+		t.Errorf("content: after GET ETag differs: (-want +got)\n%s", diff)
+	}
+	if diff := cmp.Diff("public, max-age=31536000, immutable", rec.Header().Get("Cache-Control")); diff != "" {
+		t.Errorf("content: after GET Cache-Control differs: (-want +got)\n%s", diff)
+	}
+
+	// URLs with an outdated checksum must be revalidated.
+	if rec := get("/assets/baseq3/1-pak0.pk3", nil); rec.Header().Get("Cache-Control") != "no-cache" {
+		t.Fatalf("expected no-cache, received %q", rec.Header().Get("Cache-Control"))
+	}
+	if rec := get("/assets/baseq3/"+checksum+"-pak0.pk3", map[string]string{"If-None-Match": `"` + checksum + `"`}); rec.Code != http.StatusNotModified {
+		t.Fatalf("expected status %d, received %d", http.StatusNotModified, rec.Code)
+	}
+	rec = get("/assets/baseq3/"+checksum+"-pak0.pk3", map[string]string{"Range": "bytes=4-7", "If-Range": `"` + checksum + `"`})
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "pak0" {
+		t.Fatalf("expected status %d, received %d %q", http.StatusPartialContent, rec.Code, rec.Body)
+	}
+	// A range of a changed file isn't served.
+	rec = get("/assets/baseq3/"+checksum+"-pak0.pk3", map[string]string{"Range": "bytes=4-7", "If-Range": `"1"`})
+	if rec.Code != http.StatusOK || rec.Body.Len() != len(data) {
+		t.Fatalf("expected status %d with the file, received %d", http.StatusOK, rec.Code)
+	}
+
+	h.Precompressed, _ = precompress.NewStore(dir, "gzip")
+	files, _, err := h.Index.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Precompressed.Sync(files); err != nil {
+		t.Fatal(err)
+	}
+	rec = get("/assets/baseq3/"+checksum+"-pak0.pk3", map[string]string{"Accept-Encoding": "gzip"})
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Body.Len() >= len(data) {
+		t.Fatalf("expected gzip encoding, received %q with %d bytes", rec.Header().Get("Content-Encoding"), rec.Body.Len())
+	}
+	if diff := cmp.Diff(`"`+checksum+`-gzip"`, rec.Header().Get("ETag")); diff != "" {
+		t.Errorf("content: after GET with gzip ETag differs: (-want +got)\n%s", diff)
+	}
+}
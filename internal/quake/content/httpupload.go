@@ -0,0 +1,177 @@
+package content
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ChrisRx/quake-kube/internal/quake/content/auth"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/upload"
+)
+
+// The upload endpoints follow the tus protocol (https://tus.io), so that
+// browsers can resume uploads with a tus client:
+//
+//   - POST /uploads creates a session, from the Upload-Length and
+//     Upload-Metadata headers, and returns its URL in Location.
+//   - HEAD /uploads/:id returns the offset of a session in Upload-Offset.
+//   - PATCH /uploads/:id appends the body to a session, starting at the
+//     Upload-Offset header.
+//   - DELETE /uploads/:id removes a session.
+//
+// Unlike tus, a complete session has to be finalized with POST
+// /uploads/:id/finalize, which verifies and installs the file.
+const (
+	headerTusResumable   = "Tus-Resumable"
+	headerUploadOffset   = "Upload-Offset"
+	headerUploadLength   = "Upload-Length"
+	headerUploadMetadata = "Upload-Metadata"
+
+	tusVersion             = "1.0.0"
+	contentTypeOffsetOctet = "application/offset+octet-stream"
+)
+
+type uploadResponse struct {
+	Name    string   `json:"name"`
+	Size    int64    `json:"size"`
+	Message string   `json:"message"`
+	Files   []string `json:"files"`
+}
+
+func (h *HTTPServer) registerUploadRoutes() {
+	h.POST("/uploads", func(c echo.Context) error {
+		c.Response().Header().Set(headerTusResumable, tusVersion)
+		size, err := strconv.ParseInt(c.Request().Header.Get(headerUploadLength), 10, 64)
+		if err != nil {
+			return c.String(http.StatusBadRequest, "invalid Upload-Length")
+		}
+		hdr, err := parseUploadMetadata(c.Request().Header.Get(headerUploadMetadata))
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		hdr.Size = size
+		sess, err := h.uploads.Create(c.Request().Context(), *hdr)
+		if err != nil {
+			return uploadError(c, err)
+		}
+		c.Response().Header().Set(echo.HeaderLocation, "/uploads/"+sess.ID)
+		c.Response().Header().Set(headerUploadOffset, "0")
+		return c.JSON(http.StatusCreated, sess)
+	})
+	h.HEAD("/uploads/:id", func(c echo.Context) error {
+		c.Response().Header().Set(headerTusResumable, tusVersion)
+		c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+		sess, err := h.uploads.Get(c.Param("id"))
+		if err != nil {
+			return uploadError(c, err)
+		}
+		c.Response().Header().Set(headerUploadOffset, strconv.FormatInt(sess.Offset, 10))
+		c.Response().Header().Set(headerUploadLength, strconv.FormatInt(sess.Size, 10))
+		return c.NoContent(http.StatusOK)
+	})
+	h.GET("/uploads/:id", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+		sess, err := h.uploads.Get(c.Param("id"))
+		if err != nil {
+			return uploadError(c, err)
+		}
+		return c.JSON(http.StatusOK, sess)
+	})
+	h.PATCH("/uploads/:id", func(c echo.Context) error {
+		c.Response().Header().Set(headerTusResumable, tusVersion)
+		if c.Request().Header.Get(echo.HeaderContentType) != contentTypeOffsetOctet {
+			return c.String(http.StatusUnsupportedMediaType, "Content-Type must be "+contentTypeOffsetOctet)
+		}
+		offset, err := strconv.ParseInt(c.Request().Header.Get(headerUploadOffset), 10, 64)
+		if err != nil {
+			return c.String(http.StatusBadRequest, "invalid Upload-Offset")
+		}
+		sess, err := h.uploads.Write(c.Request().Context(), c.Param("id"), offset, c.Request().Body)
+		if err != nil {
+			return uploadError(c, err)
+		}
+		c.Response().Header().Set(headerUploadOffset, strconv.FormatInt(sess.Offset, 10))
+		return c.NoContent(http.StatusNoContent)
+	})
+	h.POST("/uploads/:id/finalize", func(c echo.Context) error {
+		sess, err := h.uploads.Get(c.Param("id"))
+		if err != nil {
+			return uploadError(c, err)
+		}
+		result, err := h.uploads.Finalize(c.Request().Context(), sess.ID)
+		if err != nil {
+			return uploadError(c, err)
+		}
+		h.Index.Invalidate()
+		return c.JSON(http.StatusOK, &uploadResponse{
+			Name:    sess.Name,
+			Size:    sess.Size,
+			Message: result.Message,
+			Files:   result.Files,
+		})
+	})
+	h.DELETE("/uploads/:id", func(c echo.Context) error {
+		c.Response().Header().Set(headerTusResumable, tusVersion)
+		if err := h.uploads.Delete(c.Request().Context(), c.Param("id")); err != nil {
+			return uploadError(c, err)
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+}
+
+// parseUploadMetadata parses the tus Upload-Metadata header, which is a comma
+// separated list of keys and base64 encoded values. The file name is the name
+// (or filename) key, and the game directory and checksum are the game and
+// checksum keys.
+func parseUploadMetadata(s string) (*upload.Header, error) {
+	hdr := &upload.Header{}
+	for _, pair := range strings.Split(s, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Metadata value for %q: %w", key, err)
+		}
+		switch key {
+		case "name", "filename":
+			hdr.Name = string(data)
+		case "game":
+			hdr.GameName = string(data)
+		case "checksum":
+			n, err := strconv.ParseUint(string(data), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Upload-Metadata checksum %q", data)
+			}
+			hdr.Checksum = uint32(n)
+		}
+	}
+	return hdr, nil
+}
+
+// uploadError responds with the HTTP status for the errors of the upload
+// package.
+func uploadError(c echo.Context, err error) error {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, upload.ErrInvalid), errors.Is(err, upload.ErrIncomplete):
+		code = http.StatusBadRequest
+	case errors.Is(err, upload.ErrTooLarge), errors.Is(err, auth.ErrQuotaExceeded):
+		code = http.StatusRequestEntityTooLarge
+	case errors.Is(err, upload.ErrChecksumMismatch):
+		code = http.StatusUnprocessableEntity
+	case errors.Is(err, upload.ErrNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, upload.ErrOffsetMismatch), errors.Is(err, upload.ErrBusy):
+		code = http.StatusConflict
+	default:
+		return err
+	}
+	return c.String(code, err.Error())
+}
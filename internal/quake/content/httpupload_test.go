@@ -0,0 +1,86 @@
+package content
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ChrisRx/quake-kube/internal/quake/content/storage"
+)
+
+func TestHTTPUpload(t *testing.T) {
+	dir := t.TempDir()
+	h := NewHTTPContentServer(context.Background(), dir, storage.NewLocal(dir))
+	h.Auth = newTestAuth(t)
+	do := func(method, path string, headers map[string]string, body string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	b64 := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+	data := "pak0pak1pak2"
+	rec := do(http.MethodPost, "/uploads", map[string]string{
+		headerUploadLength:   fmt.Sprint(len(data)),
+		headerUploadMetadata: fmt.Sprintf("filename %s,game %s,checksum %s", b64("maps.pk3"), b64("osp"), b64(fmt.Sprint(crc32.ChecksumIEEE([]byte(data))))),
+	}, "")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, received %d: %s", http.StatusCreated, rec.Code, rec.Body)
+	}
+	location := rec.Header().Get("Location")
+
+	patch := func(offset int, body string) *httptest.ResponseRecorder {
+		return do(http.MethodPatch, location, map[string]string{
+			"Content-Type":     contentTypeOffsetOctet,
+			headerUploadOffset: fmt.Sprint(offset),
+		}, body)
+	}
+	if rec := patch(0, data[:4]); rec.Code != http.StatusNoContent || rec.Header().Get(headerUploadOffset) != "4" {
+		t.Fatalf("expected offset 4, received %d %q: %s", rec.Code, rec.Header().Get(headerUploadOffset), rec.Body)
+	}
+	if rec := patch(0, data); rec.Code != http.StatusConflict {
+		t.Fatalf("expected status %d, received %d", http.StatusConflict, rec.Code)
+	}
+	rec = do(http.MethodHead, location, nil, "")
+	if rec.Code != http.StatusOK || rec.Header().Get(headerUploadOffset) != "4" {
+		t.Fatalf("expected offset 4, received %d %q", rec.Code, rec.Header().Get(headerUploadOffset))
+	}
+	if rec := patch(4, data[4:]); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, received %d: %s", http.StatusNoContent, rec.Code, rec.Body)
+	}
+
+	// Upload sessions are never served as assets.
+	id := filepath.Base(location)
+	if rec := do(http.MethodGet, "/assets/.uploads/"+id+"/data", nil, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, received %d", http.StatusNotFound, rec.Code)
+	}
+
+	rec = do(http.MethodPost, location+"/finalize", nil, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, received %d: %s", http.StatusOK, rec.Code, rec.Body)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "osp", "maps.pk3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != data {
+		t.Fatalf("expected %q, received %q", data, got)
+	}
+	if rec := do(http.MethodHead, location, nil, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, received %d", http.StatusNotFound, rec.Code)
+	}
+}
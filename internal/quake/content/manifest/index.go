@@ -0,0 +1,424 @@
+package manifest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/ChrisRx/quake-kube/internal/quake/content/storage"
+	contentutil "github.com/ChrisRx/quake-kube/internal/quake/content/util"
+	"github.com/ChrisRx/quake-kube/internal/run"
+	fsutil "github.com/ChrisRx/quake-kube/internal/util/fs"
+)
+
+// IndexFile is the file in the storage that the index is saved to, so that
+// the checksums are kept across restarts. It is hidden, so it is never
+// served.
+const IndexFile = ".manifest.json"
+
+// watchDelay is how long the watcher waits for more changes before updating
+// the index, so that copying many files only updates it once.
+const watchDelay = 500 * time.Millisecond
+
+// pollInterval is how often storages that can't be watched, such as object
+// stores, are checked for changes made by other content servers.
+var pollInterval = 30 * time.Second
+
+// exts are the extensions of the files in the manifest.
+var exts = []string{".pk3", ".sh", ".run"}
+
+type entry struct {
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+	Checksum uint32    `json:"checksum"`
+
+	// Maps are the maps of a map pack. They are never nil for map packs, so
+	// that map packs of indexes saved before the maps were indexed are read
+	// again.
+	Maps []*contentutil.Map `json:"maps"`
+}
+
+type snapshot struct {
+	gen   uint64
+	files []*contentutil.File
+	infos map[string]*contentutil.FileInfo
+	maps  []*contentutil.Map
+	etag  string
+}
+
+// Index is a checksum index of the files in a storage, and of the maps in its
+// map packs. Files are only read when they are new, or their size or
+// modification time changed, and the manifest is served from memory until
+// the index is invalidated.
+type Index struct {
+	storage storage.Storage
+
+	notifyMu sync.Mutex
+	notify   []func()
+
+	// mu serializes updates of the entries.
+	mu      sync.Mutex
+	entries map[string]entry
+	loaded  bool
+
+	gen      atomic.Uint64
+	current  atomic.Pointer[snapshot]
+	watching atomic.Bool
+}
+
+func NewIndex(s storage.Storage) *Index {
+	return &Index{storage: s}
+}
+
+// Files returns the manifest of the storage and its ETag. The index
+// is updated first if it was invalidated.
+func (x *Index) Files() ([]*contentutil.File, string, error) {
+	if s := x.current.Load(); s != nil && s.gen == x.gen.Load() {
+		return s.files, s.etag, nil
+	}
+	if err := x.Update(); err != nil {
+		return nil, "", err
+	}
+	s := x.current.Load()
+	return s.files, s.etag, nil
+}
+
+// File returns the manifest entry of a file, or nil if the file isn't in the
+// manifest.
+func (x *Index) File(name string) (*contentutil.File, error) {
+	if _, _, err := x.Files(); err != nil {
+		return nil, err
+	}
+	info, ok := x.current.Load().infos[name]
+	if !ok {
+		return nil, nil
+	}
+	return &info.File, nil
+}
+
+// Maps returns the maps in the map packs of the storage. Map packs that can't
+// be read, such as corrupt files, are skipped, since the server skips them
+// too.
+func (x *Index) Maps() ([]*contentutil.Map, error) {
+	if _, _, err := x.Files(); err != nil {
+		return nil, err
+	}
+	return x.current.Load().maps, nil
+}
+
+// Stat returns information about a file in the storage, like
+// contentutil.StatFile, from the index. Files that aren't in the manifest,
+// such as zip files, are read from the storage.
+func (x *Index) Stat(ctx context.Context, name string) (*contentutil.FileInfo, error) {
+	name, err := cleanName(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := x.Files(); err != nil {
+		return nil, err
+	}
+	if info, ok := x.current.Load().infos[name]; ok {
+		return info, nil
+	}
+	return contentutil.StatFile(ctx, x.storage, name)
+}
+
+// MoveFile renames a file like contentutil.MoveFile. The contents of the file
+// don't change, so its checksum and maps are kept rather than read again.
+func (x *Index) MoveFile(ctx context.Context, name, newName string, used []contentutil.UsedMap) (*contentutil.File, error) {
+	if err := contentutil.MoveFile(ctx, x.storage, name, newName, used); err != nil {
+		return nil, err
+	}
+
+	// The names are valid, since the file was moved.
+	name, _ = cleanName(name)
+	newName, _ = cleanName(newName)
+	x.mu.Lock()
+	if e, ok := x.entries[name]; ok {
+		if fi, err := x.storage.Stat(ctx, newName); err == nil && fi.Size == e.Size {
+			e.ModTime = fi.ModTime
+			if e.Maps != nil {
+				maps := make([]*contentutil.Map, 0, len(e.Maps))
+				for _, m := range e.Maps {
+					m := *m
+					m.File = newName
+					maps = append(maps, &m)
+				}
+				e.Maps = maps
+			}
+			x.entries[newName] = e
+		}
+	}
+	x.mu.Unlock()
+
+	x.Invalidate()
+	info, err := x.Stat(ctx, newName)
+	if err != nil {
+		return nil, err
+	}
+	return &info.File, nil
+}
+
+// cleanName returns the clean, slash-separated name of a file in the storage.
+func cleanName(name string) (string, error) {
+	name, err := contentutil.CleanName(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(name), nil
+}
+
+// Invalidate marks the index as out of date, e.g. after an upload, so that it
+// is updated before the manifest is served again.
+func (x *Index) Invalidate() {
+	x.gen.Add(1)
+}
+
+// Update brings the index up to date with the storage.
+func (x *Index) Update() error {
+	changed, err := x.update()
+	if err != nil {
+		return err
+	}
+	if changed {
+		x.notifyMu.Lock()
+		defer x.notifyMu.Unlock()
+		for _, fn := range x.notify {
+			fn()
+		}
+	}
+	return nil
+}
+
+// Notify adds a function that is called after each update that found new,
+// changed or removed files.
+func (x *Index) Notify(fn func()) {
+	x.notifyMu.Lock()
+	defer x.notifyMu.Unlock()
+
+	x.notify = append(x.notify, fn)
+}
+
+func (x *Index) update() (bool, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	ctx := context.Background()
+	gen := x.gen.Load()
+	if !x.loaded {
+		if err := x.load(ctx); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("manifest: cannot load index, rebuilding: %v\n", err)
+		}
+		x.loaded = true
+	}
+	infos, err := x.storage.List(ctx)
+	if err != nil {
+		return false, err
+	}
+	entries := make(map[string]entry)
+	files := make([]*contentutil.File, 0)
+	snap := &snapshot{gen: gen, infos: make(map[string]*contentutil.FileInfo), maps: make([]*contentutil.Map, 0)}
+	changed := false
+	for _, info := range infos {
+		if !fsutil.HasExts(info.Name, exts...) {
+			continue
+		}
+		e, ok := x.entries[info.Name]
+		isMapPack := fsutil.HasExts(info.Name, ".pk3")
+		if !ok || e.Size != info.Size || !e.ModTime.Equal(info.ModTime) || (isMapPack && e.Maps == nil) {
+			e, err = x.read(ctx, info)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return false, err
+			}
+			changed = true
+		}
+		entries[info.Name] = e
+		f := &contentutil.File{Name: info.Name, Compressed: e.Size, Checksum: e.Checksum}
+		files = append(files, f)
+		fi := &contentutil.FileInfo{File: *f, ModTime: e.ModTime}
+		for _, m := range e.Maps {
+			fi.Maps = append(fi.Maps, m.Name)
+		}
+		snap.infos[info.Name] = fi
+		snap.maps = append(snap.maps, e.Maps...)
+	}
+	if len(entries) != len(x.entries) {
+		changed = true
+	}
+	x.entries = entries
+	snap.files = files
+	snap.etag = ETag(files)
+	x.current.Store(snap)
+	if changed {
+		// The index is only a cache, so the manifest is still served when it
+		// can't be saved, e.g. on a read-only volume.
+		if err := x.save(); err != nil {
+			log.Printf("manifest: cannot save index: %v\n", err)
+		}
+	}
+	return changed, nil
+}
+
+// read returns the entry of a file, with its checksum and the maps of map
+// packs.
+func (x *Index) read(ctx context.Context, info *storage.FileInfo) (entry, error) {
+	f, err := x.storage.Open(ctx, info.Name)
+	if err != nil {
+		return entry{}, err
+	}
+	defer f.Close()
+
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, f); err != nil {
+		return entry{}, err
+	}
+	e := entry{Size: info.Size, ModTime: info.ModTime, Checksum: h.Sum32()}
+	if fsutil.HasExts(info.Name, ".pk3") {
+		maps, err := contentutil.ReadMapPack(f, info.Size, info.Name)
+		if err != nil {
+			log.Printf("manifest: cannot read maps of map pack %s, skipping them: %v\n", info.Name, err)
+		}
+		e.Maps = append(make([]*contentutil.Map, 0), maps...)
+	}
+	return e, nil
+}
+
+func (x *Index) load(ctx context.Context) error {
+	f, err := x.storage.Open(ctx, IndexFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewDecoder(f).Decode(&x.entries)
+}
+
+func (x *Index) save() error {
+	data, err := json.Marshal(x.entries)
+	if err != nil {
+		return err
+	}
+	w, err := x.storage.Create(context.Background(), IndexFile)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.Commit()
+}
+
+// Watch updates the index when files in the storage change, until the context
+// is done. Local storages are watched for changes, other storages are polled.
+// The index can be shared by several servers, so only the first call watches
+// and the others return immediately.
+func (x *Index) Watch(ctx context.Context) error {
+	if !x.watching.CompareAndSwap(false, true) {
+		return nil
+	}
+	defer x.watching.Store(false)
+
+	l, ok := x.storage.(*storage.Local)
+	if !ok {
+		if err := x.Update(); err != nil {
+			log.Printf("manifest: %v\n", err)
+		}
+		run.Until(func() {
+			x.Invalidate()
+			if err := x.Update(); err != nil {
+				log.Printf("manifest: %v\n", err)
+			}
+		}, ctx.Done(), pollInterval)
+		return nil
+	}
+	dir := l.Dir()
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return err
+		}
+		if path != dir && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		return w.Add(path)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot watch %s: %w", dir, err)
+	}
+	if err := x.Update(); err != nil {
+		log.Printf("manifest: %v\n", err)
+	}
+
+	var update <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if strings.HasPrefix(filepath.Base(ev.Name), ".") {
+				continue
+			}
+			// New game directories are watched too.
+			if ev.Has(fsnotify.Create) {
+				if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() {
+					if err := w.Add(ev.Name); err != nil {
+						log.Printf("manifest: cannot watch %s: %v\n", ev.Name, err)
+					}
+				}
+			}
+			if update == nil {
+				update = time.After(watchDelay)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("manifest: %v\n", err)
+		case <-update:
+			update = nil
+			x.Invalidate()
+			if err := x.Update(); err != nil {
+				log.Printf("manifest: %v\n", err)
+			}
+		}
+	}
+}
+
+// ETag returns the entity tag of a manifest, which changes whenever a file is
+// added, removed or changed.
+func ETag(files []*contentutil.File) string {
+	h := sha256.New()
+	for _, f := range files {
+		fmt.Fprintf(h, "%s %d %d\n", f.Name, f.Compressed, f.Checksum)
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
@@ -0,0 +1,201 @@
+package manifest
+
+import (
+	"archive/zip"
+	"context"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ChrisRx/quake-kube/internal/quake/content/storage"
+	contentutil "github.com/ChrisRx/quake-kube/internal/quake/content/util"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func file(name, data string) *contentutil.File {
+	return &contentutil.File{Name: name, Compressed: int64(len(data)), Checksum: crc32.ChecksumIEEE([]byte(data))}
+}
+
+func TestIndex(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "baseq3", "pak0.pk3"), "pak0")
+	writeFile(t, filepath.Join(dir, "baseq3", "maps.pk3"), "maps")
+	writeFile(t, filepath.Join(dir, "baseq3", "server.cfg"), "map q3dm17")
+	writeFile(t, filepath.Join(dir, ".uploads", "abc", "data.pk3"), "data")
+
+	x := NewIndex(storage.NewLocal(dir))
+	files, etag, err := x.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*contentutil.File{file("baseq3/maps.pk3", "maps"), file("baseq3/pak0.pk3", "pak0")}
+	if diff := cmp.Diff(expected, files); diff != "" {
+		t.Errorf("manifest: after Files differs: (-want +got)\n%s", diff)
+	}
+
+	// Files with the same size and modification time aren't checksummed
+	// again, also by a new index that loads the saved one.
+	path := filepath.Join(dir, "baseq3", "pak0.pk3")
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path, "PAK0")
+	if err := os.Chtimes(path, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	x = NewIndex(storage.NewLocal(dir))
+	files, cached, err := x.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(expected, files); diff != "" {
+		t.Errorf("manifest: after Files of saved index differs: (-want +got)\n%s", diff)
+	}
+	if cached != etag {
+		t.Errorf("expected etag %s, received %s", etag, cached)
+	}
+
+	// Changed files are checksummed once the index is invalidated.
+	writeFile(t, filepath.Join(dir, "baseq3", "maps.pk3"), "maps2")
+	if files, _, _ := x.Files(); !cmp.Equal(expected, files) {
+		t.Errorf("expected the manifest to be served from memory, received %v", files)
+	}
+	x.Invalidate()
+	files, updated, err := x.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []*contentutil.File{file("baseq3/maps.pk3", "maps2"), file("baseq3/pak0.pk3", "pak0")}
+	if diff := cmp.Diff(expected, files); diff != "" {
+		t.Errorf("manifest: after Invalidate differs: (-want +got)\n%s", diff)
+	}
+	if updated == etag {
+		t.Errorf("expected etag to change, received %s", updated)
+	}
+}
+
+func TestIndexWatch(t *testing.T) {
+	dir := t.TempDir()
+	x := NewIndex(storage.NewLocal(dir))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errch := make(chan error, 1)
+	go func() { errch <- x.Watch(ctx) }()
+
+	// The game directory is created after the watch started.
+	expected := []*contentutil.File{file("osp/maps.pk3", "maps")}
+	var files []*contentutil.File
+	for i := 0; i < 100 && !cmp.Equal(expected, files); i++ {
+		if i == 10 {
+			writeFile(t, filepath.Join(dir, "osp", "maps.pk3"), "maps")
+		}
+		time.Sleep(50 * time.Millisecond)
+		var err error
+		files, _, err = x.Files()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if diff := cmp.Diff(expected, files); diff != "" {
+		t.Errorf("manifest: after Watch differs: (-want +got)\n%s", diff)
+	}
+	cancel()
+	if err := <-errch; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// countingStorage counts the files opened, other than the hidden ones such as
+// the saved index.
+type countingStorage struct {
+	*storage.Local
+	opened atomic.Int32
+}
+
+func (s *countingStorage) Open(ctx context.Context, name string) (storage.File, error) {
+	if !strings.HasPrefix(name, ".") {
+		s.opened.Add(1)
+	}
+	return s.Local.Open(ctx, name)
+}
+
+func TestIndexMaps(t *testing.T) {
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "maps.pk3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	if _, err := zw.Create("maps/q3dm17.bsp"); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	writeFile(t, filepath.Join(dir, "baseq3", "broken.pk3"), "broken")
+	if err := os.Rename(filepath.Join(dir, "maps.pk3"), filepath.Join(dir, "baseq3", "maps.pk3")); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &countingStorage{Local: storage.NewLocal(dir)}
+	x := NewIndex(s)
+	maps, err := x.Maps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*contentutil.Map{{File: "baseq3/maps.pk3", Name: "q3dm17", UnknownEntities: true}}
+	if diff := cmp.Diff(expected, maps); diff != "" {
+		t.Errorf("manifest: after Maps differs: (-want +got)\n%s", diff)
+	}
+
+	// Listing, stating and moving files doesn't read them again.
+	opened := s.opened.Load()
+	ctx := context.Background()
+	if _, err := x.Maps(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := x.Stat(ctx, "baseq3/maps.pk3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"q3dm17"}, info.Maps); diff != "" {
+		t.Errorf("manifest: after Stat differs: (-want +got)\n%s", diff)
+	}
+	moved, err := x.MoveFile(ctx, "baseq3/maps.pk3", "osp/maps.pk3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.Name != "osp/maps.pk3" || moved.Checksum != info.Checksum {
+		t.Errorf("expected osp/maps.pk3 with checksum %d, received %+v", info.Checksum, moved)
+	}
+	maps, err = x.Maps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []*contentutil.Map{{File: "osp/maps.pk3", Name: "q3dm17", UnknownEntities: true}}
+	if diff := cmp.Diff(expected, maps); diff != "" {
+		t.Errorf("manifest: after MoveFile differs: (-want +got)\n%s", diff)
+	}
+	if n := s.opened.Load() - opened; n != 0 {
+		t.Errorf("expected no files to be read again, %d were", n)
+	}
+}
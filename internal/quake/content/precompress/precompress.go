@@ -0,0 +1,226 @@
+package precompress
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+
+	contentutil "github.com/ChrisRx/quake-kube/internal/quake/content/util"
+)
+
+// Dir is the directory in the assets directory with the precompressed
+// variants of the files. It is hidden, so it is never served directly.
+const Dir = ".compressed"
+
+// Encodings are the supported content encodings, in order of preference.
+var Encodings = []string{"br", "gzip"}
+
+var exts = map[string]string{
+	"br":   ".br",
+	"gzip": ".gz",
+}
+
+// Store keeps precompressed variants of the files in the manifest. A variant
+// is named by the checksum of the file, so a changed file never gets the
+// variant of its previous contents.
+//
+// Map packs are already compressed, so a variant is only kept when it is
+// smaller than the file. Otherwise an empty file is written, which records
+// that compressing the file isn't worthwhile.
+type Store struct {
+	dir       string
+	encodings []string
+	notify    chan struct{}
+}
+
+func NewStore(assetsDir string, encodings ...string) (*Store, error) {
+	for _, enc := range encodings {
+		if _, ok := exts[enc]; !ok {
+			return nil, fmt.Errorf("unknown encoding %q, must be one of: %s", enc, strings.Join(Encodings, ", "))
+		}
+	}
+	return &Store{
+		dir:       assetsDir,
+		encodings: encodings,
+		notify:    make(chan struct{}, 1),
+	}, nil
+}
+
+func (s *Store) path(f *contentutil.File, enc string) string {
+	dir, base := filepath.Split(f.Name)
+	return filepath.Join(s.dir, Dir, dir, strconv.FormatUint(uint64(f.Checksum), 10)+"-"+base+exts[enc])
+}
+
+// Negotiate returns the path of the preferred variant of a file accepted by
+// the Accept-Encoding header, and its encoding. The path is empty when the
+// file should be served as is.
+func (s *Store) Negotiate(acceptEncoding string, f *contentutil.File) (string, string) {
+	accepted := parseAcceptEncoding(acceptEncoding)
+	for _, enc := range Encodings {
+		if !accepted[enc] || !s.has(enc) {
+			continue
+		}
+		path := s.path(f, enc)
+		if fi, err := os.Stat(path); err == nil && fi.Size() > 0 && fi.Size() < f.Compressed {
+			return path, enc
+		}
+	}
+	return "", ""
+}
+
+func (s *Store) has(enc string) bool {
+	for _, e := range s.encodings {
+		if e == enc {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAcceptEncoding returns the encodings accepted by the Accept-Encoding
+// header, excluding the ones with a quality of 0.
+func parseAcceptEncoding(header string) map[string]bool {
+	accepted := make(map[string]bool)
+	for _, s := range strings.Split(header, ",") {
+		enc, params, _ := strings.Cut(strings.TrimSpace(s), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if enc != "" && q > 0 {
+			accepted[strings.ToLower(enc)] = true
+		}
+	}
+	return accepted
+}
+
+// Notify signals that the files changed, so that missing variants are
+// generated. It never blocks.
+func (s *Store) Notify() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Run generates the variants of the files in the manifest, and removes the
+// variants of files that were changed or removed, whenever it is notified
+// and once at the start, until the context is done.
+func (s *Store) Run(ctx context.Context, files func() ([]*contentutil.File, string, error)) {
+	s.Notify()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.notify:
+			fs, _, err := files()
+			if err != nil {
+				log.Printf("precompress: %v\n", err)
+				continue
+			}
+			if err := s.Sync(fs); err != nil {
+				log.Printf("precompress: %v\n", err)
+			}
+		}
+	}
+}
+
+// Sync generates the missing variants of the files, and removes all other
+// variants.
+func (s *Store) Sync(files []*contentutil.File) error {
+	keep := make(map[string]bool)
+	for _, f := range files {
+		for _, enc := range s.encodings {
+			path := s.path(f, enc)
+			keep[path] = true
+			if _, err := os.Stat(path); err == nil {
+				continue
+			}
+			// Files removed since the manifest was read are skipped.
+			if err := s.compress(f, enc); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return filepath.Walk(filepath.Join(s.dir, Dir), func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if !keep[path] {
+			return os.Remove(path)
+		}
+		return nil
+	})
+}
+
+func (s *Store) compress(f *contentutil.File, enc string) error {
+	path := s.path(f, enc)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	src, err := os.Open(filepath.Join(s.dir, f.Name))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	var w io.WriteCloser
+	switch enc {
+	case "br":
+		w = brotli.NewWriterLevel(tmp, brotli.DefaultCompression)
+	case "gzip":
+		w, err = gzip.NewWriterLevel(tmp, gzip.BestCompression)
+		if err != nil {
+			return err
+		}
+	}
+	crc := crc32.NewIEEE()
+	if _, err := io.Copy(io.MultiWriter(w, crc), src); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	// The file changed since it was checksummed, and its variant is generated
+	// once the index is updated.
+	if crc.Sum32() != f.Checksum {
+		return nil
+	}
+	fi, err := tmp.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() >= f.Compressed {
+		if err := tmp.Truncate(0); err != nil {
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
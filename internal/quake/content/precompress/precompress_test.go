@@ -0,0 +1,79 @@
+package precompress
+
+import (
+	"bytes"
+	"crypto/rand"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	contentutil "github.com/ChrisRx/quake-kube/internal/quake/content/util"
+)
+
+func writeFile(t *testing.T, dir, name string, data []byte) *contentutil.File {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &contentutil.File{Name: name, Compressed: int64(len(data)), Checksum: crc32.ChecksumIEEE(data)}
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewStore(dir, "gzip", "br")
+	if err != nil {
+		t.Fatal(err)
+	}
+	random := make([]byte, 4096)
+	rand.Read(random)
+	text := writeFile(t, dir, "baseq3/text.pk3", bytes.Repeat([]byte("pak0"), 1024))
+	noise := writeFile(t, dir, "baseq3/noise.pk3", random)
+	if err := s.Sync([]*contentutil.File{text, noise}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name           string
+		file           *contentutil.File
+		acceptEncoding string
+		expected       string
+	}{
+		{"preferred", text, "gzip, deflate, br", "br"},
+		{"quality", text, "gzip, br;q=0", "gzip"},
+		{"not accepted", text, "deflate", ""},
+		{"not smaller", noise, "gzip, br", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path, enc := s.Negotiate(c.acceptEncoding, c.file)
+			if enc != c.expected {
+				t.Fatalf("expected encoding %q, received %q", c.expected, enc)
+			}
+			if enc != "" && path != s.path(c.file, enc) {
+				t.Fatalf("expected path %q, received %q", s.path(c.file, enc), path)
+			}
+		})
+	}
+
+	// The variants of changed and removed files are removed.
+	changed := writeFile(t, dir, "baseq3/text.pk3", bytes.Repeat([]byte("pak1"), 1024))
+	if err := s.Sync([]*contentutil.File{changed}); err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, Dir, "baseq3", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{s.path(changed, "br"), s.path(changed, "gzip")}
+	if diff := cmp.Diff(expected, files); diff != "" {
+		t.Errorf("precompress: after Sync differs: (-want +got)\n%s", diff)
+	}
+}
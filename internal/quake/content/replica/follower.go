@@ -0,0 +1,301 @@
+package replica
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/ChrisRx/quake-kube/internal/log"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/auth"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/manifest"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/storage"
+	contentutil "github.com/ChrisRx/quake-kube/internal/quake/content/util"
+	"github.com/ChrisRx/quake-kube/internal/run"
+)
+
+var (
+	lag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "quake_content_replication_lag_seconds",
+		Help: "Seconds since the last successful sync with an upstream content server",
+	}, []string{"upstream"})
+	pending = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "quake_content_replication_pending_files",
+		Help: "Files that differ from an upstream content server, as of the last sync",
+	}, []string{"upstream"})
+	replicated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "quake_content_replication_files",
+		Help: "Files downloaded from, or removed like, an upstream content server",
+	}, []string{"upstream", "op"})
+	syncErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "quake_content_replication_errors",
+		Help: "Failed syncs with an upstream content server",
+	}, []string{"upstream"})
+)
+
+// StateFile is the file in the assets directory that the files seen on each
+// upstream are saved to, next to the manifest index, so that files removed
+// upstream while the follower was down are still removed. It is hidden, so
+// it is never served.
+const StateFile = ".follow.json"
+
+// Follower mirrors the files of upstream content servers to the assets
+// directory, which must be the storage of the index. It polls the manifest of each upstream, and downloads the files
+// that were added or changed, and removes the files that were removed.
+//
+// Only changes made upstream since the last poll are mirrored, so files
+// uploaded to, or removed from, the follower itself are kept until they are
+// changed upstream. When a file was changed on both sides, or differs on the
+// first poll, the file with the higher checksum wins. This way content
+// servers that follow each other end up with the same files.
+type Follower struct {
+	Dir       string
+	Index     *manifest.Index
+	Upstreams []string
+	Interval  time.Duration
+
+	// Token is sent to the upstreams, and TLSConfig is used to connect to
+	// them, e.g. with a client certificate, when they require authentication
+	// to read.
+	Token     string
+	TLSConfig *tls.Config
+
+	// mu serializes syncs, so that upstreams don't change the same files at
+	// the same time, and guards state.
+	mu     sync.Mutex
+	client *http.Client
+	state  map[string]map[string]uint32
+}
+
+type upstream struct {
+	url      string
+	etag     string
+	seen     map[string]uint32
+	lastSync time.Time
+}
+
+// Run follows the upstreams until the context is done.
+func (f *Follower) Run(ctx context.Context) {
+	if f.Interval == 0 {
+		f.Interval = 30 * time.Second
+	}
+	if err := f.loadState(); err != nil {
+		log.Warn("cannot read the files seen upstream, starting over", "path", filepath.Join(f.Dir, StateFile), "error", err)
+	}
+	var wg sync.WaitGroup
+	for _, u := range f.Upstreams {
+		url := strings.TrimSuffix(u, "/")
+		wg.Add(1)
+		go func(up *upstream) {
+			defer wg.Done()
+
+			f.follow(ctx, up)
+		}(&upstream{url: url, seen: f.state[url], lastSync: time.Now()})
+	}
+	wg.Wait()
+}
+
+// httpClient returns the client used for the upstreams, which authenticates
+// with the token and TLS config of the follower.
+func (f *Follower) httpClient() *http.Client {
+	if f.client != nil {
+		return f.client
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = f.TLSConfig
+	var rt http.RoundTripper = transport
+	if f.Token != "" {
+		rt = auth.TokenTransport(f.Token, transport)
+	}
+	f.client = &http.Client{Transport: rt, Timeout: 30 * time.Minute}
+	return f.client
+}
+
+// loadState reads the files seen on the upstreams before a restart.
+func (f *Follower) loadState() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := os.ReadFile(filepath.Join(f.Dir, StateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &f.state)
+}
+
+// saveState saves the files seen on an upstream. The lock must be held.
+func (f *Follower) saveState(url string, seen map[string]uint32) error {
+	if f.state == nil {
+		f.state = make(map[string]map[string]uint32)
+	}
+	f.state[url] = seen
+	data, err := json.Marshal(f.state)
+	if err != nil {
+		return err
+	}
+	w, err := storage.NewLocal(f.Dir).Create(context.Background(), StateFile)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.Commit()
+}
+
+func (f *Follower) follow(ctx context.Context, up *upstream) {
+	poll := func() {
+		if err := f.sync(ctx, up); err != nil {
+			syncErrors.WithLabelValues(up.url).Inc()
+			log.Warn("cannot sync with upstream", "upstream", up.url, "error", err)
+		}
+		lag.WithLabelValues(up.url).Set(time.Since(up.lastSync).Seconds())
+	}
+	poll()
+	run.Until(poll, ctx.Done(), f.Interval)
+}
+
+func (f *Follower) sync(ctx context.Context, up *upstream) error {
+	client := f.httpClient()
+	files, etag, err := fetchManifest(ctx, client, up.url, up.etag)
+	if err != nil {
+		return err
+	}
+	// The manifest didn't change since the last sync.
+	if files == nil {
+		up.lastSync = time.Now()
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	current, _, err := f.Index.Files()
+	if err != nil {
+		return err
+	}
+	local := make(map[string]uint32, len(current))
+	for _, file := range current {
+		local[file.Name] = file.Checksum
+	}
+	downloads, removals := plan(up.seen, files, local)
+	pending.WithLabelValues(up.url).Set(float64(len(downloads) + len(removals)))
+	if len(downloads) > 0 || len(removals) > 0 {
+		defer f.Index.Invalidate()
+	}
+
+	if err := contentutil.DownloadFilesWithClient(ctx, client, up.url, f.Dir, downloads); err != nil {
+		return err
+	}
+	replicated.WithLabelValues(up.url, "download").Add(float64(len(downloads)))
+	dir := storage.NewLocal(f.Dir)
+	for _, name := range removals {
+		if err := contentutil.DeleteFile(ctx, dir, name, nil); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		log.Info("removed asset", "name", name, "upstream", up.url)
+		replicated.WithLabelValues(up.url, "remove").Inc()
+	}
+	pending.WithLabelValues(up.url).Set(0)
+
+	seen := make(map[string]uint32, len(files))
+	for _, file := range files {
+		seen[file.Name] = file.Checksum
+	}
+	if err := f.saveState(up.url, seen); err != nil {
+		return err
+	}
+	up.seen = seen
+	up.etag = etag
+	up.lastSync = time.Now()
+	return nil
+}
+
+// plan returns the files to download from an upstream, and the files to
+// remove, given the files seen upstream on the previous sync (nil for the
+// first sync), the upstream files now and the local checksums.
+func plan(seen map[string]uint32, files []*contentutil.File, local map[string]uint32) ([]*contentutil.File, []string) {
+	downloads := make([]*contentutil.File, 0)
+	for _, file := range files {
+		l, ok := local[file.Name]
+		if ok && l == file.Checksum {
+			continue
+		}
+		s, wasSeen := seen[file.Name]
+		switch {
+		case wasSeen && s == file.Checksum:
+			// Unchanged upstream, so it was changed or removed locally.
+		case !ok:
+			downloads = append(downloads, file)
+		case wasSeen && l == s:
+			// Only changed upstream.
+			downloads = append(downloads, file)
+		case file.Checksum > l:
+			// Changed on both sides, or differing on the first sync.
+			downloads = append(downloads, file)
+		}
+	}
+
+	upstream := make(map[string]bool, len(files))
+	for _, file := range files {
+		upstream[file.Name] = true
+	}
+	removals := make([]string, 0)
+	for name, s := range seen {
+		// Files changed locally since are kept.
+		if l, ok := local[name]; ok && !upstream[name] && l == s {
+			removals = append(removals, name)
+		}
+	}
+	sort.Strings(removals)
+	return downloads, removals
+}
+
+// fetchManifest returns the manifest of a content server and its ETag, or nil
+// if it still has the ETag of the last request.
+func fetchManifest(ctx context.Context, client *http.Client, url, etag string) ([]*contentutil.File, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/assets/manifest.json", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, etag, nil
+	case http.StatusOK:
+	default:
+		return nil, "", fmt.Errorf("cannot get url %q: %v", req.URL, http.StatusText(resp.StatusCode))
+	}
+	files := make([]*contentutil.File, 0)
+	if err := json.NewDecoder(resp.Body).Decode(&files); err != nil {
+		return nil, "", fmt.Errorf("%w: cannot unmarshal %s/assets/manifest.json", err, url)
+	}
+	return files, resp.Header.Get("ETag"), nil
+}
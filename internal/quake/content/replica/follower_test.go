@@ -0,0 +1,204 @@
+package replica
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ChrisRx/quake-kube/internal/quake/content"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/manifest"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/storage"
+	contentutil "github.com/ChrisRx/quake-kube/internal/quake/content/util"
+)
+
+func TestPlan(t *testing.T) {
+	cases := []struct {
+		name      string
+		seen      map[string]uint32
+		upstream  map[string]uint32
+		local     map[string]uint32
+		downloads []string
+		removals  []string
+	}{
+		{
+			name:      "first sync",
+			upstream:  map[string]uint32{"baseq3/new.pk3": 1, "baseq3/low.pk3": 1, "baseq3/high.pk3": 3},
+			local:     map[string]uint32{"baseq3/low.pk3": 2, "baseq3/high.pk3": 2, "baseq3/local.pk3": 1},
+			downloads: []string{"baseq3/high.pk3", "baseq3/new.pk3"},
+		},
+		{
+			name:      "changed upstream",
+			seen:      map[string]uint32{"baseq3/maps.pk3": 1},
+			upstream:  map[string]uint32{"baseq3/maps.pk3": 0},
+			local:     map[string]uint32{"baseq3/maps.pk3": 1},
+			downloads: []string{"baseq3/maps.pk3"},
+		},
+		{
+			name:     "changed locally",
+			seen:     map[string]uint32{"baseq3/maps.pk3": 1, "baseq3/removed.pk3": 1},
+			upstream: map[string]uint32{"baseq3/maps.pk3": 1, "baseq3/removed.pk3": 1},
+			local:    map[string]uint32{"baseq3/maps.pk3": 2},
+		},
+		{
+			name:      "changed on both sides",
+			seen:      map[string]uint32{"baseq3/low.pk3": 1, "baseq3/high.pk3": 1},
+			upstream:  map[string]uint32{"baseq3/low.pk3": 2, "baseq3/high.pk3": 4},
+			local:     map[string]uint32{"baseq3/low.pk3": 3, "baseq3/high.pk3": 3},
+			downloads: []string{"baseq3/high.pk3"},
+		},
+		{
+			name:     "removed upstream",
+			seen:     map[string]uint32{"baseq3/removed.pk3": 1, "baseq3/changed.pk3": 1},
+			upstream: map[string]uint32{},
+			local:    map[string]uint32{"baseq3/removed.pk3": 1, "baseq3/changed.pk3": 2},
+			removals: []string{"baseq3/removed.pk3"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var files []*contentutil.File
+			for name, checksum := range c.upstream {
+				files = append(files, &contentutil.File{Name: name, Checksum: checksum})
+			}
+			downloads, removals := plan(c.seen, files, c.local)
+			names := make(map[string]bool)
+			for _, f := range downloads {
+				names[f.Name] = true
+			}
+			expected := make(map[string]bool)
+			for _, name := range c.downloads {
+				expected[name] = true
+			}
+			if diff := cmp.Diff(expected, names); diff != "" {
+				t.Errorf("replica: after plan downloads differ: (-want +got)\n%s", diff)
+			}
+			if diff := cmp.Diff(append([]string{}, c.removals...), removals); diff != "" {
+				t.Errorf("replica: after plan removals differ: (-want +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFollowerSync(t *testing.T) {
+	remote := t.TempDir()
+	writeFile(t, filepath.Join(remote, "baseq3", "pak0.pk3"), "pak0")
+	writeFile(t, filepath.Join(remote, "osp", "maps.pk3"), "maps")
+	h := content.NewHTTPContentServer(context.Background(), remote, storage.NewLocal(remote))
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	dir := t.TempDir()
+	f := &Follower{Dir: dir, Index: manifest.NewIndex(storage.NewLocal(dir))}
+	up := &upstream{url: s.URL}
+	sync := func() []*contentutil.File {
+		t.Helper()
+
+		if err := f.sync(context.Background(), up); err != nil {
+			t.Fatal(err)
+		}
+		files, _, err := f.Index.Files()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+	remoteFiles := func() []*contentutil.File {
+		t.Helper()
+
+		files, err := contentutil.ReadManifest(remote)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+
+	if diff := cmp.Diff(remoteFiles(), sync()); diff != "" {
+		t.Errorf("replica: after sync differs: (-want +got)\n%s", diff)
+	}
+	etag := up.etag
+	if etag == "" {
+		t.Fatal("expected the etag of the upstream manifest")
+	}
+	sync()
+	if up.etag != etag {
+		t.Fatalf("expected etag %s, received %s", etag, up.etag)
+	}
+
+	if err := os.Remove(filepath.Join(remote, "osp", "maps.pk3")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(remote, "baseq3", "ztn.pk3"), "ztn")
+	h.Index.Invalidate()
+	if diff := cmp.Diff(remoteFiles(), sync()); diff != "" {
+		t.Errorf("replica: after sync of changes differs: (-want +got)\n%s", diff)
+	}
+}
+
+func TestFollowerRestart(t *testing.T) {
+	remote := t.TempDir()
+	writeFile(t, filepath.Join(remote, "baseq3", "pak0.pk3"), "pak0")
+	writeFile(t, filepath.Join(remote, "osp", "maps.pk3"), "maps")
+	h := content.NewHTTPContentServer(context.Background(), remote, storage.NewLocal(remote))
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+
+	dir := t.TempDir()
+	f := &Follower{Dir: dir, Index: manifest.NewIndex(storage.NewLocal(dir)), Token: "secret"}
+	if err := f.sync(context.Background(), &upstream{url: s.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is removed upstream while the follower is down.
+	if err := os.Remove(filepath.Join(remote, "osp", "maps.pk3")); err != nil {
+		t.Fatal(err)
+	}
+	h.Index.Invalidate()
+
+	f = &Follower{Dir: dir, Index: manifest.NewIndex(storage.NewLocal(dir)), Token: "secret"}
+	if err := f.loadState(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.sync(context.Background(), &upstream{url: s.URL, seen: f.state[s.URL]}); err != nil {
+		t.Fatal(err)
+	}
+	files, _, err := f.Index.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteFiles, err := contentutil.ReadManifest(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(remoteFiles, files); diff != "" {
+		t.Errorf("replica: after sync following a restart differs: (-want +got)\n%s", diff)
+	}
+
+	f.Token = "wrong"
+	f.client = nil
+	if err := f.sync(context.Background(), &upstream{url: s.URL}); err == nil {
+		t.Error("expected an error syncing with the wrong token")
+	}
+}
@@ -7,17 +7,38 @@ import (
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/health"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 
 	contentapiv1 "github.com/ChrisRx/quake-kube/internal/quake/content/api/v1"
 	contentapiv2 "github.com/ChrisRx/quake-kube/internal/quake/content/api/v2"
+	contentapiv3 "github.com/ChrisRx/quake-kube/internal/quake/content/api/v3"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/auth"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/manifest"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/storage"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/upload"
+	contentutil "github.com/ChrisRx/quake-kube/internal/quake/content/util"
 	"github.com/ChrisRx/quake-kube/internal/run"
 	quakenet "github.com/ChrisRx/quake-kube/pkg/quake/net"
 )
 
 type RPCServer struct {
-	assetsDir string
+	storage storage.Storage
+	uploads *upload.Store
+
+	// UsedMaps returns the maps used by the server, whose map packs can't be
+	// deleted unless forced.
+	UsedMaps func() ([]contentutil.UsedMap, error)
+
+	// Index is the checksum index of the storage, which can be shared with the
+	// HTTP server.
+	Index *manifest.Index
+
+	// Auth authorizes the callers, when it is set. Callers need to be allowed
+	// to read to use the read methods, and to write to use all others.
+	Auth *auth.Authenticator
 
 	ctx context.Context
 	s   *grpc.Server
@@ -26,12 +47,15 @@ type RPCServer struct {
 	health     *health.Server
 }
 
-func NewRPCServer(ctx context.Context, assetsDir, serverAddr string) *RPCServer {
+// NewRPCServer returns a gRPC content server for the files in the storage.
+// Uploads are staged in the assets directory.
+func NewRPCServer(ctx context.Context, assetsDir string, s storage.Storage, serverAddr string) *RPCServer {
 	r := &RPCServer{
-		assetsDir:  assetsDir,
+		storage:    s,
+		uploads:    upload.NewStore(assetsDir, s),
+		Index:      manifest.NewIndex(s),
 		serverAddr: serverAddr,
 		ctx:        ctx,
-		s:          grpc.NewServer(),
 	}
 	if r.serverAddr != "" {
 		r.health = health.NewServer()
@@ -50,14 +74,64 @@ func (r *RPCServer) checkServerHealth() {
 	}, r.ctx.Done(), 10*time.Second)
 }
 
+// readMethods are the methods that only read files.
+var readMethods = map[string]bool{
+	contentapiv2.Assets_GetManifest_FullMethodName: true,
+	contentapiv3.Assets_GetManifest_FullMethodName: true,
+	contentapiv3.Assets_StatFile_FullMethodName:    true,
+	contentapiv3.Assets_ListMaps_FullMethodName:    true,
+	healthpb.Health_Check_FullMethodName:           true,
+	healthpb.Health_Watch_FullMethodName:           true,
+}
+
+// destructiveMethods are the methods that remove files, which are refused
+// when authentication is disabled, unlike the uploads, which can only add
+// files.
+var destructiveMethods = map[string]bool{
+	contentapiv3.Assets_DeleteFile_FullMethodName: true,
+	contentapiv3.Assets_MoveFile_FullMethodName:   true,
+}
+
+// refuseDestructive refuses the destructive methods, for servers without
+// authentication.
+func refuseDestructive(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if destructiveMethods[info.FullMethod] {
+		return nil, status.Error(codes.PermissionDenied, "removing or moving files requires --auth-config")
+	}
+	return handler(ctx, req)
+}
+
+func methodPermission(method string) auth.Permission {
+	if readMethods[method] {
+		return auth.Read
+	}
+	return auth.Write
+}
+
 func (r *RPCServer) Serve(l net.Listener) error {
+	var sopts []grpc.ServerOption
+	if r.Auth != nil {
+		sopts = append(sopts,
+			grpc.Creds(auth.ServerCredentials()),
+			grpc.UnaryInterceptor(auth.UnaryServerInterceptor(r.Auth, methodPermission)),
+			grpc.StreamInterceptor(auth.StreamServerInterceptor(r.Auth, methodPermission)),
+		)
+	} else {
+		sopts = append(sopts, grpc.UnaryInterceptor(refuseDestructive))
+	}
+	r.s = grpc.NewServer(sopts...)
 	if r.health != nil {
 		r.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
 		healthpb.RegisterHealthServer(r.s, r.health)
 		go r.checkServerHealth()
 	}
-	contentapiv1.RegisterAssetsServer(r.s, contentapiv1.NewAssetsService(r.assetsDir))
-	contentapiv2.RegisterAssetsServer(r.s, contentapiv2.NewAssetsService(r.assetsDir))
+	contentapiv1.RegisterAssetsServer(r.s, contentapiv1.NewAssetsService(r.storage))
+	contentapiv2.RegisterAssetsServer(r.s, contentapiv2.NewAssetsService(r.storage, r.Index))
+	assets := contentapiv3.NewAssetsService(r.storage, r.uploads, r.Index)
+	assets.UsedMaps = r.UsedMaps
+	contentapiv3.RegisterAssetsServer(r.s, assets)
+	go r.uploads.RunCleanup(r.ctx, 1*time.Hour)
+	go watchIndex(r.ctx, r.Index)
 
 	errch := make(chan error, 1)
 	go func() {
@@ -75,3 +149,12 @@ func (r *RPCServer) Serve(l net.Listener) error {
 		return err
 	}
 }
+
+// watchIndex keeps the index up to date with changes to the storage. When it
+// can't be watched, changes made by the content server itself still
+// invalidate the index.
+func watchIndex(ctx context.Context, index *manifest.Index) {
+	if err := index.Watch(ctx); err != nil {
+		log.Printf("manifest: %v\n", err)
+	}
+}
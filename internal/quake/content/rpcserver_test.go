@@ -0,0 +1,27 @@
+package content
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	contentapiv3 "github.com/ChrisRx/quake-kube/internal/quake/content/api/v3"
+)
+
+func TestRefuseDestructive(t *testing.T) {
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+	for method, code := range map[string]codes.Code{
+		contentapiv3.Assets_DeleteFile_FullMethodName: codes.PermissionDenied,
+		contentapiv3.Assets_MoveFile_FullMethodName:   codes.PermissionDenied,
+		contentapiv3.Assets_UploadFile_FullMethodName: codes.OK,
+		contentapiv3.Assets_ListMaps_FullMethodName:   codes.OK,
+	} {
+		_, err := refuseDestructive(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		if status.Code(err) != code {
+			t.Errorf("%s: expected code %s, received %v", method, code, err)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Local stores files in a local directory.
+type Local struct {
+	dir string
+}
+
+func NewLocal(dir string) *Local {
+	return &Local{dir: dir}
+}
+
+// Dir returns the directory of the storage.
+func (l *Local) Dir() string {
+	return l.dir
+}
+
+func (l *Local) path(name string) (string, error) {
+	if err := checkName(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(name)), nil
+}
+
+func (l *Local) List(ctx context.Context) ([]*FileInfo, error) {
+	files := make([]*FileInfo, 0)
+	err := filepath.Walk(l.dir, func(path string, info os.FileInfo, err error) error {
+		if errors.Is(err, fs.ErrNotExist) || os.IsPermission(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if path == l.dir {
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		name, err := filepath.Rel(l.dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, &FileInfo{Name: filepath.ToSlash(name), Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+func (l *Local) Stat(ctx context.Context, name string) (*FileInfo, error) {
+	path, err := l.path(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, fmt.Errorf("%w: %s is a directory", fs.ErrInvalid, name)
+	}
+	return &FileInfo{Name: name, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+func (l *Local) Open(ctx context.Context, name string) (File, error) {
+	if _, err := l.Stat(ctx, name); err != nil {
+		return nil, err
+	}
+	path, err := l.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// Create writes the file to a temporary file in the same directory, which is
+// moved into place when committed.
+func (l *Local) Create(ctx context.Context, name string) (Writer, error) {
+	path, err := l.path(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &localWriter{File: f, path: path}, nil
+}
+
+func (l *Local) Delete(ctx context.Context, name string) error {
+	if _, err := l.Stat(ctx, name); err != nil {
+		return err
+	}
+	path, err := l.path(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func (l *Local) Rename(ctx context.Context, name, newName string) error {
+	if _, err := l.Stat(ctx, name); err != nil {
+		return err
+	}
+	src, err := l.path(name)
+	if err != nil {
+		return err
+	}
+	dst, err := l.path(newName)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.Rename(src, dst)
+}
+
+// put moves a local file into the storage, which only works when it is in the
+// same filesystem.
+func (l *Local) put(name, src string) error {
+	path, err := l.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.Chmod(src, 0644); err != nil {
+		return err
+	}
+	return os.Rename(src, path)
+}
+
+type localWriter struct {
+	*os.File
+	path      string
+	committed bool
+}
+
+func (w *localWriter) Commit() error {
+	if err := w.File.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(w.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(w.Name(), w.path); err != nil {
+		return err
+	}
+	w.committed = true
+	return nil
+}
+
+func (w *localWriter) Close() error {
+	if w.committed {
+		return nil
+	}
+	w.File.Close()
+	if err := os.Remove(w.Name()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
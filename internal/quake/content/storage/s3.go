@@ -0,0 +1,462 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Options configures the bucket of an S3 storage.
+type S3Options struct {
+	// Endpoint is the URL of the object store, e.g. http://minio:9000. It
+	// defaults to the AWS S3 endpoint of the region.
+	Endpoint string
+
+	// Region defaults to us-east-1.
+	Region string
+	Bucket string
+
+	// Prefix is prepended to the names of the files, so that a bucket can be
+	// shared.
+	Prefix string
+
+	// Requests are anonymous without an access key.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// S3 stores files in a bucket of an S3-compatible object store. Buckets are
+// addressed by path (e.g. http://minio:9000/bucket/key), which every
+// S3-compatible object store supports.
+type S3 struct {
+	opts     S3Options
+	endpoint *url.URL
+	now      func() time.Time
+}
+
+func NewS3(opts S3Options) (*S3, error) {
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = "https://s3." + opts.Region + ".amazonaws.com"
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.Prefix != "" && !strings.HasSuffix(opts.Prefix, "/") {
+		opts.Prefix += "/"
+	}
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("s3: missing bucket")
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(opts.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("s3: invalid endpoint: %w", err)
+	}
+	return &S3{opts: opts, endpoint: endpoint, now: time.Now}, nil
+}
+
+// S3Error is an error response of the object store.
+type S3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: %s", http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("s3: %s: %s", e.Code, e.Message)
+}
+
+func (s *S3) url(key string, query url.Values) string {
+	u := *s.endpoint
+	u.RawPath = u.EscapedPath() + "/" + escape(s.opts.Bucket, false)
+	if key != "" {
+		u.RawPath += "/" + escape(key, false)
+	}
+	u.Path, _ = url.PathUnescape(u.RawPath)
+	u.RawQuery = canonicalQuery(query)
+	return u.String()
+}
+
+func (s *S3) do(ctx context.Context, method, key string, query url.Values, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.url(key, query), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	if s.opts.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.opts.SessionToken)
+	}
+	if s.opts.AccessKeyID != "" {
+		sign(req, s.opts.AccessKeyID, s.opts.SecretAccessKey, s.opts.Region, s.now())
+	}
+	resp, err := s.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	e := &S3Error{StatusCode: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	_ = xml.Unmarshal(data, e)
+	if resp.StatusCode == http.StatusNotFound && e.Code != "NoSuchBucket" {
+		return nil, fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+	}
+	return nil, e
+}
+
+func (s *S3) key(name string) (string, error) {
+	if err := checkName(name); err != nil {
+		return "", err
+	}
+	return s.opts.Prefix + name, nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3) List(ctx context.Context) ([]*FileInfo, error) {
+	files := make([]*FileInfo, 0)
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.opts.Prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3: cannot decode list of objects: %w", err)
+		}
+		for _, obj := range result.Contents {
+			name := strings.TrimPrefix(obj.Key, s.opts.Prefix)
+			// Keys ending with a slash are directory markers of some clients.
+			if checkName(name) != nil || isHidden(name) {
+				continue
+			}
+			files = append(files, &FileInfo{Name: name, Size: obj.Size, ModTime: obj.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+func (s *S3) Stat(ctx context.Context, name string) (*FileInfo, error) {
+	key, err := s.key(name)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &FileInfo{Name: name, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+// Open returns the object, which is read with range requests. Reading it from
+// start to end takes a single request.
+func (s *S3) Open(ctx context.Context, name string) (File, error) {
+	fi, err := s.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	key, _ := s.key(name)
+	return &s3File{s: s, ctx: ctx, key: key, size: fi.Size}, nil
+}
+
+// Create buffers the file in a temporary file, which is uploaded when
+// committed.
+func (s *S3) Create(ctx context.Context, name string) (Writer, error) {
+	key, err := s.key(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp("", "s3-upload-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &s3Writer{File: f, s: s, ctx: ctx, key: key}, nil
+}
+
+func (s *S3) Delete(ctx context.Context, name string) error {
+	// Deleting an object that doesn't exist succeeds, so it is checked first.
+	if _, err := s.Stat(ctx, name); err != nil {
+		return err
+	}
+	key, _ := s.key(name)
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+type s3File struct {
+	s    *S3
+	ctx  context.Context
+	key  string
+	size int64
+
+	off  int64
+	body io.ReadCloser
+}
+
+func (f *s3File) get(off, end int64) (io.ReadCloser, error) {
+	header := http.Header{}
+	if end < 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", off))
+	} else {
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, end))
+	}
+	resp, err := f.s.do(f.ctx, http.MethodGet, f.key, nil, header, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (f *s3File) Read(p []byte) (int, error) {
+	if f.off >= f.size {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		body, err := f.get(f.off, -1)
+		if err != nil {
+			return 0, err
+		}
+		f.body = body
+	}
+	n, err := f.body.Read(p)
+	f.off += int64(n)
+	if err == io.EOF && f.off < f.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (f *s3File) ReadAt(p []byte, off int64) (int, error) {
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > f.size {
+		end = f.size
+	}
+	if end == off {
+		return 0, nil
+	}
+	body, err := f.get(off, end-1)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	n, err := io.ReadFull(body, p[:end-off])
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *s3File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return 0, errors.New("s3: negative position")
+	}
+	if offset != f.off && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *s3File) Close() error {
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+type s3Writer struct {
+	*os.File
+	s   *S3
+	ctx context.Context
+	key string
+}
+
+func (w *s3Writer) Commit() error {
+	size, err := w.File.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := w.File.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	// The body is wrapped so that the client doesn't close the file.
+	resp, err := w.s.do(w.ctx, http.MethodPut, w.key, nil, nil, io.NopCloser(w.File), size)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (w *s3Writer) Close() error {
+	w.File.Close()
+	return os.Remove(w.Name())
+}
+
+// sign signs a request with AWS Signature Version 4. The host, the range and
+// the x-amz-* headers are signed, and the payload hash is the
+// X-Amz-Content-Sha256 header.
+func sign(req *http.Request, accessKeyID, secretAccessKey, region string, t time.Time) {
+	t = t.UTC()
+	date := t.Format("20060102")
+	req.Header.Set("X-Amz-Date", t.Format("20060102T150405Z"))
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if k == "range" || strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		req.Header.Get("X-Amz-Date"),
+		scope,
+		hexSHA256(canonicalRequest),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	for _, s := range []string{region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+func hexSHA256(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// canonicalQuery encodes the query parameters sorted by name, as required by
+// the signature.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	params := make([]string, 0, len(keys))
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			params = append(params, escape(k, true)+"="+escape(v, true))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// escape percent-encodes everything but the unreserved characters of RFC 3986,
+// and slashes unless they are escaped too.
+func escape(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !slash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,167 @@
+// Package storage stores the files of the content server, either in a local
+// directory or in an S3-compatible object store.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// FileInfo describes a file in a storage.
+type FileInfo struct {
+	// Name is the slash-separated path of the file, e.g. baseq3/pak0.pk3.
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// File is a file opened for reading. It can be read at any offset, e.g. to
+// read the zip directory of a map pack, or to serve range requests.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+// Writer writes a file, which only replaces the existing file once it is
+// committed, so a failed write never leaves a partial file behind.
+type Writer interface {
+	io.Writer
+
+	// Commit stores the file.
+	Commit() error
+
+	// Close discards the file, unless it was committed.
+	Close() error
+}
+
+// Storage stores files by their slash-separated path. Errors for files that
+// don't exist wrap fs.ErrNotExist.
+type Storage interface {
+	// List returns all files, sorted by name. Hidden files, and the files in
+	// hidden directories, aren't listed, since they are used by the content
+	// server itself.
+	List(ctx context.Context) ([]*FileInfo, error)
+	Stat(ctx context.Context, name string) (*FileInfo, error)
+	Open(ctx context.Context, name string) (File, error)
+	Create(ctx context.Context, name string) (Writer, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// Renamer is implemented by storages that can rename a file without copying
+// it.
+type Renamer interface {
+	Rename(ctx context.Context, name, newName string) error
+}
+
+// Rename renames a file, replacing any file with the new name. Storages that
+// can't rename files get a copy, and the file is removed afterwards.
+func Rename(ctx context.Context, s Storage, name, newName string) error {
+	if r, ok := s.(Renamer); ok {
+		return r.Rename(ctx, name, newName)
+	}
+	f, err := s.Open(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := copyFile(ctx, s, newName, f); err != nil {
+		return err
+	}
+	return s.Delete(ctx, name)
+}
+
+// Put moves the local file at path into the storage. A local storage takes
+// over the file when it is in the same filesystem, otherwise the file is
+// copied and removed afterwards.
+func Put(ctx context.Context, s Storage, name, path string) error {
+	if l, ok := s.(*Local); ok {
+		if err := l.put(name, path); err == nil {
+			return nil
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := copyFile(ctx, s, name, f); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func copyFile(ctx context.Context, s Storage, name string, r io.Reader) error {
+	w, err := s.Create(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	return w.Commit()
+}
+
+// New returns the storage at a location, which is either a local directory or
+// the URL of a bucket in an S3-compatible object store, with an optional
+// prefix for the files:
+//
+//	s3://bucket/prefix?endpoint=http://minio:9000&region=us-east-1
+//
+// The endpoint defaults to AWS S3, and the credentials are read from the
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment
+// variables.
+func New(location string) (Storage, error) {
+	if !strings.HasPrefix(location, "s3://") {
+		return NewLocal(location), nil
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid storage %q: missing bucket", location)
+	}
+	q := u.Query()
+	return NewS3(S3Options{
+		Endpoint:        q.Get("endpoint"),
+		Region:          q.Get("region"),
+		Bucket:          u.Host,
+		Prefix:          strings.Trim(u.Path, "/"),
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	})
+}
+
+// checkName returns an error wrapping fs.ErrInvalid for names that aren't
+// clean, slash-separated paths relative to the root of the storage.
+func checkName(name string) error {
+	if !fs.ValidPath(name) || name == "." || path.Clean(name) != name {
+		return fmt.Errorf("%w: invalid file name %q", fs.ErrInvalid, name)
+	}
+	return nil
+}
+
+// isHidden reports whether any element of the path is a hidden file or
+// directory.
+func isHidden(name string) bool {
+	for _, elem := range strings.Split(name, "/") {
+		if strings.HasPrefix(elem, ".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStorage(t *testing.T) {
+	storages := map[string]func(t *testing.T) Storage{
+		"local": func(t *testing.T) Storage {
+			return NewLocal(t.TempDir())
+		},
+		"s3": func(t *testing.T) Storage {
+			srv := newFakeS3(t, "assets")
+			s, err := NewS3(S3Options{
+				Endpoint:        srv.URL,
+				Bucket:          "assets",
+				Prefix:          "quake",
+				AccessKeyID:     testAccessKeyID,
+				SecretAccessKey: testSecretAccessKey,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+	}
+	for name, newStorage := range storages {
+		t.Run(name, func(t *testing.T) {
+			testStorage(t, newStorage(t))
+		})
+	}
+}
+
+func write(t *testing.T, s Storage, name, data string) {
+	t.Helper()
+
+	w, err := s.Create(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if _, err := io.WriteString(w, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func read(t *testing.T, s Storage, name string) string {
+	t.Helper()
+
+	f, err := s.Open(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func names(t *testing.T, s Storage) []string {
+	t.Helper()
+
+	files, err := s.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make([]string, 0)
+	for _, f := range files {
+		result = append(result, f.Name)
+	}
+	return result
+}
+
+func testStorage(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	write(t, s, "baseq3/pak0.pk3", "pak0")
+	write(t, s, "baseq3/maps.pk3", "maps")
+	write(t, s, "missionpack/pak0.pk3", "missionpack")
+	write(t, s, "linuxq3ademo.gz.sh", "demo")
+	write(t, s, ".manifest.json", "{}")
+	write(t, s, ".uploads/abc/data", "data")
+
+	// Files that aren't committed are discarded.
+	w, err := s.Create(ctx, "baseq3/partial.pk3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, "partial"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"baseq3/maps.pk3", "baseq3/pak0.pk3", "linuxq3ademo.gz.sh", "missionpack/pak0.pk3"}
+	if diff := cmp.Diff(expected, names(t, s)); diff != "" {
+		t.Errorf("storage: after List differs: (-want +got)\n%s", diff)
+	}
+
+	// Hidden files aren't listed, but can be read.
+	if data := read(t, s, ".manifest.json"); data != "{}" {
+		t.Errorf("storage: after Open of a hidden file expected %q, received %q", "{}", data)
+	}
+
+	fi, err := s.Stat(ctx, "missionpack/pak0.pk3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Name != "missionpack/pak0.pk3" || fi.Size != int64(len("missionpack")) || fi.ModTime.IsZero() {
+		t.Errorf("storage: after Stat received %+v", fi)
+	}
+
+	f, err := s.Open(ctx, "missionpack/pak0.pk3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, 4)
+	if n, err := f.ReadAt(buf, 7); n != 4 || err != nil || string(buf) != "pack" {
+		t.Errorf("storage: after ReadAt expected %q, received %q (%v)", "pack", buf[:n], err)
+	}
+	if n, err := f.ReadAt(buf, 9); n != 2 || err != io.EOF || string(buf[:n]) != "ck" {
+		t.Errorf("storage: after ReadAt past the end expected %q and EOF, received %q (%v)", "ck", buf[:n], err)
+	}
+	if _, err := f.Seek(7, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := io.ReadAll(f); err != nil || string(data) != "pack" {
+		t.Errorf("storage: after Seek and Read expected %q, received %q (%v)", "pack", data, err)
+	}
+
+	// Committed files replace the existing file.
+	write(t, s, "baseq3/maps.pk3", "MAPS")
+	if data := read(t, s, "baseq3/maps.pk3"); data != "MAPS" {
+		t.Errorf("storage: after Create of an existing file expected %q, received %q", "MAPS", data)
+	}
+
+	if err := Rename(ctx, s, "baseq3/maps.pk3", "missionpack/maps.pk3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "linuxq3ademo.gz.sh"); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "upload.pk3")
+	if err := os.WriteFile(path, []byte("upload"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Put(ctx, s, "baseq3/upload.pk3", path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("storage: after Put expected the file to be removed, received %v", err)
+	}
+	expected = []string{"baseq3/pak0.pk3", "baseq3/upload.pk3", "missionpack/maps.pk3", "missionpack/pak0.pk3"}
+	if diff := cmp.Diff(expected, names(t, s)); diff != "" {
+		t.Errorf("storage: after Rename, Delete and Put differs: (-want +got)\n%s", diff)
+	}
+	if data := read(t, s, "baseq3/upload.pk3"); data != "upload" {
+		t.Errorf("storage: after Put expected %q, received %q", "upload", data)
+	}
+
+	for _, name := range []string{"baseq3/missing.pk3", "missing/pak0.pk3"} {
+		if _, err := s.Stat(ctx, name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("storage: after Stat of %s expected fs.ErrNotExist, received %v", name, err)
+		}
+		if _, err := s.Open(ctx, name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("storage: after Open of %s expected fs.ErrNotExist, received %v", name, err)
+		}
+		if err := s.Delete(ctx, name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("storage: after Delete of %s expected fs.ErrNotExist, received %v", name, err)
+		}
+	}
+	for _, name := range []string{"", ".", "/baseq3/pak0.pk3", "../pak0.pk3", "baseq3/../pak0.pk3", "baseq3/"} {
+		if _, err := s.Stat(ctx, name); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("storage: after Stat of %q expected fs.ErrInvalid, received %v", name, err)
+		}
+	}
+}
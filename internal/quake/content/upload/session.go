@@ -0,0 +1,386 @@
+package upload
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ChrisRx/quake-kube/internal/quake/content/auth"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/storage"
+	"github.com/ChrisRx/quake-kube/internal/run"
+)
+
+// StagingDir is the directory in the assets directory where upload sessions,
+// and the uploads received in a single request, are kept until they are
+// installed. It is hidden, so the files in it are never served.
+const StagingDir = ".uploads"
+
+var (
+	ErrNotFound       = errors.New("upload session not found")
+	ErrOffsetMismatch = errors.New("offset does not match the uploaded size")
+	ErrBusy           = errors.New("upload session is being written to")
+)
+
+var sessionIDRegexp = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// Session is a resumable upload. The file is uploaded in any number of
+// writes, which continue at the offset of the data received so far, and is
+// installed when the session is finalized.
+type Session struct {
+	ID     string `json:"id"`
+	Header `json:"header"`
+
+	// Owner is the identity that created the session, which is the only one
+	// that can write to, finalize or remove it.
+	Owner string `json:"owner"`
+
+	// Offset is the size of the data received so far.
+	Offset int64 `json:"offset"`
+
+	// Expires is when the session is removed, unless it is written to again.
+	Expires time.Time `json:"expires"`
+}
+
+// sessionInfo is the info.json of a session.
+type sessionInfo struct {
+	Header
+	Owner string `json:"owner"`
+}
+
+// Store keeps upload sessions in the staging directory, so that they survive
+// restarts of the content server, and installs them into the storage. Each
+// session is a directory with the header and owner, in info.json, and the
+// data received so far.
+//
+// The size of a session counts towards the quota of its owner from when it is
+// created. The reservations are kept in memory, like the quota, so sessions
+// that were created before a restart are counted when they are finalized.
+//
+// The staging directory is always local, even when the storage isn't, so the
+// sessions are only known to the content server that created them. Replicas
+// sharing a storage need sticky routing for the sessions to be resumed.
+type Store struct {
+	dir     string
+	storage storage.Storage
+
+	// TTL is how long a session is kept after it was last written to.
+	TTL time.Duration
+
+	mu       sync.Mutex
+	reserved map[string]func()
+}
+
+func NewStore(assetsDir string, s storage.Storage) *Store {
+	return &Store{dir: assetsDir, storage: s, TTL: 24 * time.Hour, reserved: make(map[string]func())}
+}
+
+func (s *Store) path(id string, elem ...string) string {
+	return filepath.Join(append([]string{s.dir, StagingDir, id}, elem...)...)
+}
+
+// Create starts a new session for the file described by the header, when it
+// is within the quota of the caller, and reserves its size.
+func (s *Store) Create(ctx context.Context, h Header) (*Session, error) {
+	if err := h.Validate(); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	id := hex.EncodeToString(b)
+	if err := s.reserve(ctx, id, h.Size); err != nil {
+		return nil, err
+	}
+	sess, err := s.create(ctx, id, h)
+	if err != nil {
+		s.remove(id)
+		return nil, err
+	}
+	return sess, nil
+}
+
+func (s *Store) create(ctx context.Context, id string, h Header) (*Session, error) {
+	if err := os.MkdirAll(s.path(id), 0755); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(&sessionInfo{Header: h, Owner: auth.FromContext(ctx).String()})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(s.path(id, "info.json"), data, 0644); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(s.path(id, "data"), nil, 0644); err != nil {
+		return nil, err
+	}
+	return s.Get(id)
+}
+
+// Get returns the session with its current offset.
+func (s *Store) Get(id string) (*Session, error) {
+	if !sessionIDRegexp.MatchString(id) {
+		return nil, fmt.Errorf("%w: %q", ErrNotFound, id)
+	}
+	data, err := os.ReadFile(s.path(id, "info.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %q", ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var info sessionInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	sess := &Session{ID: id, Header: info.Header, Owner: info.Owner}
+	fi, err := os.Stat(s.path(id, "data"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %q", ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sess.Offset = fi.Size()
+	sess.Expires = fi.ModTime().Add(s.TTL)
+	if time.Now().After(sess.Expires) {
+		return nil, fmt.Errorf("%w: %q has expired", ErrNotFound, id)
+	}
+	return sess, nil
+}
+
+// owned returns the session when it belongs to the caller. The sessions of
+// other identities are not found, so their IDs can't be probed.
+func (s *Store) owned(ctx context.Context, id string) (*Session, error) {
+	sess, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if sess.Owner != auth.FromContext(ctx).String() {
+		return nil, fmt.Errorf("%w: %q", ErrNotFound, id)
+	}
+	return sess, nil
+}
+
+// Write appends the data read from r to the session, starting at offset,
+// which must be the current offset of the session. Everything read before an
+// error is kept, so the upload can be resumed from the offset returned by
+// Get. Only one write to a session can happen at a time.
+func (s *Store) Write(ctx context.Context, id string, offset int64, r io.Reader) (*Session, error) {
+	sess, err := s.owned(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	f, err := s.lock(id, os.O_WRONLY|os.O_APPEND)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// The offset is read again now that the session is locked.
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	sess.Offset = fi.Size()
+	if offset != sess.Offset {
+		return nil, fmt.Errorf("%w: offset is %d, received %d", ErrOffsetMismatch, sess.Offset, offset)
+	}
+
+	// One more byte than remains is read to find out if there is too much.
+	n, err := io.Copy(f, io.LimitReader(r, sess.Size-sess.Offset+1))
+	if err == nil && sess.Offset+n > sess.Size {
+		if err := f.Truncate(sess.Size); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: file %s is larger than %d bytes", ErrTooLarge, sess.Name, sess.Size)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(id)
+}
+
+// Finalize verifies the data of a complete session and installs it. The
+// session is removed, unless it is incomplete.
+func (s *Store) Finalize(ctx context.Context, id string) (*Result, error) {
+	sess, err := s.owned(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if sess.Offset < sess.Size {
+		return nil, fmt.Errorf("%w: file %s has %d of %d bytes", ErrIncomplete, sess.Name, sess.Offset, sess.Size)
+	}
+	f, err := s.lock(id, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	defer s.remove(id)
+
+	if !s.isReserved(id) {
+		if err := s.reserve(ctx, id, sess.Size); err != nil {
+			return nil, err
+		}
+	}
+	path := s.path(id, "data")
+	if err := Verify(path, &sess.Header); err != nil {
+		return nil, err
+	}
+	// The data is moved into place, rather than copied, when the storage is
+	// the assets directory itself.
+	result, err := Install(ctx, s.storage, path, &sess.Header, func() { s.release(id) })
+	if err != nil {
+		return nil, err
+	}
+	s.keep(id)
+	return result, nil
+}
+
+// NewWriter returns a writer for an upload received in a single request,
+// described by the header, which must be valid and within the quota of the
+// caller. The size is reserved until the writer is closed without being
+// committed.
+func (s *Store) NewWriter(ctx context.Context, h *Header) (*Writer, error) {
+	release, err := auth.FromContext(ctx).ReserveUpload(h.Size)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(s.dir, StagingDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		release()
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, h.Name+".*.tmp")
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &Writer{ctx: ctx, storage: s.storage, f: f, h: h, crc: crc32.NewIEEE(), release: release}, nil
+}
+
+// reserve counts the size of a session towards the quota of the caller.
+func (s *Store) reserve(ctx context.Context, id string, size int64) error {
+	release, err := auth.FromContext(ctx).ReserveUpload(size)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reserved[id] = release
+	return nil
+}
+
+func (s *Store) isReserved(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.reserved[id]
+	return ok
+}
+
+// release gives the reserved size of a session back to the quota.
+func (s *Store) release(id string) {
+	s.mu.Lock()
+	release, ok := s.reserved[id]
+	delete(s.reserved, id)
+	s.mu.Unlock()
+
+	if ok {
+		release()
+	}
+}
+
+// keep keeps the reserved size of a session that was installed counted
+// towards the quota.
+func (s *Store) keep(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.reserved, id)
+}
+
+// lock opens the data of a session, and locks it until it is closed. The lock
+// is on the file, rather than in memory, since the gRPC and HTTP servers, or
+// several content servers sharing the assets directory, can all use the same
+// session.
+func (s *Store) lock(id string, flag int) (*os.File, error) {
+	f, err := os.OpenFile(s.path(id, "data"), flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%w: %q", ErrBusy, id)
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// Delete removes a session of the caller.
+func (s *Store) Delete(ctx context.Context, id string) error {
+	if _, err := s.owned(ctx, id); err != nil {
+		return err
+	}
+	return s.remove(id)
+}
+
+// remove removes a session, and gives its reserved size back.
+func (s *Store) remove(id string) error {
+	s.release(id)
+	return os.RemoveAll(s.path(id))
+}
+
+// Cleanup removes the sessions that have expired, and the uploads received in
+// a single request that were left behind.
+func (s *Store) Cleanup() error {
+	entries, err := os.ReadDir(filepath.Join(s.dir, StagingDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			if fi, err := e.Info(); err == nil && time.Since(fi.ModTime()) > s.TTL {
+				if err := os.Remove(s.path(e.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
+			}
+			continue
+		}
+		if _, err := s.Get(e.Name()); !errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err := s.remove(e.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunCleanup removes expired sessions periodically until the context is
+// cancelled.
+func (s *Store) RunCleanup(ctx context.Context, interval time.Duration) {
+	run.Until(func() {
+		if err := s.Cleanup(); err != nil {
+			log.Printf("upload: cannot remove expired sessions: %v\n", err)
+		}
+	}, ctx.Done(), interval)
+}
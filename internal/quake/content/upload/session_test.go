@@ -0,0 +1,234 @@
+package upload
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/ChrisRx/quake-kube/internal/quake/content/auth"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/storage"
+)
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(dir, storage.NewLocal(dir))
+
+	data := []byte("pak0pak1")
+	sess, err := s.Create(context.Background(), Header{Name: "maps.pk3", Size: int64(len(data)), Checksum: crc32.ChecksumIEEE(data)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.GameName != "baseq3" {
+		t.Fatalf("expected game name to default to baseq3, received %q", sess.GameName)
+	}
+	if _, err := s.Write(context.Background(), sess.ID, 0, strings.NewReader("pak0")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Data past the size is rejected, and what was already received is kept.
+	if _, err := s.Write(context.Background(), sess.ID, 4, strings.NewReader("pak1pak2")); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge, received %v", err)
+	}
+	got, err := s.Get(sess.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Offset != 8 {
+		t.Fatalf("expected offset 8, received %d", got.Offset)
+	}
+
+	// Only one write at a time.
+	f, err := s.lock(sess.ID, os.O_RDONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write(context.Background(), sess.ID, 8, strings.NewReader("")); !errors.Is(err, ErrBusy) {
+		t.Fatalf("expected ErrBusy, received %v", err)
+	}
+	f.Close()
+
+	result, err := s.Finalize(context.Background(), sess.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"baseq3/maps.pk3"}, result.Files); diff != "" {
+		t.Errorf("upload: after Finalize differs: (-want +got)\n%s", diff)
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, StagingDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected session to be removed, received %v", entries)
+	}
+
+	if _, err := s.Get("../../baseq3"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for invalid id, received %v", err)
+	}
+}
+
+func TestStoreCleanup(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(dir, storage.NewLocal(dir))
+
+	expired, err := s.Create(context.Background(), Header{Name: "old.pk3", Size: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, err := s.Create(context.Background(), Header{Name: "new.pk3", Size: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-s.TTL - time.Minute)
+	if err := os.Chtimes(s.path(expired.ID, "data"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(expired.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected expired session to not be found, received %v", err)
+	}
+
+	if err := s.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(s.path(expired.ID)); !os.IsNotExist(err) {
+		t.Fatalf("expected expired session to be removed, received %v", err)
+	}
+	if _, err := s.Get(active.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestIdentity(name string, maxFileSize, maxBytesPerDay string) *auth.Identity {
+	id := &auth.Identity{Name: name, Permissions: []auth.Permission{auth.Read, auth.Write}}
+	if maxFileSize != "" {
+		q := resource.MustParse(maxFileSize)
+		id.Quota.MaxFileSize = &q
+	}
+	if maxBytesPerDay != "" {
+		q := resource.MustParse(maxBytesPerDay)
+		id.Quota.MaxBytesPerDay = &q
+	}
+	return id
+}
+
+func TestStoreOwner(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(dir, storage.NewLocal(dir))
+	owner := auth.NewContext(context.Background(), newTestIdentity("ci", "", ""))
+	other := auth.NewContext(context.Background(), newTestIdentity("other", "", ""))
+
+	data := []byte("pak0")
+	sess, err := s.Create(owner, Header{Name: "maps.pk3", Size: int64(len(data)), Checksum: crc32.ChecksumIEEE(data)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.Owner != "ci" {
+		t.Fatalf("expected owner ci, received %q", sess.Owner)
+	}
+	if _, err := s.Write(other, sess.ID, 0, strings.NewReader("pak0")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound writing the session of another identity, received %v", err)
+	}
+	if _, err := s.Write(owner, sess.ID, 0, strings.NewReader("pak0")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Finalize(other, sess.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound finalizing the session of another identity, received %v", err)
+	}
+	if err := s.Delete(other, sess.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound removing the session of another identity, received %v", err)
+	}
+	if _, err := s.Finalize(owner, sess.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreQuota(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(dir, storage.NewLocal(dir))
+	ctx := auth.NewContext(context.Background(), newTestIdentity("ci", "", "10"))
+
+	sess, err := s.Create(ctx, Header{Name: "a.pk3", Size: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The size is reserved when the session is created, before any data is
+	// written.
+	if _, err := s.Create(ctx, Header{Name: "b.pk3", Size: 4}); !errors.Is(err, auth.ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, received %v", err)
+	}
+	if _, err := s.NewWriter(ctx, &Header{Name: "b.pk3", GameName: "baseq3", Size: 4}); !errors.Is(err, auth.ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, received %v", err)
+	}
+	// Removing the session gives its size back.
+	if err := s.Delete(ctx, sess.ID); err != nil {
+		t.Fatal(err)
+	}
+	w, err := s.NewWriter(ctx, &Header{Name: "b.pk3", GameName: "baseq3", Size: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if _, err := s.Create(ctx, Header{Name: "c.pk3", Size: 10}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInstallZip(t *testing.T) {
+	dir := t.TempDir()
+	s := storage.NewLocal(dir)
+	ctx := auth.NewContext(context.Background(), newTestIdentity("ci", "1Ki", ""))
+
+	// The map pack is counted with its uncompressed size, which is over the
+	// quota even though the zip file isn't.
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("maps.pk3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(make([]byte, 4096))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "maps.zip")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h := &Header{Name: "maps.zip", GameName: "baseq3", Size: int64(buf.Len())}
+	if _, err := Install(ctx, s, path, h, func() {}); !errors.Is(err, auth.ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, received %v", err)
+	}
+
+	// Map packs with more data than their size in the zip file aren't
+	// extracted.
+	buf.Reset()
+	zw = zip.NewWriter(&buf)
+	w, err = zw.CreateRaw(&zip.FileHeader{Name: "maps.pk3", Method: zip.Store, CompressedSize64: 16, UncompressedSize64: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(make([]byte, 16))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h.Size = int64(buf.Len())
+	if _, err := Install(context.Background(), s, path, h, func() {}); err == nil {
+		t.Fatal("expected error extracting a map pack larger than its size")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "baseq3", "maps.pk3")); !os.IsNotExist(err) {
+		t.Fatalf("expected map pack to not be extracted, received %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+// Package upload writes files uploaded to the content server into its
+// storage.
+package upload
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ChrisRx/quake-kube/internal/log"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/auth"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/storage"
+	fsutil "github.com/ChrisRx/quake-kube/internal/util/fs"
+)
+
+var (
+	ErrInvalid          = errors.New("invalid upload")
+	ErrTooLarge         = errors.New("upload is larger than its size")
+	ErrIncomplete       = errors.New("upload is incomplete")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// Header describes an uploaded file.
+type Header struct {
+	Name     string `json:"name"`
+	GameName string `json:"gameName"`
+	Size     int64  `json:"size"`
+
+	// Checksum is the CRC32 (IEEE) of the file, the same checksum used in the
+	// manifest.
+	Checksum uint32 `json:"checksum"`
+}
+
+// Validate checks that the file can be written to the assets directory, and
+// defaults the game name to baseq3.
+func (h *Header) Validate() error {
+	if h.GameName == "" {
+		h.GameName = "baseq3"
+	}
+	if !IsValidName(h.GameName) {
+		return fmt.Errorf("%w: invalid game name %q", ErrInvalid, h.GameName)
+	}
+	if !IsValidName(h.Name) || !fsutil.HasExts(h.Name, ".pk3", ".zip") {
+		return fmt.Errorf("%w: invalid file name %q, must be a .pk3 or .zip file", ErrInvalid, h.Name)
+	}
+	if h.Size < 0 {
+		return fmt.Errorf("%w: invalid size %d", ErrInvalid, h.Size)
+	}
+	return nil
+}
+
+// IsValidName reports whether name can be used as a single path element in
+// the assets directory. Hidden files are not allowed, since they are used for
+// files that are being uploaded.
+func IsValidName(name string) bool {
+	return name != "" && name == filepath.Base(name) && !strings.HasPrefix(name, ".") && !strings.ContainsRune(name, '\\')
+}
+
+// Result is the outcome of an upload.
+type Result struct {
+	// Files are the files written to the assets directory, relative to it.
+	Files   []string
+	Message string
+}
+
+// Verify checks that the file at path matches the size and checksum of the
+// header.
+func Verify(path string, h *Header) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	crc := crc32.NewIEEE()
+	n, err := io.Copy(crc, f)
+	if err != nil {
+		return err
+	}
+	return check(h, n, crc.Sum32())
+}
+
+func check(h *Header, n int64, sum uint32) error {
+	if n > h.Size {
+		return fmt.Errorf("%w: file %s is larger than %d bytes", ErrTooLarge, h.Name, h.Size)
+	}
+	if n != h.Size {
+		return fmt.Errorf("%w: file %s has %d of %d bytes", ErrIncomplete, h.Name, n, h.Size)
+	}
+	if sum != h.Checksum {
+		return fmt.Errorf("%w: file %s expected %d, received %d", ErrChecksumMismatch, h.Name, h.Checksum, sum)
+	}
+	return nil
+}
+
+// Install moves a verified file at path into its game directory in the
+// storage, and logs it with the identity of the caller. The map packs in zip
+// files are extracted instead, and the zip file is left where it is. The
+// upload must already be reserved in the quota of the caller. The map packs
+// extracted from a zip file are counted in place of the zip file, whose
+// reservation is given back with release.
+func Install(ctx context.Context, s storage.Storage, path string, h *Header, release func()) (*Result, error) {
+	result, err := install(ctx, s, path, h)
+	if err != nil {
+		return nil, err
+	}
+	if fsutil.HasExts(h.Name, ".zip") {
+		release()
+	}
+	log.Info("installed upload", "files", result.Files, "size", h.Size, "identity", auth.FromContext(ctx).String())
+	return result, nil
+}
+
+func install(ctx context.Context, s storage.Storage, path string, h *Header) (*Result, error) {
+	if fsutil.HasExts(h.Name, ".zip") {
+		files, err := extractMapPacks(ctx, s, path, h.GameName)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("%w: File %s did not contain any map pack files.", ErrInvalid, h.Name)
+		}
+		return &Result{
+			Files:   files,
+			Message: fmt.Sprintf("Loaded the following map packs from file %s:\n%s", h.Name, strings.Join(files, "\n")),
+		}, nil
+	}
+	name := h.GameName + "/" + h.Name
+	if err := storage.Put(ctx, s, name, path); err != nil {
+		return nil, err
+	}
+	return &Result{
+		Files:   []string{name},
+		Message: fmt.Sprintf("File %s uploaded successfully.", name),
+	}, nil
+}
+
+// extractMapPacks extracts the pk3 files in a zip file to the game directory,
+// and returns their names. Each map pack counts towards the quota of the
+// caller with its uncompressed size.
+func extractMapPacks(ctx context.Context, s storage.Storage, path, gameName string) ([]string, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cannot open zip file: %v", ErrInvalid, err)
+	}
+	defer zr.Close()
+
+	files := make([]string, 0)
+	for _, f := range zr.File {
+		name := filepath.Base(f.Name)
+		if !fsutil.HasExts(name, ".pk3") || !IsValidName(name) {
+			continue
+		}
+		name = gameName + "/" + name
+		if f.UncompressedSize64 > math.MaxInt64 {
+			return nil, fmt.Errorf("%w: file %s is too large", ErrInvalid, f.Name)
+		}
+		size := int64(f.UncompressedSize64)
+		release, err := auth.FromContext(ctx).ReserveUpload(size)
+		if err != nil {
+			return nil, err
+		}
+		if err := extractFile(ctx, s, f, name, size); err != nil {
+			release()
+			return nil, err
+		}
+		files = append(files, name)
+	}
+	return files, nil
+}
+
+// extractFile extracts a file in a zip file to the storage, which only
+// replaces the existing file once it is extracted. Files that are larger than
+// size aren't extracted.
+func extractFile(ctx context.Context, s storage.Storage, f *zip.File, name string, size int64) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := s.Create(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	// One more byte than the size is read to find out if there is too much.
+	n, err := io.Copy(w, io.LimitReader(r, size+1))
+	if err != nil {
+		return err
+	}
+	if n > size {
+		return fmt.Errorf("%w: file %s is larger than %d bytes", ErrInvalid, f.Name, size)
+	}
+	return w.Commit()
+}
+
+// Writer writes an upload to a temporary file in the staging directory, for
+// uploads that are received in a single request.
+type Writer struct {
+	ctx     context.Context
+	storage storage.Storage
+	f       *os.File
+	h       *Header
+	crc     hash.Hash32
+	n       int64
+
+	// release gives the reserved size back, unless the upload is installed.
+	release func()
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.n+int64(len(p)) > w.h.Size {
+		return 0, fmt.Errorf("%w: file %s is larger than %d bytes", ErrTooLarge, w.h.Name, w.h.Size)
+	}
+	n, err := w.f.Write(p)
+	w.crc.Write(p[:n])
+	w.n += int64(n)
+	return n, err
+}
+
+// Commit verifies the upload and installs it.
+func (w *Writer) Commit() (*Result, error) {
+	if err := w.f.Close(); err != nil {
+		return nil, err
+	}
+	if err := check(w.h, w.n, w.crc.Sum32()); err != nil {
+		return nil, err
+	}
+	result, err := Install(w.ctx, w.storage, w.f.Name(), w.h, w.release)
+	if err != nil {
+		return nil, err
+	}
+	w.release = func() {}
+	return result, nil
+}
+
+// Close removes the temporary file, which is a no-op once it has been
+// installed, and gives the reserved size back if it wasn't.
+func (w *Writer) Close() error {
+	w.release()
+	w.f.Close()
+	if err := os.Remove(w.f.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package content
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DemosDir is the directory, relative to the assets directory, where demos
+// are stored.
+const DemosDir = "demos"
+
+// Demo is the metadata of a server-side demo recording of a single match. It
+// is stored next to the demo file as <id>.json.
+type Demo struct {
+	ID       string       `json:"id"`
+	File     string       `json:"file"`
+	Server   string       `json:"server,omitempty"`
+	Map      string       `json:"map"`
+	GameType string       `json:"gameType"`
+	Start    time.Time    `json:"start"`
+	End      time.Time    `json:"end"`
+	Size     int64        `json:"size"`
+	Players  []DemoPlayer `json:"players"`
+}
+
+type DemoPlayer struct {
+	Name  string `json:"name"`
+	Score int    `json:"score"`
+}
+
+var demoIDRegexp = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// ValidDemoID reports whether id can be used as a demo ID, which must be safe
+// to use as a file name.
+func ValidDemoID(id string) bool {
+	return demoIDRegexp.MatchString(id) && !strings.Contains(id, "..")
+}
+
+// SaveDemo moves the demo file at src into the demos directory of dir, and
+// writes its metadata. The size of the demo is set from the file.
+func SaveDemo(dir string, d *Demo, src string) error {
+	if !ValidDemoID(d.ID) {
+		return fmt.Errorf("invalid demo id: %q", d.ID)
+	}
+	demosDir := filepath.Join(dir, DemosDir)
+	if err := os.MkdirAll(demosDir, 0755); err != nil {
+		return err
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	d.File = d.ID + filepath.Ext(src)
+	d.Size = info.Size()
+	if err := os.Rename(src, filepath.Join(demosDir, d.File)); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// The metadata is written last, and atomically, since its presence is what
+	// makes a demo visible.
+	tmp := filepath.Join(demosDir, "."+d.ID+".json")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(demosDir, d.ID+".json"))
+}
+
+// ReadDemos returns the metadata of all demos in the demos directory of dir,
+// newest first.
+func ReadDemos(dir string) ([]*Demo, error) {
+	files, err := filepath.Glob(filepath.Join(dir, DemosDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	demos := make([]*Demo, 0)
+	for _, path := range files {
+		d, err := readDemo(path)
+		if err != nil {
+			return nil, err
+		}
+		demos = append(demos, d)
+	}
+	slices.SortFunc(demos, func(a, b *Demo) int {
+		return b.Start.Compare(a.Start)
+	})
+	return demos, nil
+}
+
+// ReadDemo returns the metadata of a single demo, and the path of the demo
+// file. The error is os.ErrNotExist (wrapped) if there is no such demo.
+func ReadDemo(dir, id string) (*Demo, string, error) {
+	if !ValidDemoID(id) {
+		return nil, "", fmt.Errorf("invalid demo id %q: %w", id, os.ErrNotExist)
+	}
+	d, err := readDemo(filepath.Join(dir, DemosDir, id+".json"))
+	if err != nil {
+		return nil, "", err
+	}
+	return d, filepath.Join(dir, DemosDir, d.File), nil
+}
+
+func readDemo(path string) (*Demo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var d Demo
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if !ValidDemoID(d.ID) || filepath.Base(d.File) != d.File {
+		return nil, fmt.Errorf("%s: invalid demo", path)
+	}
+	return &d, nil
+}
+
+// RemoveDemo removes a demo and its metadata.
+func RemoveDemo(dir string, d *Demo) error {
+	return errors.Join(
+		os.Remove(filepath.Join(dir, DemosDir, d.ID+".json")),
+		os.Remove(filepath.Join(dir, DemosDir, d.File)),
+	)
+}
+
+// PruneDemos removes the demos that ended longer than maxAge ago, and then
+// the oldest demos until at most maxCount are left. A zero maxAge or maxCount
+// is unlimited. The removed demos are returned.
+func PruneDemos(dir string, maxAge time.Duration, maxCount int, now time.Time) ([]*Demo, error) {
+	demos, err := ReadDemos(dir)
+	if err != nil {
+		return nil, err
+	}
+	removed := make([]*Demo, 0)
+	for i, d := range demos {
+		expired := maxAge > 0 && now.Sub(d.End) > maxAge
+		if !expired && (maxCount == 0 || i < maxCount) {
+			continue
+		}
+		if err := RemoveDemo(dir, d); err != nil {
+			return removed, err
+		}
+		removed = append(removed, d)
+	}
+	return removed, nil
+}
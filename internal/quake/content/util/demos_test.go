@@ -0,0 +1,77 @@
+package content
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDemos(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, id := range []string{"match-1", "match-2", "match-3"} {
+		src := filepath.Join(dir, id+".svdm_68")
+		if err := os.WriteFile(src, []byte("demo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		d := &Demo{
+			ID:    id,
+			Map:   "q3dm7",
+			Start: start.Add(time.Duration(i) * time.Hour),
+			End:   start.Add(time.Duration(i)*time.Hour + 15*time.Minute),
+		}
+		if err := SaveDemo(dir, d, src); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d, path, err := ReadDemo(dir, "match-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("match-2.svdm_68", d.File); diff != "" {
+		t.Errorf("content: after SaveDemo differs: (-want +got)\n%s", diff)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ReadDemo(dir, "../match-2"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected not exist error, received %v", err)
+	}
+
+	// The demo that ended more than 2h before now is expired, and then only
+	// the newest demo is kept.
+	now := start.Add(2*time.Hour + 30*time.Minute)
+	removed, err := PruneDemos(dir, 2*time.Hour, 1, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := func(demos []*Demo) []string {
+		result := make([]string, 0)
+		for _, d := range demos {
+			result = append(result, d.ID)
+		}
+		return result
+	}
+	if diff := cmp.Diff([]string{"match-2", "match-1"}, ids(removed)); diff != "" {
+		t.Errorf("content: after PruneDemos removed differs: (-want +got)\n%s", diff)
+	}
+	demos, err := ReadDemos(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"match-3"}, ids(demos)); diff != "" {
+		t.Errorf("content: after PruneDemos differs: (-want +got)\n%s", diff)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, DemosDir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("expected only the demo and metadata of match-3, received %v", files)
+	}
+}
@@ -4,15 +4,25 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
+	"io/fs"
+	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/ChrisRx/quake-kube/internal/log"
+	"github.com/ChrisRx/quake-kube/internal/quake/content/storage"
 	fsutil "github.com/ChrisRx/quake-kube/internal/util/fs"
 	httputil "github.com/ChrisRx/quake-kube/internal/util/net/http"
 )
@@ -24,6 +34,192 @@ type File struct {
 	Checksum   uint32 `json:"checksum"`
 }
 
+// GameDir returns the game directory (e.g. baseq3 or missionpack) that a
+// file belongs to, or an empty string for files in the root of the assets
+// directory.
+func GameDir(name string) string {
+	dir, _, ok := strings.Cut(filepath.ToSlash(name), "/")
+	if !ok {
+		return ""
+	}
+	return dir
+}
+
+// FilterGame returns the files that are used when running the provided game
+// (mod) directory, which includes the files in the root of the assets
+// directory and the base game.
+func FilterGame(files []*File, game string) []*File {
+	result := make([]*File, 0)
+	for _, f := range files {
+		switch GameDir(f.Name) {
+		case "", "baseq3", game:
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+var (
+	ErrInvalidName = errors.New("invalid file name")
+	ErrFileInUse   = errors.New("file is used by the server")
+)
+
+// CleanName returns the clean name of a file in the assets directory, which
+// must be relative to it. Hidden files, such as upload sessions, can't be
+// named.
+func CleanName(name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if name == "" || clean == "." || filepath.IsAbs(clean) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(clean), "/") {
+		if strings.HasPrefix(elem, ".") {
+			return "", fmt.Errorf("%w: %q", ErrInvalidName, name)
+		}
+	}
+	return clean, nil
+}
+
+// FileInfo describes a file in the assets directory.
+type FileInfo struct {
+	File
+	ModTime time.Time `json:"modTime"`
+
+	// Maps are the maps in a pk3 file.
+	Maps []string `json:"maps,omitempty"`
+}
+
+// StatFile returns information about a file in the storage.
+func StatFile(ctx context.Context, s storage.Storage, name string) (*FileInfo, error) {
+	name, err := cleanStorageName(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := s.Open(ctx, name)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	defer f.Close()
+
+	fi, err := s.Stat(ctx, name)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	info := &FileInfo{
+		File:    File{Name: name, Compressed: fi.Size, Checksum: h.Sum32()},
+		ModTime: fi.ModTime,
+	}
+	if fsutil.HasExts(name, ".pk3") {
+		maps, err := ReadMapPack(f, fi.Size, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range maps {
+			info.Maps = append(info.Maps, m.Name)
+		}
+	}
+	return info, nil
+}
+
+// DeleteFile removes a file from the storage. Map packs with maps that are
+// used by the server aren't removed, and ErrFileInUse is returned. Map packs
+// whose maps can't be read, such as corrupt files, can't be used by the
+// server either, so they are always removed.
+func DeleteFile(ctx context.Context, s storage.Storage, name string, used []UsedMap) error {
+	name, err := cleanStorageName(name)
+	if err != nil {
+		return err
+	}
+	fi, err := s.Stat(ctx, name)
+	if err != nil {
+		return storageError(err)
+	}
+	if err := checkUsed(ctx, s, name, fi.Size, used); err != nil {
+		return err
+	}
+	return storageError(s.Delete(ctx, name))
+}
+
+// MoveFile renames a file in the storage, e.g. to move a map pack to the
+// directory of a mod. Like uploads, the new name must be a .pk3 or .zip file
+// in a game directory. Existing files are not replaced, and map packs with
+// maps that are used by the server aren't moved, as in DeleteFile.
+func MoveFile(ctx context.Context, s storage.Storage, name, newName string, used []UsedMap) error {
+	name, err := cleanStorageName(name)
+	if err != nil {
+		return err
+	}
+	newName, err = cleanStorageName(newName)
+	if err != nil {
+		return err
+	}
+	_, file := path.Split(newName)
+	if strings.Count(newName, "/") != 1 || strings.ContainsRune(newName, '\\') || !fsutil.HasExts(file, ".pk3", ".zip") {
+		return fmt.Errorf("%w: %q, must be a .pk3 or .zip file in a game directory", ErrInvalidName, newName)
+	}
+	fi, err := s.Stat(ctx, name)
+	if err != nil {
+		return storageError(err)
+	}
+	if err := checkUsed(ctx, s, name, fi.Size, used); err != nil {
+		return err
+	}
+	if _, err := s.Stat(ctx, newName); err == nil {
+		return fmt.Errorf("%s: %w", newName, os.ErrExist)
+	}
+	return storageError(storage.Rename(ctx, s, name, newName))
+}
+
+// checkUsed returns ErrFileInUse when the map pack name has maps that are
+// used by the server. Map packs whose maps can't be read, such as corrupt
+// files, can't be used by the server either.
+func checkUsed(ctx context.Context, s storage.Storage, name string, size int64, used []UsedMap) error {
+	if len(used) == 0 || !fsutil.HasExts(name, ".pk3") {
+		return nil
+	}
+	f, err := s.Open(ctx, name)
+	if err != nil {
+		return storageError(err)
+	}
+	defer f.Close()
+
+	maps, err := ReadMapPack(f, size, name)
+	if err != nil {
+		log.Warn("cannot read maps of map pack", "name", name, "error", err)
+	}
+	for _, m := range maps {
+		for _, u := range used {
+			if u.Uses(m) {
+				return fmt.Errorf("%w: %s has map %s", ErrFileInUse, name, m.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// cleanStorageName returns the clean, slash-separated name of a file in the
+// storage.
+func cleanStorageName(name string) (string, error) {
+	name, err := CleanName(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(name), nil
+}
+
+// storageError returns ErrInvalidName for the names the storage doesn't
+// accept, such as directories.
+func storageError(err error) error {
+	if errors.Is(err, fs.ErrInvalid) {
+		return fmt.Errorf("%w: %v", ErrInvalidName, err)
+	}
+	return err
+}
+
 // DownloadManifest
 func DownloadManifest(url string) ([]*File, error) {
 	data, err := httputil.GetBody(url + "/assets/manifest.json")
@@ -38,14 +234,29 @@ func DownloadManifest(url string) ([]*File, error) {
 	return files, nil
 }
 
+// Checksum returns the CRC32 checksum of a file. The file is streamed, rather
+// than read into memory.
+func Checksum(path string) (uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, f); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
+
 // ReadManifest
 func ReadManifest(dir string) (files []*File, err error) {
 	err = fsutil.WalkFiles(dir, func(path string, info os.FileInfo, err error) error {
-		data, err := os.ReadFile(path)
+		n, err := Checksum(path)
 		if err != nil {
 			return err
 		}
-		n := crc32.ChecksumIEEE(data)
 		path = strings.TrimPrefix(path, dir+"/")
 		files = append(files, &File{path, info.Size(), n})
 		return nil
@@ -53,35 +264,94 @@ func ReadManifest(dir string) (files []*File, err error) {
 	return
 }
 
-// DownloadAssets
+// The files of a manifest are downloaded concurrently, and each download is
+// retried with an exponential backoff.
+var (
+	downloadConcurrency = 4
+	downloadRetries     = 3
+	downloadBackoff     = 1 * time.Second
+	downloadClient      = &http.Client{Timeout: 30 * time.Minute}
+)
+
+// DownloadAssets downloads the files of the manifest of a content server that
+// are missing, or differ from the manifest, in the assets directory.
 func DownloadAssets(u *url.URL, dir string) error {
 	url := strings.TrimSuffix(u.String(), "/")
 	files, err := DownloadManifest(url)
 	if err != nil {
 		return err
 	}
+	return DownloadFiles(context.Background(), url, dir, files)
+}
+
+// DownloadFiles downloads files of the manifest of the content server at url,
+// unless the assets directory already has them with the same size and
+// checksum. Files are written to a temporary file first, and only moved into
+// place once they match the manifest.
+func DownloadFiles(ctx context.Context, url, dir string, files []*File) error {
+	return DownloadFilesWithClient(ctx, downloadClient, url, dir, files)
+}
 
+// DownloadFilesWithClient is DownloadFiles with an HTTP client, e.g. one that
+// authenticates with the content server.
+func DownloadFilesWithClient(ctx context.Context, client *http.Client, url, dir string, files []*File) error {
+	missing := make([]*File, 0)
+	var size int64
 	for _, f := range files {
-		path := filepath.Join(dir, f.Name)
-		if _, err := os.Stat(path); !os.IsNotExist(err) {
+		if _, err := assetName(f.Name); err != nil {
+			log.Warn("skipping file of manifest", "name", f.Name, "error", err)
 			continue
 		}
-		data, err := httputil.GetBody(url + fmt.Sprintf("/assets/%d-%s", f.Checksum, f.Name))
+		ok, err := hasFile(dir, f)
 		if err != nil {
 			return err
 		}
-		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-			return err
+		if !ok {
+			missing = append(missing, f)
+			size += f.Compressed
 		}
-		if err := os.WriteFile(path, data, 0644); err != nil {
-			return err
-		}
-		fmt.Printf("Downloaded %s\n", f.Name)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	log.Info("downloading assets", "url", url, "files", len(missing), "size", size)
 
-		// The demo and point releases are compressed gzip files and contain the
-		// base pak files needed to play the Quake 3 Arena demo.
+	sem := make(chan struct{}, downloadConcurrency)
+	errs := make([]error, len(missing))
+	var done atomic.Int64
+	var wg sync.WaitGroup
+	for i, f := range missing {
+		wg.Add(1)
+		go func(i int, f *File) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			}
+			defer func() { <-sem }()
+
+			if err := downloadFileWithRetry(ctx, client, url, dir, f); err != nil {
+				errs[i] = fmt.Errorf("cannot download %s: %w", f.Name, err)
+				return
+			}
+			log.Info("downloaded asset", "name", f.Name, "size", f.Compressed, "done", done.Add(1), "total", len(missing))
+		}(i, f)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	// The demo and point releases are compressed gzip files and contain the
+	// base pak files needed to play the Quake 3 Arena demo. They are extracted
+	// once all downloads are done, since they can contain files of the
+	// manifest.
+	for _, f := range missing {
 		if strings.HasPrefix(f.Name, "linuxq3ademo") || strings.HasPrefix(f.Name, "linuxq3apoint") {
-			if err := ExtractGzip(path, dir); err != nil {
+			if err := ExtractGzip(filepath.Join(dir, f.Name), dir); err != nil {
 				return err
 			}
 		}
@@ -89,6 +359,110 @@ func DownloadAssets(u *url.URL, dir string) error {
 	return nil
 }
 
+// assetName returns the clean name of a file that is written to the assets
+// directory from a manifest or an archive, which is either in its root or in
+// a game directory.
+func assetName(name string) (string, error) {
+	clean, err := CleanName(name)
+	if err != nil {
+		return "", err
+	}
+	if strings.Count(filepath.ToSlash(clean), "/") > 1 {
+		return "", fmt.Errorf("%w: %q is not in a game directory", ErrInvalidName, name)
+	}
+	return clean, nil
+}
+
+// AssetPath returns the path of a file on a content server, which has the
+// checksum as prefix of the file name, e.g. baseq3/2483777038-pak0.pk3.
+func AssetPath(f *File) string {
+	dir, base := path.Split(f.Name)
+	return fmt.Sprintf("%s%d-%s", dir, f.Checksum, base)
+}
+
+// hasFile reports whether the assets directory has a file with the size and
+// checksum of the manifest.
+func hasFile(dir string, f *File) (bool, error) {
+	path := filepath.Join(dir, f.Name)
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if fi.Size() != f.Compressed {
+		return false, nil
+	}
+	sum, err := Checksum(path)
+	if err != nil {
+		return false, err
+	}
+	return sum == f.Checksum, nil
+}
+
+func downloadFileWithRetry(ctx context.Context, client *http.Client, url, dir string, f *File) (err error) {
+	backoff := downloadBackoff
+	for attempt := 0; ; attempt++ {
+		if err = downloadFile(ctx, client, url, dir, f); err == nil || attempt == downloadRetries {
+			return err
+		}
+		log.Warn("retrying download", "name", f.Name, "error", err, "backoff", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func downloadFile(ctx context.Context, client *http.Client, url, dir string, f *File) error {
+	path := filepath.Join(dir, f.Name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/assets/"+AssetPath(f), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot get url %q: %v", req.URL, http.StatusText(resp.StatusCode))
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := crc32.NewIEEE()
+	n, err := io.Copy(io.MultiWriter(tmp, h), resp.Body)
+	if err != nil {
+		return err
+	}
+	if n != f.Compressed {
+		return fmt.Errorf("expected %d bytes, received %d", f.Compressed, n)
+	}
+	if h.Sum32() != f.Checksum {
+		return fmt.Errorf("expected checksum %d, received %d", f.Checksum, h.Sum32())
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
 var gzipMagicHeader = []byte{'\x1f', '\x8b'}
 
 // ExtractGzip
@@ -122,7 +496,6 @@ func ExtractGzip(path, dir string) error {
 			return err
 		}
 		if strings.HasSuffix(hdr.Name, ".pk3") || strings.HasSuffix(hdr.Name, ".txt") || hdr.Name == "README" {
-			fmt.Printf("Extracted %s\n", hdr.Name)
 			data, err := io.ReadAll(tr)
 			if err != nil {
 				return err
@@ -132,7 +505,13 @@ func ExtractGzip(path, dir string) error {
 			if strings.HasPrefix(hdr.Name, "demoq3/pak0.pk3") {
 				hdr.Name = filepath.Join("baseq3", filepath.Base(hdr.Name))
 			}
-			path := filepath.Join(dir, hdr.Name)
+			name, err := assetName(hdr.Name)
+			if err != nil {
+				log.Warn("skipping file of archive", "name", hdr.Name, "error", err)
+				continue
+			}
+			log.Info("extracted asset", "name", name)
+			path := filepath.Join(dir, name)
 			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 				return err
 			}
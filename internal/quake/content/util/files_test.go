@@ -0,0 +1,276 @@
+package content
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ChrisRx/quake-kube/internal/quake/content/storage"
+	"github.com/ChrisRx/quake-kube/pkg/must"
+)
+
+func writeTestMapPack(t *testing.T, path string, maps ...string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for _, name := range maps {
+		w, err := zw.Create("maps/" + name + ".bsp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(newTestBSP(t, `{
+"classname" "worldspawn"
+}
+`))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCleanName(t *testing.T) {
+	for _, name := range []string{"", ".", "/baseq3/pak0.pk3", "../pak0.pk3", "baseq3/../../pak0.pk3", ".uploads/abc/data"} {
+		if _, err := CleanName(name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("expected ErrInvalidName for %q, received %v", name, err)
+		}
+	}
+	name, err := CleanName("baseq3/./maps.pk3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != filepath.Join("baseq3", "maps.pk3") {
+		t.Errorf("expected clean name, received %q", name)
+	}
+}
+
+func TestDeleteFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := storage.NewLocal(dir)
+	writeTestMapPack(t, filepath.Join(dir, "baseq3", "maps.pk3"), "ztn", "q3dm17")
+
+	if err := DeleteFile(ctx, s, "baseq3", nil); !errors.Is(err, ErrInvalidName) {
+		t.Fatalf("expected ErrInvalidName for a directory, received %v", err)
+	}
+
+	used := []UsedMap{{Name: "Q3DM17", Game: "baseq3"}}
+	if err := DeleteFile(ctx, s, "baseq3/maps.pk3", used); !errors.Is(err, ErrFileInUse) {
+		t.Fatalf("expected ErrFileInUse, received %v", err)
+	}
+	// Maps of the base game are also used by servers running a mod.
+	if err := DeleteFile(ctx, s, "baseq3/maps.pk3", []UsedMap{{Name: "q3dm17", Game: "osp"}}); !errors.Is(err, ErrFileInUse) {
+		t.Fatalf("expected ErrFileInUse, received %v", err)
+	}
+	if err := DeleteFile(ctx, s, "baseq3/maps.pk3", []UsedMap{{Name: "q3tourney2", Game: "osp"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "baseq3", "maps.pk3")); !os.IsNotExist(err) {
+		t.Fatalf("expected file to be removed, received %v", err)
+	}
+	if err := DeleteFile(ctx, s, "baseq3/maps.pk3", nil); !os.IsNotExist(err) {
+		t.Fatalf("expected file to not exist, received %v", err)
+	}
+
+	// Corrupt map packs are removed without forcing it.
+	if err := os.WriteFile(filepath.Join(dir, "baseq3", "broken.pk3"), []byte("PK\x03\x04broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteFile(ctx, s, "baseq3/broken.pk3", used); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "baseq3", "broken.pk3")); !os.IsNotExist(err) {
+		t.Fatalf("expected broken file to be removed, received %v", err)
+	}
+}
+
+func TestMoveFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := storage.NewLocal(dir)
+	writeTestMapPack(t, filepath.Join(dir, "baseq3", "ctf.pk3"), "q3ctf1")
+	writeTestMapPack(t, filepath.Join(dir, "baseq3", "dm.pk3"), "q3dm17")
+
+	if err := MoveFile(ctx, s, "baseq3/ctf.pk3", "baseq3/dm.pk3", nil); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("expected os.ErrExist, received %v", err)
+	}
+	for _, name := range []string{"ctf.pk3", "missionpack/ctf.cfg", "missionpack/maps/ctf.pk3", "missionpack/.ctf.pk3"} {
+		if err := MoveFile(ctx, s, "baseq3/ctf.pk3", name, nil); !errors.Is(err, ErrInvalidName) {
+			t.Fatalf("expected ErrInvalidName for %q, received %v", name, err)
+		}
+	}
+	used := []UsedMap{{Name: "q3ctf1", Game: "baseq3"}}
+	if err := MoveFile(ctx, s, "baseq3/ctf.pk3", "missionpack/ctf.pk3", used); !errors.Is(err, ErrFileInUse) {
+		t.Fatalf("expected ErrFileInUse, received %v", err)
+	}
+	if err := MoveFile(ctx, s, "baseq3/ctf.pk3", "missionpack/ctf.pk3", nil); err != nil {
+		t.Fatal(err)
+	}
+	info, err := StatFile(ctx, s, "missionpack/ctf.pk3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"q3ctf1"}, info.Maps); diff != "" {
+		t.Errorf("content: after MoveFile differs: (-want +got)\n%s", diff)
+	}
+}
+
+func TestDownloadAssets(t *testing.T) {
+	downloadBackoff = time.Millisecond
+
+	remote := t.TempDir()
+	writeTestMapPack(t, filepath.Join(remote, "baseq3", "pak0.pk3"), "q3dm1")
+	writeTestMapPack(t, filepath.Join(remote, "baseq3", "maps.pk3"), "q3dm17")
+	writeTestMapPack(t, filepath.Join(remote, "osp", "ztn.pk3"), "ztn")
+	files, err := ReadManifest(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first request of each file fails.
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/assets/manifest.json" {
+			json.NewEncoder(w).Encode(files)
+			return
+		}
+		mu.Lock()
+		requests[r.URL.Path]++
+		n := requests[r.URL.Path]
+		mu.Unlock()
+		if n == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		for _, f := range files {
+			if "/assets/"+AssetPath(f) == r.URL.Path {
+				http.ServeFile(w, r, filepath.Join(remote, f.Name))
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+	defer s.Close()
+
+	// Truncated local files are downloaded again.
+	dir := t.TempDir()
+	writeTestMapPack(t, filepath.Join(dir, "osp", "ztn.pk3"), "ztn")
+	if err := os.Truncate(filepath.Join(dir, "osp", "ztn.pk3"), 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := DownloadAssets(must.Must(url.Parse(s.URL)), dir); err != nil {
+		t.Fatal(err)
+	}
+	local, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(files, local); diff != "" {
+		t.Errorf("content: after DownloadAssets differs: (-want +got)\n%s", diff)
+	}
+
+	// Files that match the manifest aren't downloaded again.
+	clear(requests)
+	if err := DownloadAssets(must.Must(url.Parse(s.URL)), dir); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 0 {
+		t.Errorf("expected no downloads, received %v", requests)
+	}
+}
+
+func TestDownloadFilesInvalidNames(t *testing.T) {
+	data := []byte("pak0")
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer s.Close()
+
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "assets")
+	var files []*File
+	for _, name := range []string{"../escape.pk3", "/abs.pk3", "baseq3/../../escape.pk3", ".uploads/x.pk3", "baseq3/maps/deep.pk3", "baseq3/pak0.pk3"} {
+		files = append(files, &File{Name: name, Compressed: int64(len(data)), Checksum: crc32.ChecksumIEEE(data)})
+	}
+	if err := DownloadFiles(context.Background(), s.URL, dir, files); err != nil {
+		t.Fatal(err)
+	}
+	local, err := ReadManifest(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range local {
+		names = append(names, f.Name)
+	}
+	if diff := cmp.Diff([]string{"assets/baseq3/pak0.pk3"}, names); diff != "" {
+		t.Errorf("content: after DownloadFiles differs: (-want +got)\n%s", diff)
+	}
+}
+
+func TestExtractGzip(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("#!/bin/sh\n")
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, name := range []string{"demoq3/pak0.pk3", "../escape.pk3", "missionpack/../../escape.pk3", "README"} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: 4}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte("pak0"))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	parent := t.TempDir()
+	path := filepath.Join(parent, "linuxq3ademo.gz.sh")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(parent, "assets")
+	if err := ExtractGzip(path, dir); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	err := filepath.WalkDir(parent, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			names = append(names, filepath.ToSlash(must.Must(filepath.Rel(parent, path))))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"assets/README", "assets/baseq3/pak0.pk3", "linuxq3ademo.gz.sh"}, names); diff != "" {
+		t.Errorf("content: after ExtractGzip differs: (-want +got)\n%s", diff)
+	}
+}
@@ -2,26 +2,92 @@ package content
 
 import (
 	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 
+	"github.com/ChrisRx/quake-kube/internal/log"
 	fsutil "github.com/ChrisRx/quake-kube/internal/util/fs"
 )
 
 type Map struct {
 	File string `json:"file"`
 	Name string `json:"name"`
+
+	// Entities contains the team entity classnames (e.g. team_CTF_redflag)
+	// found in the map, which determine the game types a map supports.
+	Entities []string `json:"entities,omitempty"`
+
+	// UnknownEntities is set when the entities of the map couldn't be read,
+	// so it isn't known which game types the map supports.
+	UnknownEntities bool `json:"unknownEntities,omitempty"`
+}
+
+// HasEntities reports whether the map contains all of the provided entity
+// classnames.
+func (m *Map) HasEntities(names ...string) bool {
+	for _, name := range names {
+		if !slices.Contains(m.Entities, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterGameMaps returns the maps that can be loaded when running the
+// provided game (mod) directory.
+func FilterGameMaps(maps []*Map, game string) []*Map {
+	result := make([]*Map, 0)
+	for _, m := range maps {
+		switch GameDir(m.File) {
+		case "baseq3", game:
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// UsedMap is a map in the rotation of a server.
+type UsedMap struct {
+	Name string
+
+	// Game is the game directory of the server, either the base game or a
+	// mod.
+	Game string
+}
+
+// Uses reports whether the server would load the map, which is the case for
+// maps with the same name in the base game or the game directory of the
+// server. Map names are case-insensitive.
+func (u UsedMap) Uses(m *Map) bool {
+	if !strings.EqualFold(u.Name, m.Name) {
+		return false
+	}
+	switch GameDir(m.File) {
+	case "baseq3", u.Game:
+		return true
+	}
+	return false
 }
 
+// ReadMaps returns the maps in the map packs of a directory. Map packs that
+// can't be read, such as corrupt files, are skipped, since the server skips
+// them too.
 func ReadMaps(dir string) (result []*Map, err error) {
 	err = fsutil.WalkFiles(dir, func(path string, info os.FileInfo, err error) error {
 		maps, err := OpenMapPack(path)
 		if err != nil {
-			return err
+			log.Warn("cannot read maps of map pack, skipping it", "path", path, "error", err)
+			return nil
 		}
 		result = append(result, maps...)
-		return err
+		return nil
 	}, ".pk3")
 	return
 }
@@ -39,18 +105,96 @@ func OpenMapPack(path string) ([]*Map, error) {
 	if err != nil {
 		return nil, err
 	}
-	r, err := zip.NewReader(f, info.Size())
+	return ReadMapPack(f, info.Size(), filepath.Join(filepath.Base(filepath.Dir(path)), filepath.Base(path)))
+}
+
+// ReadMapPack returns the maps in a map pack, whose name is the game
+// directory and file name of the map pack, e.g. baseq3/maps.pk3.
+func ReadMapPack(r io.ReaderAt, size int64, name string) ([]*Map, error) {
+	zr, err := zip.NewReader(r, size)
 	if err != nil {
 		return nil, err
 	}
 	maps := make([]*Map, 0)
-	for _, f := range r.File {
+	for _, f := range zr.File {
 		if !fsutil.HasExts(f.Name, ".bsp") {
 			continue
 		}
-		path := filepath.Join(filepath.Base(filepath.Dir(path)), filepath.Base(path))
 		mapName := strings.TrimSuffix(filepath.Base(f.Name), ".bsp")
-		maps = append(maps, &Map{File: path, Name: mapName})
+		// A map with unreadable entities is still listed, since the game
+		// might load it fine, it just can't be matched to game types.
+		entities, err := readZipEntities(f)
+		if err != nil {
+			log.Warn("cannot read entities of map", "file", name, "map", f.Name, "error", err)
+		}
+		maps = append(maps, &Map{File: filepath.ToSlash(name), Name: mapName, Entities: entities, UnknownEntities: err != nil})
 	}
 	return maps, nil
 }
+
+func readZipEntities(f *zip.File) ([]string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return ReadBSPEntities(rc)
+}
+
+const (
+	bspHeaderLumps = 17
+	bspHeaderSize  = 8 + bspHeaderLumps*8
+)
+
+var classnameRegexp = regexp.MustCompile(`"classname"\s+"(team_[^"]+)"`)
+
+// ReadBSPEntities reads the entity lump of a Quake 3 .bsp file and returns
+// the unique team entity classnames. Only the team entities are kept since
+// they are what differentiate the game types a map can be played with.
+func ReadBSPEntities(r io.Reader) ([]string, error) {
+	var hdr struct {
+		Magic   [4]byte
+		Version int32
+		Lumps   [bspHeaderLumps]struct {
+			Offset int32
+			Length int32
+		}
+	}
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("cannot read bsp header: %w", err)
+	}
+	if string(hdr.Magic[:]) != "IBSP" {
+		return nil, fmt.Errorf("invalid bsp magic: %q", hdr.Magic[:])
+	}
+
+	// The entities lump is always the first lump in the header, but it isn't
+	// necessarily written first in the file, so everything before it is
+	// discarded.
+	lump := hdr.Lumps[0]
+	if lump.Offset < bspHeaderSize || lump.Length < 0 {
+		return nil, fmt.Errorf("invalid bsp entities lump: offset=%d length=%d", lump.Offset, lump.Length)
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(lump.Offset-bspHeaderSize)); err != nil {
+		return nil, err
+	}
+	// The length comes from the file, so the lump is only read as far as the
+	// file goes, rather than allocated up front.
+	data, err := io.ReadAll(io.LimitReader(r, int64(lump.Length)))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != int(lump.Length) {
+		return nil, fmt.Errorf("bsp entities lump has %d of %d bytes: %w", len(data), lump.Length, io.ErrUnexpectedEOF)
+	}
+	data = bytes.TrimRight(data, "\x00")
+
+	entities := make([]string, 0)
+	for _, m := range classnameRegexp.FindAllSubmatch(data, -1) {
+		if name := string(m[1]); !slices.Contains(entities, name) {
+			entities = append(entities, name)
+		}
+	}
+	slices.Sort(entities)
+	return entities, nil
+}
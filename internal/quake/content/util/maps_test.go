@@ -0,0 +1,126 @@
+package content
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func newTestBSP(t *testing.T, entities string) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+	b.WriteString("IBSP")
+	// The version is followed by the offset and length of each lump.
+	lumps := make([]int32, 1+bspHeaderLumps*2)
+	lumps[0] = 46
+	// The entities lump is written after another lump to ensure that
+	// preceding data is skipped.
+	lumps[1] = bspHeaderSize + 16
+	lumps[2] = int32(len(entities))
+	if err := binary.Write(&b, binary.LittleEndian, lumps); err != nil {
+		t.Fatal(err)
+	}
+	b.Write(make([]byte, 16))
+	b.WriteString(entities)
+	return b.Bytes()
+}
+
+func TestReadBSPEntities(t *testing.T) {
+	data := newTestBSP(t, `{
+"classname" "worldspawn"
+}
+{
+"classname" "team_CTF_redflag"
+"origin" "0 0 0"
+}
+{
+"classname" "team_CTF_blueflag"
+}
+{
+"classname" "team_CTF_redflag"
+}
+`)
+	entities, err := ReadBSPEntities(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"team_CTF_blueflag", "team_CTF_redflag"}
+	if diff := cmp.Diff(expected, entities); diff != "" {
+		t.Errorf("content: after ReadBSPEntities differs: (-want +got)\n%s", diff)
+	}
+}
+
+func TestReadBSPEntitiesTruncated(t *testing.T) {
+	data := newTestBSP(t, `{"classname" "team_CTF_redflag"}`)
+	// The length of the entities lump is larger than the file.
+	binary.LittleEndian.PutUint32(data[12:], 1<<30)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := ReadBSPEntities(bytes.NewReader(data)); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF, received %v", err)
+	}
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Errorf("expected the lump to not be allocated, received %d bytes", n)
+	}
+}
+
+func TestReadMapPackInvalidEntities(t *testing.T) {
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for name, data := range map[string][]byte{
+		"maps/q3dm17.bsp": newTestBSP(t, `{
+"classname" "team_CTF_redflag"
+}
+`),
+		"maps/broken.bsp": []byte("IBSP"),
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	maps, err := ReadMapPack(bytes.NewReader(b.Bytes()), int64(b.Len()), "baseq3/maps.pk3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(maps, func(a, b *Map) int { return strings.Compare(a.Name, b.Name) })
+	expected := []*Map{
+		{File: "baseq3/maps.pk3", Name: "broken", UnknownEntities: true},
+		{File: "baseq3/maps.pk3", Name: "q3dm17", Entities: []string{"team_CTF_redflag"}},
+	}
+	if diff := cmp.Diff(expected, maps); diff != "" {
+		t.Errorf("content: after ReadMapPack differs: (-want +got)\n%s", diff)
+	}
+}
+
+func TestFilterGame(t *testing.T) {
+	files := []*File{
+		{Name: "linuxq3ademo-1.11-6.x86.gz.sh"},
+		{Name: "baseq3/pak0.pk3"},
+		{Name: "missionpack/pak0.pk3"},
+		{Name: "cpma/z-cpma-pak152.pk3"},
+	}
+	var result []string
+	for _, f := range FilterGame(files, "missionpack") {
+		result = append(result, f.Name)
+	}
+	expected := []string{"linuxq3ademo-1.11-6.x86.gz.sh", "baseq3/pak0.pk3", "missionpack/pak0.pk3"}
+	if diff := cmp.Diff(expected, result); diff != "" {
+		t.Errorf("content: after FilterGame differs: (-want +got)\n%s", diff)
+	}
+}
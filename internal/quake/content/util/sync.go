@@ -0,0 +1,99 @@
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/ChrisRx/quake-kube/internal/log"
+	httputil "github.com/ChrisRx/quake-kube/internal/util/net/http"
+)
+
+// DownloadMaps returns the maps of the content server at url.
+func DownloadMaps(url string) ([]*Map, error) {
+	data, err := httputil.GetBody(url + "/maps")
+	if err != nil {
+		return nil, err
+	}
+
+	maps := make([]*Map, 0)
+	if err := json.Unmarshal(data, &maps); err != nil {
+		return nil, fmt.Errorf("%w: cannot unmarshal %s/maps", err, url)
+	}
+	return maps, nil
+}
+
+// SelectFiles returns the files of a manifest that a server needs to run the
+// used maps, along with the names of the maps that aren't in any map pack.
+//
+// The files of the base game and the mod of the server are always included,
+// except for map packs, which are only included when they have a used map.
+// The base paks (e.g. baseq3/pak0.pk3) also have maps, but are always
+// included.
+func SelectFiles(files []*File, maps []*Map, used []UsedMap) ([]*File, []string) {
+	mapPacks := make(map[string]bool)
+	for _, m := range maps {
+		mapPacks[m.File] = true
+	}
+	needed := make(map[string]bool)
+	var missing []string
+	for _, u := range used {
+		found := false
+		for _, m := range maps {
+			if u.Uses(m) {
+				needed[m.File] = true
+				found = true
+			}
+		}
+		if !found {
+			missing = append(missing, u.Name)
+		}
+	}
+
+	var game string
+	if len(used) > 0 {
+		game = used[0].Game
+	}
+	result := make([]*File, 0)
+	for _, f := range FilterGame(files, game) {
+		if needed[f.Name] || !mapPacks[f.Name] || isBasePak(f.Name) {
+			result = append(result, f)
+		}
+	}
+	return result, missing
+}
+
+// isBasePak reports whether the file is one of the paks of a game, e.g.
+// baseq3/pak0.pk3 or missionpack/pak1.pk3.
+func isBasePak(name string) bool {
+	base := path.Base(name)
+	return GameDir(name) != "" && strings.HasPrefix(base, "pak") && strings.HasSuffix(base, ".pk3")
+}
+
+// SyncAssets downloads the files from a content server that are needed to
+// run the used maps. All files are downloaded when there are no used maps, or
+// when the content server doesn't list its maps, like content servers that
+// only serve the assets (e.g. content.quakejs.com).
+func SyncAssets(ctx context.Context, u *url.URL, dir string, used []UsedMap) error {
+	url := strings.TrimSuffix(u.String(), "/")
+	files, err := DownloadManifest(url)
+	if err != nil {
+		return err
+	}
+	if len(used) == 0 {
+		return DownloadFiles(ctx, url, dir, files)
+	}
+	maps, err := DownloadMaps(url)
+	if err != nil {
+		log.Warn("cannot list maps of content server, downloading all assets", "url", url, "error", err)
+		return DownloadFiles(ctx, url, dir, files)
+	}
+	files, missing := SelectFiles(files, maps, used)
+	if len(missing) > 0 {
+		log.Warn("maps not found on content server", "url", url, "maps", missing)
+	}
+	return DownloadFiles(ctx, url, dir, files)
+}
@@ -0,0 +1,99 @@
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ChrisRx/quake-kube/pkg/must"
+)
+
+func TestSelectFiles(t *testing.T) {
+	files := []*File{
+		{Name: "linuxq3ademo-1.11-6.x86.gz.sh"},
+		{Name: "baseq3/pak0.pk3"},
+		{Name: "baseq3/ctf.pk3"},
+		{Name: "baseq3/dm.pk3"},
+		{Name: "missionpack/pak0.pk3"},
+		{Name: "missionpack/ta.pk3"},
+		{Name: "osp/zz-osp-pak3.pk3"},
+		{Name: "osp/ospdm.pk3"},
+		{Name: "osp/ztn.pk3"},
+	}
+	maps := []*Map{
+		{File: "baseq3/pak0.pk3", Name: "q3dm1"},
+		{File: "baseq3/ctf.pk3", Name: "q3ctf1"},
+		{File: "baseq3/dm.pk3", Name: "q3dm17"},
+		{File: "missionpack/ta.pk3", Name: "mpteam1"},
+		{File: "osp/ospdm.pk3", Name: "ospdm1"},
+		{File: "osp/ztn.pk3", Name: "ztn"},
+	}
+	used := []UsedMap{
+		{Name: "Q3DM17", Game: "osp"},
+		{Name: "ztn", Game: "osp"},
+		{Name: "q3dm99", Game: "osp"},
+	}
+	result, missing := SelectFiles(files, maps, used)
+	var names []string
+	for _, f := range result {
+		names = append(names, f.Name)
+	}
+	expected := []string{
+		"linuxq3ademo-1.11-6.x86.gz.sh",
+		"baseq3/pak0.pk3",
+		"baseq3/dm.pk3",
+		"osp/zz-osp-pak3.pk3",
+		"osp/ztn.pk3",
+	}
+	if diff := cmp.Diff(expected, names); diff != "" {
+		t.Errorf("content: after SelectFiles differs: (-want +got)\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"q3dm99"}, missing); diff != "" {
+		t.Errorf("content: after SelectFiles missing maps differs: (-want +got)\n%s", diff)
+	}
+}
+
+// TestSyncAssetsWithoutMaps checks that all files are downloaded from a
+// content server that doesn't serve /maps.
+func TestSyncAssetsWithoutMaps(t *testing.T) {
+	remote := t.TempDir()
+	writeTestMapPack(t, filepath.Join(remote, "baseq3", "pak0.pk3"), "q3dm1")
+	writeTestMapPack(t, filepath.Join(remote, "baseq3", "maps.pk3"), "q3dm17")
+	files, err := ReadManifest(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/assets/manifest.json" {
+			json.NewEncoder(w).Encode(files)
+			return
+		}
+		for _, f := range files {
+			if "/assets/"+AssetPath(f) == r.URL.Path {
+				http.ServeFile(w, r, filepath.Join(remote, f.Name))
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+	defer s.Close()
+
+	dir := t.TempDir()
+	used := []UsedMap{{Name: "q3dm17", Game: "baseq3"}}
+	if err := SyncAssets(context.Background(), must.Must(url.Parse(s.URL)), dir, used); err != nil {
+		t.Fatal(err)
+	}
+	local, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(files, local); diff != "" {
+		t.Errorf("content: after SyncAssets differs: (-want +got)\n%s", diff)
+	}
+}
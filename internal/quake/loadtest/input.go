@@ -0,0 +1,134 @@
+package loadtest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+
+	quakenet "github.com/ChrisRx/quake-kube/pkg/quake/net"
+)
+
+// Frame is the input of a player at a point in time. A replay file has one
+// frame per line, as JSON, e.g.:
+//
+//	{"time": 0, "forwardMove": 127, "angles": [0, 16384, 0]}
+//	{"time": 250, "forwardMove": 127, "buttons": 1}
+//
+// Angles are 16-bit, 65536 is a full turn. Time is in milliseconds from the
+// start of the recording.
+type Frame struct {
+	Time        int64    `json:"time"`
+	Angles      [3]int32 `json:"angles"`
+	ForwardMove int8     `json:"forwardMove"`
+	RightMove   int8     `json:"rightMove"`
+	UpMove      int8     `json:"upMove"`
+	Buttons     int32    `json:"buttons"`
+	Weapon      uint8    `json:"weapon"`
+}
+
+func (f *Frame) UserCmd() quakenet.UserCmd {
+	return quakenet.UserCmd{
+		Angles:      f.Angles,
+		ForwardMove: f.ForwardMove,
+		RightMove:   f.RightMove,
+		UpMove:      f.UpMove,
+		Buttons:     f.Buttons,
+		Weapon:      f.Weapon,
+	}
+}
+
+// ReadReplayFile reads the frames of a replay file, which must be in order.
+func ReadReplayFile(path string) ([]Frame, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	frames := make([]Frame, 0)
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		var frame Frame
+		if err := json.Unmarshal(s.Bytes(), &frame); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		if len(frames) > 0 && frame.Time < frames[len(frames)-1].Time {
+			return nil, fmt.Errorf("%s:%d: frame is out of order", path, n)
+		}
+		frames = append(frames, frame)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("%s: no frames", path)
+	}
+	return frames, nil
+}
+
+// input returns the commands of a client, either from a replay or made up.
+type input struct {
+	frames []Frame
+	start  time.Time
+	rand   *rand.Rand
+
+	cmd  quakenet.UserCmd
+	next time.Time
+}
+
+func newInput(frames []Frame, r *rand.Rand) *input {
+	in := &input{frames: frames, start: time.Now(), rand: r}
+	if len(frames) > 0 {
+		// Clients start at different points of the replay, so that they
+		// don't all do the same thing at the same time.
+		length := frames[len(frames)-1].Time + 1
+		in.start = in.start.Add(-time.Duration(r.Int63n(length)) * time.Millisecond)
+	}
+	return in
+}
+
+func (in *input) nextCmd() quakenet.UserCmd {
+	if len(in.frames) > 0 {
+		return in.replay(time.Now())
+	}
+	return in.synthetic(time.Now())
+}
+
+func (in *input) replay(now time.Time) quakenet.UserCmd {
+	length := in.frames[len(in.frames)-1].Time + 1
+	t := now.Sub(in.start).Milliseconds() % length
+	i := 0
+	for i < len(in.frames)-1 && in.frames[i+1].Time <= t {
+		i++
+	}
+	return in.frames[i].UserCmd()
+}
+
+// synthetic changes direction every half second or so, and sometimes jumps
+// and shoots, which is roughly the packet rate and size of a real player.
+func (in *input) synthetic(now time.Time) quakenet.UserCmd {
+	if now.Before(in.next) {
+		return in.cmd
+	}
+	in.next = now.Add(time.Duration(250+in.rand.Intn(500)) * time.Millisecond)
+	moves := []int8{-127, 0, 127}
+	in.cmd.ForwardMove = moves[in.rand.Intn(3)]
+	in.cmd.RightMove = moves[in.rand.Intn(3)]
+	in.cmd.UpMove = 0
+	if in.rand.Intn(4) == 0 {
+		in.cmd.UpMove = 127
+	}
+	in.cmd.Buttons = 0
+	if in.rand.Intn(3) == 0 {
+		in.cmd.Buttons = quakenet.ButtonAttack
+	}
+	in.cmd.Angles[1] += int32(in.rand.Intn(8192) - 4096)
+	in.cmd.Angles[1] &= 0xffff
+	return in.cmd
+}
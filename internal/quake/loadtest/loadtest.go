@@ -0,0 +1,181 @@
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	quakenet "github.com/ChrisRx/quake-kube/pkg/quake/net"
+)
+
+// Config configures a load test, where many clients connect to a server
+// through the websocket proxy, like browser clients do.
+type Config struct {
+	// URL is the websocket URL of the proxy, e.g. ws://localhost:8080 or
+	// ws://localhost:8080/servers/<name> for one of several servers.
+	URL     string
+	Clients int
+
+	// Duration is how long the clients stay connected once they all tried to
+	// connect.
+	Duration time.Duration
+
+	// Ramp spreads the connections over a period of time. Servers rate limit
+	// connectionless packets from a single address, so connecting many
+	// clients at once takes a few retries.
+	Ramp time.Duration
+
+	ConnectTimeout time.Duration
+	PacketRate     int
+	Password       string
+	Paks           []*quakenet.Pak
+
+	// Replay is the recorded input of a player, which every client plays in
+	// a loop starting at a random point. Synthetic input is used if it is
+	// empty.
+	Replay []Frame
+}
+
+type result struct {
+	connectTime time.Duration
+	connectErr  error
+	runErr      error
+	pings       []time.Duration
+	stats       quakenet.ClientStats
+}
+
+// Run runs a load test and returns its report. The clients are disconnected
+// when the test ends or the context is cancelled.
+func Run(ctx context.Context, cfg *Config) (*Report, error) {
+	if cfg.Clients <= 0 {
+		return nil, errors.New("loadtest: clients must be positive")
+	}
+	// Every client needs its own qport, which is 16 bits.
+	if cfg.Clients >= 0xffff {
+		return nil, fmt.Errorf("loadtest: at most %d clients are supported", 0xffff-1)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The duration starts once every client tried to connect.
+	go func() {
+		select {
+		case <-time.After(cfg.Ramp + cfg.ConnectTimeout + cfg.Duration):
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	results := make([]*result, cfg.Clients)
+	qport := rand.Intn(0xffff - cfg.Clients)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			select {
+			case <-time.After(cfg.Ramp * time.Duration(i) / time.Duration(cfg.Clients)):
+			case <-ctx.Done():
+				results[i] = &result{connectErr: ctx.Err()}
+				return
+			}
+			// Every client has the same address as seen by the server, so the
+			// qports must be unique.
+			results[i] = runClient(ctx, cfg, i, qport+i+1)
+		}(i)
+	}
+	wg.Wait()
+	return newReport(results), nil
+}
+
+func runClient(ctx context.Context, cfg *Config, i, qport int) *result {
+	r := &result{}
+	start := time.Now()
+	connectCtx, cancel := context.WithTimeout(ctx, cfg.ConnectTimeout)
+	defer cancel()
+
+	ws, _, err := websocket.DefaultDialer.DialContext(connectCtx, cfg.URL, nil)
+	if err != nil {
+		r.connectErr = err
+		return r
+	}
+	c := quakenet.NewClient(&wsConn{ws: ws}, &quakenet.ClientConfig{
+		Name:       fmt.Sprintf("loadtest-%02d", i),
+		Password:   cfg.Password,
+		Paks:       cfg.Paks,
+		PacketRate: cfg.PacketRate,
+		QPort:      qport,
+	})
+	defer c.Close()
+
+	if err := c.Connect(connectCtx); err != nil {
+		r.connectErr = err
+		return r
+	}
+	r.connectTime = time.Since(start)
+
+	runCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	done := make(chan error, 1)
+	go func() { done <- c.Run(runCtx) }()
+
+	input := newInput(cfg.Replay, rand.New(rand.NewSource(int64(qport))))
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+	sample := time.NewTicker(time.Second)
+	defer sample.Stop()
+	for {
+		select {
+		case <-tick.C:
+			c.SetCommand(input.nextCmd())
+		case <-sample.C:
+			if ping := c.Stats().Ping; ping > 0 {
+				r.pings = append(r.pings, ping)
+			}
+		case err := <-done:
+			if !errors.Is(err, context.Canceled) {
+				log.Printf("loadtest: client %d: %v\n", i, err)
+				r.runErr = err
+			}
+			r.stats = c.Stats()
+			return r
+		}
+	}
+}
+
+// wsConn sends and receives packets as binary websocket messages, like the
+// websocket proxy expects.
+type wsConn struct {
+	ws *websocket.Conn
+}
+
+func (c *wsConn) Read(b []byte) (int, error) {
+	for {
+		typ, data, err := c.ws.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		if typ != websocket.BinaryMessage {
+			continue
+		}
+		return copy(b, data), nil
+	}
+}
+
+func (c *wsConn) Write(b []byte) (int, error) {
+	if err := c.ws.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *wsConn) Close() error {
+	return c.ws.Close()
+}
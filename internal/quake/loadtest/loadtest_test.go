@@ -0,0 +1,102 @@
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	quakenet "github.com/ChrisRx/quake-kube/pkg/quake/net"
+)
+
+func TestRunInvalidClients(t *testing.T) {
+	for _, clients := range []int{-1, 0, 0xffff, 100000} {
+		if _, err := Run(context.Background(), &Config{URL: "ws://127.0.0.1:0", Clients: clients}); err == nil {
+			t.Errorf("%d clients: expected error", clients)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	results := []*result{
+		{connectErr: errors.New("connect: context deadline exceeded: server: Server is full.")},
+		{
+			connectTime: 100 * time.Millisecond,
+			pings:       []time.Duration{10 * time.Millisecond, 20 * time.Millisecond},
+			stats:       quakenet.ClientStats{PacketsSent: 100, PacketsReceived: 95, PacketsDropped: 5},
+		},
+		{
+			connectTime: 300 * time.Millisecond,
+			runErr:      quakenet.ErrDisconnected,
+			pings:       []time.Duration{30 * time.Millisecond},
+			stats:       quakenet.ClientStats{PacketsSent: 100, PacketsReceived: 100},
+		},
+	}
+	expected := &Report{
+		Clients:      3,
+		Connected:    2,
+		Disconnected: 1,
+		Errors: map[string]int{
+			"connect: context deadline exceeded: server: Server is full.": 1,
+			"disconnected by server": 1,
+		},
+		ConnectTime:     Percentiles{P50: 100 * time.Millisecond, P95: 100 * time.Millisecond, P99: 100 * time.Millisecond, Max: 300 * time.Millisecond},
+		Ping:            Percentiles{P50: 20 * time.Millisecond, P95: 20 * time.Millisecond, P99: 20 * time.Millisecond, Max: 30 * time.Millisecond},
+		PacketsSent:     200,
+		PacketsReceived: 195,
+		PacketsDropped:  5,
+	}
+	report := newReport(results)
+	if diff := cmp.Diff(expected, report); diff != "" {
+		t.Errorf("loadtest: after newReport differs: (-want +got)\n%s", diff)
+	}
+	if diff := cmp.Diff(0.025, report.PacketLoss()); diff != "" {
+		t.Errorf("loadtest: after newReport differs: (-want +got)\n%s", diff)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replay.jsonl")
+	data := `{"time": 0, "forwardMove": 127}
+
+{"time": 250, "forwardMove": 127, "buttons": 1, "angles": [0, 16384, 0]}
+{"time": 999, "rightMove": -127}
+`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	frames, err := ReadReplayFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := newInput(frames, rand.New(rand.NewSource(1)))
+	start := in.start
+	var got []quakenet.UserCmd
+	for _, ms := range []int{0, 249, 250, 1000, 1998, 1999} {
+		got = append(got, in.replay(start.Add(time.Duration(ms)*time.Millisecond)))
+	}
+	expected := []quakenet.UserCmd{
+		{ForwardMove: 127},
+		{ForwardMove: 127},
+		{ForwardMove: 127, Buttons: 1, Angles: [3]int32{0, 16384, 0}},
+		{ForwardMove: 127},
+		{ForwardMove: 127, Buttons: 1, Angles: [3]int32{0, 16384, 0}},
+		{RightMove: -127},
+	}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("loadtest: after replay differs: (-want +got)\n%s", diff)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"time": 10}`+"\n"+`{"time": 5}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadReplayFile(path); err == nil {
+		t.Error("loadtest: expected error for out of order frames")
+	}
+}
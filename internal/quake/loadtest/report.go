@@ -0,0 +1,114 @@
+package loadtest
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Report summarizes a load test.
+type Report struct {
+	Clients      int
+	Connected    int
+	Disconnected int
+
+	// Errors counts the clients that failed to connect, or were disconnected,
+	// by error.
+	Errors map[string]int
+
+	ConnectTime Percentiles
+
+	// Ping is the time it takes for a command to be reflected in a snapshot,
+	// which includes the proxy, the network and up to a server frame.
+	Ping Percentiles
+
+	PacketsSent     int
+	PacketsReceived int
+
+	// PacketsDropped are the packets from the server that were lost, which
+	// the clients can tell from the sequence numbers.
+	PacketsDropped int
+}
+
+type Percentiles struct {
+	P50, P95, P99, Max time.Duration
+}
+
+func percentiles(d []time.Duration) Percentiles {
+	if len(d) == 0 {
+		return Percentiles{}
+	}
+	d = slices.Clone(d)
+	slices.Sort(d)
+	at := func(p float64) time.Duration {
+		return d[int(p*float64(len(d)-1))]
+	}
+	return Percentiles{P50: at(0.5), P95: at(0.95), P99: at(0.99), Max: d[len(d)-1]}
+}
+
+func (p Percentiles) String() string {
+	return fmt.Sprintf("p50 %v, p95 %v, p99 %v, max %v",
+		p.P50.Round(time.Millisecond), p.P95.Round(time.Millisecond),
+		p.P99.Round(time.Millisecond), p.Max.Round(time.Millisecond))
+}
+
+func newReport(results []*result) *Report {
+	r := &Report{
+		Clients: len(results),
+		Errors:  make(map[string]int),
+	}
+	var connectTimes, pings []time.Duration
+	for _, res := range results {
+		if res.connectErr != nil {
+			r.Errors[res.connectErr.Error()]++
+			continue
+		}
+		r.Connected++
+		connectTimes = append(connectTimes, res.connectTime)
+		pings = append(pings, res.pings...)
+		if res.runErr != nil {
+			r.Disconnected++
+			r.Errors[res.runErr.Error()]++
+		}
+		r.PacketsSent += res.stats.PacketsSent
+		r.PacketsReceived += res.stats.PacketsReceived
+		r.PacketsDropped += res.stats.PacketsDropped
+	}
+	r.ConnectTime = percentiles(connectTimes)
+	r.Ping = percentiles(pings)
+	return r
+}
+
+// PacketLoss returns the fraction of the packets from the server that were
+// lost.
+func (r *Report) PacketLoss() float64 {
+	if r.PacketsReceived+r.PacketsDropped == 0 {
+		return 0
+	}
+	return float64(r.PacketsDropped) / float64(r.PacketsReceived+r.PacketsDropped)
+}
+
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "clients:      %d\n", r.Clients)
+	fmt.Fprintf(w, "connected:    %d (%.1f%%)\n", r.Connected, 100*float64(r.Connected)/float64(r.Clients))
+	fmt.Fprintf(w, "disconnected: %d\n", r.Disconnected)
+	if r.Connected > 0 {
+		fmt.Fprintf(w, "connect time: %s\n", r.ConnectTime)
+		fmt.Fprintf(w, "ping:         %s\n", r.Ping)
+		fmt.Fprintf(w, "packets:      %d sent, %d received\n", r.PacketsSent, r.PacketsReceived)
+		fmt.Fprintf(w, "packet loss:  %.2f%% (%d server packets)\n", 100*r.PacketLoss(), r.PacketsDropped)
+	}
+	if len(r.Errors) > 0 {
+		errs := make([]string, 0, len(r.Errors))
+		for err := range r.Errors {
+			errs = append(errs, err)
+		}
+		sort.Strings(errs)
+		fmt.Fprintf(w, "errors:\n")
+		for _, err := range errs {
+			fmt.Fprintf(w, "  %dx %s\n", r.Errors[err], err)
+		}
+	}
+}
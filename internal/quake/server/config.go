@@ -10,7 +10,8 @@ import (
 	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"sigs.k8s.io/yaml"
+
+	contentutil "github.com/ChrisRx/quake-kube/internal/quake/content/util"
 )
 
 func ReadConfigFromFile(path string) (*Config, error) {
@@ -18,41 +19,47 @@ func ReadConfigFromFile(path string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	cfg := Default()
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, err
+	cfg, err := ParseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
 	}
 	return cfg, nil
 }
 
 type Config struct {
-	FragLimit int             `name:"fraglimit"`
-	TimeLimit metav1.Duration `name:"timelimit"`
+	FragLimit int             `name:"fraglimit" json:"fragLimit"`
+	TimeLimit metav1.Duration `name:"timelimit" json:"timeLimit"`
 
 	BotConfig        `json:"bot"`
 	GameConfig       `json:"game"`
 	FileServerConfig `json:"fs"`
 	ServerConfig     `json:"server"`
-	Commands         []string `json:"commands"`
+	Demos            DemoConfig `json:"demos"`
+	Cvars            Cvars      `json:"cvars,omitempty"`
+	Commands         []string   `json:"commands"`
 
-	Maps
+	Maps `json:"maps"`
 }
 
 type BotConfig struct {
-	MinPlayers int  `name:"bot_minplayers"`
-	NoChat     bool `name:"bot_nochat"`
+	MinPlayers int  `name:"bot_minplayers" json:"minPlayers"`
+	NoChat     bool `name:"bot_nochat"     json:"noChat"`
 }
 
 type GameConfig struct {
-	ForceRespawn      bool            `name:"g_forcerespawn"`
+	ForceRespawn      bool            `name:"g_forcerespawn"  json:"forceRespawn"`
 	GameType          GameType        `name:"g_gametype"      json:"type"`
-	Inactivity        metav1.Duration `name:"g_inactivity"`
-	Log               string          `name:"g_log"`
-	MOTD              string          `name:"g_motd"`
-	Password          string          `name:"g_password"`
-	QuadFactor        int             `name:"g_quadfactor"`
-	SinglePlayerSkill int             `name:"g_spSkill"`
-	WeaponRespawn     int             `name:"g_weaponrespawn"`
+	Inactivity        metav1.Duration `name:"g_inactivity"    json:"inactivity"`
+	Log               string          `name:"g_log"           json:"log"`
+	MOTD              string          `name:"g_motd"          json:"motd"`
+	Password          string          `name:"g_password"      json:"password"`
+	QuadFactor        int             `name:"g_quadfactor"    json:"quadFactor"`
+	SinglePlayerSkill int             `name:"g_spSkill"       json:"singlePlayerSkill"`
+	WeaponRespawn     int             `name:"g_weaponrespawn" json:"weaponRespawn"`
+
+	// PasswordFile is a file containing the game password, such as a mounted
+	// Kubernetes Secret. It takes precedence over Password.
+	PasswordFile string `json:"passwordFile"`
 }
 
 type GameType int
@@ -63,8 +70,44 @@ const (
 	SinglePlayer   GameType = 2
 	TeamDeathmatch GameType = 3
 	CaptureTheFlag GameType = 4
+
+	// Team Arena game types, which require the missionpack game (or a mod that
+	// supports them).
+	OneFlagCTF GameType = 5
+	Overload   GameType = 6
+	Harvester  GameType = 7
 )
 
+var gameTypes = []GameType{
+	FreeForAll,
+	Tournament,
+	SinglePlayer,
+	TeamDeathmatch,
+	CaptureTheFlag,
+	OneFlagCTF,
+	Overload,
+	Harvester,
+}
+
+// gameTypeNames returns all of the names accepted by GameType.UnmarshalText.
+func gameTypeNames() []string {
+	names := make([]string, 0)
+	for _, gt := range gameTypes {
+		names = append(names, gt.String())
+	}
+	return append(names, "FFA", "CTF", "1FCTF", "Obelisk")
+}
+
+// IsTeamArena reports whether the game type is only available in Team Arena.
+func (gt GameType) IsTeamArena() bool {
+	return gt >= OneFlagCTF
+}
+
+// HasCaptureLimit reports whether capturelimit applies to the game type.
+func (gt GameType) HasCaptureLimit() bool {
+	return gt >= CaptureTheFlag
+}
+
 func (gt GameType) String() string {
 	switch gt {
 	case FreeForAll:
@@ -77,11 +120,24 @@ func (gt GameType) String() string {
 		return "TeamDeathmatch"
 	case CaptureTheFlag:
 		return "CaptureTheFlag"
+	case OneFlagCTF:
+		return "OneFlagCTF"
+	case Overload:
+		return "Overload"
+	case Harvester:
+		return "Harvester"
 	default:
 		return "Unknown"
 	}
 }
 
+func (gt GameType) MarshalText() ([]byte, error) {
+	if gt.String() == "Unknown" {
+		return nil, fmt.Errorf("unknown GameType: %d", gt)
+	}
+	return []byte(gt.String()), nil
+}
+
 func (gt *GameType) UnmarshalText(data []byte) error {
 	switch string(data) {
 	case "FreeForAll", "FFA":
@@ -94,36 +150,82 @@ func (gt *GameType) UnmarshalText(data []byte) error {
 		*gt = TeamDeathmatch
 	case "CaptureTheFlag", "CTF":
 		*gt = CaptureTheFlag
+	case "OneFlagCTF", "1FCTF":
+		*gt = OneFlagCTF
+	case "Overload", "Obelisk":
+		*gt = Overload
+	case "Harvester":
+		*gt = Harvester
 	default:
 		return fmt.Errorf("unknown GameType: %s", data)
 	}
 	return nil
 }
 
+// BaseGame is the game directory of vanilla Quake 3.
+const BaseGame = "baseq3"
+
 type FileServerConfig struct {
 	// allows people to base mods upon mods syntax to follow
-	BaseGame string `name:"fs_basegame"`
+	BaseGame string `name:"fs_basegame" json:"baseGame"`
 	// set base path root C:\Program Files\Quake III Arena for files to be
 	// downloaded from this path may change for TC's and MOD's
-	BasePath string `name:"fs_basepath"`
+	BasePath string `name:"fs_basepath" json:"basePath"`
 	// toggle if files can be copied from servers or if client will download
-	CopyFiles bool `name:"fs_copyfiles"`
+	CopyFiles bool `name:"fs_copyfiles" json:"copyFiles"`
 	// possibly enables file server debug mode for download/uploads or
 	// something
-	Debug bool `name:"fs_debug"`
-	// set gamedir set the game folder/dir default is baseq3
-	Game string `name:"fs_game"`
+	Debug bool `name:"fs_debug" json:"debug"`
+	// set gamedir set the game folder/dir default is baseq3. This is also
+	// passed on the ioq3ded command line, since it can't be changed once the
+	// server has started. Use missionpack for Team Arena.
+	Game string `name:"fs_game" json:"game"`
 	// possibly for TC's and MODS the default is the path to quake3.exe
-	HomePath string `name:"fs_homepath"`
+	HomePath string `name:"fs_homepath" json:"homePath"`
 }
 
 type ServerConfig struct {
-	AllowDownload bool   `name:"sv_allowDownload"`
-	DownloadURL   string `name:"sv_dlURL"`
-	Hostname      string `name:"sv_hostname"`
-	MaxClients    int    `name:"sv_maxclients"`
-	Password      string `name:"rconpassword"`
-	ListServer    string `name:"sv_master1"`
+	AllowDownload bool   `name:"sv_allowDownload" json:"allowDownload"`
+	DownloadURL   string `name:"sv_dlURL"         json:"downloadURL"`
+	Hostname      string `name:"sv_hostname"      json:"hostname"`
+	MaxClients    int    `name:"sv_maxclients"    json:"maxClients"`
+	Password      string `name:"rconpassword"     json:"password"`
+	ListServer    string `name:"sv_master1"       json:"listServer"`
+
+	// RconPasswordFile is a file containing the rcon password, such as a
+	// mounted Kubernetes Secret. It takes precedence over Password.
+	RconPasswordFile string `json:"rconPasswordFile"`
+}
+
+// GameDir returns the game directory that the server runs, which is the mod
+// selected with fs_game or baseq3.
+func (c *Config) GameDir() string {
+	if c.FileServerConfig.Game != "" {
+		return c.FileServerConfig.Game
+	}
+	return BaseGame
+}
+
+// UsedMaps returns the maps in the rotation.
+func (c *Config) UsedMaps() []contentutil.UsedMap {
+	used := make([]contentutil.UsedMap, 0, len(c.Maps))
+	for _, m := range c.Maps {
+		used = append(used, contentutil.UsedMap{Name: m.Name, Game: c.GameDir()})
+	}
+	return used
+}
+
+// ReadUsedMaps returns the maps in the rotations of the config files.
+func ReadUsedMaps(paths ...string) ([]contentutil.UsedMap, error) {
+	var used []contentutil.UsedMap
+	for _, path := range paths {
+		cfg, err := ReadConfigFromFile(path)
+		if err != nil {
+			return nil, err
+		}
+		used = append(used, cfg.UsedMaps()...)
+	}
+	return used, nil
 }
 
 func (c *Config) Marshal() ([]byte, error) {
@@ -132,33 +234,25 @@ func (c *Config) Marshal() ([]byte, error) {
 
 func writeStruct(v reflect.Value) ([]byte, error) {
 	if v.Kind() != reflect.Struct {
-		return nil, fmt.Errorf("expected struct, received %T", v.Kind())
+		return nil, fmt.Errorf("expected struct, received %v", v.Kind())
 	}
 	var b bytes.Buffer
 	for i := 0; i < v.Type().NumField(); i++ {
-		fv := v.Field(i)
-		switch fv.Kind() {
-		case reflect.Struct:
-			data, err := writeStruct(fv)
+		fv, ft := v.Field(i), v.Type().Field(i)
+		if !ft.IsExported() {
+			continue
+		}
+		if _, ok := fv.Interface().(metav1.Duration); ok {
+			// The durations (timelimit and g_inactivity) are not written, as
+			// they never were, since that would change the limits of existing
+			// configs, which set them with commands or per map.
+			continue
+		}
+		if tv, ok := ft.Tag.Lookup("name"); ok {
+			s, err := toString(ft.Name, fv)
 			if err != nil {
-				return nil, err
-			}
-			b.Write(data)
-		case reflect.Slice:
-			switch val := fv.Interface().(type) {
-			case Maps:
-				data, _ := val.Marshal()
-				b.Write(data)
-			case []string:
-			default:
-				panic(fmt.Errorf("received unknown type %T", val))
-			}
-		default:
-			tv, ok := v.Type().Field(i).Tag.Lookup("name")
-			if !ok {
-				continue
+				return nil, fmt.Errorf("%s: %w", ft.Name, err)
 			}
-			s := toString(v.Type().Field(i).Name, fv)
 			switch tv {
 			case "sv_dlURL":
 				if s != "" {
@@ -167,6 +261,35 @@ func writeStruct(v reflect.Value) ([]byte, error) {
 			default:
 				b.WriteString(fmt.Sprintf("seta %s %s\n", tv, strconv.Quote(s)))
 			}
+			continue
+		}
+		switch val := fv.Interface().(type) {
+		case Maps:
+			data, err := val.Marshal()
+			if err != nil {
+				return nil, err
+			}
+			b.Write(data)
+		case Cvars:
+			data, err := val.Marshal()
+			if err != nil {
+				return nil, err
+			}
+			b.Write(data)
+		case []string:
+			// Commands are written last, after the map rotation.
+		default:
+			switch fv.Kind() {
+			case reflect.Struct:
+				data, err := writeStruct(fv)
+				if err != nil {
+					return nil, err
+				}
+				b.Write(data)
+			case reflect.Slice, reflect.Map:
+				return nil, fmt.Errorf("%s: received unknown type %T", ft.Name, val)
+			}
+			// Any other fields without a cvar name are config-only.
 		}
 	}
 	for i := 0; i < v.Type().NumField(); i++ {
@@ -181,34 +304,38 @@ func writeStruct(v reflect.Value) ([]byte, error) {
 	return b.Bytes(), nil
 }
 
-func toString(name string, v reflect.Value) string {
+func toString(name string, v reflect.Value) (string, error) {
 	switch val := v.Interface().(type) {
 	case string:
-		return val
+		return val, nil
 	case int:
-		return strconv.Itoa(val)
+		return strconv.Itoa(val), nil
 	case metav1.Duration:
 		switch name {
 		case "TimeLimit":
-			return fmt.Sprintf("%d", int(val.Minutes()))
+			return fmt.Sprintf("%d", int(val.Minutes())), nil
 		default:
-			return fmt.Sprintf("%d", int(val.Seconds()))
+			return fmt.Sprintf("%d", int(val.Seconds())), nil
 		}
 	case bool:
 		if val {
-			return "1"
+			return "1", nil
 		}
-		return "0"
+		return "0", nil
 	case GameType:
-		return fmt.Sprintf("%d", val)
-	case Maps:
-		data, _ := val.Marshal()
-		return string(data)
+		return fmt.Sprintf("%d", val), nil
+	case CvarValue:
+		return val.String(), nil
 	default:
-		panic(fmt.Errorf("received unknown type %T", v.Interface()))
+		return "", fmt.Errorf("received unknown type %T", v.Interface())
 	}
 }
 
+// DefaultRconPassword is the rcon password the server uses when a config
+// doesn't set one. It isn't set by Default, so that the server can tell an
+// unset password from one that is set to the same value.
+const DefaultRconPassword = "changeme"
+
 func Default() *Config {
 	return &Config{
 		FragLimit: 25,
@@ -230,7 +357,11 @@ func Default() *Config {
 		ServerConfig: ServerConfig{
 			MaxClients: 12,
 			Hostname:   "quakekube",
-			Password:   "changeme",
+		},
+		Demos: DemoConfig{
+			RecordCommand: "demo_record",
+			StopCommand:   "demo_stop",
+			Dir:           "svdemos",
 		},
 		Maps: Maps{
 			{Name: "q3dm7", Type: FreeForAll},
@@ -246,6 +377,10 @@ type Map struct {
 	CaptureLimit int             `json:"captureLimit"`
 	FragLimit    int             `json:"fragLimit"`
 	TimeLimit    metav1.Duration `json:"timeLimit"`
+
+	// Cvars are set when the map is loaded. Like the limits, they are not
+	// reset for subsequent maps in the rotation.
+	Cvars Cvars `json:"cvars,omitempty"`
 }
 
 type Maps []Map
@@ -256,17 +391,27 @@ func (maps Maps) Marshal() ([]byte, error) {
 		cmds := []string{
 			fmt.Sprintf("g_gametype %d", m.Type),
 		}
-		if m.Type == CaptureTheFlag && m.CaptureLimit != 0 {
+		if m.Type.HasCaptureLimit() && m.CaptureLimit != 0 {
 			cmds = append(cmds, fmt.Sprintf("capturelimit %d", m.CaptureLimit))
 		}
 		if m.FragLimit != 0 {
 			cmds = append(cmds, fmt.Sprintf("fraglimit %d", m.FragLimit))
 		}
 		if m.TimeLimit.Duration != 0 {
-			cmds = append(
-				cmds,
-				fmt.Sprintf("timelimit %s", toString("TimeLimit", reflect.ValueOf(m.TimeLimit))),
-			)
+			s, err := toString("TimeLimit", reflect.ValueOf(m.TimeLimit))
+			if err != nil {
+				return nil, err
+			}
+			cmds = append(cmds, fmt.Sprintf("timelimit %s", s))
+		}
+		for _, name := range m.Cvars.names() {
+			// The map commands are already quoted by the enclosing set, so the
+			// values cannot be quoted or contain command separators.
+			s := m.Cvars[name].String()
+			if strings.ContainsAny(s, "\";\n") {
+				return nil, fmt.Errorf("map %s: cvar %s: value %q cannot contain quotes or semicolons", m.Name, name, s)
+			}
+			cmds = append(cmds, fmt.Sprintf("seta %s %s", name, s))
 		}
 		cmds = append(cmds, fmt.Sprintf("map %s", m.Name))
 		nextmap := "d0"
@@ -1,11 +1,15 @@
 package server
 
 import (
+	"archive/zip"
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
-	"sigs.k8s.io/yaml"
 )
 
 const config = `
@@ -22,6 +26,11 @@ server:
   hostname: "quakekube"
   maxClients: 12
   password: "changeme"
+cvars:
+  sv_fps: 30
+  g_allowVote: false
+  sv_timeout: !duration 2m
+  sv_joinmessage: welcome
 commands:
 - seta g_inactivity 600
 - seta sv_timeout 120
@@ -33,6 +42,8 @@ maps:
 - name: q3wctf1
   type: CaptureTheFlag
   captureLimit: 8
+  cvars:
+    g_friendlyFire: true
 - name: q3tourney2
   type: Tournament
 - name: q3wctf3
@@ -44,14 +55,14 @@ maps:
 
 const expectedConfig = `seta fraglimit "25"
 seta bot_minplayers "0"
-seta bot_nochat "0"
+seta bot_nochat "1"
 seta g_forcerespawn "0"
 seta g_gametype "0"
 seta g_log ""
 seta g_motd "Welcome to Quake Kube"
 seta g_password ""
 seta g_quadfactor "3"
-seta g_spSkill "0"
+seta g_spSkill "2"
 seta g_weaponrespawn "3"
 seta fs_basegame ""
 seta fs_basepath ""
@@ -64,9 +75,13 @@ seta sv_hostname "quakekube"
 seta sv_maxclients "12"
 seta rconpassword "changeme"
 seta sv_master1 ""
+seta g_allowVote "0"
+seta sv_fps "30"
+seta sv_joinmessage "welcome"
+seta sv_timeout "120"
 set d0 "seta g_gametype 0 ; map q3dm7 ; set nextmap vstr d1"
 set d1 "seta g_gametype 0 ; map q3dm17 ; set nextmap vstr d2"
-set d2 "seta g_gametype 4 ; capturelimit 8 ; map q3wctf1 ; set nextmap vstr d3"
+set d2 "seta g_gametype 4 ; capturelimit 8 ; seta g_friendlyFire 1 ; map q3wctf1 ; set nextmap vstr d3"
 set d3 "seta g_gametype 1 ; map q3tourney2 ; set nextmap vstr d4"
 set d4 "seta g_gametype 4 ; capturelimit 8 ; map q3wctf3 ; set nextmap vstr d5"
 set d5 "seta g_gametype 1 ; map ztn3tourney1 ; set nextmap vstr d0"
@@ -76,8 +91,8 @@ seta sv_timeout 120
 `
 
 func TestConfigMarshal(t *testing.T) {
-	var cfg *Config
-	if err := yaml.Unmarshal([]byte(config), &cfg); err != nil {
+	cfg, err := ParseConfig([]byte(config))
+	if err != nil {
 		t.Fatal(err)
 	}
 	data, err := cfg.Marshal()
@@ -89,3 +104,408 @@ func TestConfigMarshal(t *testing.T) {
 		t.Fatalf(diff)
 	}
 }
+
+func TestParseConfigStrict(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "unknown field",
+			input: `
+fragLimit: 25
+game:
+  motd: "Welcome to Quake Kube"
+  typo: FreeForAll
+`,
+			expected: "line 5: game.typo: unknown field",
+		},
+		{
+			name: "unknown map field",
+			input: `
+maps:
+- name: q3dm7
+  type: FreeForAll
+- name: q3wctf1
+  captureLimt: 8
+`,
+			expected: "line 6: maps[1].captureLimt: unknown field",
+		},
+		{
+			name: "invalid cvar duration",
+			input: `
+cvars:
+  sv_timeout: !duration soon
+`,
+			expected: `line 3: cvars.sv_timeout: invalid duration "soon", e.g. 2m or 1h30m`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := ParseConfig([]byte(c.input))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if diff := cmp.Diff(c.expected, err.Error()); diff != "" {
+				t.Errorf("server: after ParseConfig differs: (-want +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseConfigCvars(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`
+cvars:
+  sv_hostname: 1h
+  g_motd: "2m"
+  sv_fps: 30
+  sv_timeout: !duration 2m
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Cvars{
+		"sv_hostname": StringCvar("1h"),
+		"g_motd":      StringCvar("2m"),
+		"sv_fps":      IntCvar(30),
+		"sv_timeout":  IntCvar(120),
+	}
+	if diff := cmp.Diff(expected, cfg.Cvars, cmp.AllowUnexported(CvarValue{})); diff != "" {
+		t.Errorf("server: after ParseConfig differs: (-want +got)\n%s", diff)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg, lines, err := parseConfig([]byte(`
+fragLimit: -1
+maps:
+- name: q3dm7
+  type: FreeForAll
+- name: q3dm17
+  timeLimit: -10m
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.Validate("")
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("expected ValidationErrors, received %v", err)
+	}
+	for _, err := range errs {
+		err.Line = lines.lookup(err.Field)
+	}
+	expected := "line 2: fragLimit: must be non-negative, received -1\n" +
+		"line 7: maps[1].timeLimit: must be non-negative, received -10m0s"
+	if diff := cmp.Diff(expected, errs.Error()); diff != "" {
+		t.Errorf("server: after Validate differs: (-want +got)\n%s", diff)
+	}
+}
+
+func TestConfigValidateUnreadableMaps(t *testing.T) {
+	dir := t.TempDir()
+	writeTestPak(t, filepath.Join(dir, "baseq3", "maps.pk3"), "q3dm17")
+	writeFile(t, filepath.Join(dir, "baseq3", "corrupt.pk3"), "PK\x03\x04corrupt")
+
+	// A map whose entities can't be read.
+	f, err := os.Create(filepath.Join(dir, "baseq3", "ctf.pk3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("maps/q3ctf1.bsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("IBSP"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	cfg, err := ParseConfig([]byte(`
+maps:
+- name: q3ctf1
+  type: CaptureTheFlag
+- name: q3dm17
+  type: CaptureTheFlag
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The corrupt map pack is skipped, and only the map whose entities are
+	// known is checked for flags.
+	expected := `maps[1].type: map "q3dm17" does not support CaptureTheFlag, it requires entities: team_CTF_redflag, team_CTF_blueflag`
+	if err := cfg.Validate(dir); err == nil || err.Error() != expected {
+		t.Errorf("server: after Validate expected %q, received %v", expected, err)
+	}
+}
+
+const legacyConfig = `// legacy server config
+seta sv_hostname "Legacy Server"
+seta sv_maxclients 16
+seta g_motd "hello world" // trailing comment
+seta timelimit 20
+seta sv_pure 1
+set g_gametype 0
+set d1 "map q3dm1 ; set nextmap vstr d2"
+set d2 "fraglimit 30 ; map q3dm17 ; set nextmap vstr d3"
+set d3 "g_gametype 4; capturelimit 8; map q3ctf1 ; set nextmap vstr d1"
+addbot sarge 3
+vstr d1
+`
+
+func TestImportConfig(t *testing.T) {
+	cfg, err := ImportConfig([]byte(legacyConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Default()
+	expected.ServerConfig.Hostname = "Legacy Server"
+	expected.ServerConfig.MaxClients = 16
+	expected.GameConfig.MOTD = "hello world"
+	expected.TimeLimit.Duration = 20 * time.Minute
+	expected.Cvars = Cvars{
+		"sv_pure": IntCvar(1),
+	}
+	expected.Commands = []string{
+		"addbot sarge 3",
+	}
+	expected.Maps = Maps{
+		{Name: "q3dm1", Type: FreeForAll},
+		{Name: "q3dm17", Type: FreeForAll, FragLimit: 30},
+		{Name: "q3ctf1", Type: CaptureTheFlag, FragLimit: 30, CaptureLimit: 8},
+	}
+	if diff := cmp.Diff(expected, cfg, cmp.AllowUnexported(CvarValue{})); diff != "" {
+		t.Errorf("server: after ImportConfig differs: (-want +got)\n%s", diff)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	data, err := Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s struct {
+		Properties struct {
+			Game struct {
+				Properties map[string]struct {
+					Enum []string `json:"enum"`
+				} `json:"properties"`
+			} `json:"game"`
+			Maps struct {
+				Items struct {
+					Properties map[string]any `json:"properties"`
+				} `json:"items"`
+			} `json:"maps"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"FreeForAll", "Tournament", "SinglePlayer", "TeamDeathmatch", "CaptureTheFlag",
+		"OneFlagCTF", "Overload", "Harvester",
+		"FFA", "CTF", "1FCTF", "Obelisk",
+	}
+	if diff := cmp.Diff(expected, s.Properties.Game.Properties["type"].Enum); diff != "" {
+		t.Errorf("server: game type enum differs: (-want +got)\n%s", diff)
+	}
+	for _, name := range []string{"name", "type", "captureLimit", "fragLimit", "timeLimit"} {
+		if _, ok := s.Properties.Maps.Items.Properties[name]; !ok {
+			t.Errorf("server: maps schema missing property %q", name)
+		}
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("QUAKE_RCON_PASSWORD", "hunter2")
+	t.Setenv("QUAKE_MOTD", "Welcome: \"fraggers\"\nhave fun")
+	t.Setenv("QUAKE_FRAGLIMIT", "30")
+
+	cases := []struct {
+		name     string
+		input    string
+		get      func(*Config) any
+		expected any
+		err      string
+	}{
+		{
+			name:     "braced variable",
+			input:    "server:\n  password: \"${QUAKE_RCON_PASSWORD}\"\n",
+			get:      func(c *Config) any { return c.ServerConfig.Password },
+			expected: "hunter2",
+		},
+		{
+			name:     "escaped and bare variables",
+			input:    "game:\n  password: \"$${QUAKE_RCON_PASSWORD}$QUAKE_RCON_PASSWORD\"\n",
+			get:      func(c *Config) any { return c.GameConfig.Password },
+			expected: "${QUAKE_RCON_PASSWORD}$QUAKE_RCON_PASSWORD",
+		},
+		{
+			name:     "value with colon, newline and quotes",
+			input:    "game:\n  motd: ${QUAKE_MOTD}\n",
+			get:      func(c *Config) any { return c.GameConfig.MOTD },
+			expected: "Welcome: \"fraggers\"\nhave fun",
+		},
+		{
+			name:     "quoted value with colon, newline and quotes",
+			input:    "game:\n  motd: \"[${QUAKE_MOTD}]\"\n",
+			get:      func(c *Config) any { return c.GameConfig.MOTD },
+			expected: "[Welcome: \"fraggers\"\nhave fun]",
+		},
+		{
+			name:     "unquoted integer",
+			input:    "fragLimit: ${QUAKE_FRAGLIMIT}\n",
+			get:      func(c *Config) any { return c.FragLimit },
+			expected: 30,
+		},
+		{
+			name:     "comments are not expanded",
+			input:    "# fragLimit: ${QUAKE_UNSET_VARIABLE}\nfragLimit: 25 # ${QUAKE_UNSET_VARIABLE}\n",
+			get:      func(c *Config) any { return c.FragLimit },
+			expected: 25,
+		},
+		{
+			name:  "unset variable",
+			input: "fragLimit: 25\ngame:\n  motd: ${QUAKE_UNSET_VARIABLE}\n",
+			err:   "line 3: ${QUAKE_UNSET_VARIABLE}: environment variable QUAKE_UNSET_VARIABLE is not set",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg, err := ParseConfig([]byte(c.input))
+			if c.err != "" {
+				if err == nil || err.Error() != c.err {
+					t.Fatalf("expected error %q, received %v", c.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(c.expected, c.get(cfg)); diff != "" {
+				t.Errorf("server: after expandEnv differs: (-want +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReadInstances(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected *Instances
+		err      string
+	}{
+		{
+			name: "relative config files",
+			input: `
+servers:
+- name: ffa
+  addr: 0.0.0.0:27960
+  configFile: ffa.yaml
+- name: tourney
+  addr: 0.0.0.0:27961
+  configFile: /etc/quake/tourney.yaml
+`,
+			expected: &Instances{
+				Servers: []Instance{
+					{Name: "ffa", Addr: "0.0.0.0:27960", ConfigFile: "ffa.yaml"},
+					{Name: "tourney", Addr: "0.0.0.0:27961", ConfigFile: "/etc/quake/tourney.yaml"},
+				},
+			},
+		},
+		{
+			name: "duplicate port",
+			input: `
+servers:
+- name: ffa
+  addr: 0.0.0.0:27960
+- name: tourney
+  addr: 127.0.0.1:27960
+`,
+			err: "servers[1].addr: port 27960 is already used",
+		},
+		{
+			name: "engine overrides",
+			input: `
+servers:
+- name: ffa
+  addr: 0.0.0.0:27960
+  configFile: /etc/quake/ffa.yaml
+- name: cpma
+  addr: 0.0.0.0:27961
+  configFile: /etc/quake/cpma.yaml
+  engine: cnq3
+  args: ["+set", "sv_fps", "125"]
+  env: ["LD_LIBRARY_PATH=/opt/cnq3"]
+`,
+			expected: &Instances{
+				Servers: []Instance{
+					{Name: "ffa", Addr: "0.0.0.0:27960", ConfigFile: "/etc/quake/ffa.yaml"},
+					{
+						Name:       "cpma",
+						Addr:       "0.0.0.0:27961",
+						ConfigFile: "/etc/quake/cpma.yaml",
+						Engine:     EngineCNQ3,
+						Args:       []string{"+set", "sv_fps", "125"},
+						Env:        []string{"LD_LIBRARY_PATH=/opt/cnq3"},
+					},
+				},
+			},
+		},
+		{
+			name: "unknown engine",
+			input: `
+servers:
+- name: ffa
+  addr: 0.0.0.0:27960
+  engine: quake2
+`,
+			err: `servers[0].engine: unknown engine "quake2", must be one of [ioq3 quake3e cnq3]`,
+		},
+		{
+			name: "invalid name",
+			input: `
+servers:
+- name: Tourney Server
+  addr: 0.0.0.0:27960
+`,
+			err: `servers[0].name: invalid name "Tourney Server", must be lowercase alphanumeric`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "servers.yaml")
+			if err := os.WriteFile(path, []byte(c.input), 0644); err != nil {
+				t.Fatal(err)
+			}
+			inst, err := ReadInstancesFromFile(path)
+			if c.err != "" {
+				if expected := path + ": " + c.err; err == nil || err.Error() != expected {
+					t.Fatalf("expected error %q, received %v", expected, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Relative config files are expected to be resolved against the
+			// directory of the instances file.
+			for i, s := range c.expected.Servers {
+				if !filepath.IsAbs(s.ConfigFile) {
+					c.expected.Servers[i].ConfigFile = filepath.Join(dir, s.ConfigFile)
+				}
+			}
+			if diff := cmp.Diff(c.expected, inst); diff != "" {
+				t.Errorf("server: after ReadInstancesFromFile differs: (-want +got)\n%s", diff)
+			}
+		})
+	}
+}
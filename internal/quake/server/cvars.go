@@ -0,0 +1,127 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Cvars are arbitrary cvars that are not modeled by a config field. They are
+// rendered as seta commands sorted by name.
+type Cvars map[string]CvarValue
+
+// Marshal renders the cvars as seta commands.
+func (c Cvars) Marshal() ([]byte, error) {
+	var b bytes.Buffer
+	for _, name := range c.names() {
+		b.WriteString(fmt.Sprintf("seta %s %s\n", name, strconv.Quote(c[name].String())))
+	}
+	return b.Bytes(), nil
+}
+
+func (c Cvars) names() []string {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// CvarValue is the value of a single cvar, which can be a string, int, bool
+// or duration. Strings are always kept as strings, even when they look like
+// durations (e.g. sv_hostname: 1h). In YAML, durations are tagged, e.g.
+// !duration 2m, and are set in seconds.
+type CvarValue struct {
+	v any
+}
+
+func StringCvar(s string) CvarValue          { return CvarValue{v: s} }
+func IntCvar(n int) CvarValue                { return CvarValue{v: n} }
+func BoolCvar(b bool) CvarValue              { return CvarValue{v: b} }
+func DurationCvar(d time.Duration) CvarValue { return CvarValue{v: d} }
+
+// Value returns the underlying string, int, bool or time.Duration value.
+func (c CvarValue) Value() any {
+	return c.v
+}
+
+// String returns the value as it is set in server.cfg.
+func (c CvarValue) String() string {
+	switch v := c.v.(type) {
+	case int:
+		return strconv.Itoa(v)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case time.Duration:
+		return strconv.Itoa(int(v.Seconds()))
+	case string:
+		return v
+	default:
+		return ""
+	}
+}
+
+func (c CvarValue) MarshalJSON() ([]byte, error) {
+	switch v := c.v.(type) {
+	case time.Duration:
+		return json.Marshal(int(v.Seconds()))
+	case nil:
+		return json.Marshal("")
+	default:
+		return json.Marshal(v)
+	}
+}
+
+func (c *CvarValue) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case bool:
+		c.v = v
+	case json.Number:
+		n, err := strconv.Atoi(v.String())
+		if err != nil {
+			return fmt.Errorf("cvar value %s must be an integer, quote it to set it as a string", v)
+		}
+		c.v = n
+	case string:
+		c.v = v
+	default:
+		return fmt.Errorf("cvar value must be a string, int, bool or duration, received %s", data)
+	}
+	return nil
+}
+
+// durationTag is the YAML tag of cvar values that are durations, which
+// are converted to seconds when the config is parsed.
+const durationTag = "!duration"
+
+// parseDurationCvar returns the value in seconds of a cvar tagged as a
+// duration, e.g. !duration 2m.
+func parseDurationCvar(s string) (string, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid duration %q, e.g. 2m or 1h30m", s)
+	}
+	return strconv.Itoa(int(d.Seconds())), nil
+}
+
+// parseCvarValue returns the CvarValue for a value read from server.cfg,
+// which is always a string but is treated as an int when possible.
+func parseCvarValue(s string) CvarValue {
+	if n, err := strconv.Atoi(s); err == nil {
+		return IntCvar(n)
+	}
+	return StringCvar(s)
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	contentutil "github.com/ChrisRx/quake-kube/internal/quake/content/util"
+	quakenet "github.com/ChrisRx/quake-kube/pkg/quake/net"
+)
+
+// DemoConfig configures the server-side demo recording of every match. This
+// requires a dedicated server that supports server-side demos, since vanilla
+// ioq3ded can only record demos from a client.
+type DemoConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// RecordCommand and StopCommand are the rcon commands that start and stop
+	// recording. The record command is passed the demo name.
+	RecordCommand string `json:"recordCommand"`
+	StopCommand   string `json:"stopCommand"`
+
+	// Dir is the directory, relative to the game directory, where the
+	// dedicated server writes demos. Finished demos are moved from there to
+	// the demos directory of the assets directory.
+	Dir string `json:"dir"`
+
+	// MaxAge and MaxCount limit how many demos are kept. Zero is unlimited.
+	MaxAge   metav1.Duration `json:"maxAge"`
+	MaxCount int             `json:"maxCount"`
+}
+
+// Match events are printed to the console by the game module. Depending on
+// the server, they can be prefixed with the level time.
+var matchEventRegexp = regexp.MustCompile(`^(?:\d+:\d\d )?(InitGame|Exit|ShutdownGame):\s?(.*)$`)
+
+type matchEvent struct {
+	name string
+	args string
+	time time.Time
+}
+
+// logWatcher is an io.Writer for the dedicated server output that sends match
+// events to a channel. Events are dropped, rather than blocking the dedicated
+// server, if the channel is full.
+type logWatcher struct {
+	mu  sync.Mutex
+	buf []byte
+	ch  chan matchEvent
+}
+
+func newLogWatcher() *logWatcher {
+	return &logWatcher{ch: make(chan matchEvent, 16)}
+}
+
+func (w *logWatcher) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimSpace(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+		m := matchEventRegexp.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		select {
+		case w.ch <- matchEvent{name: m[1], args: m[2], time: time.Now()}:
+		default:
+			log.Printf("demos: dropped %s event\n", m[1])
+		}
+	}
+	// A very long line without a newline isn't a match event.
+	if len(w.buf) > 64*1024 {
+		w.buf = w.buf[:0]
+	}
+	return len(p), nil
+}
+
+// demoRecorder records a server-side demo of every match. A match starts at
+// InitGame and ends at Exit. A game that is shut down before Exit, such as
+// the warmup or a map change, is discarded, and so are matches without any
+// human players.
+type demoRecorder struct {
+	s       *Server
+	addr    string
+	current *contentutil.Demo
+}
+
+func (r *demoRecorder) run(ctx context.Context, events <-chan matchEvent) {
+	for {
+		select {
+		case ev := <-events:
+			if err := r.handle(ev); err != nil {
+				log.Printf("demos: %s: %v\n", ev.name, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *demoRecorder) handle(ev matchEvent) error {
+	cfg := r.s.config.Load()
+	if cfg == nil {
+		return nil
+	}
+	switch ev.name {
+	case "InitGame":
+		if r.current != nil {
+			if err := r.discard(cfg); err != nil {
+				log.Printf("demos: %v\n", err)
+			}
+		}
+		if !cfg.Demos.Enabled {
+			return nil
+		}
+		return r.start(cfg, ev)
+	case "Exit":
+		if r.current == nil {
+			return nil
+		}
+		return r.finish(cfg, ev)
+	case "ShutdownGame":
+		if r.current == nil {
+			return nil
+		}
+		return r.discard(cfg)
+	}
+	return nil
+}
+
+var unsafeNameRegexp = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+func (r *demoRecorder) start(cfg *Config, ev matchEvent) error {
+	info := parseInfoString(ev.args)
+	mapName := info["mapname"]
+	parts := []string{ev.time.UTC().Format("20060102-150405")}
+	if r.s.Name != "" {
+		parts = append(parts, r.s.Name)
+	}
+	if mapName != "" {
+		parts = append(parts, unsafeNameRegexp.ReplaceAllString(mapName, "_"))
+	}
+	d := &contentutil.Demo{
+		ID:     strings.Join(parts, "-"),
+		Server: r.s.Name,
+		Map:    mapName,
+		Start:  ev.time,
+	}
+	if n, err := strconv.Atoi(info["g_gametype"]); err == nil {
+		d.GameType = GameType(n).String()
+	}
+	if _, err := quakenet.SendServerCommand(r.addr, cfg.ServerConfig.Password, cfg.Demos.RecordCommand+" "+d.ID); err != nil {
+		return err
+	}
+	r.current = d
+	return nil
+}
+
+func (r *demoRecorder) stop(cfg *Config) (*contentutil.Demo, error) {
+	d := r.current
+	r.current = nil
+	if _, err := quakenet.SendServerCommand(r.addr, cfg.ServerConfig.Password, cfg.Demos.StopCommand); err != nil {
+		return d, err
+	}
+	return d, nil
+}
+
+func (r *demoRecorder) finish(cfg *Config, ev matchEvent) error {
+	// The scores are taken before stopping, while they are still those of
+	// the match that just ended.
+	status, err := quakenet.GetStatus(r.addr)
+	if err != nil {
+		return err
+	}
+	d, err := r.stop(cfg)
+	if err != nil {
+		return err
+	}
+	d.End = ev.time
+	humans := false
+	for _, p := range status.Players {
+		d.Players = append(d.Players, contentutil.DemoPlayer{Name: p.Name, Score: p.Score})
+		if !p.IsBot() {
+			humans = true
+		}
+	}
+	path, err := r.demoFile(cfg, d.ID)
+	if err != nil {
+		return err
+	}
+	if !humans {
+		return os.Remove(path)
+	}
+	if err := contentutil.SaveDemo(r.s.Dir, d, path); err != nil {
+		return err
+	}
+	log.Printf("demos: saved %s\n", d.ID)
+
+	removed, err := contentutil.PruneDemos(r.s.Dir, cfg.Demos.MaxAge.Duration, cfg.Demos.MaxCount, time.Now())
+	for _, d := range removed {
+		log.Printf("demos: removed %s\n", d.ID)
+	}
+	return err
+}
+
+func (r *demoRecorder) discard(cfg *Config) error {
+	d, err := r.stop(cfg)
+	if err != nil {
+		return err
+	}
+	path, err := r.demoFile(cfg, d.ID)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// demoFile returns the path of the demo file written by the dedicated
+// server. The extension depends on the server, so any extension matches.
+func (r *demoRecorder) demoFile(cfg *Config, id string) (string, error) {
+	dir := filepath.Join(r.s.Dir, cfg.GameDir(), cfg.Demos.Dir)
+	matches, err := filepath.Glob(filepath.Join(dir, id+".*"))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("cannot find demo %s in %s, check that the server supports server-side demos", id, dir)
+	}
+	return matches[0], nil
+}
+
+// parseInfoString parses a \key\value info string, such as the one printed
+// with InitGame.
+func parseInfoString(s string) map[string]string {
+	parts := strings.Split(strings.TrimPrefix(s, "\\"), "\\")
+	m := make(map[string]string)
+	for i := 0; i < len(parts)-1; i += 2 {
+		m[parts[i]] = parts[i+1]
+	}
+	return m
+}
@@ -0,0 +1,77 @@
+package server
+
+import "fmt"
+
+// Engine is a dedicated server engine. The engines are forks of the Quake 3
+// source code, so they take mostly the same command line, but differ in the
+// details.
+type Engine string
+
+const (
+	// EngineIOQ3 is ioquake3, which is the default.
+	EngineIOQ3 Engine = "ioq3"
+
+	// EngineQuake3e is Quake3e, a fork focused on performance.
+	EngineQuake3e Engine = "quake3e"
+
+	// EngineCNQ3 is CNQ3, the engine made for CPMA.
+	EngineCNQ3 Engine = "cnq3"
+)
+
+// EngineProfile is what differs between engines when running them.
+type EngineProfile struct {
+	// Binary is the name of the dedicated server binary, which is looked up
+	// in the PATH.
+	Binary string
+
+	// Protocol is the network protocol of the engine. ioq3 uses protocol 71,
+	// but also accepts clients using 68, the protocol of Quake 3 1.32 that
+	// the other engines use.
+	Protocol int
+
+	// Masters is the number of sv_master cvars, which are all cleared so that
+	// servers aren't listed publicly.
+	Masters int
+
+	// BaseGameCvar is the cvar that sets the base game directory, for engines
+	// that support base games other than baseq3.
+	BaseGameCvar string
+
+	// Args are engine specific arguments.
+	Args []string
+}
+
+var engineProfiles = map[Engine]*EngineProfile{
+	EngineIOQ3: {
+		Binary:       "ioq3ded",
+		Protocol:     71,
+		Masters:      5,
+		BaseGameCvar: "com_basegame",
+		Args:         []string{"+set", "com_gamename", "Quake3Arena"},
+	},
+	EngineQuake3e: {
+		Binary:       "quake3e.ded",
+		Protocol:     68,
+		Masters:      5,
+		BaseGameCvar: "fs_basegame",
+	},
+	EngineCNQ3: {
+		Binary:   "cnq3-server-x64",
+		Protocol: 68,
+		Masters:  3,
+	},
+}
+
+// Engines returns the names of the supported engines.
+func Engines() []Engine {
+	return []Engine{EngineIOQ3, EngineQuake3e, EngineCNQ3}
+}
+
+// Profile returns the profile of the engine.
+func (e Engine) Profile() (*EngineProfile, error) {
+	p, ok := engineProfiles[e]
+	if !ok {
+		return nil, fmt.Errorf("unknown engine %q, must be one of %v", e, Engines())
+	}
+	return p, nil
+}
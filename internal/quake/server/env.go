@@ -0,0 +1,112 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	yamlv3 "sigs.k8s.io/yaml/goyaml.v3"
+)
+
+var envRegexp = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${VAR} references in the values of a parsed config
+// file with the value of the environment variable VAR. A literal ${VAR} can
+// be written as $${VAR}. Unlike os.Expand, bare $VAR references are left
+// alone so that passwords containing $ don't need to be escaped. Only scalar
+// values are expanded, after parsing, so that the value of a variable is
+// never parsed as YAML, and comments and keys are left alone.
+func expandEnv(n *yamlv3.Node) error {
+	var errs ValidationErrors
+	var walk func(n *yamlv3.Node)
+	walk = func(n *yamlv3.Node) {
+		switch n.Kind {
+		case yamlv3.DocumentNode, yamlv3.SequenceNode:
+			for _, c := range n.Content {
+				walk(c)
+			}
+		case yamlv3.MappingNode:
+			for i := 1; i < len(n.Content); i += 2 {
+				walk(n.Content[i])
+			}
+		case yamlv3.ScalarNode:
+			value, ok := expandEnvString(n.Value, n.Line, &errs)
+			if !ok {
+				return
+			}
+			n.Value = value
+			// The type of an unquoted value is resolved from the expanded
+			// value, e.g. fragLimit: ${FRAGLIMIT} is an integer.
+			if n.Style&(yamlv3.SingleQuotedStyle|yamlv3.DoubleQuotedStyle|yamlv3.LiteralStyle|yamlv3.FoldedStyle) == 0 {
+				n.Tag = ""
+			}
+		}
+		// Aliases are expanded where their anchor is.
+	}
+	walk(n)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// expandEnvString expands the ${VAR} references in a single value, and
+// reports whether it has any.
+func expandEnvString(s string, line int, errs *ValidationErrors) (string, bool) {
+	matches := envRegexp.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s, false
+	}
+	var (
+		b    strings.Builder
+		last int
+	)
+	for _, m := range matches {
+		b.WriteString(s[last:m[0]])
+		last = m[1]
+		ref := s[m[0]:m[1]]
+		if strings.HasPrefix(ref, "$$") {
+			b.WriteString(ref[1:])
+			continue
+		}
+		name := s[m[2]:m[3]]
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			*errs = append(*errs, &ValidationError{
+				Line:  line,
+				Field: ref,
+				Msg:   fmt.Sprintf("environment variable %s is not set", name),
+			})
+			continue
+		}
+		b.WriteString(value)
+	}
+	b.WriteString(s[last:])
+	return b.String(), true
+}
+
+// readPasswordFiles sets the passwords from their password files, if set.
+// They are only read by the server, and not when parsing the config, so that
+// a config can be checked or rendered without the files, e.g. a Secret that
+// is only mounted in the pod of the server.
+func (c *Config) readPasswordFiles() error {
+	var errs ValidationErrors
+	read := func(field, path string, password *string) {
+		if path == "" {
+			return
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, &ValidationError{Field: field, Msg: err.Error()})
+			return
+		}
+		*password = strings.TrimSpace(string(data))
+	}
+	read("game.passwordFile", c.GameConfig.PasswordFile, &c.GameConfig.Password)
+	read("server.rconPasswordFile", c.ServerConfig.RconPasswordFile, &c.ServerConfig.Password)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
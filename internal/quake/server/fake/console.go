@@ -0,0 +1,77 @@
+package fake
+
+import "strings"
+
+// commandLine splits the command line into commands, which start with a
+// plus sign, e.g. +set net_port 27960 +exec server.cfg.
+func commandLine(args []string) [][]string {
+	var cmds [][]string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "+") {
+			cmds = append(cmds, []string{arg[1:]})
+			continue
+		}
+		if len(cmds) > 0 {
+			cmds[len(cmds)-1] = append(cmds[len(cmds)-1], arg)
+		}
+	}
+	return cmds
+}
+
+// splitCommands splits console text into commands, which are separated by
+// newlines, or semicolons outside of quotes. Comments are removed.
+func splitCommands(text string) []string {
+	var (
+		cmds   []string
+		b      strings.Builder
+		quoted bool
+	)
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c == '"' {
+			quoted = !quoted
+		}
+		if !quoted && strings.HasPrefix(text[i:], "//") {
+			j := strings.IndexByte(text[i:], '\n')
+			if j < 0 {
+				break
+			}
+			i += j
+			c = '\n'
+		}
+		if c == '\n' || c == '\r' || (c == ';' && !quoted) {
+			cmds = append(cmds, b.String())
+			b.Reset()
+			quoted = false
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return append(cmds, b.String())
+}
+
+// tokenize splits a command into its arguments, which can be quoted.
+func tokenize(s string) []string {
+	var args []string
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return args
+		}
+		if s[0] == '"' {
+			arg, rest, ok := strings.Cut(s[1:], `"`)
+			args = append(args, arg)
+			if !ok {
+				return args
+			}
+			s = rest
+			continue
+		}
+		i := strings.IndexAny(s, " \t")
+		if i < 0 {
+			return append(args, s)
+		}
+		args = append(args, s[:i])
+		s = s[i:]
+	}
+}
@@ -0,0 +1,556 @@
+// Package fake is a fake of ioq3ded, the Quake 3 dedicated server, for
+// testing without the game binary or the game files. It takes the same
+// command line, runs the config files, answers getinfo, getstatus and rcon,
+// and prints game log lines, following a Script.
+//
+// Tests can run it as the dedicated server by setting Server.Binary to the
+// test binary, and calling Main from TestMain when the test binary is run
+// by the server.
+package fake
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// EnvScript is the environment variable with the path of the script that
+// Main runs.
+const EnvScript = "FAKE_IOQ3DED_SCRIPT"
+
+const outOfBandHeader = "\xff\xff\xff\xff"
+
+// serverInfo are the cvars in the server info string, in the order ioq3ded
+// usually sends them.
+var serverInfo = []string{
+	"version", "dmflags", "fraglimit", "timelimit", "capturelimit",
+	"sv_hostname", "sv_maxclients", "g_gametype", "protocol", "mapname",
+	"sv_privateClients", "sv_allowDownload", "bot_minplayers", "gamename",
+	"g_needpass",
+}
+
+// Main runs a fake server with the ioq3ded command line in os.Args, and the
+// script in EnvScript, if set. It exits when the server quits.
+func Main() {
+	script := &Script{}
+	if path := os.Getenv(EnvScript); path != "" {
+		var err error
+		script, err = ReadScript(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fake: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := New(script, os.Stdout).Run(ctx, os.Args[1:])
+	var code exitCode
+	switch {
+	case errors.As(err, &code):
+		os.Exit(int(code))
+	case err != nil && !errors.Is(err, context.Canceled):
+		fmt.Fprintf(os.Stderr, "fake: %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+type exitCode int
+
+func (c exitCode) Error() string {
+	return fmt.Sprintf("exit status %d", int(c))
+}
+
+type Server struct {
+	script    *Script
+	stdout    io.Writer
+	quit      chan error
+	listening chan struct{}
+	conn      net.PacketConn
+
+	mu         sync.Mutex
+	cvars      map[string]string
+	serverInfo []string
+	players    []Player
+	running    bool
+
+	// out is the output of the rcon command being run.
+	out *bytes.Buffer
+}
+
+// New returns a fake server that prints its console output to stdout.
+func New(script *Script, stdout io.Writer) *Server {
+	if script == nil {
+		script = &Script{}
+	}
+	return &Server{
+		script:    script,
+		stdout:    stdout,
+		quit:      make(chan error, 1),
+		listening: make(chan struct{}),
+		cvars: map[string]string{
+			"version":       "ioq3 1.36_fake linux-x86_64",
+			"protocol":      "71",
+			"gamename":      "baseq3",
+			"com_basegame":  "baseq3",
+			"fs_homepath":   ".",
+			"net_ip":        "0.0.0.0",
+			"net_port":      "27960",
+			"sv_hostname":   "noname",
+			"sv_maxclients": "8",
+			"sv_pure":       "1",
+			"g_gametype":    "0",
+			"g_needpass":    "0",
+			"fraglimit":     "20",
+			"timelimit":     "0",
+			"capturelimit":  "8",
+			"dmflags":       "0",
+			"rconpassword":  "",
+		},
+		players: append([]Player(nil), script.Players...),
+	}
+}
+
+// Run runs the command line, e.g. +set net_port 27960 +exec server.cfg, and
+// then serves until the context is done or the server quits. Like ioq3ded,
+// the server doesn't answer any queries until a map is loaded.
+func (s *Server) Run(ctx context.Context, args []string) error {
+	s.mu.Lock()
+	s.printf("%s\n", s.cvars["version"])
+	for _, cmd := range commandLine(args) {
+		s.execute(cmd)
+	}
+	addr := net.JoinHostPort(s.cvars["net_ip"], s.cvars["net_port"])
+	s.mu.Unlock()
+
+	conn, err := net.ListenPacket("udp4", addr)
+	if err != nil {
+		close(s.listening)
+		return err
+	}
+	defer conn.Close()
+	s.conn = conn
+	close(s.listening)
+
+	s.mu.Lock()
+	s.printf("Opening IP socket: %s\n", conn.LocalAddr())
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go s.serve()
+	go s.runSteps(ctx)
+
+	select {
+	case err := <-s.quit:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Addr returns the address the server listens on, once it is running, or
+// an empty string if it couldn't listen.
+func (s *Server) Addr() string {
+	<-s.listening
+	if s.conn == nil {
+		return ""
+	}
+	return s.conn.LocalAddr().String()
+}
+
+// Players returns the players on the server.
+func (s *Server) Players() []Player {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Player(nil), s.players...)
+}
+
+// Cvar returns the value of a cvar.
+func (s *Server) Cvar(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cvars[strings.ToLower(name)]
+}
+
+func (s *Server) runSteps(ctx context.Context) {
+	for _, step := range s.script.Steps {
+		select {
+		case <-time.After(step.After.Duration):
+		case <-ctx.Done():
+			return
+		}
+		s.mu.Lock()
+		for _, line := range step.Log {
+			s.printf("%s\n", line)
+		}
+		for _, cmd := range step.Commands {
+			s.run(cmd)
+		}
+		for _, p := range step.Join {
+			s.join(p)
+		}
+		for _, name := range step.Leave {
+			s.leave(name)
+		}
+		s.mu.Unlock()
+		if step.Exit != nil {
+			s.exit(exitCode(*step.Exit))
+			return
+		}
+	}
+}
+
+func (s *Server) exit(err error) {
+	select {
+	case s.quit <- err:
+	default:
+	}
+}
+
+// printf prints to the console, which must be locked.
+func (s *Server) printf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if s.out != nil {
+		s.out.WriteString(msg)
+	}
+	io.WriteString(s.stdout, msg)
+}
+
+func (s *Server) serve() {
+	buf := make([]byte, 16384)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		msg, ok := bytes.CutPrefix(buf[:n], []byte(outOfBandHeader))
+		if !ok {
+			// Clients can't connect to a fake server.
+			continue
+		}
+		s.record(string(msg))
+		if resp := s.connectionless(string(msg), addr); resp != "" {
+			s.conn.WriteTo([]byte(outOfBandHeader+resp), addr)
+		}
+	}
+}
+
+func (s *Server) record(msg string) {
+	if s.script.Transcript == "" {
+		return
+	}
+	f, err := os.OpenFile(s.script.Transcript, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, strings.TrimRight(msg, "\n"))
+}
+
+func (s *Server) connectionless(msg string, addr net.Addr) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.running {
+		return ""
+	}
+	line, _, _ := strings.Cut(msg, "\n")
+	args := tokenize(line)
+	if len(args) == 0 {
+		return ""
+	}
+	switch args[0] {
+	case "getinfo":
+		return "infoResponse\n" + s.infoString(args)
+	case "getstatus":
+		var b strings.Builder
+		b.WriteString("statusResponse\n")
+		b.WriteString(s.serverInfoString())
+		if len(args) > 1 {
+			b.WriteString(`\challenge\` + args[1])
+		}
+		b.WriteString("\n")
+		for _, p := range s.players {
+			fmt.Fprintf(&b, "%d %d \"%s\"\n", p.Score, p.Ping, p.Name)
+		}
+		return b.String()
+	case "rcon":
+		return "print\n" + s.rcon(msg, addr)
+	}
+	return ""
+}
+
+// rcon runs an rcon command, rcon <password> <command>, and returns its
+// output. Unlike ioq3ded, the output is also printed to the console.
+func (s *Server) rcon(msg string, addr net.Addr) string {
+	rest := strings.TrimLeft(strings.TrimPrefix(msg, "rcon"), " ")
+	var password string
+	if strings.HasPrefix(rest, `"`) {
+		password, rest, _ = strings.Cut(rest[1:], `"`)
+	} else {
+		password, rest, _ = strings.Cut(rest, " ")
+	}
+	cmd := strings.TrimSpace(rest)
+
+	switch {
+	case s.cvars["rconpassword"] == "":
+		return "No rconpassword set on the server.\n"
+	case password != s.cvars["rconpassword"]:
+		s.printf("Bad rcon from %s: %s\n", addr, cmd)
+		return "Bad rconpassword.\n"
+	}
+	s.printf("Rcon from %s: %s\n", addr, cmd)
+	var out bytes.Buffer
+	s.out = &out
+	s.run(cmd)
+	s.out = nil
+	return out.String()
+}
+
+func (s *Server) infoString(args []string) string {
+	humans := 0
+	for _, p := range s.players {
+		if p.Ping > 0 {
+			humans++
+		}
+	}
+	var b strings.Builder
+	if len(args) > 1 {
+		b.WriteString(`\challenge\` + args[1])
+	}
+	for _, kv := range [][2]string{
+		{"gamename", s.cvars["gamename"]},
+		{"protocol", s.cvars["protocol"]},
+		{"hostname", s.cvars["sv_hostname"]},
+		{"mapname", s.cvars["mapname"]},
+		{"clients", strconv.Itoa(len(s.players))},
+		{"g_humanplayers", strconv.Itoa(humans)},
+		{"sv_maxclients", s.cvars["sv_maxclients"]},
+		{"gametype", s.cvars["g_gametype"]},
+		{"pure", s.cvars["sv_pure"]},
+		{"g_needpass", s.cvars["g_needpass"]},
+	} {
+		b.WriteString(`\` + kv[0] + `\` + kv[1])
+	}
+	if game := s.cvars["fs_game"]; game != "" {
+		b.WriteString(`\game\` + game)
+	}
+	return b.String()
+}
+
+func (s *Server) serverInfoString() string {
+	var b strings.Builder
+	for _, name := range serverInfo {
+		if v, ok := s.cvars[strings.ToLower(name)]; ok {
+			b.WriteString(`\` + name + `\` + v)
+		}
+	}
+	// Cvars set with sets are also server info.
+	for _, name := range s.serverInfo {
+		b.WriteString(`\` + name + `\` + s.cvars[name])
+	}
+	return b.String()
+}
+
+// run runs console text, which can have several commands separated by
+// semicolons or newlines.
+func (s *Server) run(text string) {
+	for _, line := range splitCommands(text) {
+		if args := tokenize(line); len(args) > 0 {
+			s.execute(args)
+		}
+	}
+}
+
+func (s *Server) execute(args []string) {
+	name := strings.ToLower(args[0])
+	if out, ok := s.script.Rcon[name]; ok {
+		s.printf("%s", out)
+		return
+	}
+	switch name {
+	case "set", "seta", "sets", "setu":
+		if len(args) < 3 {
+			s.printf("usage: %s <variable> <value>\n", name)
+			return
+		}
+		s.cvars[strings.ToLower(args[1])] = strings.Join(args[2:], " ")
+		if name == "sets" && !contains(serverInfo, args[1]) && !contains(s.serverInfo, args[1]) {
+			s.serverInfo = append(s.serverInfo, strings.ToLower(args[1]))
+		}
+	case "vstr":
+		if len(args) != 2 {
+			s.printf("vstr <variablename> : execute a variable command\n")
+			return
+		}
+		s.run(s.cvars[strings.ToLower(args[1])])
+	case "exec":
+		if len(args) != 2 {
+			s.printf("exec <filename> : execute a script file\n")
+			return
+		}
+		s.exec(args[1])
+	case "map", "devmap":
+		if len(args) != 2 {
+			return
+		}
+		s.loadMap(args[1])
+	case "say":
+		s.printf("broadcast: print \"server: %s\\n\"\n", strings.Join(args[1:], " "))
+	case "kick", "clientkick":
+		if len(args) != 2 {
+			s.printf("Usage: kick <player name>\nkick all = kick everyone\nkick allbots = kick all bots\n")
+			return
+		}
+		s.kick(args[1])
+	case "status":
+		s.status()
+	case "serverinfo":
+		s.printf("Server info settings:\n%s\n", s.serverInfoString())
+	case "echo":
+		s.printf("%s\n", strings.Join(args[1:], " "))
+	case "quit", "killserver":
+		s.exit(nil)
+	default:
+		// Like ioq3ded, a cvar name prints or sets the cvar.
+		v, ok := s.cvars[name]
+		switch {
+		case ok && len(args) == 1:
+			s.printf("\"%s\" is:\"%s^7\"\n", args[0], v)
+		case ok:
+			s.cvars[name] = strings.Join(args[1:], " ")
+		default:
+			s.printf("Unknown command \"%s^7\"\n", args[0])
+		}
+	}
+}
+
+// exec runs a config file from the game directory, or the base game
+// directory, in the home path and then the base path.
+func (s *Server) exec(name string) {
+	if filepath.Ext(name) == "" {
+		name += ".cfg"
+	}
+	for _, path := range []string{s.cvars["fs_homepath"], s.cvars["fs_basepath"]} {
+		for _, dir := range []string{s.cvars["fs_game"], s.cvars["com_basegame"]} {
+			if path == "" || dir == "" {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(path, dir, name))
+			if err != nil {
+				continue
+			}
+			s.printf("execing %s\n", name)
+			s.run(string(data))
+			return
+		}
+	}
+	s.printf("couldn't exec %s\n", name)
+}
+
+// loadMap prints the log lines of a map change, which the game module
+// prints on a dedicated server.
+func (s *Server) loadMap(name string) {
+	if s.running {
+		s.printf("ShutdownGame:\n")
+		s.printf("%s\n", strings.Repeat("-", 60))
+	}
+	s.cvars["mapname"] = name
+	s.printf("------ Server Initialization ------\n")
+	s.printf("Server: %s\n", name)
+	s.printf("------- Game Initialization -------\n")
+	s.printf("gamename: %s\n", s.cvars["gamename"])
+	s.printf("InitGame: %s\n", s.serverInfoString())
+	s.running = true
+}
+
+func (s *Server) kick(name string) {
+	var kicked, players []Player
+	for _, p := range s.players {
+		switch {
+		case name == "all",
+			name == "allbots" && p.Ping == 0,
+			p.Name == name,
+			strings.EqualFold(cleanName(p.Name), cleanName(name)):
+			kicked = append(kicked, p)
+		default:
+			players = append(players, p)
+		}
+	}
+	if len(kicked) == 0 {
+		s.printf("Player %s is not on the server\n", name)
+		return
+	}
+	s.players = players
+	for _, p := range kicked {
+		s.printf("broadcast: print \"%s^7 was kicked\\n\"\n", p.Name)
+	}
+}
+
+func (s *Server) status() {
+	s.printf("map: %s\n", s.cvars["mapname"])
+	s.printf("num score ping name            lastmsg address               qport rate\n")
+	s.printf("--- ----- ---- --------------- ------- --------------------- ----- -----\n")
+	for i, p := range s.players {
+		addr := "127.0.0.1"
+		if p.Ping == 0 {
+			addr = "bot"
+		}
+		s.printf("%3d %5d %4d %-15s %7d %-21s %5d %5d\n", i, p.Score, p.Ping, p.Name, 0, addr, 0, 25000)
+	}
+	s.printf("\n")
+}
+
+func (s *Server) join(player Player) {
+	for i, p := range s.players {
+		if p.Name == player.Name {
+			s.players[i] = player
+			return
+		}
+	}
+	s.players = append(s.players, player)
+}
+
+func (s *Server) leave(name string) {
+	for i, p := range s.players {
+		if p.Name == name {
+			s.players = append(s.players[:i], s.players[i+1:]...)
+			return
+		}
+	}
+}
+
+// cleanName removes the color codes from a name, e.g. ^1Sarge.
+func cleanName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '^' && i+1 < len(name) && name[i+1] != '^' {
+			i++
+			continue
+		}
+		b.WriteByte(name[i])
+	}
+	return b.String()
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if strings.EqualFold(e, v) {
+			return true
+		}
+	}
+	return false
+}
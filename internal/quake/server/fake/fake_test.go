@@ -0,0 +1,171 @@
+package fake
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	quakenet "github.com/ChrisRx/quake-kube/pkg/quake/net"
+)
+
+const testConfig = `seta sv_hostname "fake server" // comment
+seta rconpassword "secret"
+set d0 "seta g_gametype 0 ; map q3dm7 ; set nextmap vstr d1"
+set d1 "map q3dm17 ; set nextmap vstr d0"
+vstr d0
+`
+
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "baseq3"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "baseq3", "server.cfg"), []byte(testConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	transcript := filepath.Join(dir, "transcript")
+	script := &Script{
+		Players: []Player{{Name: "^1Sarge", Score: 3}, {Name: "player", Score: 12, Ping: 48}},
+		Steps: []Step{
+			{After: metav1.Duration{Duration: 10 * time.Millisecond}, Log: []string{"Exit: Fraglimit hit."}, Join: []Player{{Name: "Major", Ping: 0}}},
+		},
+		Rcon:       map[string]string{"sv_record": "recording\n"},
+		Transcript: transcript,
+	}
+	var stdout syncBuffer
+	s := New(script, &stdout)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.Run(ctx, []string{"+set", "dedicated", "2", "+set", "net_ip", "127.0.0.1", "+set", "net_port", "0", "+set", "fs_homepath", dir, "+exec", "server.cfg"})
+	}()
+	addr := s.Addr()
+	if addr == "" {
+		t.Fatal(<-errc)
+	}
+
+	info, err := quakenet.GetInfo(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info["hostname"] != "fake server" || info["mapname"] != "q3dm7" || info["clients"] != "2" || info["g_humanplayers"] != "1" {
+		t.Errorf("fake: unexpected info: %v", info)
+	}
+
+	waitFor(t, func() bool { return strings.Contains(stdout.String(), "Exit: Fraglimit hit.") })
+	status, err := quakenet.GetStatus(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []quakenet.Player{
+		{Name: "^1Sarge", Score: 3},
+		{Name: "player", Score: 12, Ping: 48},
+		{Name: "Major"},
+	}
+	if diff := cmp.Diff(expected, status.Players); diff != "" {
+		t.Errorf("fake: after GetStatus differs: (-want +got)\n%s", diff)
+	}
+
+	for _, tc := range []struct {
+		password, cmd, expected string
+	}{
+		{"wrong", "status", "Bad rconpassword.\n"},
+		{"secret", "say hello", "broadcast: print \"server: hello\\n\"\n"},
+		{"secret", "kick Sarge", "broadcast: print \"^1Sarge^7 was kicked\\n\"\n"},
+		{"secret", "sv_record demo1", "recording\n"},
+		{"secret", "vstr nextmap", "ShutdownGame:\n"},
+		{"secret", "mapname", "\"mapname\" is:\"q3dm17^7\"\n"},
+	} {
+		resp, err := quakenet.SendServerCommand(addr, tc.password, tc.cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Commands that change the map also have the game log in their output.
+		if !strings.HasPrefix(string(resp), quakenet.OutOfBandHeader+"print\n"+tc.expected) {
+			t.Errorf("fake: unexpected output of rcon %s: %q", tc.cmd, resp)
+		}
+	}
+	if diff := cmp.Diff([]Player{{Name: "player", Score: 12, Ping: 48}, {Name: "Major"}}, s.Players()); diff != "" {
+		t.Errorf("fake: after kick differs: (-want +got)\n%s", diff)
+	}
+
+	// The map change is in the game log.
+	for _, line := range []string{"InitGame: ", `\mapname\q3dm7`, "ShutdownGame:", `\mapname\q3dm17`} {
+		if !strings.Contains(stdout.String(), line) {
+			t.Errorf("fake: expected %q in output:\n%s", line, stdout.String())
+		}
+	}
+
+	data, err := os.ReadFile(transcript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "getinfo\ngetstatus\nrcon wrong status\nrcon secret say hello\n") {
+		t.Errorf("fake: unexpected transcript:\n%s", data)
+	}
+
+	if _, err := quakenet.SendServerCommand(addr, "secret", "quit"); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("expected server to quit, received %v", err)
+	}
+}
+
+func TestSplitCommands(t *testing.T) {
+	cmds := splitCommands("set d0 \"map q3dm7 ; set nextmap vstr d1\"; vstr d0 // start\nsay \"a // b\"\n")
+	var got [][]string
+	for _, cmd := range cmds {
+		if args := tokenize(cmd); len(args) > 0 {
+			got = append(got, args)
+		}
+	}
+	expected := [][]string{
+		{"set", "d0", "map q3dm7 ; set nextmap vstr d1"},
+		{"vstr", "d0"},
+		{"say", "a // b"},
+	}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("fake: after splitCommands differs: (-want +got)\n%s", diff)
+	}
+}
+
+func waitFor(t *testing.T, fn func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if fn() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out")
+}
@@ -0,0 +1,69 @@
+package fake
+
+import (
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Script is what happens on a fake server, other than answering queries and
+// rcon commands.
+type Script struct {
+	// Players are on the server from the start.
+	Players []Player `json:"players"`
+
+	// Steps run in order, once the server is started.
+	Steps []Step `json:"steps"`
+
+	// Rcon has canned output for rcon commands, by command name, which takes
+	// precedence over the commands implemented by the fake, e.g. for mod
+	// specific commands like demo recording.
+	Rcon map[string]string `json:"rcon"`
+
+	// Transcript is a file that every connectionless command received by the
+	// server is appended to, one per line, such as "rcon <password> say hi".
+	// It is appended to so that it survives server restarts.
+	Transcript string `json:"transcript"`
+}
+
+// Player is a player on the server. Players with a ping of 0 are bots.
+type Player struct {
+	Name  string `json:"name"`
+	Score int    `json:"score"`
+	Ping  int    `json:"ping"`
+}
+
+type Step struct {
+	// After is how long to wait after the previous step.
+	After metav1.Duration `json:"after"`
+
+	// Log lines are printed to stdout, like the game module prints its log on
+	// a dedicated server, e.g. "Exit: Fraglimit hit.".
+	Log []string `json:"log"`
+
+	// Commands are run on the console, e.g. "map q3dm17".
+	Commands []string `json:"commands"`
+
+	// Join adds players, or updates the score and ping of players with the
+	// same name.
+	Join []Player `json:"join"`
+
+	// Leave removes players by name.
+	Leave []string `json:"leave"`
+
+	// Exit makes the server exit with the status code, like a crash.
+	Exit *int `json:"exit"`
+}
+
+func ReadScript(path string) (*Script, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Script
+	if err := yaml.UnmarshalStrict(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ImportConfig parses an existing server.cfg into a Config. Cvars that map to
+// a Config field are set on that field, and the map rotation is recovered by
+// following the vstr chain that is started by the last top-level vstr (or
+// map) command. Any other cvars are added to Cvars, and everything else is
+// kept as-is in Commands.
+func ImportConfig(data []byte) (*Config, error) {
+	cfg := Default()
+	cfg.Commands = []string{}
+	cfg.Maps = Maps{}
+	fields := make(map[string]cvarField)
+	cvarFields(reflect.ValueOf(cfg).Elem(), fields)
+
+	type command struct {
+		line  string
+		set   string
+		value string
+	}
+	var (
+		cmds    []command
+		vars    = make(map[string]string)
+		entry   string
+		mapName string
+	)
+	for _, line := range splitCommands(string(data)) {
+		args := tokenize(line)
+		if len(args) == 0 {
+			continue
+		}
+		switch cmd := strings.ToLower(args[0]); cmd {
+		case "set", "seta", "sets", "setu":
+			if len(args) < 3 {
+				cmds = append(cmds, command{line: line})
+				continue
+			}
+			name, value := args[1], strings.Join(args[2:], " ")
+			if f, ok := fields[strings.ToLower(name)]; ok {
+				if err := fromString(f.name, f.v, value); err != nil {
+					return nil, fmt.Errorf("%s: %w", line, err)
+				}
+				continue
+			}
+			vars[name] = value
+			cmds = append(cmds, command{line: line, set: name, value: value})
+		case "vstr":
+			if len(args) != 2 {
+				return nil, fmt.Errorf("%s: expected variable name", line)
+			}
+			entry, mapName = args[1], ""
+		case "map", "devmap":
+			if len(args) != 2 {
+				return nil, fmt.Errorf("%s: expected map name", line)
+			}
+			entry, mapName = "", args[1]
+		default:
+			// Cvars can also be set by using their name as a command.
+			if f, ok := fields[cmd]; ok && len(args) == 2 {
+				if err := fromString(f.name, f.v, args[1]); err != nil {
+					return nil, fmt.Errorf("%s: %w", line, err)
+				}
+				continue
+			}
+			cmds = append(cmds, command{line: line})
+		}
+	}
+
+	consumed := map[string]bool{"nextmap": true}
+	switch {
+	case entry != "":
+		maps, visited, err := readRotation(entry, vars, cfg.GameConfig.GameType)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Maps = maps
+		for name := range visited {
+			consumed[name] = true
+		}
+	case mapName != "":
+		cfg.Maps = Maps{{Name: mapName, Type: cfg.GameConfig.GameType}}
+	}
+	for _, cmd := range cmds {
+		switch {
+		case consumed[cmd.set]:
+		case cmd.set != "":
+			if cfg.Cvars == nil {
+				cfg.Cvars = make(Cvars)
+			}
+			cfg.Cvars[cmd.set] = parseCvarValue(cmd.value)
+		default:
+			cfg.Commands = append(cfg.Commands, cmd.line)
+		}
+	}
+	return cfg, nil
+}
+
+// readRotation follows a chain of vstr variables, starting with entry, and
+// returns the maps that were loaded along the way. The game type and limits
+// carry over from one map to the next, the same as they would in-game.
+func readRotation(entry string, vars map[string]string, gt GameType) (Maps, map[string]bool, error) {
+	maps := Maps{}
+	visited := make(map[string]bool)
+	state := Map{Type: gt}
+	for cur := entry; cur != "" && !visited[cur]; {
+		value, ok := vars[cur]
+		if !ok {
+			return nil, nil, fmt.Errorf("vstr %s: variable is not set", cur)
+		}
+		visited[cur] = true
+		next := ""
+		for _, line := range splitCommands(value) {
+			args := tokenize(line)
+			if len(args) < 2 {
+				continue
+			}
+			name, value := strings.ToLower(args[0]), strings.Join(args[1:], " ")
+			switch name {
+			case "set", "seta", "sets", "setu":
+				if len(args) < 3 {
+					continue
+				}
+				name, value = strings.ToLower(args[1]), strings.Join(args[2:], " ")
+			}
+			var err error
+			switch name {
+			case "g_gametype":
+				err = fromString("GameType", reflect.ValueOf(&state.Type).Elem(), value)
+			case "fraglimit":
+				state.FragLimit, err = strconv.Atoi(value)
+			case "capturelimit":
+				state.CaptureLimit, err = strconv.Atoi(value)
+			case "timelimit":
+				var n int
+				n, err = strconv.Atoi(value)
+				state.TimeLimit = metav1.Duration{Duration: time.Duration(n) * time.Minute}
+			case "map", "devmap":
+				m := state
+				m.Name = value
+				if !m.Type.HasCaptureLimit() {
+					m.CaptureLimit = 0
+				}
+				maps = append(maps, m)
+			case "nextmap":
+				if args := tokenize(value); len(args) == 2 && strings.ToLower(args[0]) == "vstr" {
+					next = args[1]
+				}
+			case "vstr":
+				next = value
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("vstr %s: %s: %w", cur, line, err)
+			}
+		}
+		cur = next
+	}
+	return maps, visited, nil
+}
+
+type cvarField struct {
+	name string
+	v    reflect.Value
+}
+
+// cvarFields collects the settable fields of a config struct keyed by their
+// lowercased cvar name.
+func cvarFields(v reflect.Value, fields map[string]cvarField) {
+	for i := 0; i < v.NumField(); i++ {
+		fv, ft := v.Field(i), v.Type().Field(i)
+		if fv.Kind() == reflect.Struct && ft.Anonymous {
+			cvarFields(fv, fields)
+			continue
+		}
+		if tv, ok := ft.Tag.Lookup("name"); ok {
+			fields[strings.ToLower(tv)] = cvarField{name: ft.Name, v: fv}
+		}
+	}
+}
+
+// fromString is the inverse of toString.
+func fromString(name string, v reflect.Value, s string) error {
+	switch v.Interface().(type) {
+	case string:
+		v.SetString(s)
+	case int, GameType:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case bool:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(n != 0)
+	case metav1.Duration:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		unit := time.Second
+		if name == "TimeLimit" {
+			unit = time.Minute
+		}
+		v.Set(reflect.ValueOf(metav1.Duration{Duration: time.Duration(n) * unit}))
+	default:
+		return fmt.Errorf("received unknown type %T", v.Interface())
+	}
+	return nil
+}
+
+// splitCommands splits the contents of a cfg file into individual commands
+// the same way the Quake 3 command buffer does: on newlines and on semicolons
+// that are outside of quotes.
+func splitCommands(s string) []string {
+	var (
+		cmds   []string
+		quoted bool
+		start  int
+	)
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case ';':
+			if quoted {
+				continue
+			}
+			fallthrough
+		case '\n', '\r':
+			if cmd := strings.TrimSpace(s[start:i]); cmd != "" {
+				cmds = append(cmds, cmd)
+			}
+			quoted = false
+			start = i + 1
+		}
+	}
+	if cmd := strings.TrimSpace(s[start:]); cmd != "" {
+		cmds = append(cmds, cmd)
+	}
+	return cmds
+}
+
+// tokenize splits a single command into its arguments, stripping quotes and
+// any trailing // comment.
+func tokenize(s string) []string {
+	var args []string
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == ' ' || s[i] == '\t':
+			i++
+		case strings.HasPrefix(s[i:], "//"):
+			return args
+		case s[i] == '"':
+			end := strings.IndexByte(s[i+1:], '"')
+			if end < 0 {
+				return append(args, s[i+1:])
+			}
+			args = append(args, s[i+1:i+1+end])
+			i += end + 2
+		default:
+			end := strings.IndexAny(s[i:], " \t\"")
+			if end < 0 {
+				return append(args, s[i:])
+			}
+			args = append(args, s[i:i+end])
+			i += end
+		}
+	}
+	return args
+}
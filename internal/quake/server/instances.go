@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Instances describes several dedicated servers that are run by a single
+// process, e.g. a FFA server and a tourney server sharing the same assets.
+type Instances struct {
+	Servers []Instance `json:"servers"`
+}
+
+// Instance is a single dedicated server. The name is used in URL paths, so it
+// must be lowercase alphanumeric (and dashes).
+type Instance struct {
+	Name string `json:"name"`
+	Addr string `json:"addr"`
+
+	// ConfigFile is the server config file for this instance. Relative paths
+	// are relative to the directory containing the instances file.
+	ConfigFile string `json:"configFile"`
+
+	// Engine, Binary, Args and Env override the ones set on the command line
+	// for this instance, e.g. to try another engine on a single server.
+	Engine Engine   `json:"engine"`
+	Binary string   `json:"binary"`
+	Args   []string `json:"args"`
+	Env    []string `json:"env"`
+}
+
+var instanceNameRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// ReadInstancesFromFile reads and validates an instances file.
+func ReadInstancesFromFile(path string) (*Instances, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var inst Instances
+	if err := yaml.UnmarshalStrict(data, &inst); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := inst.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, s := range inst.Servers {
+		if s.ConfigFile != "" && !filepath.IsAbs(s.ConfigFile) {
+			inst.Servers[i].ConfigFile = filepath.Join(filepath.Dir(path), s.ConfigFile)
+		}
+	}
+	return &inst, nil
+}
+
+// Validate checks that there is at least one instance and that the instance
+// names and ports are unique.
+func (inst *Instances) Validate() error {
+	if len(inst.Servers) == 0 {
+		return errors.New("servers: must have at least one server")
+	}
+	names := make(map[string]bool)
+	ports := make(map[string]bool)
+	for i, s := range inst.Servers {
+		if !instanceNameRegexp.MatchString(s.Name) {
+			return fmt.Errorf("servers[%d].name: invalid name %q, must be lowercase alphanumeric", i, s.Name)
+		}
+		if names[s.Name] {
+			return fmt.Errorf("servers[%d].name: duplicate name %q", i, s.Name)
+		}
+		names[s.Name] = true
+
+		_, port, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return fmt.Errorf("servers[%d].addr: %w", i, err)
+		}
+		if s.Engine != "" {
+			if _, err := s.Engine.Profile(); err != nil {
+				return fmt.Errorf("servers[%d].engine: %w", i, err)
+			}
+		}
+		// Every instance binds the same interfaces, so only the port has to be
+		// unique.
+		if ports[port] {
+			return fmt.Errorf("servers[%d].addr: port %s is already used", i, port)
+		}
+		ports[port] = true
+	}
+	return nil
+}
+
+// Supervisor runs several dedicated servers until the context is cancelled.
+// A server that fails doesn't stop the others.
+type Supervisor struct {
+	Servers []*Server
+}
+
+// Start starts all servers and blocks until they have all returned. The
+// errors of every server are returned together. Servers that exit because
+// they are idle don't return an error.
+func (s *Supervisor) Start(ctx context.Context) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, srv := range s.Servers {
+		wg.Add(1)
+		go func(srv *Server) {
+			defer wg.Done()
+
+			err := srv.Start(ctx)
+			if errors.Is(err, ErrIdle) {
+				log.Printf("quakeserver %s: exited while idle\n", srv.Name)
+				return
+			}
+			if err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("quakeserver %s: %v\n", srv.Name, err)
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", srv.Name, err))
+				mu.Unlock()
+			}
+		}(srv)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// HardStop kills every server process.
+func (s *Supervisor) HardStop() {
+	for _, srv := range s.Servers {
+		srv.HardStop()
+	}
+}
+
+// Wake starts the named server again if it was stopped while idle, and
+// reports whether it is starting. Unknown names are ignored.
+func (s *Supervisor) Wake(name string) bool {
+	if srv := s.server(name); srv != nil {
+		return srv.Wake()
+	}
+	return false
+}
+
+// Stopped reports whether the named server was stopped while idle.
+func (s *Supervisor) Stopped(name string) bool {
+	if srv := s.server(name); srv != nil {
+		return srv.Stopped()
+	}
+	return false
+}
+
+func (s *Supervisor) server(name string) *Server {
+	for _, srv := range s.Servers {
+		if srv.Name == name {
+			return srv
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	quakenet "github.com/ChrisRx/quake-kube/pkg/quake/net"
+)
+
+// State is a step in the lifecycle of a game server.
+type State string
+
+const (
+	// StateDownloading is the initial state, while the assets are downloaded
+	// from the content server.
+	StateDownloading State = "downloading"
+
+	// StateStarting is set once the dedicated server process is started, until
+	// it responds to getstatus.
+	StateStarting State = "starting"
+
+	// StateReady means the dedicated server is accepting clients.
+	StateReady State = "ready"
+
+	// StateDegraded means the dedicated server stopped responding after it was
+	// ready, or that it failed.
+	StateDegraded State = "degraded"
+)
+
+// degradedAfter is the number of consecutive failed getstatus requests before
+// a ready server is considered degraded. A single dropped UDP packet shouldn't
+// be enough.
+const degradedAfter = 3
+
+// Lifecycle tracks the state of a game server, from downloading its assets
+// until it is ready for clients. The zero value is in StateDownloading.
+type Lifecycle struct {
+	mu     sync.RWMutex
+	state  State
+	err    error
+	failed bool
+}
+
+// State returns the current state, and the error that caused it to be
+// degraded, if any.
+func (l *Lifecycle) State() (State, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.state == "" {
+		return StateDownloading, nil
+	}
+	return l.state, l.err
+}
+
+// Ready reports whether the server is accepting clients.
+func (l *Lifecycle) Ready() bool {
+	state, _ := l.State()
+	return state == StateReady
+}
+
+// Set changes the state and clears any previous error.
+func (l *Lifecycle) Set(state State) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.set(state, nil)
+	l.failed = false
+}
+
+// Fail changes the state to degraded because of err. Unlike when a server
+// stops responding, the server doesn't become ready again by itself.
+func (l *Lifecycle) Fail(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.set(StateDegraded, err)
+	l.failed = true
+}
+
+func (l *Lifecycle) set(state State, err error) {
+	switch {
+	case err != nil:
+		log.Printf("lifecycle: %s: %v\n", state, err)
+	case l.state != state:
+		log.Printf("lifecycle: %s\n", state)
+	}
+	l.state, l.err = state, err
+}
+
+// Watch sends getstatus to the server at addr every interval until the
+// context is done. A starting server becomes ready once it responds, and a
+// ready server becomes degraded when it stops responding (and ready again if
+// it recovers). Watch doesn't change the state of a server that failed.
+func (l *Lifecycle) Watch(ctx context.Context, addr string, interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-tick.C:
+			_, err := quakenet.SendCommandWithTimeout(addr, quakenet.GetStatusCommand, interval)
+			l.mu.Lock()
+			switch {
+			case l.failed:
+			case err == nil:
+				failures = 0
+				if l.state == StateStarting || l.state == StateDegraded {
+					l.set(StateReady, nil)
+				}
+			case l.state == StateReady:
+				failures++
+				if failures >= degradedAfter {
+					l.set(StateDegraded, fmt.Errorf("getstatus: %w", err))
+				}
+			}
+			l.mu.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ChrisRx/quake-kube/pkg/quake/net/nettest"
+)
+
+func TestLifecycleWatch(t *testing.T) {
+	s := nettest.NewServer()
+	s.Handle("getstatus", []byte("\xff\xff\xff\xffstatusResponse\n\\mapname\\q3dm7\n"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var lc Lifecycle
+	if state, _ := lc.State(); state != StateDownloading {
+		t.Fatalf("expected initial state %q, received %q", StateDownloading, state)
+	}
+	lc.Set(StateStarting)
+	go lc.Watch(ctx, s.Addr, 20*time.Millisecond)
+
+	waitForState(t, &lc, StateReady)
+
+	// Once the server stops responding, it is degraded.
+	s.Close()
+	waitForState(t, &lc, StateDegraded)
+	if _, err := lc.State(); err == nil {
+		t.Fatal("expected degraded state to have an error")
+	}
+
+	lc.Fail(errors.New("ioq3ded exited"))
+	state, err := lc.State()
+	if state != StateDegraded || err == nil || err.Error() != "ioq3ded exited" {
+		t.Fatalf("expected failed state, received %q %v", state, err)
+	}
+}
+
+func waitForState(t *testing.T, lc *Lifecycle, expected State) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if state, _ := lc.State(); state == expected {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	state, err := lc.State()
+	t.Fatalf("expected state %q, received %q (%v)", expected, state, err)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// durationPattern matches the strings accepted by time.ParseDuration.
+const durationPattern = `^-?(0|(\d+(\.\d*)?|\.\d+)(ns|us|µs|ms|s|m|h))+$`
+
+var (
+	durationType = reflect.TypeOf(metav1.Duration{})
+	gameTypeType = reflect.TypeOf(GameType(0))
+	cvarType     = reflect.TypeOf(CvarValue{})
+)
+
+// Schema returns a JSON Schema describing the server config file.
+func Schema() ([]byte, error) {
+	s := schemaFor(reflect.TypeOf(Config{}))
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["title"] = "QuakeKube server config"
+	return json.MarshalIndent(s, "", "  ")
+}
+
+func schemaFor(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case durationType:
+		return map[string]any{
+			"type":        "string",
+			"pattern":     durationPattern,
+			"description": "A duration string, e.g. 15m or 1h30m",
+		}
+	case gameTypeType:
+		return map[string]any{
+			"type": "string",
+			"enum": gameTypeNames(),
+		}
+	case cvarType:
+		return map[string]any{
+			"type":        []string{"string", "integer", "boolean"},
+			"description": "A cvar value. Durations are tagged, e.g. !duration 2m, and set in seconds",
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		props := make(map[string]any)
+		schemaProperties(t, props)
+		return map[string]any{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{
+			"type":  "array",
+			"items": schemaFor(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]any{
+			"type":                 "object",
+			"additionalProperties": schemaFor(t.Elem()),
+		}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	default:
+		return map[string]any{}
+	}
+}
+
+// schemaProperties adds the JSON properties of a struct type to props, using
+// the same field naming rules as jsonFields.
+func schemaProperties(t reflect.Type, props map[string]any) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			schemaProperties(f.Type, props)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s := schemaFor(f.Type)
+		if cvar, ok := f.Tag.Lookup("name"); ok {
+			s["description"] = "Sets the " + cvar + " cvar"
+		}
+		props[name] = s
+	}
+}
@@ -2,13 +2,21 @@ package server
 
 import (
 	"context"
+	"crypto/rand"
 	_ "embed"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
+	osexec "os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hako/durafmt"
@@ -21,114 +29,250 @@ import (
 )
 
 var (
-	activePlayers = promauto.NewGauge(prometheus.GaugeOpts{
+	// The server label is the name of the server instance, which is empty
+	// when only a single server is running.
+	activePlayers = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "quake_active_players",
 		Help: "The current number of active players",
-	})
+	}, []string{"server"})
 
 	scores = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "quake_player_scores",
 		Help: "Current scores by player, by map",
-	}, []string{"player", "map"})
+	}, []string{"server", "player", "map"})
 
 	pings = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "quake_player_pings",
 		Help: "Current ping by player",
-	}, []string{"player"})
+	}, []string{"server", "player"})
 
-	configReloads = promauto.NewCounter(prometheus.CounterOpts{
+	configReloads = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "quake_config_reloads",
 		Help: "Config file reload count",
-	})
+	}, []string{"server"})
 )
 
 type Server struct {
+	// Name identifies the server when several servers share the same
+	// directory. It is optional for a single server.
+	Name string
+
 	Addr          string
 	ConfigFile    string
 	Dir           string
 	WatchInterval time.Duration
 	ShutdownDelay time.Duration
 
-	cmd *exec.Cmd
+	// Engine is the dedicated server engine, ioq3 by default.
+	Engine Engine
+
+	// Binary is the dedicated server binary, which defaults to the binary of
+	// the engine.
+	Binary string
+
+	// Args are extra arguments for the dedicated server, e.g. +set sv_fps 40,
+	// which come before the config is run.
+	Args []string
+
+	// Env are extra environment variables for the dedicated server, in the
+	// form KEY=value.
+	Env []string
+
+	// WorkDir is the working directory of the dedicated server, Dir by
+	// default.
+	WorkDir string
+
+	// BasePath is where the dedicated server looks for the game files, in
+	// addition to Dir, such as the installation directory of the engine.
+	BasePath string
+
+	// GenerateRconPassword replaces the default rcon password with a random
+	// one, when the config doesn't set one. It is written to a file in Dir,
+	// readable only by its owner, when the server starts.
+	GenerateRconPassword bool
+
+	// IdleTimeout is how long the server can run without any human players
+	// before the IdleAction is taken. Zero disables idle shutdown.
+	IdleTimeout time.Duration
+	IdleAction  IdleAction
+
+	// Sync is called with a changed config file before it is validated and
+	// reloaded, e.g. to download the maps added to the rotation. The first
+	// config is expected to be synced before Start.
+	Sync func(ctx context.Context, cfg *Config) error
+
+	// mu guards cmd, which is replaced while the server runs, and is also
+	// killed by HardStop from other goroutines.
+	mu           sync.Mutex
+	cmd          *exec.Cmd
+	profile      *EngineProfile
+	rconPassword string
+	state        atomic.Int32
+	wake         chan struct{}
+	config       atomic.Pointer[Config]
+	logs         *logWatcher
+}
+
+// IdleAction is what a server does after being idle for the IdleTimeout.
+type IdleAction string
+
+const (
+	// IdleStop stops the dedicated server process until it is woken up by
+	// calling Wake.
+	IdleStop IdleAction = "stop"
+
+	// IdleExit returns ErrIdle from Start, so that the whole process can exit.
+	IdleExit IdleAction = "exit"
+)
+
+// ErrIdle is returned by Start when the server exits after being idle.
+var ErrIdle = errors.New("server is idle")
+
+// statusInterval is how often the status of a running server is checked, for
+// the metrics and the idle timeout.
+var statusInterval = 5 * time.Second
+
+const (
+	// stateNew is the state of a server that wasn't started yet, and so isn't
+	// known to be starting.
+	stateNew int32 = iota
+	stateStarting
+	stateRunning
+	stateStopped
+)
+
+// Wake starts the server again if it was stopped while idle. It reports
+// whether the server is starting, in which case it can't accept clients yet.
+// A server is starting from Start, or Wake, until it responds to getstatus.
+func (s *Server) Wake() bool {
+	switch s.state.Load() {
+	case stateStopped:
+		if s.state.CompareAndSwap(stateStopped, stateStarting) {
+			s.wake <- struct{}{}
+		}
+		return true
+	case stateStarting:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stopped reports whether the server was stopped while idle.
+func (s *Server) Stopped() bool {
+	return s.state.Load() == stateStopped
 }
 
 func (s *Server) Start(ctx context.Context) error {
 	if s.Addr == "" {
 		s.Addr = "0.0.0.0:27960"
 	}
+	if s.Engine == "" {
+		s.Engine = EngineIOQ3
+	}
 	host, port, err := net.SplitHostPort(s.Addr)
 	if err != nil {
 		return err
 	}
-	args := []string{
-		"+set", "dedicated", "2",
-		"+set", "sv_master1", "", // master.ioquake3.org
-		"+set", "sv_master2", "", // master.quake3arena..com
-		"+set", "sv_master3", "", // localhost:27950
-		"+set", "net_ip", host,
-		"+set", "net_port", port,
-		"+set", "fs_homepath", s.Dir,
-		"+set", "com_basegame", "baseq3",
-		// This won't work with the q3demo pak files:
-		// "+set", "fs_game", "arena",
-		"+set", "com_gamename", "Quake3Arena",
-		"+exec", "server.cfg",
-	}
-	s.cmd = exec.CommandContext(context.Background(), "ioq3ded", args...)
-	s.cmd.Dir = s.Dir
-	s.cmd.Stdout = os.Stdout
-	s.cmd.Stderr = os.Stderr
-
-	if s.ConfigFile == "" {
-		cfg := Default()
-		data, err := cfg.Marshal()
+	if s.GenerateRconPassword {
+		s.rconPassword, err = randomPassword()
 		if err != nil {
 			return err
 		}
-		if err := os.WriteFile(filepath.Join(s.Dir, "baseq3/server.cfg"), data, 0644); err != nil {
+		// The password is written to a file, rather than logged, so that it
+		// can still be used to administer the server, since it changes with
+		// every start.
+		path := filepath.Join(s.Dir, s.rconPasswordName())
+		if err := writePasswordFile(path, s.rconPassword); err != nil {
 			return err
 		}
-		if err := s.cmd.Start(); err != nil {
-			return err
-		}
-		return s.cmd.Wait()
+		log.Printf("quakeserver: random rcon password written to %s, used when the config doesn't set one\n", path)
 	}
 
-	if err := s.reload(); err != nil {
-		return err
+	switch s.IdleAction {
+	case "", IdleStop, IdleExit:
+	default:
+		return fmt.Errorf("invalid idle action: %q", s.IdleAction)
 	}
-	if err := s.cmd.Start(); err != nil {
+	s.profile, err = s.Engine.Profile()
+	if err != nil {
 		return err
 	}
+	s.wake = make(chan struct{}, 1)
 
-	go func() {
-		if err := s.cmd.Wait(); err != nil {
-			log.Println(err)
+	s.state.Store(stateStarting)
+
+	s.logs = newLogWatcher()
+	recorder := &demoRecorder{s: s, addr: s.localAddr()}
+	go recorder.run(ctx, s.logs.ch)
+
+	// Without a config file, the server runs with the default config, which
+	// is never reloaded.
+	var cfg *Config
+	if s.ConfigFile == "" {
+		cfg = Default()
+		if err := s.setPasswords(cfg); err != nil {
+			return err
 		}
-	}()
+		if err := s.writeConfig(cfg); err != nil {
+			return err
+		}
+		s.config.Store(cfg)
+	} else {
+		cfg, err = s.reload()
+		if err != nil {
+			return err
+		}
+	}
+	if err := s.startCmd(s.command(host, port, cfg)); err != nil {
+		return err
+	}
 
+	idle := make(chan struct{}, 1)
 	go func() {
-		addr := s.Addr
-		if net.ParseIP(host).IsUnspecified() {
-			addr = net.JoinHostPort("127.0.0.1", port)
-		}
-		tick := time.NewTicker(5 * time.Second)
+		addr := s.localAddr()
+		tick := time.NewTicker(statusInterval)
 		defer tick.Stop()
 
+		lastActive := time.Now()
+		checkedProtocol := false
 		for {
 			select {
 			case <-tick.C:
+				if s.Stopped() {
+					lastActive = time.Now()
+					continue
+				}
 				status, err := quakenet.GetStatus(addr)
 				if err != nil {
 					log.Printf("metrics: get status failed %v", err)
 					continue
 				}
-				activePlayers.Set(float64(len(status.Players)))
+				s.state.CompareAndSwap(stateStarting, stateRunning)
+				// A different protocol usually means that the binary isn't the
+				// engine it is configured as.
+				if p := status.Configuration["protocol"]; !checkedProtocol && p != "" {
+					checkedProtocol = true
+					if p != strconv.Itoa(s.profile.Protocol) {
+						log.Printf("quakeserver: server protocol is %s, but engine %s uses %d\n", p, s.Engine, s.profile.Protocol)
+					}
+				}
+				activePlayers.WithLabelValues(s.Name).Set(float64(len(status.Players)))
 				for _, p := range status.Players {
 					if mapname, ok := status.Configuration["mapname"]; ok {
-						scores.WithLabelValues(p.Name, mapname).Set(float64(p.Score))
+						scores.WithLabelValues(s.Name, p.Name, mapname).Set(float64(p.Score))
+					}
+					pings.WithLabelValues(s.Name, p.Name).Set(float64(p.Ping))
+					if !p.IsBot() {
+						lastActive = time.Now()
+					}
+				}
+				if s.IdleTimeout > 0 && time.Since(lastActive) >= s.IdleTimeout {
+					lastActive = time.Now()
+					select {
+					case idle <- struct{}{}:
+					default:
 					}
-					pings.WithLabelValues(p.Name).Set(float64(p.Ping))
 				}
 			case <-ctx.Done():
 				return
@@ -136,34 +280,66 @@ func (s *Server) Start(ctx context.Context) error {
 		}
 	}()
 
-	ch, err := s.watch(ctx)
-	if err != nil {
-		return err
+	var ch <-chan struct{}
+	if s.ConfigFile != "" {
+		ch, err = s.watch(ctx)
+		if err != nil {
+			return err
+		}
 	}
 
 	defer func() {
-		if s.cmd.Process != nil {
-			if err := s.cmd.Process.Kill(); err != nil {
-				log.Printf("couldn't kill process: %v\n", err)
-			}
+		if !s.Stopped() {
+			s.kill()
 		}
 	}()
 
 	for {
 		select {
 		case <-ch:
-			if err := s.reload(); err != nil {
-				return err
+			s.sync(ctx)
+			// An invalid config is skipped rather than returned so that a bad
+			// edit doesn't take down a running server.
+			newCfg, err := s.reload()
+			if err != nil {
+				log.Printf("config: skipping reload: %v\n", err)
+				continue
+			}
+			cfg = newCfg
+			configReloads.WithLabelValues(s.Name).Inc()
+
+			// A stopped server picks up the new config when it is woken up.
+			if s.Stopped() {
+				continue
 			}
-			configReloads.Inc()
-			if err := s.cmd.Restart(ctx); err != nil {
+
+			if err := s.restartCmd(s.command(host, port, cfg)); err != nil {
 				return err
 			}
-			go func() {
-				if err := s.cmd.Wait(); err != nil {
-					log.Println(err)
+		case <-idle:
+			if s.IdleAction == IdleExit {
+				log.Printf("quakeserver: no players for %s, exiting\n", s.IdleTimeout)
+				return ErrIdle
+			}
+			log.Printf("quakeserver: no players for %s, stopping server\n", s.IdleTimeout)
+			s.state.Store(stateStopped)
+			activePlayers.WithLabelValues(s.Name).Set(0)
+			s.kill()
+		case <-s.wake:
+			log.Println("quakeserver: starting idle server")
+			// The config file may have changed while the server was stopped. If
+			// it is invalid, the server is started with the last valid config.
+			if s.ConfigFile != "" {
+				s.sync(ctx)
+				if newCfg, err := s.reload(); err != nil {
+					log.Printf("config: skipping reload: %v\n", err)
+				} else {
+					cfg = newCfg
 				}
-			}()
+			}
+			if err := s.startCmd(s.command(host, port, cfg)); err != nil {
+				return err
+			}
 		case <-ctx.Done():
 			s.GracefulStop()
 			return ctx.Err()
@@ -171,13 +347,15 @@ func (s *Server) Start(ctx context.Context) error {
 	}
 }
 
+// GracefulStop warns the players and kicks them once the ShutdownDelay is
+// over. The commands are sent with the rcon password of the config the
+// server is running with.
 func (s *Server) GracefulStop() {
-	if s.ShutdownDelay == 0 {
+	if s.ShutdownDelay == 0 || s.Stopped() {
 		return
 	}
-	cfg, err := ReadConfigFromFile(s.ConfigFile)
-	if err != nil {
-		log.Println(err)
+	cfg := s.config.Load()
+	if cfg == nil {
 		return
 	}
 	msg := fmt.Sprintf("say SERVER WILL BE SHUTTING DOWN IN %s", strings.ToUpper(durafmt.Parse(s.ShutdownDelay).String()))
@@ -197,8 +375,9 @@ func (s *Server) GracefulStop() {
 		select {
 		case <-ticker.C:
 			countdown--
-			if countdown == 0 {
-				return
+			// The players are only kicked once the delay is over.
+			if countdown <= 0 {
+				continue
 			}
 			if _, err := quakenet.SendServerCommand(s.Addr, cfg.ServerConfig.Password, fmt.Sprintf("say %d\n", countdown)); err != nil {
 				log.Printf("countdown: %v\n", err)
@@ -224,23 +403,217 @@ func (s *Server) GracefulStop() {
 }
 
 func (s *Server) HardStop() {
-	if s.cmd.Process != nil {
+	if !s.Stopped() {
+		s.kill()
+	}
+}
+
+// startCmd starts a new dedicated server process. The process is waited for
+// in the background, since it is only expected to exit when it is killed.
+func (s *Server) startCmd(cmd *exec.Cmd) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cmd = cmd
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go wait(cmd.Cmd)
+	return nil
+}
+
+// restartCmd replaces the dedicated server process with a new one, with the
+// arguments of cmd. The mod can change between reloads, so the arguments are
+// always recreated.
+func (s *Server) restartCmd(cmd *exec.Cmd) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cmd.Args = cmd.Args
+	// Like the first process, the new one isn't tied to the context, so that
+	// it is still running for GracefulStop.
+	if err := s.cmd.Restart(context.Background()); err != nil {
+		return err
+	}
+	go wait(s.cmd.Cmd)
+	return nil
+}
+
+// kill kills the dedicated server process, if it was started.
+func (s *Server) kill() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cmd != nil && s.cmd.Process != nil {
 		if err := s.cmd.Process.Kill(); err != nil {
 			log.Printf("couldn't kill process: %v\n", err)
 		}
 	}
 }
 
-func (s *Server) reload() error {
-	cfg, err := ReadConfigFromFile(s.ConfigFile)
+func wait(cmd *osexec.Cmd) {
+	if err := cmd.Wait(); err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *Server) command(host, port string, cfg *Config) *exec.Cmd {
+	args := []string{"+set", "dedicated", "2"}
+	for i := 1; i <= s.profile.Masters; i++ {
+		args = append(args, "+set", fmt.Sprintf("sv_master%d", i), "")
+	}
+	args = append(args,
+		"+set", "net_ip", host,
+		"+set", "net_port", port,
+		"+set", "fs_homepath", s.Dir,
+	)
+	if s.BasePath != "" {
+		args = append(args, "+set", "fs_basepath", s.BasePath)
+	}
+	if s.profile.BaseGameCvar != "" {
+		args = append(args, "+set", s.profile.BaseGameCvar, BaseGame)
+	}
+	// The fs_game cvar can only be set on the command line. Note that mods,
+	// including missionpack, won't work with only the q3demo pak files.
+	if cfg.FileServerConfig.Game != "" {
+		args = append(args, "+set", "fs_game", cfg.FileServerConfig.Game)
+	}
+	args = append(args, s.profile.Args...)
+	args = append(args, s.Args...)
+	args = append(args, "+exec", s.configName())
+
+	binary := s.Binary
+	if binary == "" {
+		binary = s.profile.Binary
+	}
+	cmd := exec.CommandContext(context.Background(), binary, args...)
+	cmd.Dir = s.Dir
+	if s.WorkDir != "" {
+		cmd.Dir = s.WorkDir
+	}
+	if len(s.Env) > 0 {
+		cmd.Env = append(os.Environ(), s.Env...)
+	}
+	cmd.Stdout = os.Stdout
+	if s.logs != nil {
+		cmd.Stdout = io.MultiWriter(os.Stdout, s.logs)
+	}
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+func (s *Server) reload() (*Config, error) {
+	cfg, err := ValidateConfigFile(s.ConfigFile, s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.setPasswords(cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.ConfigFile, err)
+	}
+	if err := s.writeConfig(cfg); err != nil {
+		return nil, err
+	}
+	s.config.Store(cfg)
+	return cfg, nil
+}
+
+// sync calls Sync with the config file. Errors are only logged, since the
+// config can still be valid, e.g. when the content server is unavailable but
+// the maps were downloaded before.
+func (s *Server) sync(ctx context.Context) {
+	if s.Sync == nil {
+		return
+	}
+	cfg, err := s.readConfig()
 	if err != nil {
+		return
+	}
+	if err := s.Sync(ctx, cfg); err != nil {
+		log.Printf("config: cannot sync assets: %v\n", err)
+	}
+}
+
+// localAddr returns the address used to send commands to the server, which
+// is the loopback address when the server listens on all addresses.
+func (s *Server) localAddr() string {
+	host, port, err := net.SplitHostPort(s.Addr)
+	if err != nil || !net.ParseIP(host).IsUnspecified() {
+		return s.Addr
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+// configName returns the name of the generated cfg file, which includes the
+// server name so that servers sharing a directory don't overwrite each other.
+func (s *Server) configName() string {
+	if s.Name == "" {
+		return "server.cfg"
+	}
+	return "server-" + s.Name + ".cfg"
+}
+
+// rconPasswordName returns the name of the file with the random rcon
+// password, which is hidden so that the content server never serves it.
+func (s *Server) rconPasswordName() string {
+	if s.Name == "" {
+		return ".rconpassword"
+	}
+	return ".rconpassword-" + s.Name
+}
+
+// writePasswordFile writes a password to a file that only its owner can
+// read, also when the file already exists.
+func writePasswordFile(path, password string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(password+"\n"), 0600); err != nil {
 		return err
 	}
+	return os.Chmod(path, 0600)
+}
+
+// writeConfig writes the server.cfg to the game directory of the selected
+// mod, so that it is found before any server.cfg in baseq3.
+func (s *Server) writeConfig(cfg *Config) error {
 	data, err := cfg.Marshal()
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filepath.Join(s.Dir, "baseq3/server.cfg"), data, 0644)
+	dir := filepath.Join(s.Dir, cfg.GameDir())
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, s.configName()), data, 0644)
+}
+
+// readConfig reads the config file the same way as reload, but without
+// validating it against the assets directory or reading the password files.
+func (s *Server) readConfig() (*Config, error) {
+	return ReadConfigFromFile(s.ConfigFile)
+}
+
+// setPasswords reads the password files of the config. When it doesn't set
+// an rcon password, the random one is used, or DefaultRconPassword.
+func (s *Server) setPasswords(cfg *Config) error {
+	if err := cfg.readPasswordFiles(); err != nil {
+		return err
+	}
+	if cfg.ServerConfig.Password == "" {
+		cfg.ServerConfig.Password = DefaultRconPassword
+		if s.rconPassword != "" {
+			cfg.ServerConfig.Password = s.rconPassword
+		}
+	}
+	return nil
+}
+
+func randomPassword() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
 
 func (s *Server) watch(ctx context.Context) (<-chan struct{}, error) {
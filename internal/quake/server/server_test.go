@@ -0,0 +1,491 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/ChrisRx/quake-kube/internal/quake/server/fake"
+	quakenet "github.com/ChrisRx/quake-kube/pkg/quake/net"
+)
+
+// fakeServerEnv is set when the test binary is run as the dedicated server.
+const fakeServerEnv = "QUAKE_KUBE_FAKE_IOQ3DED"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeServerEnv) != "" {
+		fake.Main()
+	}
+	os.Exit(m.Run())
+}
+
+func TestServerWake(t *testing.T) {
+	s := &Server{wake: make(chan struct{}, 1)}
+	if s.Wake() || s.Stopped() {
+		t.Fatal("expected server that wasn't started to be neither starting nor stopped")
+	}
+
+	s.state.Store(stateRunning)
+	if s.Wake() {
+		t.Fatal("expected running server to not be starting")
+	}
+
+	s.state.Store(stateStopped)
+	if !s.Stopped() {
+		t.Fatal("expected server to be stopped")
+	}
+	if !s.Wake() {
+		t.Fatal("expected stopped server to be starting after Wake")
+	}
+	select {
+	case <-s.wake:
+	default:
+		t.Fatal("expected Wake to signal the server to start")
+	}
+
+	// Waking a server that is already starting must not signal it again.
+	if !s.Wake() {
+		t.Fatal("expected server to still be starting")
+	}
+	select {
+	case <-s.wake:
+		t.Fatal("expected no signal for a server that is already starting")
+	default:
+	}
+}
+
+// TestServerIdleWakeHardStop checks that a server without a config file is
+// stopped while idle, and that it can be woken up while HardStop is called
+// from another goroutine. It is meant to be run with -race.
+func TestServerIdleWakeHardStop(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping end-to-end test in short mode")
+	}
+	defer func(d time.Duration) { statusInterval = d }(statusInterval)
+	statusInterval = 20 * time.Millisecond
+	t.Setenv(fakeServerEnv, "1")
+
+	s := &Server{
+		Addr:        freeAddr(t),
+		Dir:         t.TempDir(),
+		Binary:      os.Args[0],
+		IdleTimeout: 100 * time.Millisecond,
+		IdleAction:  IdleStop,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- s.Start(ctx) }()
+
+	// The fake server has no players, so it is stopped once it is running.
+	waitUntil := func(msg string, fn func() bool) {
+		t.Helper()
+
+		deadline := time.Now().Add(10 * time.Second)
+		for !fn() {
+			if time.Now().After(deadline) {
+				t.Fatal(msg)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitUntil("expected idle server to be stopped", s.Stopped)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 20; i++ {
+			s.HardStop()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	if !s.Wake() {
+		t.Fatal("expected stopped server to be starting after Wake")
+	}
+	<-done
+
+	// The process started by Wake was likely killed by HardStop, which is only
+	// meant to be called when exiting, so the server is only expected to stop.
+	cancel()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the server to stop")
+	}
+}
+
+func TestLogWatcher(t *testing.T) {
+	w := newLogWatcher()
+	// Lines can be split across writes.
+	for _, s := range []string{
+		"------ Server Initialization ------\nServer: q3dm7\n",
+		`InitGame: \sv_hostname\quakekube\g_gametype\0\map`,
+		"name\\q3dm7\n",
+		"Item: 2 weapon_rocketlauncher\n  3:25 Exit: Fraglimit hit.\n",
+		"ShutdownGame:\n",
+	} {
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(w.ch)
+
+	type event struct {
+		Name string
+		Info map[string]string
+	}
+	var events []event
+	for ev := range w.ch {
+		events = append(events, event{Name: ev.name, Info: parseInfoString(ev.args)})
+	}
+	expected := []event{
+		{Name: "InitGame", Info: map[string]string{"sv_hostname": "quakekube", "g_gametype": "0", "mapname": "q3dm7"}},
+		{Name: "Exit", Info: map[string]string{}},
+		{Name: "ShutdownGame", Info: map[string]string{}},
+	}
+	if diff := cmp.Diff(expected, events); diff != "" {
+		t.Errorf("server: after logWatcher.Write differs: (-want +got)\n%s", diff)
+	}
+}
+
+const e2eConfig = `
+server:
+  hostname: %s
+  password: secret
+maps:
+%s`
+
+const e2eScript = `
+players:
+- name: Sarge
+- name: player
+  score: 5
+  ping: 40
+transcript: %s
+`
+
+func TestServerCommand(t *testing.T) {
+	cases := []struct {
+		name     string
+		server   *Server
+		cfg      *Config
+		expected []string
+	}{
+		{
+			name:   "ioq3",
+			server: &Server{Engine: EngineIOQ3, Dir: "/assets"},
+			cfg:    &Config{},
+			expected: []string{
+				"ioq3ded",
+				"+set", "dedicated", "2",
+				"+set", "sv_master1", "",
+				"+set", "sv_master2", "",
+				"+set", "sv_master3", "",
+				"+set", "sv_master4", "",
+				"+set", "sv_master5", "",
+				"+set", "net_ip", "0.0.0.0",
+				"+set", "net_port", "27960",
+				"+set", "fs_homepath", "/assets",
+				"+set", "com_basegame", "baseq3",
+				"+set", "com_gamename", "Quake3Arena",
+				"+exec", "server.cfg",
+			},
+		},
+		{
+			name: "quake3e with mod and args",
+			server: &Server{
+				Name:     "ctf",
+				Engine:   EngineQuake3e,
+				Dir:      "/assets",
+				BasePath: "/opt/quake3e",
+				Args:     []string{"+set", "sv_fps", "40"},
+			},
+			cfg: &Config{FileServerConfig: FileServerConfig{Game: "osp"}},
+			expected: []string{
+				"quake3e.ded",
+				"+set", "dedicated", "2",
+				"+set", "sv_master1", "",
+				"+set", "sv_master2", "",
+				"+set", "sv_master3", "",
+				"+set", "sv_master4", "",
+				"+set", "sv_master5", "",
+				"+set", "net_ip", "0.0.0.0",
+				"+set", "net_port", "27960",
+				"+set", "fs_homepath", "/assets",
+				"+set", "fs_basepath", "/opt/quake3e",
+				"+set", "fs_basegame", "baseq3",
+				"+set", "fs_game", "osp",
+				"+set", "sv_fps", "40",
+				"+exec", "server-ctf.cfg",
+			},
+		},
+		{
+			name:   "cnq3 with binary",
+			server: &Server{Engine: EngineCNQ3, Dir: "/assets", Binary: "/usr/local/bin/cnq3"},
+			cfg:    &Config{},
+			expected: []string{
+				"/usr/local/bin/cnq3",
+				"+set", "dedicated", "2",
+				"+set", "sv_master1", "",
+				"+set", "sv_master2", "",
+				"+set", "sv_master3", "",
+				"+set", "net_ip", "0.0.0.0",
+				"+set", "net_port", "27960",
+				"+set", "fs_homepath", "/assets",
+				"+exec", "server.cfg",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var err error
+			c.server.profile, err = c.server.Engine.Profile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			cmd := c.server.command("0.0.0.0", "27960", c.cfg)
+			if diff := cmp.Diff(c.expected, cmd.Args); diff != "" {
+				t.Errorf("server: after command differs: (-want +got)\n%s", diff)
+			}
+			if cmd.Dir != c.server.Dir {
+				t.Errorf("expected working directory %q, received %q", c.server.Dir, cmd.Dir)
+			}
+		})
+	}
+
+	if _, err := Engine("quake2").Profile(); err == nil {
+		t.Fatal("expected error for unknown engine")
+	}
+}
+
+func TestServerEndToEnd(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping end-to-end test in short mode")
+	}
+	dir := t.TempDir()
+	writeTestPak(t, filepath.Join(dir, "baseq3", "maps.pk3"), "q3dm7", "q3dm17")
+	configFile := filepath.Join(dir, "config.yaml")
+	writeFile(t, configFile, fmt.Sprintf(e2eConfig, "first", "- name: q3dm7"))
+	transcript := filepath.Join(dir, "transcript")
+	scriptFile := filepath.Join(dir, "script.yaml")
+	writeFile(t, scriptFile, fmt.Sprintf(e2eScript, transcript))
+	t.Setenv(fakeServerEnv, "1")
+	t.Setenv(fake.EnvScript, scriptFile)
+
+	s := &Server{
+		Name:          "e2e",
+		Addr:          freeAddr(t),
+		ConfigFile:    configFile,
+		Dir:           dir,
+		WatchInterval: 50 * time.Millisecond,
+		ShutdownDelay: 3 * time.Second,
+		Binary:        os.Args[0],
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- s.Start(ctx) }()
+
+	waitForStatus(t, s.Addr, func(status *quakenet.StatusResponse) bool {
+		return status.Configuration["mapname"] == "q3dm7" && status.Configuration["sv_hostname"] == "first"
+	})
+
+	// Metrics are collected every few seconds.
+	deadline := time.Now().Add(10 * time.Second)
+	for testutil.ToFloat64(activePlayers.WithLabelValues("e2e")) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected active players metric to be 2")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if score := testutil.ToFloat64(scores.WithLabelValues("e2e", "player", "q3dm7")); score != 5 {
+		t.Errorf("expected score metric to be 5, received %v", score)
+	}
+
+	// Changing the config restarts the server with the new config.
+	writeFile(t, configFile, fmt.Sprintf(e2eConfig, "second", "- name: q3dm17"))
+	future := time.Now().Add(time.Second)
+	if err := os.Chtimes(configFile, future, future); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, s.Addr, func(status *quakenet.StatusResponse) bool {
+		return status.Configuration["mapname"] == "q3dm17" && status.Configuration["sv_hostname"] == "second"
+	})
+	if n := testutil.ToFloat64(configReloads.WithLabelValues("e2e")); n != 1 {
+		t.Errorf("expected 1 config reload, received %v", n)
+	}
+
+	// Stopping the server warns and then kicks the players, also after the
+	// server was restarted by a reload.
+	cancel()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the server to stop")
+	}
+	data, err := os.ReadFile(transcript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range []string{
+		"rcon secret say SERVER WILL BE SHUTTING DOWN IN 3 SECONDS",
+		"rcon secret say GOODBYE",
+		"rcon secret kick Sarge",
+		"rcon secret kick player",
+	} {
+		if !strings.Contains(string(data), cmd+"\n") {
+			t.Errorf("expected %q in transcript:\n%s", cmd, data)
+		}
+	}
+}
+
+func TestWritePasswordFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".rconpassword")
+	writeFile(t, path, "old")
+	if err := writePasswordFile(path, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("expected password file to be 0600, received %v", fi.Mode().Perm())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "secret\n" {
+		t.Errorf("expected password in file, received %q", data)
+	}
+}
+
+func waitForStatus(t *testing.T, addr string, fn func(*quakenet.StatusResponse) bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		status, err := quakenet.GetStatus(addr)
+		if err == nil && fn(status) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for server status")
+}
+
+// freeAddr returns a local address with a UDP port that is free, at least
+// for now.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().String()
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestPak writes a pk3 with maps that have no entities, which is
+// enough for the config to be valid.
+func writeTestPak(t *testing.T, path string, maps ...string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for _, name := range maps {
+		w, err := zw.Create("maps/" + name + ".bsp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The header is the magic, the version, and the offset and length of
+		// each lump, where the entities lump is empty.
+		hdr := make([]int32, 1+17*2)
+		hdr[0] = 46
+		hdr[1] = 8 + 17*8
+		w.Write([]byte("IBSP"))
+		if err := binary.Write(w, binary.LittleEndian, hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path, b.String())
+}
+
+func TestServerSetPasswords(t *testing.T) {
+	dir := t.TempDir()
+	rconFile := filepath.Join(dir, "rcon-password")
+	writeFile(t, rconFile, " fromfile\n")
+
+	for _, tc := range []struct {
+		name     string
+		input    string
+		random   string
+		expected string
+	}{
+		{"default", "{}", "", DefaultRconPassword},
+		{"random", "{}", "random", "random"},
+		{"explicit default", "server:\n  password: changeme\n", "random", "changeme"},
+		{"explicit", "server:\n  password: secret\n", "random", "secret"},
+		{"file", fmt.Sprintf("server:\n  password: secret\n  rconPasswordFile: %s\n", rconFile), "random", "fromfile"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := ParseConfig([]byte(tc.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &Server{rconPassword: tc.random}
+			if err := s.setPasswords(cfg); err != nil {
+				t.Fatal(err)
+			}
+			if cfg.ServerConfig.Password != tc.expected {
+				t.Errorf("expected rcon password %q, received %q", tc.expected, cfg.ServerConfig.Password)
+			}
+		})
+	}
+
+	// Missing password files only fail in the server, so that the config can
+	// be checked without them.
+	cfg, err := ParseConfig([]byte("game:\n  passwordFile: " + filepath.Join(dir, "missing") + "\n"))
+	if err != nil {
+		t.Fatalf("expected config with a missing password file to parse, received %v", err)
+	}
+	if err := (&Server{}).setPasswords(cfg); err == nil || !strings.Contains(err.Error(), "game.passwordFile") {
+		t.Errorf("expected game.passwordFile error, received %v", err)
+	}
+}
@@ -0,0 +1,348 @@
+package server
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+	yamlv3 "sigs.k8s.io/yaml/goyaml.v3"
+
+	contentutil "github.com/ChrisRx/quake-kube/internal/quake/content/util"
+)
+
+// ValidationError is a single problem found in a server config. Line is the
+// line number of the offending field in the config file, or 0 if unknown.
+type ValidationError struct {
+	Line  int
+	Field string
+	Msg   string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", e.Line, e.Field, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Msg)
+}
+
+// ValidationErrors is the list of all problems found in a server config.
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (errs *ValidationErrors) add(field, format string, args ...any) {
+	*errs = append(*errs, &ValidationError{Field: field, Msg: fmt.Sprintf(format, args...)})
+}
+
+// ParseConfig strictly decodes a YAML server config. Unknown or duplicate
+// fields are returned as ValidationErrors annotated with line numbers.
+// References to environment variables (${VAR}) in values are expanded before
+// decoding, and password files are read after.
+func ParseConfig(data []byte) (*Config, error) {
+	cfg, _, err := parseConfig(data)
+	return cfg, err
+}
+
+func parseConfig(data []byte) (*Config, lineIndex, error) {
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+	if err := expandEnv(&doc); err != nil {
+		return nil, nil, err
+	}
+	lines := make(lineIndex)
+	var errs ValidationErrors
+	walkConfigNode(reflect.TypeOf(Config{}), &doc, "", lines, &errs)
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+	// The config is decoded from the expanded document, since the decoding
+	// of durations and quantities relies on their JSON unmarshalers.
+	if doc.Kind != 0 {
+		var err error
+		data, err = yamlv3.Marshal(&doc)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	cfg := Default()
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, nil, err
+	}
+	return cfg, lines, nil
+}
+
+// ValidateConfigFile reads the config file at path and checks it for both
+// decoding and semantic errors. Maps are checked against the contents of
+// assetsDir, unless assetsDir is empty.
+func ValidateConfigFile(path, assetsDir string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, lines, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := cfg.Validate(assetsDir); err != nil {
+		var errs ValidationErrors
+		if errors.As(err, &errs) {
+			for _, err := range errs {
+				err.Line = lines.lookup(err.Field)
+			}
+		}
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate performs semantic checks on the config. Maps are checked against
+// the contents of assetsDir, unless assetsDir is empty.
+func (c *Config) Validate(assetsDir string) error {
+	var errs ValidationErrors
+	nonNegative := func(field string, negative bool, v any) {
+		if negative {
+			errs.add(field, "must be non-negative, received %v", v)
+		}
+	}
+	nonNegative("fragLimit", c.FragLimit < 0, c.FragLimit)
+	nonNegative("timeLimit", c.TimeLimit.Duration < 0, c.TimeLimit.Duration)
+	nonNegative("bot.minPlayers", c.BotConfig.MinPlayers < 0, c.BotConfig.MinPlayers)
+	nonNegative("game.inactivity", c.GameConfig.Inactivity.Duration < 0, c.GameConfig.Inactivity.Duration)
+	nonNegative("game.quadFactor", c.GameConfig.QuadFactor < 0, c.GameConfig.QuadFactor)
+	nonNegative("game.weaponRespawn", c.GameConfig.WeaponRespawn < 0, c.GameConfig.WeaponRespawn)
+	nonNegative("server.maxClients", c.ServerConfig.MaxClients < 0, c.ServerConfig.MaxClients)
+	nonNegative("demos.maxAge", c.Demos.MaxAge.Duration < 0, c.Demos.MaxAge.Duration)
+	nonNegative("demos.maxCount", c.Demos.MaxCount < 0, c.Demos.MaxCount)
+	if c.Demos.Enabled && (c.Demos.RecordCommand == "" || c.Demos.StopCommand == "") {
+		errs.add("demos", "recordCommand and stopCommand are required to record demos")
+	}
+	if c.GameConfig.GameType.String() == "Unknown" {
+		errs.add("game.type", "unknown game type %d", c.GameConfig.GameType)
+	}
+	if c.GameConfig.GameType.IsTeamArena() && c.FileServerConfig.Game == "" {
+		errs.add("game.type", "game type %s requires fs.game to be set, e.g. missionpack", c.GameConfig.GameType)
+	}
+	if len(c.Maps) == 0 {
+		errs.add("maps", "at least one map is required")
+	}
+	validateCvars("cvars", c.Cvars, &errs)
+
+	var available map[string]*contentutil.Map
+	if assetsDir != "" {
+		maps, err := contentutil.ReadMaps(assetsDir)
+		if err != nil {
+			return err
+		}
+		// Only maps from the base game and the selected mod are in the search
+		// path of the server.
+		available = make(map[string]*contentutil.Map)
+		for _, m := range contentutil.FilterGameMaps(maps, c.GameDir()) {
+			available[strings.ToLower(m.Name)] = m
+		}
+	}
+	for i, m := range c.Maps {
+		field := fmt.Sprintf("maps[%d]", i)
+		nonNegative(field+".captureLimit", m.CaptureLimit < 0, m.CaptureLimit)
+		nonNegative(field+".fragLimit", m.FragLimit < 0, m.FragLimit)
+		nonNegative(field+".timeLimit", m.TimeLimit.Duration < 0, m.TimeLimit.Duration)
+		if m.Type.String() == "Unknown" {
+			errs.add(field+".type", "unknown game type %d", m.Type)
+		}
+		if m.Type.IsTeamArena() && c.FileServerConfig.Game == "" {
+			errs.add(field+".type", "game type %s requires fs.game to be set, e.g. missionpack", m.Type)
+		}
+		validateCvars(field+".cvars", m.Cvars, &errs)
+		for _, name := range m.Cvars.names() {
+			if s := m.Cvars[name].String(); strings.ContainsAny(s, "\";\n") {
+				errs.add(field+".cvars."+name, "map cvar values cannot contain quotes or semicolons")
+			}
+		}
+		if m.Name == "" {
+			errs.add(field+".name", "map name is required")
+			continue
+		}
+		if available == nil {
+			continue
+		}
+		am, ok := available[strings.ToLower(m.Name)]
+		if !ok {
+			errs.add(field+".name", "map %q not found in %s for game %s", m.Name, assetsDir, c.GameDir())
+			continue
+		}
+		// Maps whose entities couldn't be read might still support the game
+		// type, so they are only checked when the entities are known.
+		if entities := requiredEntities[m.Type]; !am.UnknownEntities && !am.HasEntities(entities...) {
+			errs.add(field+".type", "map %q does not support %s, it requires entities: %s", m.Name, m.Type, strings.Join(entities, ", "))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// requiredEntities are the map entities needed to play each of the objective
+// based game types.
+var requiredEntities = map[GameType][]string{
+	CaptureTheFlag: {"team_CTF_redflag", "team_CTF_blueflag"},
+	OneFlagCTF:     {"team_CTF_redflag", "team_CTF_blueflag", "team_CTF_neutralflag"},
+	Overload:       {"team_redobelisk", "team_blueobelisk"},
+	Harvester:      {"team_redobelisk", "team_blueobelisk", "team_neutralobelisk"},
+}
+
+func validateCvars(field string, cvars Cvars, errs *ValidationErrors) {
+	for _, name := range cvars.names() {
+		if name == "" || strings.ContainsAny(name, " \t\n\";") {
+			errs.add(field+"."+name, "invalid cvar name %q", name)
+		}
+	}
+}
+
+// lineIndex maps lowercased config field paths (e.g. maps[2].type) to the
+// line they were defined on.
+type lineIndex map[string]int
+
+func (idx lineIndex) lookup(field string) int {
+	for field != "" {
+		if line, ok := idx[strings.ToLower(field)]; ok {
+			return line
+		}
+		// Fall back to the closest parent field that was defined, since
+		// defaulted fields won't appear in the config file.
+		i := strings.LastIndexAny(field, ".[")
+		if i < 0 {
+			break
+		}
+		field = field[:i]
+	}
+	return 0
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// walkConfigNode walks the YAML node tree alongside the Go type it will be
+// decoded into, recording the line of each field and reporting any fields
+// that don't exist in the Go type. Field names follow the same rules as
+// encoding/json, which is what sigs.k8s.io/yaml ultimately decodes with.
+func walkConfigNode(t reflect.Type, n *yamlv3.Node, path string, lines lineIndex, errs *ValidationErrors) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch n.Kind {
+	case yamlv3.DocumentNode:
+		for _, c := range n.Content {
+			walkConfigNode(t, c, path, lines, errs)
+		}
+		return
+	case yamlv3.AliasNode:
+		walkConfigNode(t, n.Alias, path, lines, errs)
+		return
+	}
+	// Cvar durations are converted to seconds, since the tag is lost when
+	// the config is decoded.
+	if t == cvarType && n.Kind == yamlv3.ScalarNode && n.Tag == durationTag {
+		value, err := parseDurationCvar(n.Value)
+		if err != nil {
+			*errs = append(*errs, &ValidationError{Line: n.Line, Field: path, Msg: err.Error()})
+			return
+		}
+		n.Value, n.Tag, n.Style = value, "!!int", 0
+		return
+	}
+	if reflect.PointerTo(t).Implements(jsonUnmarshalerType) || reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if n.Kind != yamlv3.MappingNode {
+			return
+		}
+		fields := jsonFields(t)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			field := joinField(path, k.Value)
+			f, ok := fields[strings.ToLower(k.Value)]
+			if !ok {
+				*errs = append(*errs, &ValidationError{Line: k.Line, Field: field, Msg: "unknown field"})
+				continue
+			}
+			if _, ok := lines[strings.ToLower(field)]; ok {
+				*errs = append(*errs, &ValidationError{Line: k.Line, Field: field, Msg: "duplicate field"})
+				continue
+			}
+			lines[strings.ToLower(field)] = k.Line
+			walkConfigNode(f, v, field, lines, errs)
+		}
+	case reflect.Map:
+		if n.Kind != yamlv3.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			field := joinField(path, k.Value)
+			lines[strings.ToLower(field)] = k.Line
+			walkConfigNode(t.Elem(), v, field, lines, errs)
+		}
+	case reflect.Slice, reflect.Array:
+		if n.Kind != yamlv3.SequenceNode {
+			return
+		}
+		for i, v := range n.Content {
+			field := fmt.Sprintf("%s[%d]", path, i)
+			lines[strings.ToLower(field)] = v.Line
+			walkConfigNode(t.Elem(), v, field, lines, errs)
+		}
+	}
+}
+
+// jsonFields returns the lowercased JSON field names of a struct type mapped
+// to their types, with embedded untagged structs flattened.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			for k, v := range jsonFields(f.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[strings.ToLower(name)] = f.Type
+	}
+	return fields
+}
+
+func joinField(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,67 @@
+package exec
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SplitArgs splits a command line into arguments like a shell does, without
+// any expansion. Arguments are separated by whitespace, unless it is quoted
+// with single or double quotes, or escaped with a backslash. Within double
+// quotes, only \" and \\ are escapes.
+func SplitArgs(s string) ([]string, error) {
+	var (
+		args  []string
+		b     strings.Builder
+		inArg bool
+		quote rune
+	)
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+				continue
+			}
+			b.WriteRune(r)
+		case quote == '"':
+			switch {
+			case r == '"':
+				quote = 0
+			case r == '\\' && i+1 < len(runes) && (runes[i+1] == '"' || runes[i+1] == '\\'):
+				i++
+				b.WriteRune(runes[i])
+			default:
+				b.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == '\\':
+			if i+1 == len(runes) {
+				return nil, fmt.Errorf("cannot split %q: trailing backslash", s)
+			}
+			i++
+			b.WriteRune(runes[i])
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, b.String())
+				b.Reset()
+				inArg = false
+			}
+		default:
+			b.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("cannot split %q: unterminated %c quote", s, quote)
+	}
+	if inArg {
+		args = append(args, b.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,40 @@
+package exec
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSplitArgs(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected []string
+		err      bool
+	}{
+		{input: "", expected: nil},
+		{input: "  +set sv_fps 40 ", expected: []string{"+set", "sv_fps", "40"}},
+		{input: `+set sv_hostname "Quake Kube"`, expected: []string{"+set", "sv_hostname", "Quake Kube"}},
+		{input: `+set g_motd 'say "hi"'`, expected: []string{"+set", "g_motd", `say "hi"`}},
+		{input: `+set g_motd "say \"hi\" \n"`, expected: []string{"+set", "g_motd", `say "hi" \n`}},
+		{input: `+set sv_hostname Quake\ Kube ""`, expected: []string{"+set", "sv_hostname", "Quake Kube", ""}},
+		{input: `+set sv_hostname "Quake Kube`, err: true},
+		{input: `+set sv_hostname \`, err: true},
+	}
+	for _, c := range cases {
+		args, err := SplitArgs(c.input)
+		if c.err {
+			if err == nil {
+				t.Errorf("%q: expected error", c.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", c.input, err)
+			continue
+		}
+		if diff := cmp.Diff(c.expected, args); diff != "" {
+			t.Errorf("exec: after SplitArgs of %q differs: (-want +got)\n%s", c.input, diff)
+		}
+	}
+}
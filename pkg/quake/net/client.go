@@ -0,0 +1,957 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ProtocolVersion is the protocol of ioquake3 servers.
+	ProtocolVersion = 71
+
+	// LegacyProtocolVersion is the protocol of Quake 3 1.32 servers, which
+	// ioquake3 servers also accept.
+	LegacyProtocolVersion = 68
+
+	// GameName is sent with getchallenge, and must match the server.
+	GameName = "Quake3Arena"
+)
+
+// Server to client operations.
+const (
+	svcBad = iota
+	svcNop
+	svcGamestate
+	svcConfigstring
+	svcBaseline
+	svcServerCommand
+	svcDownload
+	svcSnapshot
+	svcEOF
+)
+
+// Client to server operations.
+const (
+	clcBad = iota
+	clcNop
+	clcMove
+	clcMoveNoDelta
+	clcClientCommand
+	clcEOF
+)
+
+const (
+	maxReliableCommands = 64
+	maxConfigStrings    = 1024
+	maxPacketUserCmds   = 32
+
+	// packetBackup is the number of snapshots and sent packets that are kept,
+	// for delta compression and to compute the ping.
+	packetBackup = 32
+
+	csServerInfo = 0
+	csSystemInfo = 1
+
+	// resendInterval is how often getchallenge and connect are resent until
+	// the server responds.
+	resendInterval = time.Second
+)
+
+// ErrDisconnected is returned when the server drops the client.
+var ErrDisconnected = errors.New("disconnected by server")
+
+// ClientConfig configures a Client.
+type ClientConfig struct {
+	Name     string
+	Password string
+
+	// Userinfo is added to the userinfo sent with connect, and can override
+	// the defaults, e.g. the model.
+	Userinfo map[string]string
+
+	// Paks are used to answer pure servers (sv_pure 1). A pure server drops
+	// clients without its pk3 files.
+	Paks []*Pak
+
+	// PacketRate is the number of packets sent per second. The default is 30,
+	// the default cl_maxpackets.
+	PacketRate int
+
+	// QPort identifies the client when several clients share an address. The
+	// default is random.
+	QPort int
+
+	// OnServerCommand, if set, is called with every reliable command sent by
+	// the server, e.g. print and chat commands.
+	OnServerCommand func(cmd string)
+}
+
+type clientState int
+
+const (
+	clientChallenging clientState = iota
+	clientConnecting
+	clientConnected
+	clientPrimed
+	clientActive
+)
+
+// GameState is the state of the game that is sent when a client connects,
+// and again when the map changes.
+type GameState struct {
+	ConfigStrings map[int]string
+	Baselines     map[int]EntityState
+	ClientNum     int
+	ChecksumFeed  int32
+}
+
+// ServerInfo returns the server info config string, which has the same
+// values as getinfo, or nil if it is malformed.
+func (gs *GameState) ServerInfo() map[string]string {
+	m, _ := parseInfoString([]byte(gs.ConfigStrings[csServerInfo]))
+	return m
+}
+
+// SystemInfo returns the system info config string, e.g. sv_serverid and
+// sv_paks, or nil if it is malformed.
+func (gs *GameState) SystemInfo() map[string]string {
+	m, _ := parseInfoString([]byte(gs.ConfigStrings[csSystemInfo]))
+	return m
+}
+
+// Snapshot is the state of the game sent to the client every server frame.
+type Snapshot struct {
+	MessageNum  int32
+	ServerTime  int32
+	Flags       int
+	AreaMask    []byte
+	PlayerState PlayerState
+	Entities    []EntityState
+}
+
+// ClientStats are counters of a client connection.
+type ClientStats struct {
+	PacketsSent     int
+	PacketsReceived int
+
+	// PacketsDropped is the number of packets from the server that never
+	// arrived, which is known from gaps in the sequence numbers.
+	PacketsDropped int
+
+	Snapshots int
+
+	// Ping is the time it took for the last acknowledged user command to be
+	// reflected in a snapshot.
+	Ping time.Duration
+}
+
+type outPacket struct {
+	time       time.Time
+	serverTime int32
+	cmdNumber  int
+}
+
+// Client is a headless Quake 3 client. It connects to a server, takes part in
+// the game (standing still, unless given other commands with SetCommand),
+// and keeps the connection alive. The connection must preserve packet
+// boundaries, e.g. a connected UDP socket.
+type Client struct {
+	cfg  ClientConfig
+	conn io.ReadWriteCloser
+
+	readOnce  sync.Once
+	packets   chan []byte
+	readErr   chan error
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	mu    sync.Mutex
+	state clientState
+	stats ClientStats
+
+	// serverMessage is the last message printed by the server while
+	// connecting, which is usually why it was rejected.
+	serverMessage string
+
+	clientChallenge int32
+	challenge       int32
+	legacy          bool
+	lastSend        time.Time
+	chan_           *netchan
+
+	gs       *GameState
+	serverID int32
+
+	serverMessageSequence int32
+	serverCommandSequence int32
+	serverCommands        [maxReliableCommands]string
+	bigConfigString       string
+
+	reliableSequence    int32
+	reliableAcknowledge int32
+	reliableCommands    [maxReliableCommands]string
+
+	snapshots [packetBackup]*Snapshot
+	snap      *Snapshot
+	snapTime  time.Time
+
+	cmd        UserCmd
+	cmds       [maxPacketUserCmds]UserCmd
+	cmdNumber  int
+	outPackets [packetBackup]outPacket
+}
+
+// NewClient returns a client that connects over conn.
+func NewClient(conn io.ReadWriteCloser, cfg *ClientConfig) *Client {
+	c := &Client{
+		conn:            conn,
+		packets:         make(chan []byte, 64),
+		readErr:         make(chan error, 1),
+		closed:          make(chan struct{}),
+		clientChallenge: rand.Int31(),
+	}
+	if cfg != nil {
+		c.cfg = *cfg
+	}
+	if c.cfg.Name == "" {
+		c.cfg.Name = "UnnamedPlayer"
+	}
+	if c.cfg.PacketRate <= 0 {
+		c.cfg.PacketRate = 30
+	}
+	if c.cfg.QPort == 0 {
+		c.cfg.QPort = rand.Intn(0xffff) + 1
+	}
+	return c
+}
+
+// DialClient returns a client that connects to the server at addr over UDP.
+func DialClient(addr string, cfg *ClientConfig) (*Client, error) {
+	raddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp4", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn, cfg), nil
+}
+
+// Connect connects to the server, and returns once the client has entered
+// the game. It keeps retrying until the context is done.
+func (c *Client) Connect(ctx context.Context) error {
+	c.readOnce.Do(func() { go c.read() })
+	err := c.run(ctx, func() bool { return c.state == clientActive })
+	if err == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.serverMessage != "" {
+		return fmt.Errorf("connect: %w: server: %s", err, c.serverMessage)
+	}
+	return fmt.Errorf("connect: %w", err)
+}
+
+// Run keeps the client connected until the context is done, and then
+// disconnects. It returns ErrDisconnected (wrapped) if the server drops the
+// client.
+func (c *Client) Run(ctx context.Context) error {
+	c.readOnce.Do(func() { go c.read() })
+	err := c.run(ctx, func() bool { return false })
+	if ctx.Err() != nil {
+		c.disconnect()
+	}
+	return err
+}
+
+// Close closes the connection. It doesn't tell the server, which will
+// eventually time the client out.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.conn.Close()
+}
+
+// SetCommand sets the command sent for every following client frame. The
+// server time is set by the client.
+func (c *Client) SetCommand(cmd UserCmd) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cmd = cmd
+}
+
+// GameState returns the last game state, or nil before it is received.
+func (c *Client) GameState() *GameState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gs
+}
+
+// Snapshot returns the last snapshot, or nil before the first one.
+func (c *Client) Snapshot() *Snapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.snap
+}
+
+func (c *Client) Stats() ClientStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+func (c *Client) read() {
+	for {
+		buf := make([]byte, MaxMessageLength)
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			c.readErr <- err
+			return
+		}
+		select {
+		case c.packets <- buf[:n]:
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (c *Client) run(ctx context.Context, done func() bool) error {
+	tick := time.NewTicker(time.Second / time.Duration(c.cfg.PacketRate))
+	defer tick.Stop()
+
+	c.mu.Lock()
+	err := c.frame()
+	c.mu.Unlock()
+	for err == nil {
+		c.mu.Lock()
+		finished := done()
+		c.mu.Unlock()
+		if finished {
+			return nil
+		}
+		select {
+		case p := <-c.packets:
+			c.mu.Lock()
+			err = c.packet(p)
+			c.mu.Unlock()
+		case <-tick.C:
+			c.mu.Lock()
+			err = c.frame()
+			c.mu.Unlock()
+		case err = <-c.readErr:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+func (c *Client) send(data []byte) error {
+	c.stats.PacketsSent++
+	_, err := c.conn.Write(data)
+	return err
+}
+
+func (c *Client) sendOutOfBand(data []byte) error {
+	return c.send(append([]byte(OutOfBandHeader), data...))
+}
+
+// frame sends the handshake packets, or a packet with the next user command.
+func (c *Client) frame() error {
+	now := time.Now()
+	switch c.state {
+	case clientChallenging:
+		if now.Sub(c.lastSend) < resendInterval {
+			return nil
+		}
+		c.lastSend = now
+		return c.sendOutOfBand([]byte(fmt.Sprintf("getchallenge %d %s", c.clientChallenge, GameName)))
+	case clientConnecting:
+		if now.Sub(c.lastSend) < resendInterval {
+			return nil
+		}
+		c.lastSend = now
+		// Everything after "connect " is compressed.
+		data := fmt.Sprintf("connect \"%s\"", c.userinfo())
+		return c.sendOutOfBand(append([]byte(data[:8]), huffCompress([]byte(data[8:]))...))
+	}
+	return c.writePacket()
+}
+
+func (c *Client) userinfo() string {
+	protocol := ProtocolVersion
+	if c.legacy {
+		protocol = LegacyProtocolVersion
+	}
+	info := map[string]string{
+		"name":      c.cfg.Name,
+		"rate":      "25000",
+		"snaps":     "20",
+		"model":     "sarge",
+		"headmodel": "sarge",
+		"handicap":  "100",
+		"sex":       "male",
+		"color1":    "4",
+		"color2":    "5",
+	}
+	if c.cfg.Password != "" {
+		info["password"] = c.cfg.Password
+	}
+	for k, v := range c.cfg.Userinfo {
+		info[k] = v
+	}
+	info["protocol"] = strconv.Itoa(protocol)
+	info["qport"] = strconv.Itoa(c.cfg.QPort)
+	info["challenge"] = strconv.Itoa(int(c.challenge))
+
+	keys := make([]string, 0, len(info))
+	for k := range info {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "\\%s\\%s", k, info[k])
+	}
+	return b.String()
+}
+
+// addReliableCommand queues a command that is resent until the server
+// acknowledges it.
+func (c *Client) addReliableCommand(cmd string) error {
+	if c.reliableSequence-c.reliableAcknowledge >= maxReliableCommands {
+		return errors.New("client command overflow")
+	}
+	c.reliableSequence++
+	c.reliableCommands[c.reliableSequence&(maxReliableCommands-1)] = cmd
+	return nil
+}
+
+func (c *Client) writePacket() error {
+	m := &message{}
+	m.bitstream()
+	m.writeLong(c.serverID)
+	m.writeLong(c.serverMessageSequence)
+	m.writeLong(c.serverCommandSequence)
+	for i := c.reliableAcknowledge + 1; i <= c.reliableSequence; i++ {
+		m.writeByte(clcClientCommand)
+		m.writeLong(i)
+		m.writeString(c.reliableCommands[i&(maxReliableCommands-1)])
+	}
+
+	var serverTime int32
+	if c.state >= clientPrimed {
+		serverTime = c.createCommand()
+		// The commands of the previous packet are sent again, in case it
+		// was dropped.
+		prev := c.outPackets[(c.chan_.outgoingSequence-2)&(packetBackup-1)]
+		count := min(c.cmdNumber-prev.cmdNumber, maxPacketUserCmds)
+		if count < 1 {
+			count = 1
+		}
+		if c.snap != nil && c.snap.MessageNum == c.serverMessageSequence {
+			m.writeByte(clcMove)
+		} else {
+			m.writeByte(clcMoveNoDelta)
+		}
+		m.writeByte(count)
+		key := c.gs.ChecksumFeed ^ c.serverMessageSequence ^
+			hashKey(c.serverCommands[c.serverCommandSequence&(maxReliableCommands-1)], 32)
+		var from UserCmd
+		for i := 0; i < count; i++ {
+			to := c.cmds[(c.cmdNumber-count+i+1)&(maxPacketUserCmds-1)]
+			m.writeDeltaUserCmd(key, &from, &to)
+			from = to
+		}
+	}
+	m.writeByte(clcEOF)
+	if m.overflowed {
+		return errMessageOverflow
+	}
+	data := m.Bytes()
+	if c.legacy {
+		c.encode(data)
+	}
+
+	c.outPackets[c.chan_.outgoingSequence&(packetBackup-1)] = outPacket{
+		time:       time.Now(),
+		serverTime: serverTime,
+		cmdNumber:  c.cmdNumber,
+	}
+	packets, err := c.chan_.transmit(data)
+	if err != nil {
+		return err
+	}
+	for _, p := range packets {
+		if err := c.send(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createCommand adds a command for the current server time, which is
+// extrapolated from the last snapshot.
+func (c *Client) createCommand() int32 {
+	cmd := c.cmd
+	prev := c.cmds[c.cmdNumber&(maxPacketUserCmds-1)]
+	if c.snap != nil {
+		cmd.ServerTime = c.snap.ServerTime + int32(time.Since(c.snapTime)/time.Millisecond)
+	}
+	// The server ignores commands that are not newer than the last one.
+	if cmd.ServerTime <= prev.ServerTime {
+		cmd.ServerTime = prev.ServerTime + 1
+	}
+	c.cmdNumber++
+	c.cmds[c.cmdNumber&(maxPacketUserCmds-1)] = cmd
+	return cmd.ServerTime
+}
+
+func (c *Client) disconnect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state < clientConnected {
+		return
+	}
+	if err := c.addReliableCommand("disconnect"); err != nil {
+		return
+	}
+	// The command is sent several times, since nothing is resent after this.
+	for i := 0; i < 3; i++ {
+		if err := c.writePacket(); err != nil {
+			return
+		}
+	}
+}
+
+func (c *Client) packet(p []byte) error {
+	c.stats.PacketsReceived++
+	if bytes.HasPrefix(p, []byte(OutOfBandHeader)) {
+		return c.connectionlessPacket(p[len(OutOfBandHeader):])
+	}
+	if c.chan_ == nil || len(p) < 4 {
+		return nil
+	}
+	m, err := c.chan_.process(p)
+	if err != nil || m == nil {
+		// Out of order and spoofed packets are ignored.
+		return nil
+	}
+	c.stats.PacketsDropped += c.chan_.dropped
+	c.serverMessageSequence = readRawLong(m.b.data)
+	if c.legacy {
+		c.decode(m)
+	}
+	return c.parseServerMessage(m)
+}
+
+func (c *Client) connectionlessPacket(p []byte) error {
+	cmd, rest, _ := bytes.Cut(p, []byte("\n"))
+	args := tokenize(string(cmd))
+	if len(args) == 0 {
+		return nil
+	}
+	switch args[0] {
+	case "challengeResponse":
+		if c.state != clientChallenging || len(args) < 2 {
+			return nil
+		}
+		if len(args) > 2 {
+			if n, _ := strconv.Atoi(args[2]); int32(n) != c.clientChallenge {
+				return nil
+			}
+		}
+		// Servers that only support the legacy protocol don't send their
+		// protocol.
+		c.legacy = true
+		if len(args) > 3 {
+			n, _ := strconv.Atoi(args[3])
+			c.legacy = n == LegacyProtocolVersion
+		}
+		n, _ := strconv.Atoi(args[1])
+		c.challenge = int32(n)
+		c.state = clientConnecting
+		c.lastSend = time.Time{}
+		return c.frame()
+	case "connectResponse":
+		if c.state != clientConnecting {
+			return nil
+		}
+		if !c.legacy {
+			if len(args) < 2 {
+				return nil
+			}
+			if n, _ := strconv.Atoi(args[1]); int32(n) != c.challenge {
+				return nil
+			}
+		}
+		c.chan_ = newNetchan(true, c.cfg.QPort, c.challenge, c.legacy)
+		c.state = clientConnected
+		return c.writePacket()
+	case "print":
+		c.serverMessage = strings.TrimSpace(string(rest))
+	case "disconnect":
+		if c.state >= clientConnected {
+			return ErrDisconnected
+		}
+	}
+	return nil
+}
+
+func (c *Client) parseServerMessage(m *message) error {
+	m.bitstream()
+	c.reliableAcknowledge = m.readLong()
+	if c.reliableAcknowledge < c.reliableSequence-maxReliableCommands {
+		c.reliableAcknowledge = c.reliableSequence
+	}
+	for {
+		if m.overflowed {
+			return errors.New("read past end of server message")
+		}
+		switch cmd := m.readByte(); cmd {
+		case svcEOF:
+			return nil
+		case svcNop:
+		case svcServerCommand:
+			if err := c.parseCommandString(m); err != nil {
+				return err
+			}
+		case svcGamestate:
+			if err := c.parseGamestate(m); err != nil {
+				return err
+			}
+		case svcSnapshot:
+			if err := c.parseSnapshot(m); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("illegible server message: %d", cmd)
+		}
+	}
+}
+
+func (c *Client) parseCommandString(m *message) error {
+	seq := m.readLong()
+	s := m.readString()
+	if seq <= c.serverCommandSequence {
+		// Reliable commands are repeated until acknowledged.
+		return nil
+	}
+	c.serverCommandSequence = seq
+	c.serverCommands[seq&(maxReliableCommands-1)] = s
+	return c.serverCommand(s)
+}
+
+func (c *Client) serverCommand(s string) error {
+	args := tokenize(s)
+	if len(args) == 0 {
+		return nil
+	}
+	switch args[0] {
+	case "disconnect":
+		if len(args) > 1 {
+			return fmt.Errorf("%w: %s", ErrDisconnected, args[1])
+		}
+		return ErrDisconnected
+	case "bcs0":
+		if len(args) > 2 {
+			c.bigConfigString = fmt.Sprintf("cs %s \"%s", args[1], args[2])
+		}
+		return nil
+	case "bcs1":
+		if len(args) > 2 {
+			c.bigConfigString += args[2]
+		}
+		return nil
+	case "bcs2":
+		if len(args) > 2 {
+			c.bigConfigString += args[2] + "\""
+			s, c.bigConfigString = c.bigConfigString, ""
+			args = tokenize(s)
+		}
+	}
+	if args[0] == "cs" && len(args) > 2 && c.gs != nil {
+		if n, err := strconv.Atoi(args[1]); err == nil && n >= 0 && n < maxConfigStrings {
+			c.gs.ConfigStrings[n] = args[2]
+		}
+	}
+	if c.cfg.OnServerCommand != nil {
+		c.cfg.OnServerCommand(s)
+	}
+	return nil
+}
+
+func (c *Client) parseGamestate(m *message) error {
+	c.serverCommandSequence = m.readLong()
+	gs := &GameState{
+		ConfigStrings: make(map[int]string),
+		Baselines:     make(map[int]EntityState),
+	}
+	for {
+		cmd := m.readByte()
+		if cmd == svcEOF {
+			break
+		}
+		switch cmd {
+		case svcConfigstring:
+			i := m.readShort()
+			if i < 0 || i >= maxConfigStrings {
+				return fmt.Errorf("configstring > MAX_CONFIGSTRINGS: %d", i)
+			}
+			gs.ConfigStrings[i] = m.readBigString()
+		case svcBaseline:
+			n := int(m.readBits(gentityNumBits))
+			e, err := m.readDeltaEntity(&EntityState{}, n)
+			if err != nil {
+				return err
+			}
+			if e != nil {
+				gs.Baselines[n] = *e
+			}
+		default:
+			return fmt.Errorf("bad gamestate command byte: %d", cmd)
+		}
+		if m.overflowed {
+			return errMessageOverflow
+		}
+	}
+	gs.ClientNum = int(m.readLong())
+	gs.ChecksumFeed = m.readLong()
+	if m.overflowed {
+		return errMessageOverflow
+	}
+	c.gs = gs
+	c.snap = nil
+	c.snapshots = [packetBackup]*Snapshot{}
+	c.cmds = [maxPacketUserCmds]UserCmd{}
+
+	sysinfo := gs.SystemInfo()
+	n, _ := strconv.Atoi(sysinfo["sv_serverid"])
+	c.serverID = int32(n)
+	if pure, _ := strconv.Atoi(sysinfo["sv_pure"]); pure != 0 {
+		cp, err := c.pureChecksums(sysinfo["sv_paks"])
+		if err != nil {
+			return err
+		}
+		if err := c.addReliableCommand(cp); err != nil {
+			return err
+		}
+	}
+	if c.state < clientPrimed {
+		c.state = clientPrimed
+	}
+	return nil
+}
+
+// pureChecksums returns the cp command for a pure server, with the pure
+// checksums of the paks that have the cgame and ui modules. No other paks
+// are referenced, since a headless client doesn't load anything.
+func (c *Client) pureChecksums(serverPaks string) (string, error) {
+	var cgame, ui *Pak
+	for _, s := range strings.Fields(serverPaks) {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			continue
+		}
+		for _, p := range c.cfg.Paks {
+			if p.Checksum != int32(n) {
+				continue
+			}
+			if cgame == nil && p.hasCGame {
+				cgame = p
+			}
+			if ui == nil && p.hasUI {
+				ui = p
+			}
+		}
+	}
+	if cgame == nil || ui == nil {
+		return "", errors.New("pure server: cannot find the server's cgame and ui paks")
+	}
+	feed := c.gs.ChecksumFeed
+	return fmt.Sprintf("cp %d %d %d @ %d", c.serverID, cgame.PureChecksum(feed), ui.PureChecksum(feed), feed), nil
+}
+
+func (c *Client) parseSnapshot(m *message) error {
+	if c.gs == nil {
+		return errors.New("snapshot before gamestate")
+	}
+	snap := &Snapshot{
+		MessageNum: c.serverMessageSequence,
+		ServerTime: m.readLong(),
+	}
+	var old *Snapshot
+	valid := true
+	if delta := int32(m.readByte()); delta != 0 {
+		deltaNum := snap.MessageNum - delta
+		old = c.snapshots[deltaNum&(packetBackup-1)]
+		// The snapshot still has to be read, but it can't be used.
+		if old == nil || old.MessageNum != deltaNum {
+			old = nil
+			valid = false
+		}
+	}
+	snap.Flags = m.readByte()
+	n := m.readByte()
+	if n < 0 || n > 32 {
+		return fmt.Errorf("invalid areamask length: %d", n)
+	}
+	snap.AreaMask = m.readData(n)
+
+	var fromPS *PlayerState
+	if old != nil {
+		fromPS = &old.PlayerState
+	}
+	ps, err := m.readDeltaPlayerState(fromPS)
+	if err != nil {
+		return err
+	}
+	snap.PlayerState = *ps
+	snap.Entities, err = c.parsePacketEntities(m, old)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return nil
+	}
+
+	c.snapshots[snap.MessageNum&(packetBackup-1)] = snap
+	c.snap = snap
+	c.snapTime = time.Now()
+	c.stats.Snapshots++
+	for i := int32(0); i < packetBackup; i++ {
+		p := c.outPackets[(c.chan_.outgoingSequence-1-i)&(packetBackup-1)]
+		if p.serverTime != 0 && snap.PlayerState.CommandTime >= p.serverTime {
+			c.stats.Ping = c.snapTime.Sub(p.time)
+			break
+		}
+	}
+	if c.state == clientPrimed {
+		c.state = clientActive
+	}
+	return nil
+}
+
+// parsePacketEntities reads the entities of a snapshot, as changes from the
+// old snapshot (or from the baselines for new entities).
+func (c *Client) parsePacketEntities(m *message, old *Snapshot) ([]EntityState, error) {
+	var oldEntities []EntityState
+	if old != nil {
+		oldEntities = old.Entities
+	}
+	entities := make([]EntityState, 0, len(oldEntities))
+	for {
+		n := int(m.readBits(gentityNumBits))
+		if m.overflowed {
+			return nil, errors.New("end of message in packet entities")
+		}
+		if n == maxGentities-1 {
+			break
+		}
+		// Entities are sorted, and the ones missing from the message are
+		// unchanged.
+		for len(oldEntities) > 0 && int(oldEntities[0].Number) < n {
+			entities = append(entities, oldEntities[0])
+			oldEntities = oldEntities[1:]
+		}
+		from := c.gs.Baselines[n]
+		if len(oldEntities) > 0 && int(oldEntities[0].Number) == n {
+			from = oldEntities[0]
+			oldEntities = oldEntities[1:]
+		}
+		e, err := m.readDeltaEntity(&from, n)
+		if err != nil {
+			return nil, err
+		}
+		if e != nil {
+			entities = append(entities, *e)
+		}
+	}
+	return append(entities, oldEntities...), nil
+}
+
+// encode obfuscates a message for legacy servers.
+func (c *Client) encode(data []byte) {
+	const start = 12
+	if len(data) <= start {
+		return
+	}
+	m := &message{b: bitBuffer{data: data}, size: len(data)}
+	serverID := m.readLong()
+	messageAcknowledge := m.readLong()
+	reliableAcknowledge := m.readLong()
+	s := c.serverCommands[reliableAcknowledge&(maxReliableCommands-1)]
+	key := byte(c.challenge ^ serverID ^ messageAcknowledge)
+	xorKey(data, start, key, s)
+}
+
+// decode reverses the obfuscation of a message from a legacy server.
+func (c *Client) decode(m *message) {
+	const start = 4
+	bit := m.b.bit
+	m.oob = false
+	reliableAcknowledge := m.readLong()
+	m.oob = true
+	m.b.bit = bit
+	m.overflowed = false
+	s := c.reliableCommands[reliableAcknowledge&(maxReliableCommands-1)]
+	key := byte(c.challenge ^ readRawLong(m.b.data))
+	xorKey(m.b.data, bit>>3+start, key, s)
+}
+
+func xorKey(data []byte, start int, key byte, s string) {
+	index := 0
+	for i := start; i < len(data); i++ {
+		if index >= len(s) {
+			index = 0
+		}
+		var c byte
+		if index < len(s) {
+			c = s[index]
+			if c > 127 || c == '%' {
+				c = '.'
+			}
+		}
+		key ^= c << (i & 1)
+		index++
+		data[i] ^= key
+	}
+}
+
+// tokenize splits a command into arguments, like Cmd_TokenizeString.
+func tokenize(s string) []string {
+	args := make([]string, 0)
+	for {
+		s = strings.TrimLeft(s, " \t\r\n")
+		if s == "" || strings.HasPrefix(s, "//") {
+			return args
+		}
+		if s[0] == '"' {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				return append(args, s[1:])
+			}
+			args = append(args, s[1:end+1])
+			s = s[end+2:]
+			continue
+		}
+		end := strings.IndexAny(s, " \t\r\n")
+		if end < 0 {
+			return append(args, s)
+		}
+		args = append(args, s[:end])
+		s = s[end:]
+	}
+}
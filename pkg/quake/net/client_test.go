@@ -0,0 +1,481 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNetchanFragments(t *testing.T) {
+	client := newNetchan(true, 1234, 5678, false)
+	server := newNetchan(false, 0, 5678, false)
+
+	for _, size := range []int{10, fragmentSize, 3*fragmentSize + 100} {
+		data := bytes.Repeat([]byte{byte(size)}, size)
+		packets, err := client.transmit(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m *message
+		for _, p := range packets {
+			if m != nil {
+				t.Fatalf("net: message of %d bytes complete before last fragment", size)
+			}
+			m, err = server.process(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if m == nil {
+			t.Fatalf("net: message of %d bytes incomplete", size)
+		}
+		if diff := cmp.Diff(data, m.b.data[m.readCount():]); diff != "" {
+			t.Errorf("net: after process differs: (-want +got)\n%s", diff)
+		}
+	}
+
+	// Replayed and spoofed packets are rejected.
+	packets, _ := client.transmit([]byte("hello"))
+	if _, err := server.process(packets[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.process(packets[0]); !errors.Is(err, errOutOfOrder) {
+		t.Errorf("net: expected out of order error, got %v", err)
+	}
+	spoofed := newNetchan(true, 1234, 1, false)
+	spoofed.outgoingSequence = client.outgoingSequence
+	packets, _ = spoofed.transmit([]byte("hello"))
+	if _, err := server.process(packets[0]); err == nil {
+		t.Error("net: expected checksum error")
+	}
+}
+
+// TestSnapshotBeforeGamestate checks that a snapshot received before the
+// gamestate is an error, rather than a nil dereference.
+func TestSnapshotBeforeGamestate(t *testing.T) {
+	m := &message{}
+	m.writeLong(0)
+	(&fakeServer{}).writeSnapshot(m)
+	m.writeByte(svcEOF)
+	packets, err := newNetchan(false, 1234, 5678, false).transmit(m.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewClient(nil, &ClientConfig{QPort: 1234})
+	c.state = clientConnected
+	c.chan_ = newNetchan(true, 1234, 5678, false)
+	if err := c.packet(packets[0]); err == nil {
+		t.Error("net: expected an error for a snapshot before gamestate")
+	}
+}
+
+func TestClient(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+
+	c, err := DialClient(s.Addr(), &ClientConfig{Name: "bot", PacketRate: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetCommand(UserCmd{ForwardMove: 127, Buttons: ButtonAttack})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	gs := c.GameState()
+	if diff := cmp.Diff("q3dm7", gs.ServerInfo()["mapname"]); diff != "" {
+		t.Errorf("net: after Connect differs: (-want +got)\n%s", diff)
+	}
+	snap := c.Snapshot()
+	want := []EntityState{
+		{Number: 1, Type: 2, Origin: [3]float32{10, -20, 0.5}, ModelIndex: 3},
+		{Number: 7, Type: 1, Origin: [3]float32{64, 0, 0}, Pos: Trajectory{Time: 1000}},
+	}
+	if diff := cmp.Diff(want, snap.Entities); diff != "" {
+		t.Errorf("net: after Connect differs: (-want +got)\n%s", diff)
+	}
+	// The command time is the server time of the last user command.
+	ps := s.ps
+	ps.CommandTime = snap.PlayerState.CommandTime
+	if diff := cmp.Diff(ps, snap.PlayerState); diff != "" {
+		t.Errorf("net: after Connect differs: (-want +got)\n%s", diff)
+	}
+
+	runCtx, stop := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- c.Run(runCtx) }()
+	time.Sleep(100 * time.Millisecond)
+	stop()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatal(err)
+	}
+
+	// The disconnect command is sent without waiting for the server.
+	for i := 0; i < 50 && len(s.Commands()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if diff := cmp.Diff("bot", s.userinfo["name"]); diff != "" {
+		t.Errorf("net: after Connect differs: (-want +got)\n%s", diff)
+	}
+	if s.cmd.ForwardMove != 127 || s.cmd.Buttons != ButtonAttack || s.cmd.ServerTime == 0 {
+		t.Errorf("net: unexpected user command: %+v", s.cmd)
+	}
+	if diff := cmp.Diff([]string{"disconnect"}, s.commands); diff != "" {
+		t.Errorf("net: after Run differs: (-want +got)\n%s", diff)
+	}
+	if stats := c.Stats(); stats.Snapshots == 0 || stats.Ping == 0 {
+		t.Errorf("net: unexpected stats: %+v", stats)
+	}
+}
+
+const (
+	fakeServerID     = 1234
+	fakeChallenge    = 98765
+	fakeChecksumFeed = 4242
+)
+
+// fakeServer is enough of a Quake 3 server for a client to connect, which
+// sends a snapshot in response to every packet with user commands.
+type fakeServer struct {
+	t    *testing.T
+	conn net.PacketConn
+	ch   *netchan
+	ps   PlayerState
+
+	mu          sync.Mutex
+	userinfo    map[string]string
+	cmd         UserCmd
+	commands    []string
+	lastCommand int32
+	serverTime  int32
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{
+		t:    t,
+		conn: conn,
+		ps: PlayerState{
+			Origin:     [3]float32{1, 2, 3.25},
+			ViewHeight: -8,
+			WeaponTime: -100,
+			Stats:      [maxStats]int32{0: 100, 6: 125},
+			Ammo:       [maxWeapons]int32{2: 100},
+			Powerups:   [maxPowerups]int32{1: 60000},
+		},
+	}
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) Addr() string { return s.conn.LocalAddr().String() }
+func (s *fakeServer) Close() error { return s.conn.Close() }
+
+func (s *fakeServer) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commands
+}
+
+func (s *fakeServer) serve() {
+	buf := make([]byte, MaxMessageLength)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		p := append([]byte(nil), buf[:n]...)
+		s.mu.Lock()
+		err = s.packet(p, addr)
+		s.mu.Unlock()
+		if err != nil {
+			s.t.Error(err)
+			return
+		}
+	}
+}
+
+func (s *fakeServer) sendOutOfBand(addr net.Addr, data string) error {
+	_, err := s.conn.WriteTo([]byte(OutOfBandHeader+data), addr)
+	return err
+}
+
+func (s *fakeServer) packet(p []byte, addr net.Addr) error {
+	if bytes.HasPrefix(p, []byte(OutOfBandHeader)) {
+		p = p[len(OutOfBandHeader):]
+		switch {
+		case bytes.HasPrefix(p, []byte("getchallenge ")):
+			args := tokenize(string(p))
+			return s.sendOutOfBand(addr, "challengeResponse "+strconv.Itoa(fakeChallenge)+" "+args[1]+" 71")
+		case bytes.HasPrefix(p, []byte("connect ")):
+			data, err := huffDecompress(p[8:], MaxMessageLength)
+			if err != nil {
+				return err
+			}
+			s.userinfo, err = parseInfoString(bytes.Trim(data, `"`))
+			if err != nil {
+				return err
+			}
+			qport, _ := strconv.Atoi(s.userinfo["qport"])
+			s.ch = newNetchan(false, qport, fakeChallenge, false)
+			return s.sendOutOfBand(addr, "connectResponse "+strconv.Itoa(fakeChallenge))
+		}
+		return nil
+	}
+
+	m, err := s.ch.process(p)
+	if err != nil {
+		return err
+	}
+	m.bitstream()
+	serverID := m.readLong()
+	messageAcknowledge := m.readLong()
+	m.readLong()
+	moved := false
+	for done := false; !done; {
+		switch op := m.readByte(); op {
+		case clcClientCommand:
+			seq := m.readLong()
+			cmd := m.readString()
+			if seq > s.lastCommand {
+				s.lastCommand = seq
+				s.commands = append(s.commands, cmd)
+			}
+		case clcMove, clcMoveNoDelta:
+			count := m.readByte()
+			key := int32(fakeChecksumFeed) ^ messageAcknowledge ^ hashKey("", 32)
+			var from UserCmd
+			for i := 0; i < count; i++ {
+				from = m.readDeltaUserCmd(key, &from)
+			}
+			s.cmd = from
+			moved = true
+		case clcEOF:
+			done = true
+		default:
+			return errors.New("fake server: bad client op")
+		}
+		if m.overflowed {
+			return errors.New("fake server: read past end of client message")
+		}
+	}
+
+	out := &message{}
+	out.bitstream()
+	out.writeLong(s.lastCommand)
+	switch {
+	case serverID != fakeServerID:
+		s.writeGamestate(out)
+	case moved:
+		s.writeSnapshot(out)
+	}
+	out.writeByte(svcEOF)
+	packets, err := s.ch.transmit(out.Bytes())
+	if err != nil {
+		return err
+	}
+	for _, p := range packets {
+		if _, err := s.conn.WriteTo(p, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fakeServer) writeGamestate(m *message) {
+	m.writeByte(svcGamestate)
+	m.writeLong(0)
+	m.writeByte(svcConfigstring)
+	m.writeShort(csServerInfo)
+	m.writeString(`\mapname\q3dm7\sv_hostname\test`)
+	m.writeByte(svcConfigstring)
+	m.writeShort(csSystemInfo)
+	m.writeString(`\sv_serverid\` + strconv.Itoa(fakeServerID))
+	// The gamestate is large enough to be fragmented.
+	for i := 2; i < 40; i++ {
+		m.writeByte(svcConfigstring)
+		m.writeShort(i)
+		m.writeString(string(bytes.Repeat([]byte{'a' + byte(i)}, 200)))
+	}
+	m.writeByte(svcBaseline)
+	m.writeDeltaEntity(&EntityState{}, &EntityState{Number: 1, Type: 2, ModelIndex: 3}, true)
+	m.writeByte(svcEOF)
+	m.writeLong(0)
+	m.writeLong(fakeChecksumFeed)
+}
+
+func (s *fakeServer) writeSnapshot(m *message) {
+	s.serverTime += 50
+	m.writeByte(svcSnapshot)
+	m.writeLong(s.serverTime)
+	m.writeByte(0)
+	m.writeByte(0)
+	m.writeByte(1)
+	m.writeByte(0xff)
+	ps := s.ps
+	ps.CommandTime = s.cmd.ServerTime
+	m.writeDeltaPlayerState(nil, &ps)
+	m.writeDeltaEntity(&EntityState{Number: 1, Type: 2, ModelIndex: 3}, &EntityState{Number: 1, Type: 2, Origin: [3]float32{10, -20, 0.5}, ModelIndex: 3}, false)
+	m.writeDeltaEntity(&EntityState{}, &EntityState{Number: 7, Type: 1, Origin: [3]float32{64, 0, 0}, Pos: Trajectory{Time: 1000}}, true)
+	m.writeBits(maxGentities-1, gentityNumBits)
+}
+
+func (m *message) readDeltaUserCmd(key int32, from *UserCmd) UserCmd {
+	to := *from
+	if m.readBits(1) == 1 {
+		to.ServerTime = from.ServerTime + m.readBits(8)
+	} else {
+		to.ServerTime = m.readBits(32)
+	}
+	if m.readBits(1) == 0 {
+		return to
+	}
+	key ^= to.ServerTime
+	readKey := func(old int32, bits int) int32 {
+		if m.readBits(1) == 1 {
+			return m.readBits(bits) ^ (key & (1<<bits - 1))
+		}
+		return old
+	}
+	for i := range to.Angles {
+		to.Angles[i] = readKey(from.Angles[i], 16)
+	}
+	to.ForwardMove = int8(readKey(int32(from.ForwardMove), 8))
+	to.RightMove = int8(readKey(int32(from.RightMove), 8))
+	to.UpMove = int8(readKey(int32(from.UpMove), 8))
+	to.Buttons = readKey(from.Buttons, 16)
+	to.Weapon = uint8(readKey(int32(from.Weapon), 8))
+	return to
+}
+
+func (m *message) writeFloat(f float32) {
+	if trunc := int32(f); float32(trunc) == f && trunc+floatIntBias >= 0 && trunc+floatIntBias < 1<<floatIntBits {
+		m.writeBits(0, 1)
+		m.writeBits(trunc+floatIntBias, floatIntBits)
+		return
+	}
+	m.writeBits(1, 1)
+	m.writeBits(int32(math.Float32bits(f)), 32)
+}
+
+func (m *message) writeDeltaEntity(from, to *EntityState, force bool) {
+	changed := func(field netField[EntityState]) bool {
+		if field.f != nil {
+			return *field.f(from) != *field.f(to)
+		}
+		return *field.i(from) != *field.i(to)
+	}
+	lc := 0
+	for i, field := range entityStateFields {
+		if changed(field) {
+			lc = i + 1
+		}
+	}
+	if lc == 0 && !force {
+		return
+	}
+	m.writeBits(to.Number, gentityNumBits)
+	m.writeBits(0, 1)
+	if lc == 0 {
+		m.writeBits(0, 1)
+		return
+	}
+	m.writeBits(1, 1)
+	m.writeByte(lc)
+	for _, field := range entityStateFields[:lc] {
+		if !changed(field) {
+			m.writeBits(0, 1)
+			continue
+		}
+		m.writeBits(1, 1)
+		if field.f != nil {
+			if f := *field.f(to); f == 0 {
+				m.writeBits(0, 1)
+			} else {
+				m.writeBits(1, 1)
+				m.writeFloat(f)
+			}
+			continue
+		}
+		if v := *field.i(to); v == 0 {
+			m.writeBits(0, 1)
+		} else {
+			m.writeBits(1, 1)
+			m.writeBits(v, field.bits)
+		}
+	}
+}
+
+func (m *message) writeDeltaPlayerState(from, to *PlayerState) {
+	if from == nil {
+		from = &PlayerState{}
+	}
+	m.writeByte(len(playerStateFields))
+	for _, field := range playerStateFields {
+		if field.f != nil {
+			if *field.f(from) == *field.f(to) {
+				m.writeBits(0, 1)
+			} else {
+				m.writeBits(1, 1)
+				m.writeFloat(*field.f(to))
+			}
+			continue
+		}
+		if *field.i(from) == *field.i(to) {
+			m.writeBits(0, 1)
+		} else {
+			m.writeBits(1, 1)
+			m.writeBits(*field.i(to), field.bits)
+		}
+	}
+	m.writeBits(1, 1)
+	for _, arr := range []struct {
+		from, to []int32
+		long     bool
+	}{
+		{from.Stats[:], to.Stats[:], false},
+		{from.Persistant[:], to.Persistant[:], false},
+		{from.Ammo[:], to.Ammo[:], false},
+		{from.Powerups[:], to.Powerups[:], true},
+	} {
+		var bits int32
+		for i := range arr.to {
+			if arr.from[i] != arr.to[i] {
+				bits |= 1 << i
+			}
+		}
+		if bits == 0 {
+			m.writeBits(0, 1)
+			continue
+		}
+		m.writeBits(1, 1)
+		m.writeBits(bits, 16)
+		for i := range arr.to {
+			if bits&(1<<i) == 0 {
+				continue
+			}
+			if arr.long {
+				m.writeLong(arr.to[i])
+			} else {
+				m.writeShort(int(arr.to[i]))
+			}
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package net
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	gentityNumBits = 10
+	maxGentities   = 1 << gentityNumBits
+
+	floatIntBits = 13
+	floatIntBias = 1 << (floatIntBits - 1)
+
+	maxStats      = 16
+	maxPersistant = 16
+	maxPowerups   = 16
+	maxWeapons    = 16
+)
+
+// Trajectory is the movement of an entity, which is used by the client to
+// interpolate its position.
+type Trajectory struct {
+	Type     int32
+	Time     int32
+	Duration int32
+	Base     [3]float32
+	Delta    [3]float32
+}
+
+// EntityState is the part of a game entity that is sent to clients.
+type EntityState struct {
+	Number          int32
+	Type            int32
+	Flags           int32
+	Pos             Trajectory
+	APos            Trajectory
+	Time            int32
+	Time2           int32
+	Origin          [3]float32
+	Origin2         [3]float32
+	Angles          [3]float32
+	Angles2         [3]float32
+	OtherEntityNum  int32
+	OtherEntityNum2 int32
+	GroundEntityNum int32
+	ConstantLight   int32
+	LoopSound       int32
+	ModelIndex      int32
+	ModelIndex2     int32
+	ClientNum       int32
+	Frame           int32
+	Solid           int32
+	Event           int32
+	EventParm       int32
+	Powerups        int32
+	Weapon          int32
+	LegsAnim        int32
+	TorsoAnim       int32
+	Generic1        int32
+}
+
+// PlayerState is the state of the player a client is following.
+type PlayerState struct {
+	CommandTime       int32
+	PMType            int32
+	BobCycle          int32
+	PMFlags           int32
+	PMTime            int32
+	Origin            [3]float32
+	Velocity          [3]float32
+	WeaponTime        int32
+	Gravity           int32
+	Speed             int32
+	DeltaAngles       [3]int32
+	GroundEntityNum   int32
+	LegsTimer         int32
+	LegsAnim          int32
+	TorsoTimer        int32
+	TorsoAnim         int32
+	MovementDir       int32
+	GrapplePoint      [3]float32
+	Flags             int32
+	EventSequence     int32
+	Events            [2]int32
+	EventParms        [2]int32
+	ExternalEvent     int32
+	ExternalEventParm int32
+	ClientNum         int32
+	Weapon            int32
+	WeaponState       int32
+	ViewAngles        [3]float32
+	ViewHeight        int32
+	DamageEvent       int32
+	DamageYaw         int32
+	DamagePitch       int32
+	DamageCount       int32
+	Stats             [maxStats]int32
+	Persistant        [maxPersistant]int32
+	Powerups          [maxPowerups]int32
+	Ammo              [maxWeapons]int32
+	Generic1          int32
+	LoopSound         int32
+	JumppadEnt        int32
+}
+
+// netField is a field that is delta compressed. Fields with 0 bits are
+// floats, and fields with negative bits are signed.
+type netField[T any] struct {
+	bits int
+	i    func(*T) *int32
+	f    func(*T) *float32
+}
+
+func intField[T any](bits int, i func(*T) *int32) netField[T] {
+	return netField[T]{bits: bits, i: i}
+}
+
+func floatField[T any](f func(*T) *float32) netField[T] {
+	return netField[T]{f: f}
+}
+
+// The order of the fields is part of the protocol: fields are sent up to the
+// last one that changed, so the ones that change most often come first.
+var entityStateFields = []netField[EntityState]{
+	intField(32, func(e *EntityState) *int32 { return &e.Pos.Time }),
+	floatField(func(e *EntityState) *float32 { return &e.Pos.Base[0] }),
+	floatField(func(e *EntityState) *float32 { return &e.Pos.Base[1] }),
+	floatField(func(e *EntityState) *float32 { return &e.Pos.Delta[0] }),
+	floatField(func(e *EntityState) *float32 { return &e.Pos.Delta[1] }),
+	floatField(func(e *EntityState) *float32 { return &e.Pos.Base[2] }),
+	floatField(func(e *EntityState) *float32 { return &e.APos.Base[1] }),
+	floatField(func(e *EntityState) *float32 { return &e.Pos.Delta[2] }),
+	floatField(func(e *EntityState) *float32 { return &e.APos.Base[0] }),
+	intField(10, func(e *EntityState) *int32 { return &e.Event }),
+	floatField(func(e *EntityState) *float32 { return &e.Angles2[1] }),
+	intField(8, func(e *EntityState) *int32 { return &e.Type }),
+	intField(8, func(e *EntityState) *int32 { return &e.TorsoAnim }),
+	intField(8, func(e *EntityState) *int32 { return &e.EventParm }),
+	intField(8, func(e *EntityState) *int32 { return &e.LegsAnim }),
+	intField(gentityNumBits, func(e *EntityState) *int32 { return &e.GroundEntityNum }),
+	intField(8, func(e *EntityState) *int32 { return &e.Pos.Type }),
+	intField(19, func(e *EntityState) *int32 { return &e.Flags }),
+	intField(gentityNumBits, func(e *EntityState) *int32 { return &e.OtherEntityNum }),
+	intField(8, func(e *EntityState) *int32 { return &e.Weapon }),
+	intField(8, func(e *EntityState) *int32 { return &e.ClientNum }),
+	floatField(func(e *EntityState) *float32 { return &e.Angles[1] }),
+	intField(32, func(e *EntityState) *int32 { return &e.Pos.Duration }),
+	intField(8, func(e *EntityState) *int32 { return &e.APos.Type }),
+	floatField(func(e *EntityState) *float32 { return &e.Origin[0] }),
+	floatField(func(e *EntityState) *float32 { return &e.Origin[1] }),
+	floatField(func(e *EntityState) *float32 { return &e.Origin[2] }),
+	intField(24, func(e *EntityState) *int32 { return &e.Solid }),
+	intField(maxPowerups, func(e *EntityState) *int32 { return &e.Powerups }),
+	intField(8, func(e *EntityState) *int32 { return &e.ModelIndex }),
+	intField(gentityNumBits, func(e *EntityState) *int32 { return &e.OtherEntityNum2 }),
+	intField(8, func(e *EntityState) *int32 { return &e.LoopSound }),
+	intField(8, func(e *EntityState) *int32 { return &e.Generic1 }),
+	floatField(func(e *EntityState) *float32 { return &e.Origin2[2] }),
+	floatField(func(e *EntityState) *float32 { return &e.Origin2[0] }),
+	floatField(func(e *EntityState) *float32 { return &e.Origin2[1] }),
+	intField(8, func(e *EntityState) *int32 { return &e.ModelIndex2 }),
+	floatField(func(e *EntityState) *float32 { return &e.Angles[0] }),
+	intField(32, func(e *EntityState) *int32 { return &e.Time }),
+	intField(32, func(e *EntityState) *int32 { return &e.APos.Time }),
+	intField(32, func(e *EntityState) *int32 { return &e.APos.Duration }),
+	floatField(func(e *EntityState) *float32 { return &e.APos.Base[2] }),
+	floatField(func(e *EntityState) *float32 { return &e.APos.Delta[0] }),
+	floatField(func(e *EntityState) *float32 { return &e.APos.Delta[1] }),
+	floatField(func(e *EntityState) *float32 { return &e.APos.Delta[2] }),
+	intField(32, func(e *EntityState) *int32 { return &e.Time2 }),
+	floatField(func(e *EntityState) *float32 { return &e.Angles[2] }),
+	floatField(func(e *EntityState) *float32 { return &e.Angles2[0] }),
+	floatField(func(e *EntityState) *float32 { return &e.Angles2[2] }),
+	intField(32, func(e *EntityState) *int32 { return &e.ConstantLight }),
+	intField(16, func(e *EntityState) *int32 { return &e.Frame }),
+}
+
+var playerStateFields = []netField[PlayerState]{
+	intField(32, func(p *PlayerState) *int32 { return &p.CommandTime }),
+	floatField(func(p *PlayerState) *float32 { return &p.Origin[0] }),
+	floatField(func(p *PlayerState) *float32 { return &p.Origin[1] }),
+	intField(8, func(p *PlayerState) *int32 { return &p.BobCycle }),
+	floatField(func(p *PlayerState) *float32 { return &p.Velocity[0] }),
+	floatField(func(p *PlayerState) *float32 { return &p.Velocity[1] }),
+	floatField(func(p *PlayerState) *float32 { return &p.ViewAngles[1] }),
+	floatField(func(p *PlayerState) *float32 { return &p.ViewAngles[0] }),
+	intField(-16, func(p *PlayerState) *int32 { return &p.WeaponTime }),
+	floatField(func(p *PlayerState) *float32 { return &p.Origin[2] }),
+	floatField(func(p *PlayerState) *float32 { return &p.Velocity[2] }),
+	intField(8, func(p *PlayerState) *int32 { return &p.LegsTimer }),
+	intField(-16, func(p *PlayerState) *int32 { return &p.PMTime }),
+	intField(16, func(p *PlayerState) *int32 { return &p.EventSequence }),
+	intField(8, func(p *PlayerState) *int32 { return &p.TorsoAnim }),
+	intField(4, func(p *PlayerState) *int32 { return &p.MovementDir }),
+	intField(8, func(p *PlayerState) *int32 { return &p.Events[0] }),
+	intField(8, func(p *PlayerState) *int32 { return &p.LegsAnim }),
+	intField(8, func(p *PlayerState) *int32 { return &p.Events[1] }),
+	intField(16, func(p *PlayerState) *int32 { return &p.PMFlags }),
+	intField(gentityNumBits, func(p *PlayerState) *int32 { return &p.GroundEntityNum }),
+	intField(4, func(p *PlayerState) *int32 { return &p.WeaponState }),
+	intField(16, func(p *PlayerState) *int32 { return &p.Flags }),
+	intField(10, func(p *PlayerState) *int32 { return &p.ExternalEvent }),
+	intField(16, func(p *PlayerState) *int32 { return &p.Gravity }),
+	intField(16, func(p *PlayerState) *int32 { return &p.Speed }),
+	intField(16, func(p *PlayerState) *int32 { return &p.DeltaAngles[1] }),
+	intField(8, func(p *PlayerState) *int32 { return &p.ExternalEventParm }),
+	intField(-8, func(p *PlayerState) *int32 { return &p.ViewHeight }),
+	intField(8, func(p *PlayerState) *int32 { return &p.DamageEvent }),
+	intField(8, func(p *PlayerState) *int32 { return &p.DamageYaw }),
+	intField(8, func(p *PlayerState) *int32 { return &p.DamagePitch }),
+	intField(8, func(p *PlayerState) *int32 { return &p.DamageCount }),
+	intField(8, func(p *PlayerState) *int32 { return &p.Generic1 }),
+	intField(8, func(p *PlayerState) *int32 { return &p.PMType }),
+	intField(16, func(p *PlayerState) *int32 { return &p.DeltaAngles[0] }),
+	intField(16, func(p *PlayerState) *int32 { return &p.DeltaAngles[2] }),
+	intField(12, func(p *PlayerState) *int32 { return &p.TorsoTimer }),
+	intField(8, func(p *PlayerState) *int32 { return &p.EventParms[0] }),
+	intField(8, func(p *PlayerState) *int32 { return &p.EventParms[1] }),
+	intField(8, func(p *PlayerState) *int32 { return &p.ClientNum }),
+	intField(5, func(p *PlayerState) *int32 { return &p.Weapon }),
+	floatField(func(p *PlayerState) *float32 { return &p.ViewAngles[2] }),
+	floatField(func(p *PlayerState) *float32 { return &p.GrapplePoint[0] }),
+	floatField(func(p *PlayerState) *float32 { return &p.GrapplePoint[1] }),
+	floatField(func(p *PlayerState) *float32 { return &p.GrapplePoint[2] }),
+	intField(gentityNumBits, func(p *PlayerState) *int32 { return &p.JumppadEnt }),
+	intField(16, func(p *PlayerState) *int32 { return &p.LoopSound }),
+}
+
+// readFloat reads a float that is either sent as an integer, when it is
+// integral and small enough, or as the bits of the float.
+func (m *message) readFloat() float32 {
+	if m.readBits(1) == 0 {
+		return float32(m.readBits(floatIntBits) - floatIntBias)
+	}
+	return math.Float32frombits(uint32(m.readBits(32)))
+}
+
+// readDeltaEntity reads the changes from the entity from. The returned entity
+// is nil if the entity was removed.
+func (m *message) readDeltaEntity(from *EntityState, number int) (*EntityState, error) {
+	if number < 0 || number >= maxGentities {
+		return nil, fmt.Errorf("bad entity number: %d", number)
+	}
+	if m.readBits(1) == 1 {
+		return nil, nil
+	}
+	to := *from
+	to.Number = int32(number)
+	if m.readBits(1) == 0 {
+		return &to, nil
+	}
+	n := m.readByte()
+	if n < 0 || n > len(entityStateFields) {
+		return nil, fmt.Errorf("invalid entityState field count: %d", n)
+	}
+	for _, field := range entityStateFields[:n] {
+		if m.readBits(1) == 0 {
+			continue
+		}
+		if field.f != nil {
+			// Unlike the player state, entity floats can also be zeroed.
+			if m.readBits(1) == 0 {
+				*field.f(&to) = 0
+			} else {
+				*field.f(&to) = m.readFloat()
+			}
+			continue
+		}
+		if m.readBits(1) == 0 {
+			*field.i(&to) = 0
+		} else {
+			*field.i(&to) = m.readBits(field.bits)
+		}
+	}
+	if m.overflowed {
+		return nil, errMessageOverflow
+	}
+	return &to, nil
+}
+
+// readDeltaPlayerState reads the changes from the player state from, which
+// can be nil.
+func (m *message) readDeltaPlayerState(from *PlayerState) (*PlayerState, error) {
+	var to PlayerState
+	if from != nil {
+		to = *from
+	}
+	n := m.readByte()
+	if n < 0 || n > len(playerStateFields) {
+		return nil, fmt.Errorf("invalid playerState field count: %d", n)
+	}
+	for _, field := range playerStateFields[:n] {
+		if m.readBits(1) == 0 {
+			continue
+		}
+		if field.f != nil {
+			*field.f(&to) = m.readFloat()
+		} else {
+			*field.i(&to) = m.readBits(field.bits)
+		}
+	}
+	if m.readBits(1) == 1 {
+		if m.readBits(1) == 1 {
+			bits := m.readBits(maxStats)
+			for i := range to.Stats {
+				if bits&(1<<i) != 0 {
+					to.Stats[i] = int32(m.readShort())
+				}
+			}
+		}
+		if m.readBits(1) == 1 {
+			bits := m.readBits(maxPersistant)
+			for i := range to.Persistant {
+				if bits&(1<<i) != 0 {
+					to.Persistant[i] = int32(m.readShort())
+				}
+			}
+		}
+		if m.readBits(1) == 1 {
+			bits := m.readBits(maxWeapons)
+			for i := range to.Ammo {
+				if bits&(1<<i) != 0 {
+					to.Ammo[i] = int32(m.readShort())
+				}
+			}
+		}
+		if m.readBits(1) == 1 {
+			bits := m.readBits(maxPowerups)
+			for i := range to.Powerups {
+				if bits&(1<<i) != 0 {
+					to.Powerups[i] = m.readLong()
+				}
+			}
+		}
+	}
+	if m.overflowed {
+		return nil, errMessageOverflow
+	}
+	return &to, nil
+}
+
+// UserCmd is the input of a player for one client frame.
+type UserCmd struct {
+	ServerTime  int32
+	Angles      [3]int32
+	Buttons     int32
+	Weapon      uint8
+	ForwardMove int8
+	RightMove   int8
+	UpMove      int8
+}
+
+const (
+	ButtonAttack = 1 << 0
+	ButtonTalk   = 1 << 1
+	ButtonWalk   = 1 << 4
+)
+
+func (m *message) writeDeltaKey(key, from, to int32, bits int) {
+	if from == to {
+		m.writeBits(0, 1)
+		return
+	}
+	m.writeBits(1, 1)
+	m.writeBits(to^key, bits)
+}
+
+// writeDeltaUserCmd writes the changes from the command from. Changed values
+// are obfuscated with key, which the server computes the same way.
+func (m *message) writeDeltaUserCmd(key int32, from, to *UserCmd) {
+	if to.ServerTime-from.ServerTime < 256 {
+		m.writeBits(1, 1)
+		m.writeBits(to.ServerTime-from.ServerTime, 8)
+	} else {
+		m.writeBits(0, 1)
+		m.writeBits(to.ServerTime, 32)
+	}
+	if from.Angles == to.Angles && from.ForwardMove == to.ForwardMove &&
+		from.RightMove == to.RightMove && from.UpMove == to.UpMove &&
+		from.Buttons == to.Buttons && from.Weapon == to.Weapon {
+		m.writeBits(0, 1)
+		return
+	}
+	key ^= to.ServerTime
+	m.writeBits(1, 1)
+	for i := range to.Angles {
+		m.writeDeltaKey(key, from.Angles[i], to.Angles[i], 16)
+	}
+	m.writeDeltaKey(key, int32(from.ForwardMove), int32(to.ForwardMove), 8)
+	m.writeDeltaKey(key, int32(from.RightMove), int32(to.RightMove), 8)
+	m.writeDeltaKey(key, int32(from.UpMove), int32(to.UpMove), 8)
+	m.writeDeltaKey(key, from.Buttons, to.Buttons, 16)
+	m.writeDeltaKey(key, int32(from.Weapon), int32(to.Weapon), 8)
+}
+
+// hashKey hashes a string for the user command key.
+func hashKey(s string, maxLen int) int32 {
+	var hash int32
+	for i := 0; i < maxLen && i < len(s); i++ {
+		c := int32(int8(s[i]))
+		if s[i]&0x80 != 0 || s[i] == '%' {
+			c = '.'
+		}
+		hash += c * int32(119+i)
+	}
+	return hash ^ (hash >> 10) ^ (hash >> 20)
+}
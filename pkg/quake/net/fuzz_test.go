@@ -0,0 +1,156 @@
+package net
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func addCaptures(f *testing.F) {
+	paths, err := filepath.Glob("testdata/captures/*")
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+}
+
+func FuzzParseInfoString(f *testing.F) {
+	f.Add([]byte(`\mapname\q3dm7\sv_pure\1`))
+	f.Add([]byte(`mapname\q3dm7\`))
+	f.Add([]byte(`\\\\`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, err := parseInfoString(data)
+		if err == nil && m == nil {
+			t.Fatal("nil map without an error")
+		}
+	})
+}
+
+func FuzzParseInfoResponse(f *testing.F) {
+	addCaptures(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseInfoResponse(data)
+	})
+}
+
+func FuzzParseStatusResponse(f *testing.F) {
+	addCaptures(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		status, err := parseStatusResponse(data)
+		if err != nil {
+			return
+		}
+		if len(status.Players) > maxClients {
+			t.Fatalf("too many players: %d", len(status.Players))
+		}
+	})
+}
+
+func FuzzParsePlayers(f *testing.F) {
+	f.Add([]byte("3 0 \"^1Sarge\"\n12 48 \"player\"\n"))
+	f.Add([]byte("0 0 \"\"\"\n"))
+	f.Add([]byte("-1 999 \"a b c\""))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		players, err := parsePlayers(data)
+		if err != nil {
+			return
+		}
+		for _, p := range players {
+			if len(p.Name) > maxNameLength {
+				t.Fatalf("name too long: %q", p.Name)
+			}
+		}
+	})
+}
+
+func FuzzHuffDecompress(f *testing.F) {
+	f.Add(huffCompress([]byte(`"\challenge\-12345\qport\2345\protocol\71\name\UnnamedPlayer"`)))
+	f.Add([]byte{0xff, 0xff, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		out, err := huffDecompress(data, MaxMessageLength)
+		if err == nil && len(out) > MaxMessageLength {
+			t.Fatalf("decompressed %d bytes", len(out))
+		}
+	})
+}
+
+func FuzzNetchanProcess(f *testing.F) {
+	server := newNetchan(false, 1234, 5678, false)
+	packets, err := server.transmit(make([]byte, 3*fragmentSize))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, p := range packets {
+		f.Add(p)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, legacy := range []bool{false, true} {
+			ch := newNetchan(true, 1234, 5678, legacy)
+			// A packet can complete a fragmented message from an earlier one.
+			ch.process(data)
+			ch.process(data)
+		}
+	})
+}
+
+func FuzzClientPacket(f *testing.F) {
+	f.Add([]byte(OutOfBandHeader + "challengeResponse 1234 5678 71"))
+	f.Add([]byte(OutOfBandHeader + "print\nServer is full.\n"))
+	f.Add([]byte(OutOfBandHeader + "disconnect"))
+	// A small gamestate and the first snapshot, like a server sends when a
+	// client enters the game.
+	m := &message{}
+	m.writeLong(0)
+	m.writeByte(svcGamestate)
+	m.writeLong(0)
+	m.writeByte(svcConfigstring)
+	m.writeShort(csSystemInfo)
+	m.writeString(`\sv_serverid\1`)
+	m.writeByte(svcEOF)
+	m.writeLong(0)
+	m.writeLong(0)
+	(&fakeServer{}).writeSnapshot(m)
+	m.writeByte(svcServerCommand)
+	m.writeLong(1)
+	m.writeString(`cs 0 "\mapname\q3dm17"`)
+	m.writeByte(svcEOF)
+	packets, err := newNetchan(false, 1234, 5678, false).transmit(m.Bytes())
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(packets[0])
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, legacy := range []bool{false, true} {
+			c := NewClient(nopConn{}, &ClientConfig{QPort: 1234})
+			c.challenge = 5678
+			c.legacy = legacy
+			c.state = clientConnected
+			c.chan_ = newNetchan(true, 1234, 5678, legacy)
+			c.packet(data)
+		}
+	})
+}
+
+func FuzzReadPak(f *testing.F) {
+	f.Add([]byte("PK\x05\x06\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		path := filepath.Join(t.TempDir(), "pak0.pk3")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		ReadPak(path)
+	})
+}
+
+type nopConn struct{}
+
+func (nopConn) Read([]byte) (int, error)    { return 0, io.EOF }
+func (nopConn) Write(b []byte) (int, error) { return len(b), nil }
+func (nopConn) Close() error                { return nil }
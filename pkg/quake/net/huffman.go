@@ -0,0 +1,355 @@
+package net
+
+import (
+	"errors"
+	"sync"
+)
+
+// This is a port of the adaptive Huffman coding in ioquake3. It is used both
+// adaptively, to compress the connect packet, and with a fixed tree built from
+// msgHData, to compress every netchan message.
+
+const (
+	huffMax      = 256
+	huffNYT      = huffMax
+	huffInternal = huffMax + 1
+)
+
+type huffNode struct {
+	left, right, parent *huffNode
+	next, prev          *huffNode
+	head                **huffNode
+	weight              int
+	symbol              int
+}
+
+type huffTree struct {
+	tree  *huffNode
+	lhead *huffNode
+	loc   [huffMax + 1]*huffNode
+}
+
+func newHuffTree() *huffTree {
+	nyt := &huffNode{symbol: huffNYT}
+	h := &huffTree{tree: nyt, lhead: nyt}
+	h.loc[huffNYT] = nyt
+	return h
+}
+
+// swap swaps the location of two nodes in the tree.
+func (h *huffTree) swap(node1, node2 *huffNode) {
+	par1 := node1.parent
+	par2 := node2.parent
+	if par1 != nil {
+		if par1.left == node1 {
+			par1.left = node2
+		} else {
+			par1.right = node2
+		}
+	} else {
+		h.tree = node2
+	}
+	if par2 != nil {
+		if par2.left == node2 {
+			par2.left = node1
+		} else {
+			par2.right = node1
+		}
+	} else {
+		h.tree = node1
+	}
+	node1.parent = par2
+	node2.parent = par1
+}
+
+// swapList swaps two nodes in the list ordered by weight.
+func swapList(node1, node2 *huffNode) {
+	node1.next, node2.next = node2.next, node1.next
+	node1.prev, node2.prev = node2.prev, node1.prev
+	if node1.next == node1 {
+		node1.next = node2
+	}
+	if node2.next == node2 {
+		node2.next = node1
+	}
+	if node1.next != nil {
+		node1.next.prev = node1
+	}
+	if node2.next != nil {
+		node2.next.prev = node2
+	}
+	if node1.prev != nil {
+		node1.prev.next = node1
+	}
+	if node2.prev != nil {
+		node2.prev.next = node2
+	}
+}
+
+func (h *huffTree) increment(node *huffNode) {
+	if node == nil {
+		return
+	}
+	if node.next != nil && node.next.weight == node.weight {
+		lnode := *node.head
+		if lnode != node.parent {
+			h.swap(lnode, node)
+		}
+		swapList(lnode, node)
+	}
+	if node.prev != nil && node.prev.weight == node.weight {
+		*node.head = node.prev
+	} else {
+		*node.head = nil
+	}
+	node.weight++
+	if node.next != nil && node.next.weight == node.weight {
+		node.head = node.next.head
+	} else {
+		node.head = new(*huffNode)
+		*node.head = node
+	}
+	if node.parent != nil {
+		h.increment(node.parent)
+		if node.prev == node.parent {
+			swapList(node, node.parent)
+			if *node.head == node {
+				*node.head = node.parent
+			}
+		}
+	}
+}
+
+// addRef adds a reference to the symbol ch, adding it to the tree the first
+// time it is seen.
+func (h *huffTree) addRef(ch byte) {
+	if h.loc[ch] != nil {
+		h.increment(h.loc[ch])
+		return
+	}
+	tnode := &huffNode{symbol: int(ch), weight: 1}
+	tnode2 := &huffNode{symbol: huffInternal, weight: 1}
+
+	tnode2.next = h.lhead.next
+	if h.lhead.next != nil {
+		h.lhead.next.prev = tnode2
+		if h.lhead.next.weight == 1 {
+			tnode2.head = h.lhead.next.head
+		} else {
+			tnode2.head = new(*huffNode)
+			*tnode2.head = tnode2
+		}
+	} else {
+		tnode2.head = new(*huffNode)
+		*tnode2.head = tnode2
+	}
+	h.lhead.next = tnode2
+	tnode2.prev = h.lhead
+
+	tnode.next = h.lhead.next
+	if h.lhead.next != nil {
+		h.lhead.next.prev = tnode
+		if h.lhead.next.weight == 1 {
+			tnode.head = h.lhead.next.head
+		} else {
+			tnode.head = new(*huffNode)
+			*tnode.head = tnode2
+		}
+	} else {
+		tnode.head = new(*huffNode)
+		*tnode.head = tnode
+	}
+	h.lhead.next = tnode
+	tnode.prev = h.lhead
+
+	if h.lhead.parent != nil {
+		if h.lhead.parent.left == h.lhead {
+			h.lhead.parent.left = tnode2
+		} else {
+			h.lhead.parent.right = tnode2
+		}
+	} else {
+		h.tree = tnode2
+	}
+	tnode2.right = tnode
+	tnode2.left = h.lhead
+	tnode2.parent = h.lhead.parent
+	h.lhead.parent = tnode2
+	tnode.parent = tnode2
+
+	h.loc[ch] = tnode
+	h.increment(tnode2.parent)
+}
+
+// bitBuffer is a buffer of bits, written and read from the least significant
+// bit of each byte.
+type bitBuffer struct {
+	data []byte
+	bit  int
+}
+
+func (b *bitBuffer) putBit(bit int) {
+	if b.bit&7 == 0 {
+		i := b.bit >> 3
+		if i < len(b.data) {
+			b.data[i] = 0
+		} else {
+			b.data = append(b.data, 0)
+		}
+	}
+	b.data[b.bit>>3] |= byte(bit << (b.bit & 7))
+	b.bit++
+}
+
+func (b *bitBuffer) getBit() int {
+	v := int(b.data[b.bit>>3]>>(b.bit&7)) & 1
+	b.bit++
+	return v
+}
+
+// transmit writes the code of node, from the root of the tree.
+func (b *bitBuffer) transmit(node *huffNode) {
+	var path [huffMax + 1]int
+	n := 0
+	for ; node.parent != nil; node = node.parent {
+		if node.parent.right == node {
+			path[n] = 1
+		} else {
+			path[n] = 0
+		}
+		n++
+	}
+	for i := n - 1; i >= 0; i-- {
+		b.putBit(path[i])
+	}
+}
+
+// receive reads a symbol, without reading past maxBit. It returns false if
+// there are not enough bits left.
+func (b *bitBuffer) receive(node *huffNode, maxBit int) (int, bool) {
+	for node != nil && node.symbol == huffInternal {
+		if b.bit >= maxBit {
+			return 0, false
+		}
+		if b.getBit() == 1 {
+			node = node.right
+		} else {
+			node = node.left
+		}
+	}
+	if node == nil {
+		return 0, false
+	}
+	return node.symbol, true
+}
+
+// huffCompress compresses data with an adaptive tree. The uncompressed size is
+// written first, as a big-endian 16-bit integer.
+func huffCompress(data []byte) []byte {
+	h := newHuffTree()
+	b := &bitBuffer{data: []byte{byte(len(data) >> 8), byte(len(data))}, bit: 16}
+	for _, ch := range data {
+		if h.loc[ch] == nil {
+			// The symbol hasn't been transmitted yet, so send NYT and then
+			// the symbol itself.
+			b.transmit(h.loc[huffNYT])
+			for i := 7; i >= 0; i-- {
+				b.putBit(int(ch>>i) & 1)
+			}
+		} else {
+			b.transmit(h.loc[ch])
+		}
+		h.addRef(ch)
+	}
+	return b.data
+}
+
+var errHuffmanTruncated = errors.New("huffman: truncated data")
+
+// huffDecompress decompresses data compressed with huffCompress. The size
+// is limited to maxSize.
+func huffDecompress(data []byte, maxSize int) ([]byte, error) {
+	if len(data) < 2 {
+		return nil, errHuffmanTruncated
+	}
+	size := int(data[0])<<8 | int(data[1])
+	if size > maxSize {
+		return nil, errors.New("huffman: data too large")
+	}
+	h := newHuffTree()
+	b := &bitBuffer{data: data, bit: 16}
+	maxBit := len(data) << 3
+	out := make([]byte, 0, size)
+	for len(out) < size {
+		ch, ok := b.receive(h.tree, maxBit)
+		if !ok {
+			return nil, errHuffmanTruncated
+		}
+		if ch == huffNYT {
+			if b.bit+8 > maxBit {
+				return nil, errHuffmanTruncated
+			}
+			ch = 0
+			for i := 0; i < 8; i++ {
+				ch = ch<<1 | b.getBit()
+			}
+		}
+		out = append(out, byte(ch))
+		h.addRef(byte(ch))
+	}
+	return out, nil
+}
+
+var (
+	msgHuffOnce sync.Once
+	msgHuff     *huffTree
+)
+
+// messageHuffman returns the fixed tree used for netchan messages.
+func messageHuffman() *huffTree {
+	msgHuffOnce.Do(func() {
+		msgHuff = newHuffTree()
+		for i, n := range msgHData {
+			for j := 0; j < n; j++ {
+				msgHuff.addRef(byte(i))
+			}
+		}
+	})
+	return msgHuff
+}
+
+// msgHData is the frequency of each byte in typical messages, which is used
+// to build the tree for netchan messages.
+var msgHData = [256]int{
+	250315, 41193, 6292, 7106, 3730, 3750, 6110, 23283,
+	33317, 6950, 7838, 9714, 9257, 17259, 3949, 1778,
+	8288, 1604, 1590, 1663, 1100, 1213, 1238, 1134,
+	1749, 1059, 1246, 1149, 1273, 4486, 2805, 3472,
+	21819, 1159, 1670, 1066, 1043, 1012, 1053, 1070,
+	1726, 888, 1180, 850, 960, 780, 1752, 3296,
+	10630, 4514, 5881, 2685, 4650, 3837, 2093, 1867,
+	2584, 1949, 1972, 940, 1134, 1788, 1670, 1206,
+	5719, 6128, 7222, 6654, 3710, 3795, 1492, 1524,
+	2215, 1140, 1355, 971, 2180, 1248, 1328, 1195,
+	1770, 1078, 1264, 1266, 1168, 965, 1155, 1186,
+	1347, 1228, 1529, 1600, 2617, 2048, 2546, 3275,
+	2410, 3585, 2504, 2800, 2675, 6146, 3663, 2840,
+	14253, 3164, 2221, 1687, 3208, 2739, 3512, 4796,
+	4091, 3515, 5288, 4016, 7937, 6031, 5360, 3924,
+	4892, 3743, 4566, 4807, 5852, 6400, 6225, 8291,
+	23243, 7838, 7073, 8935, 5437, 4483, 3641, 5256,
+	5312, 5328, 5370, 3492, 2458, 1694, 1821, 2121,
+	1916, 1149, 1516, 1367, 1236, 1029, 1258, 1104,
+	1245, 1006, 1149, 1025, 1241, 952, 1287, 997,
+	1713, 1009, 1187, 879, 1099, 929, 1078, 951,
+	1656, 930, 1153, 1030, 1262, 1062, 1214, 1060,
+	1621, 930, 1106, 912, 1034, 892, 1158, 990,
+	1175, 850, 1121, 903, 1087, 920, 1144, 1056,
+	3462, 2240, 4397, 12136, 7758, 1345, 1307, 3278,
+	1950, 886, 1023, 1112, 1077, 1042, 1061, 1071,
+	1484, 1001, 1096, 915, 1052, 995, 1070, 876,
+	1111, 851, 1059, 805, 1112, 923, 1103, 817,
+	1899, 1872, 976, 841, 1127, 956, 1159, 950,
+	7791, 954, 1289, 933, 1127, 3207, 1020, 927,
+	1355, 768, 1040, 745, 952, 805, 1073, 740,
+	1013, 805, 1008, 796, 996, 1057, 11457, 13504}
@@ -0,0 +1,58 @@
+package net
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHuffCompress(t *testing.T) {
+	for _, data := range [][]byte{
+		[]byte(`"\challenge\-12345\qport\2345\protocol\71\name\UnnamedPlayer\rate\25000"`),
+		bytes.Repeat([]byte{0, 1, 2, 255}, 300),
+		{42},
+	} {
+		got, err := huffDecompress(huffCompress(data), MaxMessageLength)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(data, got); diff != "" {
+			t.Errorf("net: after huffDecompress differs: (-want +got)\n%s", diff)
+		}
+	}
+
+	if _, err := huffDecompress(huffCompress([]byte("connect"))[:3], MaxMessageLength); err == nil {
+		t.Error("net: expected error for truncated data")
+	}
+}
+
+func TestMessage(t *testing.T) {
+	m := &message{oob: true}
+	m.writeLong(-1)
+	m.writeShort(1234)
+	m.bitstream()
+	m.writeBits(5, 3)
+	m.writeByte(200)
+	m.writeBits(-3, -12)
+	m.writeLong(-123456789)
+	m.writeString("say hello 100%")
+	m.writeBits(1, 1)
+
+	r := newReadMessage(m.Bytes())
+	got := []any{r.readLong(), r.readShort()}
+	r.bitstream()
+	got = append(got, r.readBits(3), r.readByte(), r.readBits(-12), r.readLong(), r.readString(), r.readBits(1))
+	want := []any{int32(-1), 1234, int32(5), 200, int32(-3), int32(-123456789), "say hello 100.", int32(1)}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("net: after readBits differs: (-want +got)\n%s", diff)
+	}
+	if r.overflowed {
+		t.Error("net: unexpected overflow")
+	}
+	r.readLong()
+	r.readLong()
+	if !r.overflowed {
+		t.Error("net: expected overflow reading past the end")
+	}
+}
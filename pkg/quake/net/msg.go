@@ -0,0 +1,224 @@
+package net
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	// MaxMessageLength is the largest netchan message, after reassembly.
+	MaxMessageLength = 16384
+
+	maxStringChars    = 1024
+	bigInfoStringSize = 8192
+)
+
+var errMessageOverflow = errors.New("message overflow")
+
+// message is a network message, ported from msg_t in ioquake3. Out of band
+// messages are read and written as plain bytes, and other messages as a
+// stream of bits compressed with the fixed netchan Huffman tree.
+type message struct {
+	b   bitBuffer
+	oob bool
+
+	// size is the number of bytes of data that can be read.
+	size int
+	// overflowed is set when reading past the end, or writing past
+	// MaxMessageLength.
+	overflowed bool
+}
+
+func newReadMessage(data []byte) *message {
+	return &message{b: bitBuffer{data: data}, size: len(data), oob: true}
+}
+
+// Bytes returns the written data.
+func (m *message) Bytes() []byte {
+	return m.b.data[:m.size]
+}
+
+// readCount returns the number of bytes read so far, including the current
+// partial byte.
+func (m *message) readCount() int {
+	if m.oob {
+		return m.b.bit >> 3
+	}
+	return m.b.bit>>3 + 1
+}
+
+func (m *message) writeBits(value int32, bits int) {
+	if m.overflowed {
+		return
+	}
+	if bits < 0 {
+		bits = -bits
+	}
+	if m.oob {
+		if m.size+bits>>3 > MaxMessageLength {
+			m.overflowed = true
+			return
+		}
+		for i := 0; i < bits; i += 8 {
+			m.b.data = append(m.b.data[:m.size], byte(value>>i))
+			m.size++
+			m.b.bit += 8
+		}
+		return
+	}
+	v := uint32(value)
+	if bits < 32 {
+		v &= 1<<bits - 1
+	}
+	if n := bits & 7; n != 0 {
+		for i := 0; i < n; i++ {
+			m.b.putBit(int(v & 1))
+			v >>= 1
+		}
+		bits -= n
+	}
+	tree := messageHuffman()
+	for i := 0; i < bits; i += 8 {
+		m.b.transmit(tree.loc[v&0xff])
+		v >>= 8
+	}
+	if m.b.bit > MaxMessageLength<<3 {
+		m.overflowed = true
+		return
+	}
+	m.size = m.b.bit>>3 + 1
+	for len(m.b.data) < m.size {
+		m.b.data = append(m.b.data, 0)
+	}
+}
+
+func (m *message) readBits(bits int) int32 {
+	if m.overflowed {
+		return 0
+	}
+	signed := bits < 0
+	if signed {
+		bits = -bits
+	}
+	var v uint32
+	if m.oob {
+		if m.b.bit>>3+bits>>3 > m.size {
+			m.overflowed = true
+			return 0
+		}
+		for i := 0; i < bits; i += 8 {
+			v |= uint32(m.b.data[m.b.bit>>3]) << i
+			m.b.bit += 8
+		}
+	} else {
+		maxBit := m.size << 3
+		n := bits & 7
+		if n != 0 {
+			if m.b.bit+n > maxBit {
+				m.overflowed = true
+				return 0
+			}
+			for i := 0; i < n; i++ {
+				v |= uint32(m.b.getBit()) << i
+			}
+		}
+		tree := messageHuffman()
+		for i := n; i < bits; i += 8 {
+			ch, ok := m.b.receive(tree.tree, maxBit)
+			if !ok {
+				m.overflowed = true
+				return 0
+			}
+			v |= uint32(ch) << i
+		}
+	}
+	if signed && bits < 32 && v&(1<<(bits-1)) != 0 {
+		v |= ^uint32(0) << bits
+	}
+	return int32(v)
+}
+
+// bitstream switches from reading or writing bytes to reading or writing
+// compressed bits.
+func (m *message) bitstream() {
+	m.oob = false
+}
+
+func (m *message) writeByte(v int)   { m.writeBits(int32(v), 8) }
+func (m *message) writeShort(v int)  { m.writeBits(int32(v), 16) }
+func (m *message) writeLong(v int32) { m.writeBits(v, 32) }
+
+func (m *message) writeData(data []byte) {
+	for _, b := range data {
+		m.writeByte(int(b))
+	}
+}
+
+func (m *message) writeString(s string) {
+	if len(s) >= maxStringChars {
+		s = ""
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		// The engine does this to avoid format string bugs.
+		if c == '%' || c > 127 {
+			c = '.'
+		}
+		m.writeByte(int(c))
+	}
+	m.writeByte(0)
+}
+
+// readByte returns -1 if there is nothing left to read.
+func (m *message) readByte() int {
+	v := int(uint8(m.readBits(8)))
+	if m.overflowed {
+		return -1
+	}
+	return v
+}
+
+func (m *message) readShort() int {
+	return int(int16(m.readBits(16)))
+}
+
+func (m *message) readLong() int32 {
+	return m.readBits(32)
+}
+
+func (m *message) readData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(m.readByte())
+	}
+	return data
+}
+
+func (m *message) readStringLimit(limit int) string {
+	s := make([]byte, 0, 64)
+	for len(s) < limit-1 {
+		c := m.readByte()
+		if c <= 0 {
+			break
+		}
+		if c == '%' || c > 127 {
+			c = '.'
+		}
+		s = append(s, byte(c))
+	}
+	return string(s)
+}
+
+func (m *message) readString() string {
+	return m.readStringLimit(maxStringChars)
+}
+
+func (m *message) readBigString() string {
+	return m.readStringLimit(bigInfoStringSize)
+}
+
+// readRawLong reads a little-endian int32 directly from data, regardless of
+// the current position.
+func readRawLong(data []byte) int32 {
+	return int32(binary.LittleEndian.Uint32(data))
+}
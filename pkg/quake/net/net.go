@@ -2,6 +2,7 @@ package net
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"net"
 	"strconv"
@@ -14,6 +15,47 @@ const (
 	GetStatusCommand = "getstatus"
 )
 
+const (
+	// maxResponseLength is the longest connectionless packet a server sends,
+	// which is MAX_MSGLEN plus the out-of-band header.
+	maxResponseLength = len(OutOfBandHeader) + MaxMessageLength
+
+	maxClients    = 64
+	maxNameLength = 64
+)
+
+// The errors wrapped by a ParseError, for each way a response can be
+// malformed.
+var (
+	ErrResponseTooLong    = errors.New("response is too long")
+	ErrUnexpectedResponse = errors.New("unexpected response")
+	ErrTruncated          = errors.New("response is truncated")
+	ErrInfoTooLong        = errors.New("info string is too long")
+	ErrMissingValue       = errors.New("info key has no value")
+	ErrTooManyPlayers     = errors.New("too many players")
+	ErrMalformedPlayer    = errors.New(`player is not: score ping "name"`)
+	ErrInvalidScore       = errors.New("invalid score")
+	ErrInvalidPing        = errors.New("invalid ping")
+	ErrUnquotedName       = errors.New("player name is not quoted")
+	ErrNameTooLong        = errors.New("player name is too long")
+)
+
+// ParseError is returned when a server sends a malformed response. Servers
+// can be anything that speaks the protocol, so a response is never trusted.
+type ParseError struct {
+	// Response is the expected response, e.g. statusResponse.
+	Response string
+	Err      error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("malformed %s: %v", e.Response, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
 func SendCommand(addr, cmd string) ([]byte, error) {
 	return SendCommandWithTimeout(addr, cmd, 5*time.Second)
 }
@@ -32,7 +74,7 @@ func SendCommandWithTimeout(addr, cmd string, timeout time.Duration) ([]byte, er
 	}
 	defer conn.Close()
 
-	buffer := make([]byte, 1024*1024)
+	buffer := make([]byte, maxResponseLength+1)
 	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
 		return nil, err
 	}
@@ -43,6 +85,9 @@ func SendCommandWithTimeout(addr, cmd string, timeout time.Duration) ([]byte, er
 	if err != nil {
 		return nil, err
 	}
+	if n > maxResponseLength {
+		return nil, ErrResponseTooLong
+	}
 	return buffer[:n], nil
 }
 
@@ -50,18 +95,44 @@ func SendServerCommand(addr, password, cmd string) ([]byte, error) {
 	return SendCommand(addr, fmt.Sprintf("rcon %s %s", password, cmd))
 }
 
-func parseMap(data []byte) map[string]string {
-	if i := bytes.Index(data, []byte("\n")); i >= 0 {
-		data = data[i+1:]
+// parseResponse returns the body of a connectionless response, after the
+// line with the name of the response.
+func parseResponse(resp []byte, name string) ([]byte, error) {
+	if len(resp) > maxResponseLength {
+		return nil, &ParseError{Response: name, Err: ErrResponseTooLong}
+	}
+	data, ok := bytes.CutPrefix(resp, []byte(OutOfBandHeader))
+	if !ok {
+		return nil, &ParseError{Response: name, Err: ErrUnexpectedResponse}
+	}
+	line, body, ok := bytes.Cut(data, []byte("\n"))
+	if string(bytes.TrimSpace(line)) != name {
+		return nil, &ParseError{Response: name, Err: fmt.Errorf("%w: %.32q", ErrUnexpectedResponse, line)}
+	}
+	if !ok {
+		return nil, &ParseError{Response: name, Err: ErrTruncated}
+	}
+	return body, nil
+}
+
+// parseInfoString parses an info string, e.g. \mapname\q3dm7\sv_pure\1.
+func parseInfoString(data []byte) (map[string]string, error) {
+	if len(data) > bigInfoStringSize {
+		return nil, ErrInfoTooLong
 	}
+	m := make(map[string]string)
 	data = bytes.TrimPrefix(data, []byte("\\"))
-	data = bytes.TrimSuffix(data, []byte("\n"))
+	if len(data) == 0 {
+		return m, nil
+	}
 	parts := bytes.Split(data, []byte("\\"))
-	m := make(map[string]string)
-	for i := 0; i < len(parts)-1; i += 2 {
+	if len(parts)%2 != 0 {
+		return nil, fmt.Errorf("%w: %.32q", ErrMissingValue, parts[len(parts)-1])
+	}
+	for i := 0; i < len(parts); i += 2 {
 		m[string(parts[i])] = string(parts[i+1])
 	}
-	return m
+	return m, nil
 }
 
 type Player struct {
@@ -70,40 +141,78 @@ type Player struct {
 	Score int
 }
 
+// IsBot reports whether the player is a bot. Bots don't have a connection to
+// the server, so their ping is always 0.
+func (p Player) IsBot() bool {
+	return p.Ping == 0
+}
+
+// parsePlayers parses the players of a status response, one per line.
 func parsePlayers(data []byte) ([]Player, error) {
 	players := make([]Player, 0)
-	for _, player := range bytes.Split(data, []byte("\n")) {
-		parts := bytes.SplitN(player, []byte(" "), 3)
-		if len(parts) != 3 {
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
 			continue
 		}
-		name, err := strconv.Unquote(string(parts[2]))
-		if err != nil {
-			return nil, err
-		}
-		ping, err := strconv.Atoi(string(parts[1]))
-		if err != nil {
-			return nil, err
+		if len(players) == maxClients {
+			return nil, ErrTooManyPlayers
 		}
-		score, err := strconv.Atoi(string(parts[0]))
+		p, err := parsePlayer(line)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
 		}
-		players = append(players, Player{
-			Name:  name,
-			Ping:  ping,
-			Score: score,
-		})
+		players = append(players, p)
 	}
 	return players, nil
 }
 
+func parsePlayer(line []byte) (Player, error) {
+	parts := bytes.SplitN(line, []byte(" "), 3)
+	if len(parts) != 3 {
+		return Player{}, ErrMalformedPlayer
+	}
+	score, err := strconv.Atoi(string(parts[0]))
+	if err != nil {
+		return Player{}, fmt.Errorf("%w: %.32q", ErrInvalidScore, parts[0])
+	}
+	ping, err := strconv.Atoi(string(parts[1]))
+	if err != nil {
+		return Player{}, fmt.Errorf("%w: %.32q", ErrInvalidPing, parts[1])
+	}
+	// Names can't have quotes, so they aren't escaped like Go strings.
+	name := parts[2]
+	if len(name) < 2 || name[0] != '"' || name[len(name)-1] != '"' {
+		return Player{}, fmt.Errorf("%w: %.32q", ErrUnquotedName, name)
+	}
+	name = name[1 : len(name)-1]
+	if len(name) > maxNameLength {
+		return Player{}, ErrNameTooLong
+	}
+	return Player{Name: string(name), Ping: ping, Score: score}, nil
+}
+
 func GetInfo(addr string) (map[string]string, error) {
-	resp, err := SendCommand(addr, GetInfoCommand)
+	return GetInfoWithTimeout(addr, 0)
+}
+
+func GetInfoWithTimeout(addr string, timeout time.Duration) (map[string]string, error) {
+	resp, err := SendCommandWithTimeout(addr, GetInfoCommand, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return parseInfoResponse(resp)
+}
+
+func parseInfoResponse(resp []byte) (map[string]string, error) {
+	body, err := parseResponse(resp, "infoResponse")
 	if err != nil {
 		return nil, err
 	}
-	return parseMap(resp), nil
+	m, err := parseInfoString(bytes.TrimSuffix(body, []byte("\n")))
+	if err != nil {
+		return nil, &ParseError{Response: "infoResponse", Err: err}
+	}
+	return m, nil
 }
 
 type StatusResponse struct {
@@ -116,22 +225,23 @@ func GetStatus(addr string) (*StatusResponse, error) {
 	if err != nil {
 		return nil, err
 	}
-	data := bytes.TrimSuffix(resp, []byte("\n"))
-	parts := bytes.SplitN(data, []byte("\n"), 3)
-	switch len(parts) {
-	case 2:
-		status := &StatusResponse{
-			Configuration: parseMap(parts[1]),
-			Players:       make([]Player, 0),
-		}
-		return status, nil
-	case 3:
-		status := &StatusResponse{
-			Configuration: parseMap(parts[1]),
-		}
-		status.Players, _ = parsePlayers(parts[2])
-		return status, nil
-	default:
-		return nil, fmt.Errorf("cannot parse response: %q", resp)
+	return parseStatusResponse(resp)
+}
+
+func parseStatusResponse(resp []byte) (*StatusResponse, error) {
+	body, err := parseResponse(resp, "statusResponse")
+	if err != nil {
+		return nil, err
+	}
+	info, players, _ := bytes.Cut(body, []byte("\n"))
+	status := &StatusResponse{}
+	status.Configuration, err = parseInfoString(info)
+	if err != nil {
+		return nil, &ParseError{Response: "statusResponse", Err: err}
+	}
+	status.Players, err = parsePlayers(players)
+	if err != nil {
+		return nil, &ParseError{Response: "statusResponse", Err: err}
 	}
+	return status, nil
 }
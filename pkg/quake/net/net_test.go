@@ -0,0 +1,104 @@
+package net
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ChrisRx/quake-kube/pkg/quake/net/nettest"
+)
+
+func TestGetStatus(t *testing.T) {
+	s := nettest.NewServer()
+	defer s.Close()
+
+	if err := s.HandleFiles(GetStatusCommand, "testdata/captures/ioq3-getstatus", "testdata/captures/ioq3-getstatus-empty"); err != nil {
+		t.Fatal(err)
+	}
+	status, err := GetStatus(s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Player{
+		{Name: "^1Sarge", Ping: 0, Score: 3},
+		{Name: "Major", Ping: 0, Score: -1},
+		{Name: "^4player^7.one", Ping: 48, Score: 12},
+	}
+	if diff := cmp.Diff(expected, status.Players); diff != "" {
+		t.Errorf("net: after GetStatus differs: (-want +got)\n%s", diff)
+	}
+	if status.Configuration["mapname"] != "q3dm7" || status.Configuration["g_maxGameClients"] != "0" {
+		t.Errorf("net: unexpected configuration: %v", status.Configuration)
+	}
+
+	// The server changed maps and everyone left.
+	status, err = GetStatus(s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Configuration["mapname"] != "q3dm17" || len(status.Players) != 0 {
+		t.Errorf("net: unexpected status: %+v", status)
+	}
+	if diff := cmp.Diff([]string{"getstatus", "getstatus"}, s.Requests()); diff != "" {
+		t.Errorf("net: after Requests differs: (-want +got)\n%s", diff)
+	}
+}
+
+func TestGetInfo(t *testing.T) {
+	s := nettest.NewServer()
+	defer s.Close()
+
+	if err := s.HandleFiles(GetInfoCommand, "testdata/captures/ioq3-getinfo"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := GetInfo(s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info["hostname"] != "quakekube" || info["clients"] != "3" || len(info) != 11 {
+		t.Errorf("net: unexpected info: %v", info)
+	}
+
+	// A status response isn't an info response.
+	if err := s.HandleFiles(GetInfoCommand, "testdata/captures/ioq3-getstatus"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetInfo(s.Addr); !errors.Is(err, ErrUnexpectedResponse) {
+		t.Errorf("net: expected %v, received %v", ErrUnexpectedResponse, err)
+	}
+}
+
+func TestParseStatusResponse(t *testing.T) {
+	cases := []struct {
+		name string
+		resp string
+		err  error
+	}{
+		{"no header", "statusResponse\n\\mapname\\q3dm7\n", ErrUnexpectedResponse},
+		{"wrong response", OutOfBandHeader + "print\nbad rconpassword.\n", ErrUnexpectedResponse},
+		{"truncated", OutOfBandHeader + "statusResponse", ErrTruncated},
+		{"too long", OutOfBandHeader + "statusResponse\n" + strings.Repeat("x", MaxMessageLength+1), ErrResponseTooLong},
+		{"info too long", OutOfBandHeader + "statusResponse\n\\x\\" + strings.Repeat("x", bigInfoStringSize), ErrInfoTooLong},
+		{"missing value", OutOfBandHeader + "statusResponse\n\\mapname\\q3dm7\\sv_pure\n", ErrMissingValue},
+		{"malformed player", OutOfBandHeader + "statusResponse\n\\mapname\\q3dm7\n0 0\n", ErrMalformedPlayer},
+		{"invalid score", OutOfBandHeader + "statusResponse\n\\mapname\\q3dm7\nx 0 \"Sarge\"\n", ErrInvalidScore},
+		{"invalid ping", OutOfBandHeader + "statusResponse\n\\mapname\\q3dm7\n0 99999999999999999999 \"Sarge\"\n", ErrInvalidPing},
+		{"unquoted name", OutOfBandHeader + "statusResponse\n\\mapname\\q3dm7\n0 0 Sarge\n", ErrUnquotedName},
+		{"name too long", OutOfBandHeader + "statusResponse\n\\mapname\\q3dm7\n0 0 \"" + strings.Repeat("x", maxNameLength+1) + "\"\n", ErrNameTooLong},
+		{"too many players", OutOfBandHeader + "statusResponse\n\\mapname\\q3dm7\n" + strings.Repeat("0 0 \"Sarge\"\n", maxClients+1), ErrTooManyPlayers},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseStatusResponse([]byte(tc.resp))
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected %v, received %v", tc.err, err)
+			}
+			var perr *ParseError
+			if !errors.As(err, &perr) || perr.Response != "statusResponse" {
+				t.Fatalf("expected a ParseError, received %T", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package net
+
+import (
+	"errors"
+)
+
+const (
+	fragmentBit  int32 = -1 << 31
+	fragmentSize       = 1300
+
+	// maxPacketLength is the size of the largest packet, a full fragment and
+	// its header.
+	maxPacketLength = fragmentSize + 16
+)
+
+var errOutOfOrder = errors.New("netchan: out of order packet")
+
+// netchan is a sequenced connection over unreliable packets. Messages larger
+// than a packet are split into fragments, which are reassembled by the other
+// side.
+type netchan struct {
+	// client is set for the client side of the connection, which sends its
+	// qport in every packet.
+	client bool
+	qport  int
+
+	// challenge is used to checksum packets, which protects against spoofing.
+	// Legacy protocol packets don't have a checksum.
+	challenge int32
+	legacy    bool
+
+	outgoingSequence int32
+	incomingSequence int32
+
+	// dropped is the number of packets missing before the last packet that
+	// was processed.
+	dropped int
+
+	fragmentSequence int32
+	fragments        []byte
+}
+
+// newNetchan returns a netchan. A client sends its qport, and the server
+// reads it.
+func newNetchan(client bool, qport int, challenge int32, legacy bool) *netchan {
+	return &netchan{
+		client:           client,
+		qport:            qport,
+		challenge:        challenge,
+		legacy:           legacy,
+		outgoingSequence: 1,
+	}
+}
+
+func netchanChecksum(challenge, sequence int32) int32 {
+	return challenge ^ (sequence * challenge)
+}
+
+func (c *netchan) header(sequence int32) *message {
+	m := &message{oob: true}
+	m.writeLong(sequence)
+	if c.client {
+		m.writeShort(c.qport)
+	}
+	if !c.legacy {
+		m.writeLong(netchanChecksum(c.challenge, c.outgoingSequence))
+	}
+	return m
+}
+
+// transmit returns the packets to send a message, which is a single packet
+// unless the message is fragmented.
+func (c *netchan) transmit(data []byte) ([][]byte, error) {
+	if len(data) > MaxMessageLength {
+		return nil, errMessageOverflow
+	}
+	if len(data) < fragmentSize {
+		m := c.header(c.outgoingSequence)
+		m.writeData(data)
+		c.outgoingSequence++
+		return [][]byte{m.Bytes()}, nil
+	}
+
+	// A message that is an exact multiple of the fragment size still needs a
+	// final empty fragment, so the other side can tell that it is complete.
+	packets := make([][]byte, 0)
+	for start := 0; ; {
+		n := min(fragmentSize, len(data)-start)
+		m := c.header(c.outgoingSequence | fragmentBit)
+		m.writeShort(start)
+		m.writeShort(n)
+		m.writeData(data[start : start+n])
+		packets = append(packets, m.Bytes())
+		start += n
+		if n != fragmentSize {
+			break
+		}
+	}
+	c.outgoingSequence++
+	return packets, nil
+}
+
+// process reads the header of a packet and returns the message, which is
+// positioned after the header. Fragments are reassembled, and the returned
+// message is nil until all of them were received.
+func (c *netchan) process(packet []byte) (*message, error) {
+	m := newReadMessage(packet)
+	sequence := m.readLong()
+	fragmented := sequence&fragmentBit != 0
+	sequence &^= fragmentBit
+	if !c.client {
+		m.readShort()
+	}
+	if !c.legacy {
+		if checksum := m.readLong(); checksum != netchanChecksum(c.challenge, sequence) {
+			return nil, errors.New("netchan: invalid checksum")
+		}
+	}
+	var start, length int
+	if fragmented {
+		start = int(uint16(m.readShort()))
+		length = int(uint16(m.readShort()))
+	}
+	if m.overflowed {
+		return nil, errors.New("netchan: truncated header")
+	}
+	if sequence <= c.incomingSequence {
+		return nil, errOutOfOrder
+	}
+	c.dropped = int(sequence - (c.incomingSequence + 1))
+
+	if !fragmented {
+		c.incomingSequence = sequence
+		return m, nil
+	}
+
+	if sequence != c.fragmentSequence {
+		c.fragmentSequence = sequence
+		c.fragments = c.fragments[:0]
+	}
+	// Fragments must arrive in order, so a missing fragment drops the whole
+	// message.
+	if start != len(c.fragments) {
+		return nil, nil
+	}
+	offset := m.readCount()
+	if offset+length > len(packet) || len(c.fragments)+length > MaxMessageLength {
+		return nil, errors.New("netchan: illegal fragment length")
+	}
+	c.fragments = append(c.fragments, packet[offset:offset+length]...)
+	if length == fragmentSize {
+		return nil, nil
+	}
+
+	// The full message keeps the sequence number in front.
+	data := make([]byte, 4, 4+len(c.fragments))
+	copy(data, packet[:4])
+	data[3] &^= 0x80
+	data = append(data, c.fragments...)
+	c.fragments = c.fragments[:0]
+	c.incomingSequence = sequence
+	m = newReadMessage(data)
+	m.b.bit = 32
+	return m, nil
+}
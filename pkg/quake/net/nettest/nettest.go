@@ -0,0 +1,120 @@
+// Package nettest provides a fake Quake 3 server for testing code that sends
+// connectionless commands, like getinfo and getstatus, by replaying
+// responses captured from real servers.
+package nettest
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+const outOfBandHeader = "\xff\xff\xff\xff"
+
+// Server is a UDP server that answers connectionless commands with canned
+// responses. Commands without a response are ignored, like a server that is
+// down would.
+type Server struct {
+	// Addr is the address of the server, e.g. 127.0.0.1:27960.
+	Addr string
+
+	conn net.PacketConn
+	wg   sync.WaitGroup
+
+	mu        sync.Mutex
+	responses map[string][][]byte
+	requests  []string
+}
+
+// NewServer starts a server on a random local port. It panics if it can't
+// listen, since it is only meant for tests.
+func NewServer() *Server {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("nettest: failed to listen: %v", err))
+	}
+	s := &Server{
+		Addr:      conn.LocalAddr().String(),
+		conn:      conn,
+		responses: make(map[string][][]byte),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Handle sets the responses to a command, e.g. getstatus, which are sent
+// verbatim, so they must include the out-of-band header. Each request gets
+// the next response, and the last one is repeated.
+func (s *Server) Handle(cmd string, responses ...[]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[cmd] = responses
+}
+
+// HandleFiles is like Handle, but the responses are read from files, e.g.
+// packets captured with tcpdump.
+func (s *Server) HandleFiles(cmd string, paths ...string) error {
+	responses := make([][]byte, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		responses = append(responses, data)
+	}
+	s.Handle(cmd, responses...)
+	return nil
+}
+
+// Requests returns the commands received so far, without the out-of-band
+// header.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *Server) Close() error {
+	err := s.conn.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	buf := make([]byte, 16384)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req, ok := bytes.CutPrefix(buf[:n], []byte(outOfBandHeader))
+		if !ok {
+			continue
+		}
+		if resp := s.response(string(req)); resp != nil {
+			s.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (s *Server) response(req string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, req)
+	cmd, _, _ := strings.Cut(req, " ")
+	responses := s.responses[cmd]
+	if len(responses) == 0 {
+		return nil
+	}
+	resp := responses[0]
+	if len(responses) > 1 {
+		s.responses[cmd] = responses[1:]
+	}
+	return resp
+}
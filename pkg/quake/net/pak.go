@@ -0,0 +1,71 @@
+package net
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/md4"
+)
+
+// Pak is a pk3 file known to a client. Pure servers, which only allow game
+// code from their own pk3 files, check that clients have the same pk3 files
+// by asking them for checksums that depend on their contents.
+type Pak struct {
+	Name string
+
+	// Checksum identifies the pk3 file in the sv_paks list of a server.
+	Checksum int32
+
+	crcs     []uint32
+	hasCGame bool
+	hasUI    bool
+}
+
+// ReadPak reads the checksums of a pk3 file.
+func ReadPak(path string) (*Pak, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	p := &Pak{Name: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))}
+	for _, f := range r.File {
+		if f.UncompressedSize64 > 0 {
+			p.crcs = append(p.crcs, f.CRC32)
+		}
+		switch strings.ToLower(f.Name) {
+		case "vm/cgame.qvm":
+			p.hasCGame = true
+		case "vm/ui.qvm":
+			p.hasUI = true
+		}
+	}
+	p.Checksum = blockChecksum(p.crcs...)
+	return p, nil
+}
+
+// PureChecksum returns the checksum of the pak for the checksum feed sent by
+// the server with the gamestate.
+func (p *Pak) PureChecksum(feed int32) int32 {
+	return blockChecksum(append([]uint32{uint32(feed)}, p.crcs...)...)
+}
+
+// blockChecksum is the xor of the words of the MD4 digest of the
+// little-endian values.
+func blockChecksum(values ...uint32) int32 {
+	data := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(data[4*i:], v)
+	}
+	h := md4.New()
+	h.Write(data)
+	digest := h.Sum(nil)
+	var v uint32
+	for i := 0; i < 4; i++ {
+		v ^= binary.LittleEndian.Uint32(digest[4*i:])
+	}
+	return int32(v)
+}